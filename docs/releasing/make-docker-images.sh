@@ -29,6 +29,11 @@ PROJECT_ID="${PROJECT_ID:?PROJECT_ID env variable must be specified e.g. google-
 while IFS= read -d $'\0' -r dir; do
     # build image
     svcname="$(basename "${dir}")"
+    # common is the Go module the Go services share, not a service
+    if [ $svcname == "common" ]
+    then
+        continue
+    fi
     builddir="${dir}"
     #PR 516 moved cartservice build artifacts one level down to src
     if [ $svcname == "cartservice" ]
@@ -38,9 +43,21 @@ while IFS= read -d $'\0' -r dir; do
     image="${REPO_PREFIX}/$svcname:$TAG"
     image_with_sample_public_image_tag="${REPO_PREFIX}/$svcname:sample-public-image-$TAG"
     (
-        cd "${builddir}"
         log "Building (and pushing) image on Google Cloud Build: ${image}"
-        gcloud builds submit --project=${PROJECT_ID} --tag=${image}
+        # Go services that use src/common are built with src/ as the context
+        if [ -f "${dir}/go.mod" ] && grep -q "src/common" "${dir}/go.mod"
+        then
+            cd "${REPO_ROOT}/src"
+            gcloud builds submit --project=${PROJECT_ID} --config=/dev/stdin <<EOF
+steps:
+- name: gcr.io/cloud-builders/docker
+  args: ["build", "-t", "${image}", "-f", "${svcname}/Dockerfile", "."]
+images: ["${image}"]
+EOF
+        else
+            cd "${builddir}"
+            gcloud builds submit --project=${PROJECT_ID} --tag=${image}
+        fi
         gcloud artifacts docker tags add ${image} ${image_with_sample_public_image_tag}
     )
 done < <(find "${REPO_ROOT}/src" -mindepth 1 -maxdepth 1 -type d -print0)
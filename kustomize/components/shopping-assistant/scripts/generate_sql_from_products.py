@@ -13,18 +13,26 @@
 # limitations under the License.
 
 import json
+from decimal import Decimal
 
 table_name = "catalog_items"
 fields = [
     'id', 'name', 'description', 'picture', 
     'price_usd_currency_code', 'price_usd_units', 'price_usd_nanos', 
-    'categories'
+    'categories', 'stock'
 ]
 
 # Load the produts JSON
 with open("products.json", 'r') as f:
     data = json.load(f)
 
+def parse_price(price):
+    """Splits a price such as "19.99 USD" into currency code, units and nanos."""
+    amount, currency_code = price.split()
+    units = int(Decimal(amount))
+    nanos = int((Decimal(amount) - units) * 1000000000)
+    return currency_code, units, nanos
+
 # Generate SQL INSERT statements
 for product in data['products']:
     columns = ', '.join(fields)
@@ -35,15 +43,17 @@ for product in data['products']:
     product['name'] = product['name'].replace("'", "")
     product['description'] = product['description'].replace("'", "")
 
+    currency_code, units, nanos = parse_price(product['priceUsd'])
     escaped_values = (
         f"'{product['id']}'",
         f"'{product['name']}'",
         f"'{product['description']}'",
         f"'{product['picture']}'",
-        f"'{product['priceUsd']['currencyCode']}'",
-        product['priceUsd']['units'],
-        product['priceUsd']['nanos'],
-        f"'{','.join(product['categories'])}'"
+        f"'{currency_code}'",
+        units,
+        nanos,
+        f"'{','.join(product['categories'])}'",
+        product.get('stock', 0)
     )
 
     # Render the formatted SQL query
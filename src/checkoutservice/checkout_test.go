@@ -0,0 +1,615 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/metric/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// fakeDeps implements every service checkout depends on, in process.
+type fakeDeps struct {
+	pb.UnimplementedCartServiceServer
+	pb.UnimplementedProductCatalogServiceServer
+	pb.UnimplementedCurrencyServiceServer
+	pb.UnimplementedShippingServiceServer
+	pb.UnimplementedPaymentServiceServer
+	pb.UnimplementedEmailServiceServer
+
+	mu       sync.Mutex
+	carts    map[string][]*pb.CartItem
+	products map[string]*pb.Product
+	charges  []*pb.ChargeRequest
+	refunds  []*pb.RefundRequest
+	converts int
+	emails   []*pb.SendOrderConfirmationRequest
+
+	stock        map[string]int32
+	reservations map[string][]*pb.CartItem
+	committed    []string
+	released     []string
+
+	chargeErr  error
+	refundErr  error
+	shipErr    error
+	emailErr   error
+	convertErr error
+	// currenciesErr fails GetSupportedCurrencies.
+	currenciesErr error
+	// onCurrencies, if set, runs before GetSupportedCurrencies answers.
+	onCurrencies func()
+
+	// onCharge, if set, runs before a charge is accepted.
+	onCharge func(*pb.ChargeRequest)
+}
+
+func newFakeDeps() *fakeDeps {
+	return &fakeDeps{
+		carts: map[string][]*pb.CartItem{},
+		products: map[string]*pb.Product{
+			"OLJCESPC7Z": {Id: "OLJCESPC7Z", Name: "Sunglasses", Categories: []string{"accessories"},
+				PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 19, Nanos: 990000000}},
+			"66VCHSJNUP": {Id: "66VCHSJNUP", Name: "Tank Top", Categories: []string{"clothing", "tops"},
+				PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 18, Nanos: 990000000}},
+		},
+		stock:        map[string]int32{"OLJCESPC7Z": 10, "66VCHSJNUP": 10},
+		reservations: map[string][]*pb.CartItem{},
+	}
+}
+
+func (f *fakeDeps) GetCart(_ context.Context, req *pb.GetCartRequest) (*pb.Cart, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &pb.Cart{UserId: req.GetUserId(), Items: f.carts[req.GetUserId()]}, nil
+}
+
+func (f *fakeDeps) EmptyCart(_ context.Context, req *pb.EmptyCartRequest) (*pb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.carts, req.GetUserId())
+	return &pb.Empty{}, nil
+}
+
+func (f *fakeDeps) GetProduct(_ context.Context, req *pb.GetProductRequest) (*pb.Product, error) {
+	p, ok := f.products[req.GetId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no product with ID %s", req.GetId())
+	}
+	return p, nil
+}
+
+func (f *fakeDeps) ReserveStock(_ context.Context, req *pb.ReserveStockRequest) (*pb.ReserveStockResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, item := range req.GetItems() {
+		have, ok := f.stock[item.GetProductId()]
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "unknown product: %s", item.GetProductId())
+		}
+		if have < item.GetQuantity() {
+			return nil, status.Errorf(codes.FailedPrecondition, "insufficient stock for product %s: %d requested, %d available",
+				item.GetProductId(), item.GetQuantity(), have)
+		}
+	}
+	for _, item := range req.GetItems() {
+		f.stock[item.GetProductId()] -= item.GetQuantity()
+	}
+	id := fmt.Sprintf("res-%d", len(f.reservations)+len(f.committed)+len(f.released)+1)
+	f.reservations[id] = req.GetItems()
+	return &pb.ReserveStockResponse{ReservationId: id}, nil
+}
+
+func (f *fakeDeps) CommitReservation(_ context.Context, req *pb.CommitReservationRequest) (*pb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.reservations[req.GetReservationId()]; !ok {
+		return nil, status.Errorf(codes.NotFound, "no reservation with ID %s", req.GetReservationId())
+	}
+	delete(f.reservations, req.GetReservationId())
+	f.committed = append(f.committed, req.GetReservationId())
+	return &pb.Empty{}, nil
+}
+
+func (f *fakeDeps) ReleaseReservation(_ context.Context, req *pb.ReleaseReservationRequest) (*pb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	items, ok := f.reservations[req.GetReservationId()]
+	if !ok {
+		return &pb.Empty{}, nil
+	}
+	for _, item := range items {
+		f.stock[item.GetProductId()] += item.GetQuantity()
+	}
+	delete(f.reservations, req.GetReservationId())
+	f.released = append(f.released, req.GetReservationId())
+	return &pb.Empty{}, nil
+}
+
+// Convert pretends every currency is worth exactly one US dollar.
+func (f *fakeDeps) Convert(_ context.Context, req *pb.CurrencyConversionRequest) (*pb.Money, error) {
+	f.mu.Lock()
+	f.converts++
+	err := f.convertErr
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Money{CurrencyCode: req.GetToCode(), Units: req.GetFrom().GetUnits(), Nanos: req.GetFrom().GetNanos()}, nil
+}
+
+func (f *fakeDeps) GetSupportedCurrencies(context.Context, *pb.Empty) (*pb.GetSupportedCurrenciesResponse, error) {
+	if f.onCurrencies != nil {
+		f.onCurrencies()
+	}
+	if f.currenciesErr != nil {
+		return nil, f.currenciesErr
+	}
+	return &pb.GetSupportedCurrenciesResponse{CurrencyCodes: []string{"USD", "EUR", "CAD", "JPY", "GBP"}}, nil
+}
+
+func (f *fakeDeps) GetQuote(context.Context, *pb.GetQuoteRequest) (*pb.GetQuoteResponse, error) {
+	return &pb.GetQuoteResponse{CostUsd: &pb.Money{CurrencyCode: "USD", Units: 8, Nanos: 990000000}}, nil
+}
+
+func (f *fakeDeps) ShipOrder(context.Context, *pb.ShipOrderRequest) (*pb.ShipOrderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.shipErr != nil {
+		return nil, f.shipErr
+	}
+	return &pb.ShipOrderResponse{TrackingId: "AB-1234-5678"}, nil
+}
+
+func (f *fakeDeps) Charge(_ context.Context, req *pb.ChargeRequest) (*pb.ChargeResponse, error) {
+	if f.onCharge != nil {
+		f.onCharge(req)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.chargeErr != nil {
+		return nil, f.chargeErr
+	}
+	f.charges = append(f.charges, req)
+	return &pb.ChargeResponse{TransactionId: fmt.Sprintf("tx-%d", len(f.charges))}, nil
+}
+
+func (f *fakeDeps) Refund(_ context.Context, req *pb.RefundRequest) (*pb.RefundResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.refundErr != nil {
+		return nil, f.refundErr
+	}
+	if req.GetTransactionId() == "" && !f.chargedWith(req.GetIdempotencyKey()) {
+		return nil, status.Errorf(codes.NotFound, "no charge was made for %s", req.GetIdempotencyKey())
+	}
+	f.refunds = append(f.refunds, req)
+	return &pb.RefundResponse{RefundId: fmt.Sprintf("rf-%d", len(f.refunds))}, nil
+}
+
+func (f *fakeDeps) chargedWith(key string) bool {
+	for _, c := range f.charges {
+		if c.GetIdempotencyKey() == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDeps) SendOrderConfirmation(_ context.Context, req *pb.SendOrderConfirmationRequest) (*pb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.emailErr != nil {
+		return nil, f.emailErr
+	}
+	f.emails = append(f.emails, req)
+	return &pb.Empty{}, nil
+}
+
+// dialTestServer serves the services registered by register over an
+// in-memory listener and returns a connection to it.
+func dialTestServer(t *testing.T, register func(*grpc.Server), opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	register(srv)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	opts = append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, opts...)
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// newTestCheckout returns a checkoutService whose downstream connections all
+// point at deps.
+func newTestCheckout(t *testing.T, deps *fakeDeps) *checkoutService {
+	t.Helper()
+	conn := dialTestServer(t, func(srv *grpc.Server) {
+		pb.RegisterCartServiceServer(srv, deps)
+		pb.RegisterProductCatalogServiceServer(srv, deps)
+		pb.RegisterCurrencyServiceServer(srv, deps)
+		pb.RegisterShippingServiceServer(srv, deps)
+		pb.RegisterPaymentServiceServer(srv, deps)
+		pb.RegisterEmailServiceServer(srv, deps)
+	})
+
+	cs := &checkoutService{
+		productCatalogSvcConn: conn,
+		cartSvcConn:           conn,
+		currencySvcConn:       conn,
+		shippingSvcConn:       conn,
+		emailSvcConn:          conn,
+		paymentSvcConn:        conn,
+		sagaLog:               newMemorySagaLog(),
+		idempotency:           newMemoryIdempotencyStore(),
+		orders:                newMemoryOrderRepository(),
+		quotes:                newQuoteSigner([]byte("test key")),
+		metrics:               newTestMetrics(t, noop.NewMeterProvider()),
+	}
+	cs.outbox = newOutboxDispatcher(cs.orders, cs.outboxHandlers())
+	return cs
+}
+
+func testOrderRequest(userID string) *pb.PlaceOrderRequest {
+	return &pb.PlaceOrderRequest{
+		UserId:       userID,
+		UserCurrency: "USD",
+		Email:        "someone@example.com",
+		Address: &pb.Address{
+			StreetAddress: "1600 Amphitheatre Parkway",
+			City:          "Mountain View",
+			State:         "CA",
+			Country:       "United States",
+			ZipCode:       94043,
+		},
+		CreditCard: &pb.CreditCardInfo{
+			CreditCardNumber:          "4432-8015-6152-0454",
+			CreditCardCvv:             672,
+			CreditCardExpirationYear:  2039,
+			CreditCardExpirationMonth: 1,
+		},
+	}
+}
+
+func TestPlaceOrder(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	cs := newTestCheckout(t, deps)
+
+	resp, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1"))
+	if err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	if got := resp.GetOrder().GetShippingTrackingId(); got != "AB-1234-5678" {
+		t.Errorf("tracking id = %q, want %q", got, "AB-1234-5678")
+	}
+	// 2 x 19.99 + 8.99 shipping
+	if got := deps.charges[0].GetAmount(); got.GetUnits() != 48 || got.GetNanos() != 970000000 {
+		t.Errorf("charged %d.%09d, want 48.970000000", got.GetUnits(), got.GetNanos())
+	}
+	if len(deps.carts["u1"]) != 0 {
+		t.Error("cart was not emptied")
+	}
+	if err := cs.outbox.dispatchOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(deps.emails) != 1 {
+		t.Errorf("sent %d confirmations, want 1", len(deps.emails))
+	}
+	if pending, _ := cs.sagaLog.Pending(); len(pending) != 0 {
+		t.Errorf("saga log has %d pending sagas, want 0", len(pending))
+	}
+}
+
+func TestPlaceOrderRoundsTotalToMinorUnits(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	cs := newTestCheckout(t, deps)
+	req := testOrderRequest("u1")
+	req.UserCurrency = "JPY"
+
+	resp, err := cs.PlaceOrder(context.Background(), req)
+	if err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	// 48.97 converts to 48.97 yen, which has no minor units.
+	want := &pb.Money{CurrencyCode: "JPY", Units: 49}
+	if got := deps.charges[0].GetAmount(); !proto.Equal(got, want) {
+		t.Errorf("charged %v, want %v", got, want)
+	}
+	if got := resp.GetOrder().GetTotal(); !proto.Equal(got, want) {
+		t.Errorf("order total = %v, want %v", got, want)
+	}
+}
+
+func TestPlaceOrderRefundsWhenShippingFails(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 1}}
+	deps.shipErr = status.Error(codes.Unavailable, "no trucks")
+	cs := newTestCheckout(t, deps)
+
+	_, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1"))
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("PlaceOrder() error = %v, want code %v", err, codes.Unavailable)
+	}
+	if len(deps.refunds) != 1 {
+		t.Fatalf("issued %d refunds, want 1", len(deps.refunds))
+	}
+	if got, want := deps.refunds[0].GetTransactionId(), "tx-1"; got != want {
+		t.Errorf("refunded transaction %q, want %q", got, want)
+	}
+	if got := deps.refunds[0].GetAmount(); got.GetUnits() != 27 || got.GetNanos() != 980000000 {
+		t.Errorf("refunded %d.%09d, want 27.980000000", got.GetUnits(), got.GetNanos())
+	}
+	if len(deps.carts["u1"]) == 0 {
+		t.Error("cart was emptied for a failed order")
+	}
+	if pending, _ := cs.sagaLog.Pending(); len(pending) != 0 {
+		t.Errorf("saga log has %d pending sagas, want 0", len(pending))
+	}
+}
+
+func TestPlaceOrderKeepsSagaWhenRefundFails(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 1}}
+	deps.shipErr = status.Error(codes.Unavailable, "no trucks")
+	deps.refundErr = status.Error(codes.Unavailable, "payment down")
+	cs := newTestCheckout(t, deps)
+
+	if _, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1")); err == nil {
+		t.Fatal("PlaceOrder() succeeded, want error")
+	}
+	pending, _ := cs.sagaLog.Pending()
+	if len(pending) != 1 {
+		t.Fatalf("saga log has %d pending sagas, want 1", len(pending))
+	}
+
+	// Once payments recover, a restart finishes the compensation.
+	deps.refundErr = nil
+	if err := recoverSagas(context.Background(), cs.sagaLog, cs.compensators(), stepShipOrder, cs.finishSaga); err != nil {
+		t.Fatal(err)
+	}
+	if len(deps.refunds) != 1 {
+		t.Errorf("issued %d refunds after recovery, want 1", len(deps.refunds))
+	}
+	if pending, _ := cs.sagaLog.Pending(); len(pending) != 0 {
+		t.Errorf("saga log has %d pending sagas after recovery, want 0", len(pending))
+	}
+}
+
+func TestPlaceOrderCommitsReservedStock(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 3}}
+	cs := newTestCheckout(t, deps)
+
+	if _, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1")); err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	if len(deps.committed) != 1 || len(deps.released) != 0 || len(deps.reservations) != 0 {
+		t.Errorf("committed %v, released %v, open %v; want one commit", deps.committed, deps.released, deps.reservations)
+	}
+	if got := deps.stock["OLJCESPC7Z"]; got != 7 {
+		t.Errorf("stock = %d, want 7", got)
+	}
+}
+
+func TestPlaceOrderReleasesStockOnFailure(t *testing.T) {
+	for name, fail := range map[string]func(*fakeDeps){
+		"charge":   func(d *fakeDeps) { d.chargeErr = status.Error(codes.InvalidArgument, "card declined") },
+		"shipping": func(d *fakeDeps) { d.shipErr = status.Error(codes.Unavailable, "no trucks") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			deps := newFakeDeps()
+			deps.carts["u1"] = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 4}}
+			fail(deps)
+			cs := newTestCheckout(t, deps)
+
+			if _, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1")); err == nil {
+				t.Fatal("PlaceOrder() succeeded, want error")
+			}
+			if len(deps.released) != 1 || len(deps.committed) != 0 {
+				t.Errorf("released %v, committed %v; want one release", deps.released, deps.committed)
+			}
+			if got := deps.stock["66VCHSJNUP"]; got != 10 {
+				t.Errorf("stock = %d, want 10", got)
+			}
+		})
+	}
+}
+
+func TestPlaceOrderOutOfStock(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}, {ProductId: "66VCHSJNUP", Quantity: 11}}
+	cs := newTestCheckout(t, deps)
+
+	_, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1"))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("PlaceOrder() error = %v, want code %v", err, codes.FailedPrecondition)
+	}
+	if len(deps.charges) != 0 {
+		t.Errorf("charged the card %d times for an order that is out of stock", len(deps.charges))
+	}
+	if got := deps.stock["OLJCESPC7Z"]; got != 10 {
+		t.Errorf("stock = %d, want 10", got)
+	}
+}
+
+func TestRecoveryCommitsStockOfShippedOrder(t *testing.T) {
+	deps := newFakeDeps()
+	cs := newTestCheckout(t, deps)
+	ctx := context.Background()
+
+	// A process that crashed right after shipping left its reservation open.
+	res, err := deps.ReserveStock(ctx, &pb.ReserveStockRequest{Items: []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sg, err := newSaga("o1", cs.sagaLog, cs.compensators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range []struct {
+		name string
+		rec  interface{}
+	}{
+		{stepReserveStock, reservationRecord{ReservationID: res.GetReservationId()}},
+		{stepChargeCard, chargeRecord{TransactionID: "tx-1"}},
+		{stepShipOrder, shipmentRecord{TrackingID: "AB-1"}},
+	} {
+		if err := sg.step(ctx, st.name, func(context.Context) (interface{}, error) { return st.rec, nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := recoverSagas(ctx, cs.sagaLog, cs.compensators(), stepShipOrder, cs.finishSaga); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{res.GetReservationId()}; fmt.Sprint(deps.committed) != fmt.Sprint(want) {
+		t.Errorf("committed %v, want %v", deps.committed, want)
+	}
+	if len(deps.refunds) != 0 {
+		t.Errorf("refunded a shipped order")
+	}
+}
+
+func TestRecoveryVoidsInterruptedCharge(t *testing.T) {
+	tests := []struct {
+		name        string
+		charged     bool
+		wantRefunds int
+	}{
+		{"crashed after the charge", true, 1},
+		{"crashed before the charge", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newFakeDeps()
+			cs := newTestCheckout(t, deps)
+			ctx := context.Background()
+
+			// The process died while charging, before the step completed.
+			sg, err := newSaga("o1", cs.sagaLog, cs.compensators())
+			if err != nil {
+				t.Fatal(err)
+			}
+			amount := usd(27, 980000000)
+			sg.stepWithIntent(ctx, stepChargeCard, chargeRecord{OrderID: "o1", Amount: amount}, func(ctx context.Context) (interface{}, error) {
+				if tt.charged {
+					if _, err := cs.chargeCard(ctx, "o1", amount, &pb.CreditCardInfo{}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return nil, errors.New("crashed")
+			})
+
+			if err := recoverSagas(ctx, cs.sagaLog, cs.compensators(), stepShipOrder, cs.finishSaga); err != nil {
+				t.Fatal(err)
+			}
+			if len(deps.refunds) != tt.wantRefunds {
+				t.Fatalf("issued %d refunds, want %d", len(deps.refunds), tt.wantRefunds)
+			}
+			if tt.wantRefunds > 0 && deps.refunds[0].GetIdempotencyKey() != "o1" {
+				t.Errorf("refunded %v, want the charge for o1", deps.refunds[0])
+			}
+			if pending, _ := cs.sagaLog.Pending(); len(pending) != 0 {
+				t.Errorf("saga log has %d pending sagas after recovery, want 0", len(pending))
+			}
+		})
+	}
+}
+
+func TestRecoveryKeepsSagaWhenChargeCannotBeVoided(t *testing.T) {
+	deps := newFakeDeps()
+	deps.refundErr = status.Error(codes.Unavailable, "payment down")
+	cs := newTestCheckout(t, deps)
+	ctx := context.Background()
+
+	sg, err := newSaga("o1", cs.sagaLog, cs.compensators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sg.stepWithIntent(ctx, stepChargeCard, chargeRecord{OrderID: "o1", Amount: usd(1, 0)}, func(context.Context) (interface{}, error) {
+		return nil, errors.New("crashed")
+	})
+
+	if err := recoverSagas(ctx, cs.sagaLog, cs.compensators(), stepShipOrder, cs.finishSaga); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := cs.sagaLog.Pending(); len(pending) != 1 {
+		t.Errorf("saga log has %d pending sagas, want the one whose charge is unknown", len(pending))
+	}
+}
+
+func TestPrepareOrderKeepsCartOrderAndDedupesConversions(t *testing.T) {
+	deps := newFakeDeps()
+	// Same price as OLJCESPC7Z, so its conversion is shared.
+	deps.products["LS4PSXUNUM"] = &pb.Product{Id: "LS4PSXUNUM", Name: "Salt & Pepper Shakers",
+		PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 19, Nanos: 990000000}}
+	cart := []*pb.CartItem{
+		{ProductId: "66VCHSJNUP", Quantity: 1},
+		{ProductId: "OLJCESPC7Z", Quantity: 2},
+		{ProductId: "LS4PSXUNUM", Quantity: 3},
+	}
+	deps.carts["u1"] = cart
+	cs := newTestCheckout(t, deps)
+
+	prep, err := cs.prepareOrderItemsAndShippingQuoteFromCart(context.Background(), "u1", "EUR", testOrderRequest("u1").Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, it := range prep.orderItems {
+		if it.GetItem().GetProductId() != cart[i].GetProductId() {
+			t.Errorf("item %d is %q, want %q", i, it.GetItem().GetProductId(), cart[i].GetProductId())
+		}
+		if it.GetCost().GetCurrencyCode() != "EUR" {
+			t.Errorf("item %d costs %v, want EUR", i, it.GetCost())
+		}
+	}
+	// Two distinct prices plus shipping.
+	if deps.converts != 3 {
+		t.Errorf("made %d conversions, want 3", deps.converts)
+	}
+}
+
+func TestPrepareOrderFailsOnUnknownProduct(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}, {ProductId: "NOPE", Quantity: 1}}
+	cs := newTestCheckout(t, deps)
+
+	if _, err := cs.prepareOrderItemsAndShippingQuoteFromCart(context.Background(), "u1", "USD", nil); err == nil {
+		t.Fatal("prepareOrderItemsAndShippingQuoteFromCart() succeeded, want error")
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
+)
+
+// currencyConverter converts amounts for a single order. Identical
+// conversions, such as the same price in the same currency pair, share one
+// CurrencyService call even when requested concurrently.
+type currencyConverter struct {
+	cs *checkoutService
+
+	mu    sync.Mutex
+	calls map[conversionKey]*conversion
+	// stale is set once an amount is converted with cs.rates.
+	stale bool
+}
+
+type conversionKey struct {
+	from, to string
+	units    int64
+	nanos    int32
+}
+
+type conversion struct {
+	done   chan struct{}
+	result *pb.Money
+	err    error
+}
+
+func newCurrencyConverter(cs *checkoutService) *currencyConverter {
+	return &currencyConverter{cs: cs, calls: make(map[conversionKey]*conversion)}
+}
+
+func (c *currencyConverter) convert(ctx context.Context, from *pb.Money, toCurrency string) (*pb.Money, error) {
+	key := conversionKey{from: from.GetCurrencyCode(), to: toCurrency, units: from.GetUnits(), nanos: from.GetNanos()}
+	c.mu.Lock()
+	call, ok := c.calls[key]
+	if !ok {
+		call = &conversion{done: make(chan struct{})}
+		c.calls[key] = call
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		call.result, call.err = c.convertOnce(ctx, from, toCurrency)
+		close(call.done)
+	} else {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	// Callers keep the result in their order items; give each its own copy.
+	return proto.Clone(call.result).(*pb.Money), nil
+}
+
+// convertOnce converts from to toCurrency with CurrencyService, or with
+// the exchange rate snapshot when it is primary or CurrencyService is
+// unavailable.
+func (c *currencyConverter) convertOnce(ctx context.Context, from *pb.Money, toCurrency string) (*pb.Money, error) {
+	rates := c.cs.rates
+	if rates != nil && rates.Primary {
+		if out, ok := rates.Convert(from, toCurrency); ok {
+			c.markStale()
+			return out, nil
+		}
+	}
+	out, err := c.cs.convertCurrency(ctx, from, toCurrency)
+	if err != nil && rates != nil && !rates.Primary && money.CurrencyServiceUnavailable(err) {
+		if out, ok := rates.Convert(from, toCurrency); ok {
+			log.Warnf("converting %s to %s with exchange rates from the snapshot: %v", from.GetCurrencyCode(), toCurrency, err)
+			c.markStale()
+			return out, nil
+		}
+	}
+	return out, err
+}
+
+func (c *currencyConverter) markStale() {
+	c.mu.Lock()
+	c.stale = true
+	c.mu.Unlock()
+}
+
+// markExchangeRates records on order whether any of its amounts were
+// converted with the exchange rate snapshot.
+func (c *currencyConverter) markExchangeRates(order *pb.OrderResult) {
+	c.mu.Lock()
+	stale := c.stale
+	c.mu.Unlock()
+	if !stale {
+		return
+	}
+	order.StaleExchangeRates = true
+	if asOf := c.cs.rates.Table.AsOf; !asOf.IsZero() {
+		order.ExchangeRatesAsOf = timestamppb.New(asOf)
+	}
+}
+
+// convertOptional converts from to toCurrency. A nil amount stays nil, and
+// amounts already in toCurrency are returned as they are.
+func (c *currencyConverter) convertOptional(ctx context.Context, from *pb.Money, toCurrency string) (*pb.Money, error) {
+	if from == nil || from.GetCurrencyCode() == toCurrency {
+		return from, nil
+	}
+	return c.convert(ctx, from, toCurrency)
+}
+
+// supportedCurrenciesTTL is how long the currencies CurrencyService
+// supports are cached for.
+const supportedCurrenciesTTL = 10 * time.Minute
+
+// supportedCurrencies caches the currencies CurrencyService supports. The
+// zero value is ready to use.
+type supportedCurrencies struct {
+	mu      sync.Mutex
+	codes   map[string]bool
+	fetched time.Time
+	// refresh is the fetch in flight, if any.
+	refresh *currenciesFetch
+}
+
+type currenciesFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// currencySupported reports whether orders can be placed in code. Those
+// the exchange rate snapshot has rates for are when it is primary or
+// CurrencyService is unavailable.
+//
+// Once the cache expires, one caller refreshes it while the others are
+// answered from the expired set, which also keeps being used if the
+// refresh fails.
+func (cs *checkoutService) currencySupported(ctx context.Context, code string) (bool, error) {
+	if cs.rates != nil && cs.rates.Primary && cs.rates.Table.Has(code) {
+		return true, nil
+	}
+	c := &cs.currencies
+	c.mu.Lock()
+	if c.codes != nil && (time.Since(c.fetched) <= supportedCurrenciesTTL || c.refresh != nil) {
+		ok := c.codes[code]
+		c.mu.Unlock()
+		return ok, nil
+	}
+	f := c.refresh
+	if f == nil {
+		f = &currenciesFetch{done: make(chan struct{})}
+		c.refresh = f
+		c.mu.Unlock()
+		cs.fetchSupportedCurrencies(ctx, f)
+	} else {
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	c.mu.Lock()
+	codes, fetched := c.codes, c.fetched
+	c.mu.Unlock()
+	switch {
+	case f.err == nil:
+	case codes != nil:
+		log.Warnf("checking currency %s against the supported currencies fetched at %s: %v", code, fetched.Format(time.RFC3339), f.err)
+	case cs.rates != nil && money.CurrencyServiceUnavailable(f.err):
+		return cs.rates.Table.Has(code), nil
+	default:
+		return false, f.err
+	}
+	return codes[code], nil
+}
+
+// fetchSupportedCurrencies runs f, storing the currencies CurrencyService
+// supports in the cache if it succeeds.
+func (cs *checkoutService) fetchSupportedCurrencies(ctx context.Context, f *currenciesFetch) {
+	resp, err := pb.NewCurrencyServiceClient(cs.currencySvcConn).GetSupportedCurrencies(ctx, &pb.Empty{})
+	c := &cs.currencies
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.err = err; err == nil {
+		c.codes = make(map[string]bool, len(resp.GetCurrencyCodes()))
+		for _, code := range resp.GetCurrencyCodes() {
+			c.codes[code] = true
+		}
+		c.fetched = time.Now()
+	}
+	c.refresh = nil
+	close(f.done)
+}
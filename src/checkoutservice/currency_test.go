@@ -0,0 +1,183 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
+)
+
+func testExchangeRates(t *testing.T, primary bool) *money.ExchangeRates {
+	t.Helper()
+	table, err := money.NewRateTable("EUR", time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC), map[string]string{"USD": "1.25"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &money.ExchangeRates{Table: table, Primary: primary}
+}
+
+func TestPlaceOrderExchangeRates(t *testing.T) {
+	tests := []struct {
+		name       string
+		rates      bool
+		primary    bool
+		convertErr error
+		wantCode   codes.Code
+		wantStale  bool
+		wantRPCs   bool
+	}{
+		{"live rates", true, false, nil, codes.OK, false, true},
+		{"currency service down", true, false, status.Error(codes.Unavailable, "connection refused"), codes.OK, true, true},
+		{"currency service slow", true, false, status.Error(codes.DeadlineExceeded, "deadline exceeded"), codes.OK, true, true},
+		{"primary", true, true, nil, codes.OK, true, false},
+		{"currency service down without rates", false, false, status.Error(codes.Unavailable, "connection refused"), codes.Unavailable, false, true},
+		{"currency refused", true, false, status.Error(codes.InvalidArgument, "unsupported currency EUR"), codes.InvalidArgument, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newFakeDeps()
+			deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+			deps.convertErr = tt.convertErr
+			cs := newTestCheckout(t, deps)
+			if tt.rates {
+				cs.rates = testExchangeRates(t, tt.primary)
+			}
+			req := testOrderRequest("u1")
+			req.UserCurrency = "EUR"
+
+			resp, err := cs.PlaceOrder(context.Background(), req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("PlaceOrder() err = %v, want code %v", err, tt.wantCode)
+			}
+			if (deps.converts > 0) != tt.wantRPCs {
+				t.Errorf("made %d Convert calls, want any: %v", deps.converts, tt.wantRPCs)
+			}
+			if err != nil {
+				return
+			}
+			order := resp.GetOrder()
+			if order.GetStaleExchangeRates() != tt.wantStale {
+				t.Errorf("stale exchange rates = %v, want %v", order.GetStaleExchangeRates(), tt.wantStale)
+			}
+			if !tt.wantStale {
+				if order.GetExchangeRatesAsOf() != nil {
+					t.Errorf("exchange rates as of %v, want unset", order.GetExchangeRatesAsOf().AsTime())
+				}
+				return
+			}
+			if got := order.GetExchangeRatesAsOf().AsTime(); !got.Equal(cs.rates.Table.AsOf) {
+				t.Errorf("exchange rates as of %v, want %v", got, cs.rates.Table.AsOf)
+			}
+			// 2 x 19.99 / 1.25 + 8.99 / 1.25 shipping = 39.176
+			if got := deps.charges[0].GetAmount(); got.GetCurrencyCode() != "EUR" || got.GetUnits() != 39 || got.GetNanos() != 180000000 {
+				t.Errorf("charged %d.%09d %s, want 39.180000000 EUR", got.GetUnits(), got.GetNanos(), got.GetCurrencyCode())
+			}
+		})
+	}
+}
+
+func TestPreviewOrderMarksStaleExchangeRates(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	deps.convertErr = status.Error(codes.Unavailable, "connection refused")
+	cs := newTestCheckout(t, deps)
+	cs.rates = testExchangeRates(t, false)
+	cs.rates.Table.AsOf = time.Time{}
+
+	req := testOrderRequest("u1")
+	resp, err := cs.PreviewOrder(context.Background(), &pb.PreviewOrderRequest{
+		UserId: "u1", UserCurrency: "EUR", Address: req.GetAddress()})
+	if err != nil {
+		t.Fatalf("PreviewOrder() failed: %v", err)
+	}
+	if !resp.GetOrder().GetStaleExchangeRates() {
+		t.Error("preview priced with the snapshot is not marked as using stale exchange rates")
+	}
+	if resp.GetOrder().GetExchangeRatesAsOf() != nil {
+		t.Error("preview has an exchange rates date from a snapshot without one")
+	}
+}
+
+func TestCurrencySupportedFallsBackToExchangeRates(t *testing.T) {
+	deps := newFakeDeps()
+	deps.currenciesErr = status.Error(codes.Unavailable, "connection refused")
+	cs := newTestCheckout(t, deps)
+	cs.rates = testExchangeRates(t, false)
+
+	for code, want := range map[string]bool{"USD": true, "EUR": true, "JPY": false} {
+		got, err := cs.currencySupported(context.Background(), code)
+		if err != nil {
+			t.Fatalf("currencySupported(%s) failed: %v", code, err)
+		}
+		if got != want {
+			t.Errorf("currencySupported(%s) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestCurrencySupportedKeepsExpiredCurrencies(t *testing.T) {
+	deps := newFakeDeps()
+	cs := newTestCheckout(t, deps)
+	ctx := context.Background()
+	if ok, err := cs.currencySupported(ctx, "EUR"); err != nil || !ok {
+		t.Fatalf("currencySupported(EUR) = %v, %v; want true", ok, err)
+	}
+	cs.currencies.fetched = time.Now().Add(-2 * supportedCurrenciesTTL)
+
+	// While one caller refreshes the expired set, others are answered from it.
+	refreshing, release := make(chan struct{}), make(chan struct{})
+	deps.onCurrencies = func() {
+		close(refreshing)
+		<-release
+	}
+	deps.currenciesErr = status.Error(codes.Internal, "currencies are broken")
+	refreshed := make(chan error)
+	go func() {
+		_, err := cs.currencySupported(ctx, "EUR")
+		refreshed <- err
+	}()
+	<-refreshing
+	if ok, err := cs.currencySupported(ctx, "CAD"); err != nil || !ok {
+		t.Errorf("currencySupported(CAD) during a refresh = %v, %v; want true", ok, err)
+	}
+	close(release)
+
+	// A failed refresh keeps the expired set.
+	if err := <-refreshed; err != nil {
+		t.Errorf("currencySupported(EUR) failed when the refresh failed: %v", err)
+	}
+	deps.onCurrencies = nil
+	for code, want := range map[string]bool{"JPY": true, "XYZ": false} {
+		if got, err := cs.currencySupported(ctx, code); err != nil || got != want {
+			t.Errorf("currencySupported(%s) after a failed refresh = %v, %v; want %v", code, got, err, want)
+		}
+	}
+}
+
+func TestCurrencySupportedFailsWithoutCurrencies(t *testing.T) {
+	deps := newFakeDeps()
+	deps.currenciesErr = status.Error(codes.Internal, "currencies are broken")
+	cs := newTestCheckout(t, deps)
+	if _, err := cs.currencySupported(context.Background(), "EUR"); status.Code(err) != codes.Internal {
+		t.Errorf("currencySupported(EUR) error = %v, want Internal", err)
+	}
+}
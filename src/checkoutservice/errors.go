@@ -0,0 +1,126 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// errorDomain is the domain of the ErrorInfo checkout attaches to errors.
+const errorDomain = "checkoutservice"
+
+// Reasons of checkout errors, in their ErrorInfo. Clients should tell
+// failures apart by these rather than by messages.
+const (
+	reasonCardDeclined        = "CARD_DECLINED"
+	reasonProductNotFound     = "PRODUCT_NOT_FOUND"
+	reasonOutOfStock          = "OUT_OF_STOCK"
+	reasonShippingUnavailable = "SHIPPING_UNAVAILABLE"
+	reasonCurrencyUnsupported = "CURRENCY_UNSUPPORTED"
+)
+
+// detailedError returns a status error with code and msg carrying an
+// ErrorInfo with reason and metadata, followed by details.
+func detailedError(code codes.Code, reason string, metadata map[string]string, msg string, details ...protoadapt.MessageV1) error {
+	st := status.New(code, msg)
+	details = append([]protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: reason, Domain: errorDomain, Metadata: metadata}}, details...)
+	if d, err := st.WithDetails(details...); err == nil {
+		st = d
+	}
+	return st.Err()
+}
+
+// asStatus returns err if it is a status already, such as one from
+// upstreamError or detailedError, and otherwise a status with code whose
+// message is msg followed by err.
+func asStatus(err error, code codes.Code, msg string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Errorf(code, "%s: %+v", msg, err)
+}
+
+// upstreamError returns the status for a failed call to another service.
+// It is UNAVAILABLE when that service was, as the order may go through if
+// retried, and INTERNAL otherwise.
+func upstreamError(err error, format string, args ...interface{}) error {
+	code := codes.Internal
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		code = codes.Unavailable
+	}
+	return status.Errorf(code, "%s: %+v", fmt.Sprintf(format, args...), err)
+}
+
+// cardDeclinedError returns the status for a card the payment service
+// refused with err.
+func cardDeclinedError(err error) error {
+	msg := status.Convert(err).Message()
+	return detailedError(codes.FailedPrecondition, reasonCardDeclined, nil, "card declined: "+msg,
+		&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{
+			{Type: "PAYMENT", Subject: "credit_card", Description: msg},
+		}})
+}
+
+// productNotFoundError returns the status for a cart holding a product
+// that is no longer in the catalog.
+func productNotFoundError(productID string) error {
+	return detailedError(codes.FailedPrecondition, reasonProductNotFound, map[string]string{"product_id": productID},
+		fmt.Sprintf("product %q in the cart is not in the catalog", productID),
+		&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{
+			{Type: "PRODUCT", Subject: productID, Description: "product is not in the catalog"},
+		}})
+}
+
+// outOfStockError returns the status for a cart the catalog could not
+// reserve stock for, as err explains.
+func outOfStockError(err error) error {
+	msg := status.Convert(err).Message()
+	return detailedError(codes.FailedPrecondition, reasonOutOfStock, nil, "could not reserve stock: "+msg,
+		&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{
+			{Type: "STOCK", Subject: "cart", Description: msg},
+		}})
+}
+
+// shippingError returns the status for a failed call to the shipping
+// service. An address it refused is FAILED_PRECONDITION; otherwise the
+// service is taken to be down and the error is UNAVAILABLE.
+func shippingError(err error, msg string) error {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return detailedError(codes.FailedPrecondition, reasonShippingUnavailable, nil, msg+": "+st.Message(),
+			&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{
+				{Type: "SHIPPING", Subject: "address", Description: st.Message()},
+			}})
+	}
+	return detailedError(codes.Unavailable, reasonShippingUnavailable, nil, fmt.Sprintf("%s: %+v", msg, err))
+}
+
+// currencyUnsupportedError returns the status for an order in a currency
+// the currency service cannot convert to.
+func currencyUnsupportedError(currency string, err error) error {
+	msg := status.Convert(err).Message()
+	return detailedError(codes.InvalidArgument, reasonCurrencyUnsupported, map[string]string{"currency_code": currency},
+		fmt.Sprintf("currency %q is not supported: %s", currency, msg),
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "user_currency", Description: fmt.Sprintf("currency %q is not supported", currency)},
+		}})
+}
@@ -0,0 +1,98 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+func TestPlaceOrderErrorDetails(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(d *fakeDeps, req *pb.PlaceOrderRequest)
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{"card declined", func(d *fakeDeps, _ *pb.PlaceOrderRequest) {
+			d.chargeErr = status.Error(codes.InvalidArgument, "Credit card info is invalid")
+		}, codes.FailedPrecondition, reasonCardDeclined},
+		{"product not found", func(d *fakeDeps, _ *pb.PlaceOrderRequest) {
+			d.carts["u1"] = append(d.carts["u1"], &pb.CartItem{ProductId: "GONE", Quantity: 1})
+		}, codes.FailedPrecondition, reasonProductNotFound},
+		{"out of stock", func(d *fakeDeps, _ *pb.PlaceOrderRequest) {
+			d.stock["OLJCESPC7Z"] = 0
+		}, codes.FailedPrecondition, reasonOutOfStock},
+		{"product not in inventory", func(d *fakeDeps, _ *pb.PlaceOrderRequest) {
+			delete(d.stock, "OLJCESPC7Z")
+		}, codes.FailedPrecondition, reasonProductNotFound},
+		{"shipping down", func(d *fakeDeps, _ *pb.PlaceOrderRequest) {
+			d.shipErr = status.Error(codes.Unavailable, "no trucks")
+		}, codes.Unavailable, reasonShippingUnavailable},
+		{"address refused", func(d *fakeDeps, _ *pb.PlaceOrderRequest) {
+			d.shipErr = status.Error(codes.InvalidArgument, "no deliveries to the moon")
+		}, codes.FailedPrecondition, reasonShippingUnavailable},
+		{"unsupported currency", func(d *fakeDeps, req *pb.PlaceOrderRequest) {
+			req.UserCurrency = "EUR"
+			d.convertErr = status.Error(codes.InvalidArgument, "unsupported currency EUR")
+		}, codes.InvalidArgument, reasonCurrencyUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := newFakeDeps()
+			deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+			req := testOrderRequest("u1")
+			tt.setup(deps, req)
+			cs := newTestCheckout(t, deps)
+
+			_, err := cs.PlaceOrder(context.Background(), req)
+			st := status.Convert(err)
+			if st.Code() != tt.wantCode {
+				t.Errorf("PlaceOrder() error = %v, want code %v", err, tt.wantCode)
+			}
+			var info *errdetails.ErrorInfo
+			for _, d := range st.Details() {
+				if i, ok := d.(*errdetails.ErrorInfo); ok {
+					info = i
+				}
+			}
+			if info.GetReason() != tt.wantReason || info.GetDomain() != errorDomain {
+				t.Errorf("PlaceOrder() error info = %v, want reason %s", info, tt.wantReason)
+			}
+			if len(st.Details()) < 2 && tt.wantCode != codes.Unavailable {
+				t.Errorf("PlaceOrder() error details = %v, want a violation besides the error info", st.Details())
+			}
+		})
+	}
+}
+
+func TestAsStatus(t *testing.T) {
+	typed := productNotFoundError("X")
+	if got := asStatus(typed, codes.Internal, "failed"); got != typed {
+		t.Errorf("asStatus() = %v, want the status unchanged", got)
+	}
+	if got := status.Code(asStatus(context.Canceled, codes.Internal, "failed")); got != codes.Internal {
+		t.Errorf("asStatus(plain error) code = %v, want %v", got, codes.Internal)
+	}
+	if got := status.Code(upstreamError(status.Error(codes.DeadlineExceeded, "slow"), "failed")); got != codes.Unavailable {
+		t.Errorf("upstreamError(deadline) code = %v, want %v", got, codes.Unavailable)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+const (
+	// idempotencyTTL is how long a completed PlaceOrder response is kept for
+	// replays of the same idempotency key.
+	idempotencyTTL = 24 * time.Hour
+
+	maxIdempotencyKeyLen = 128
+)
+
+var (
+	errIdempotencyConflict    = errors.New("idempotency key was already used for a different request")
+	errIdempotencyInFlight    = errors.New("a request with this idempotency key is still being processed")
+	errIdempotencyInterrupted = errors.New("a request with this idempotency key was interrupted and is not settled yet")
+)
+
+// idempotencyStore remembers the outcome of PlaceOrder requests by their
+// idempotency key so that retried requests do not charge the customer twice.
+type idempotencyStore interface {
+	// Begin claims key for a request with the given fingerprint that will
+	// place the order orderID. If the key already completed with the same
+	// fingerprint, its response is returned and the caller must not process
+	// the request again. It returns errIdempotencyConflict if the key was
+	// used with another fingerprint, errIdempotencyInFlight if the first
+	// request is still running and errIdempotencyInterrupted if it was
+	// interrupted by a restart and has not been settled since.
+	Begin(key, fingerprint, orderID string) (*pb.PlaceOrderResponse, error)
+	// Complete stores the response of a claimed key.
+	Complete(key string, resp *pb.PlaceOrderResponse) error
+	// Release forgets a claimed key whose request failed so that it can be
+	// retried.
+	Release(key string) error
+	// Interrupted returns the claims of requests that were in flight when
+	// the previous process stopped. They stay unsettled until completed or
+	// released.
+	Interrupted() []idempotencyRecord
+}
+
+// idempotencyRecord is a claimed key. Response is the protojson encoding of
+// the PlaceOrderResponse, and is empty while the request is in flight.
+// Released records only appear in the journal, where they undo a claim.
+type idempotencyRecord struct {
+	Key         string          `json:"key"`
+	Fingerprint string          `json:"fingerprint,omitempty"`
+	OrderID     string          `json:"order_id,omitempty"`
+	Response    json.RawMessage `json:"response,omitempty"`
+	Released    bool            `json:"released,omitempty"`
+	Expires     time.Time       `json:"expires"`
+
+	// interrupted marks a claim that a previous process never settled.
+	interrupted bool
+}
+
+// requestFingerprint hashes everything in req except its idempotency key.
+func requestFingerprint(req *pb.PlaceOrderRequest) (string, error) {
+	r := proto.Clone(req).(*pb.PlaceOrderRequest)
+	r.IdempotencyKey = ""
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// memoryIdempotencyStore keeps records in memory. It is used in tests and
+// as the index of fileIdempotencyStore.
+type memoryIdempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]*idempotencyRecord
+	now       func() time.Time
+	nextSweep time.Time
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: make(map[string]*idempotencyRecord), now: time.Now}
+}
+
+func (s *memoryIdempotencyStore) Begin(key, fingerprint, orderID string) (*pb.PlaceOrderResponse, error) {
+	resp, _, err := s.begin(key, fingerprint, orderID)
+	return resp, err
+}
+
+// begin is Begin that also returns the record of a new claim.
+func (s *memoryIdempotencyStore) begin(key, fingerprint, orderID string) (*pb.PlaceOrderResponse, idempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.sweep(now)
+	if r, ok := s.records[key]; ok && now.Before(r.Expires) {
+		switch {
+		case r.Fingerprint != fingerprint:
+			return nil, idempotencyRecord{}, errIdempotencyConflict
+		case len(r.Response) == 0 && r.interrupted:
+			return nil, idempotencyRecord{}, errIdempotencyInterrupted
+		case len(r.Response) == 0:
+			return nil, idempotencyRecord{}, errIdempotencyInFlight
+		}
+		resp := new(pb.PlaceOrderResponse)
+		if err := protojson.Unmarshal(r.Response, resp); err != nil {
+			return nil, idempotencyRecord{}, fmt.Errorf("failed to decode stored response: %w", err)
+		}
+		return resp, idempotencyRecord{}, nil
+	}
+	r := &idempotencyRecord{Key: key, Fingerprint: fingerprint, OrderID: orderID, Expires: now.Add(idempotencyTTL)}
+	s.records[key] = r
+	return nil, *r, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(key string, resp *pb.PlaceOrderResponse) error {
+	_, err := s.complete(key, resp)
+	return err
+}
+
+// complete stores resp for key and returns the completed record.
+func (s *memoryIdempotencyStore) complete(key string, resp *pb.PlaceOrderResponse) (idempotencyRecord, error) {
+	b, err := protojson.Marshal(resp)
+	if err != nil {
+		return idempotencyRecord{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[key]
+	if !ok {
+		return idempotencyRecord{}, fmt.Errorf("idempotency key %q was not claimed", key)
+	}
+	r.Response = b
+	r.Expires = s.now().Add(idempotencyTTL)
+	r.interrupted = false
+	return *r, nil
+}
+
+func (s *memoryIdempotencyStore) Release(key string) error {
+	s.release(key)
+	return nil
+}
+
+// release forgets key if it is claimed and reports whether it was.
+func (s *memoryIdempotencyStore) release(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.records[key]; ok && len(r.Response) == 0 {
+		delete(s.records, key)
+		return true
+	}
+	return false
+}
+
+func (s *memoryIdempotencyStore) Interrupted() []idempotencyRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []idempotencyRecord
+	for _, r := range s.records {
+		if r.interrupted {
+			out = append(out, *r)
+		}
+	}
+	return out
+}
+
+// load applies a record replayed from disk. Claims that were not completed
+// belong to requests a previous process did not finish.
+func (s *memoryIdempotencyStore) load(r idempotencyRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Released {
+		delete(s.records, r.Key)
+		return
+	}
+	r.interrupted = len(r.Response) == 0
+	s.records[r.Key] = &r
+}
+
+// live returns the unexpired records.
+func (s *memoryIdempotencyStore) live() []idempotencyRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var out []idempotencyRecord
+	for _, r := range s.records {
+		if now.Before(r.Expires) {
+			out = append(out, *r)
+		}
+	}
+	return out
+}
+
+// sweep drops expired records, at most once a minute.
+func (s *memoryIdempotencyStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	for k, r := range s.records {
+		if !now.Before(r.Expires) {
+			delete(s.records, k)
+		}
+	}
+	s.nextSweep = now.Add(time.Minute)
+}
+
+// fileIdempotencyStore journals claims and completed responses to a file so
+// that replays are recognised across restarts. Claims of requests that were
+// in flight during a crash come back interrupted, and are settled once the
+// saga log has settled their orders; see settleInterruptedOrders.
+type fileIdempotencyStore struct {
+	*memoryIdempotencyStore
+	j *journal[idempotencyRecord]
+}
+
+func newFileIdempotencyStore(path string) (*fileIdempotencyStore, error) {
+	mem := newMemoryIdempotencyStore()
+	j, err := openJournal(path, mem.load)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open idempotency store: %w", err)
+	}
+	if err := j.Rewrite(mem.live()); err != nil {
+		return nil, fmt.Errorf("failed to compact idempotency store: %w", err)
+	}
+	return &fileIdempotencyStore{memoryIdempotencyStore: mem, j: j}, nil
+}
+
+func (s *fileIdempotencyStore) Begin(key, fingerprint, orderID string) (*pb.PlaceOrderResponse, error) {
+	resp, r, err := s.memoryIdempotencyStore.begin(key, fingerprint, orderID)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+	if err := s.j.Append(r); err != nil {
+		s.memoryIdempotencyStore.release(key)
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (s *fileIdempotencyStore) Complete(key string, resp *pb.PlaceOrderResponse) error {
+	r, err := s.memoryIdempotencyStore.complete(key, resp)
+	if err != nil {
+		return err
+	}
+	return s.j.Append(r)
+}
+
+func (s *fileIdempotencyStore) Release(key string) error {
+	if !s.memoryIdempotencyStore.release(key) {
+		return nil
+	}
+	return s.j.Append(idempotencyRecord{Key: key, Released: true, Expires: s.now().Add(idempotencyTTL)})
+}
+
+// Close closes the store's file.
+func (s *fileIdempotencyStore) Close() error { return s.j.Close() }
+
+// settleInterruptedOrders settles the idempotency keys of orders that were
+// being placed when the previous process stopped, once recoverSagas has
+// settled the orders themselves. A replay then gets the order if it went
+// through, or places it afresh if it was undone. Keys of orders whose saga
+// is still pending stay unsettled, and replays of them fail.
+func (cs *checkoutService) settleInterruptedOrders(ctx context.Context) error {
+	pending, err := cs.sagaLog.Pending()
+	if err != nil {
+		return fmt.Errorf("failed to read saga log: %w", err)
+	}
+	unsettled := make(map[string]bool)
+	for _, evs := range pending {
+		unsettled[evs[0].SagaID] = true
+	}
+	for _, r := range cs.idempotency.Interrupted() {
+		if unsettled[r.OrderID] {
+			log.Warnf("idempotency key %q: order %s is not settled yet", r.Key, r.OrderID)
+			continue
+		}
+		order, err := cs.orders.Get(ctx, r.OrderID)
+		switch {
+		case errors.Is(err, errOrderNotFound),
+			err == nil && (order.GetStatus() == pb.OrderStatus_ORDER_STATUS_CANCELLED || order.GetStatus() == pb.OrderStatus_ORDER_STATUS_REFUNDED):
+			err = cs.idempotency.Release(r.Key)
+		case err != nil:
+			return fmt.Errorf("failed to look up order %s: %w", r.OrderID, err)
+		default:
+			err = cs.idempotency.Complete(r.Key, &pb.PlaceOrderResponse{Order: order})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to settle idempotency key %q: %w", r.Key, err)
+		}
+	}
+	return nil
+}
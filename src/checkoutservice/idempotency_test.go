@@ -0,0 +1,184 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+func TestPlaceOrderReplaysIdempotentRequest(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	cs := newTestCheckout(t, deps)
+	req := testOrderRequest("u1")
+	req.IdempotencyKey = "k1"
+
+	first, err := cs.PlaceOrder(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cs.PlaceOrder(context.Background(), req)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if !proto.Equal(first, second) {
+		t.Errorf("replay returned %v, want %v", second, first)
+	}
+	if len(deps.charges) != 1 {
+		t.Errorf("card charged %d times, want 1", len(deps.charges))
+	}
+
+	other := testOrderRequest("u1")
+	other.IdempotencyKey = "k1"
+	other.Email = "someone-else@example.com"
+	if _, err := cs.PlaceOrder(context.Background(), other); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("conflicting replay error = %v, want code %v", err, codes.AlreadyExists)
+	}
+}
+
+func TestPlaceOrderReleasesKeyOnFailure(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	deps.chargeErr = status.Error(codes.InvalidArgument, "card declined")
+	cs := newTestCheckout(t, deps)
+	req := testOrderRequest("u1")
+	req.IdempotencyKey = "k1"
+
+	if _, err := cs.PlaceOrder(context.Background(), req); err == nil {
+		t.Fatal("PlaceOrder() succeeded, want error")
+	}
+	deps.chargeErr = nil
+	if _, err := cs.PlaceOrder(context.Background(), req); err != nil {
+		t.Errorf("retry after failure: %v", err)
+	}
+}
+
+func TestIdempotencyStoreInFlightAndExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := newMemoryIdempotencyStore()
+	s.now = func() time.Time { return now }
+
+	if _, err := s.Begin("k", "fp", "o1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Begin("k", "fp", "o2"); err != errIdempotencyInFlight {
+		t.Errorf("Begin() on in-flight key error = %v, want %v", err, errIdempotencyInFlight)
+	}
+	if err := s.Complete("k", &pb.PlaceOrderResponse{Order: &pb.OrderResult{OrderId: "o1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(idempotencyTTL + time.Second)
+	prev, err := s.Begin("k", "other", "o3")
+	if err != nil || prev != nil {
+		t.Errorf("Begin() on expired key = %v, %v; want nil, nil", prev, err)
+	}
+}
+
+func TestFileIdempotencyStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.log")
+	s, err := newFileIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Begin("done", "fp", "o1")
+	s.Complete("done", &pb.PlaceOrderResponse{Order: &pb.OrderResult{OrderId: "o1"}})
+	s.Begin("failed", "fp", "o2")
+	s.Release("failed")
+	s.Begin("in-flight", "fp", "o3")
+	s.Close()
+
+	s, err = newFileIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	prev, err := s.Begin("done", "fp", "o4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prev.GetOrder().GetOrderId(); got != "o1" {
+		t.Errorf("replayed order %q, want %q", got, "o1")
+	}
+	if prev, err := s.Begin("in-flight", "fp", "o5"); prev != nil || err != errIdempotencyInterrupted {
+		t.Errorf("Begin() on key in flight at restart = %v, %v; want nil, %v", prev, err, errIdempotencyInterrupted)
+	}
+	if got := s.Interrupted(); len(got) != 1 || got[0].Key != "in-flight" || got[0].OrderID != "o3" {
+		t.Errorf("Interrupted() = %v, want the claim of in-flight for o3", got)
+	}
+	if prev, err := s.Begin("failed", "fp", "o6"); prev != nil || err != nil {
+		t.Errorf("Begin() on released key = %v, %v; want nil, nil", prev, err)
+	}
+}
+
+func TestSettleInterruptedOrders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.log")
+	s, err := newFileIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, orderID := range map[string]string{"placed": "o1", "undone": "o2", "cancelled": "o3", "pending": "o4"} {
+		if _, err := s.Begin(key, "fp", orderID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+	if s, err = newFileIdempotencyStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	deps := newFakeDeps()
+	cs := newTestCheckout(t, deps)
+	cs.idempotency = s
+	ctx := context.Background()
+	for _, o := range []*pb.OrderResult{
+		{OrderId: "o1", Status: pb.OrderStatus_ORDER_STATUS_SHIPPED},
+		{OrderId: "o3", Status: pb.OrderStatus_ORDER_STATUS_CANCELLED},
+	} {
+		if err := cs.orders.Create(ctx, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// o4's charge could not be refunded, so its saga is still pending.
+	if _, err := newSaga("o4", cs.sagaLog, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cs.settleInterruptedOrders(ctx); err != nil {
+		t.Fatal(err)
+	}
+	prev, err := s.Begin("placed", "fp", "o5")
+	if err != nil || prev.GetOrder().GetOrderId() != "o1" {
+		t.Errorf("Begin(placed) = %v, %v; want the response of o1", prev, err)
+	}
+	for _, key := range []string{"undone", "cancelled"} {
+		if prev, err := s.Begin(key, "fp", "o6"); prev != nil || err != nil {
+			t.Errorf("Begin(%s) = %v, %v; want a new claim", key, prev, err)
+		}
+	}
+	if _, err := s.Begin("pending", "fp", "o7"); err != errIdempotencyInterrupted {
+		t.Errorf("Begin(pending) error = %v, want %v", err, errIdempotencyInterrupted)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// journal is an append-only file of JSON records, one per line. Appends are
+// fsynced before they return. Callers keep their own in-memory view of the
+// records and periodically Rewrite the file with only what they still need.
+type journal[T any] struct {
+	mu   sync.Mutex
+	f    *os.File
+	path string
+}
+
+// openJournal replays every record in path through replay and opens the
+// file for appending, creating it if needed.
+func openJournal[T any](path string, replay func(T)) (*journal[T], error) {
+	if err := replayJournal(path, replay); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return &journal[T]{f: f, path: path}, nil
+}
+
+func replayJournal[T any](path string, replay func(T)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		var v T
+		if err := json.Unmarshal(sc.Bytes(), &v); err != nil {
+			// A torn final write from a crash is expected; anything before
+			// it has already been fsynced and is intact.
+			log.Warnf("skipping unreadable record in %s: %v", path, err)
+			continue
+		}
+		replay(v)
+	}
+	return sc.Err()
+}
+
+// Append durably writes v to the end of the journal.
+func (j *journal[T]) Append(v T) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write %s: %w", j.path, err)
+	}
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", j.path, err)
+	}
+	return nil
+}
+
+// Rewrite atomically replaces the journal's contents with vs.
+func (j *journal[T]) Rewrite(vs []T) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to rewrite %s: %w", j.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, v := range vs {
+		if err := enc.Encode(v); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen %s: %w", j.path, err)
+	}
+	j.f.Close()
+	j.f = f
+	return nil
+}
+
+// Close closes the underlying file.
+func (j *journal[T]) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
@@ -0,0 +1,396 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
+)
+
+var (
+	errBalanceNotFound = errors.New("no such balance")
+	errBalanceCurrency = errors.New("balance is in another currency")
+)
+
+// Reasons of errors paying with gift cards and store credit.
+const (
+	reasonBalanceUnavailable = "BALANCE_UNAVAILABLE"
+	reasonInsufficientFunds  = "INSUFFICIENT_FUNDS"
+)
+
+// giftCardCodeAlphabet leaves out letters and digits easily mistaken for
+// one another.
+const (
+	giftCardCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	giftCardCodeLen      = 16
+)
+
+// balanceAccount identifies a gift card by its code, or a user's store
+// credit by the user's ID.
+type balanceAccount struct {
+	Method pb.PaymentMethod `json:"method"`
+	ID     string           `json:"id"`
+}
+
+// balanceLedger keeps the balances of gift cards and store credit. Every
+// amount taken is recorded against the order that took it, so that it can
+// be given back exactly once.
+type balanceLedger interface {
+	// IssueBalance adds amount to the account, opening it if needed, and
+	// returns its new balance. It returns an error wrapping
+	// errBalanceCurrency if the account holds another currency.
+	IssueBalance(ctx context.Context, acct balanceAccount, amount *pb.Money) (*pb.Money, error)
+	// RedeemBalance atomically takes up to limit from the account for
+	// orderID and returns what it took, less than limit if the balance is.
+	// Redeeming again for the same order returns what was taken the first
+	// time without taking more. It returns errBalanceNotFound, or an error
+	// wrapping errBalanceCurrency if limit is in another currency.
+	RedeemBalance(ctx context.Context, acct balanceAccount, orderID string, limit *pb.Money) (*pb.Money, error)
+	// RefundBalance gives back what orderID took from the account.
+	// Refunding again, or for an order that took nothing, is a no-op.
+	RefundBalance(ctx context.Context, acct balanceAccount, orderID string) error
+	// Balance returns the account's balance, or errBalanceNotFound.
+	Balance(ctx context.Context, acct balanceAccount) (*pb.Money, error)
+}
+
+// takeBalance returns how much of limit balance covers.
+func takeBalance(balance, limit *pb.Money) (*pb.Money, error) {
+	if balance.GetCurrencyCode() != limit.GetCurrencyCode() {
+		return nil, fmt.Errorf("%w: %s, not %s", errBalanceCurrency, balance.GetCurrencyCode(), limit.GetCurrencyCode())
+	}
+	c, err := money.Compare(balance, limit)
+	if err != nil {
+		return nil, err
+	}
+	if c < 0 {
+		return copyMoney(balance), nil
+	}
+	return copyMoney(limit), nil
+}
+
+func copyMoney(m *pb.Money) *pb.Money {
+	return &pb.Money{Units: m.GetUnits(), Nanos: m.GetNanos(), CurrencyCode: m.GetCurrencyCode()}
+}
+
+// balanceEntry is what an order took from a balance.
+type balanceEntry struct {
+	amount   *pb.Money
+	refunded bool
+}
+
+type memoryBalance struct {
+	balance *pb.Money
+	// entries maps the orders that redeemed the balance to what they took.
+	entries map[string]*balanceEntry
+}
+
+func (r *memoryOrderRepository) IssueBalance(_ context.Context, acct balanceAccount, amount *pb.Money) (*pb.Money, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.balances[acct]
+	if !ok {
+		b = &memoryBalance{balance: &pb.Money{CurrencyCode: amount.GetCurrencyCode()}, entries: make(map[string]*balanceEntry)}
+		r.balances[acct] = b
+	}
+	if b.balance.GetCurrencyCode() != amount.GetCurrencyCode() {
+		return nil, fmt.Errorf("%w: %s, not %s", errBalanceCurrency, b.balance.GetCurrencyCode(), amount.GetCurrencyCode())
+	}
+	sum, err := money.Sum(b.balance, amount)
+	if err != nil {
+		return nil, err
+	}
+	b.balance = sum
+	return copyMoney(b.balance), nil
+}
+
+func (r *memoryOrderRepository) RedeemBalance(_ context.Context, acct balanceAccount, orderID string, limit *pb.Money) (*pb.Money, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.balances[acct]
+	if !ok {
+		return nil, errBalanceNotFound
+	}
+	if e, ok := b.entries[orderID]; ok {
+		return copyMoney(e.amount), nil
+	}
+	take, err := takeBalance(b.balance, limit)
+	if err != nil {
+		return nil, err
+	}
+	left, err := money.Sum(b.balance, money.Negate(take))
+	if err != nil {
+		return nil, err
+	}
+	b.balance = left
+	b.entries[orderID] = &balanceEntry{amount: take}
+	return copyMoney(take), nil
+}
+
+func (r *memoryOrderRepository) RefundBalance(_ context.Context, acct balanceAccount, orderID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.balances[acct]
+	if !ok {
+		return nil
+	}
+	e, ok := b.entries[orderID]
+	if !ok || e.refunded {
+		return nil
+	}
+	sum, err := money.Sum(b.balance, e.amount)
+	if err != nil {
+		return err
+	}
+	b.balance = sum
+	e.refunded = true
+	return nil
+}
+
+func (r *memoryOrderRepository) Balance(_ context.Context, acct balanceAccount) (*pb.Money, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	b, ok := r.balances[acct]
+	if !ok {
+		return nil, errBalanceNotFound
+	}
+	return copyMoney(b.balance), nil
+}
+
+// normalizeGiftCardCode returns code in upper case without the spaces and
+// dashes it is printed with.
+func normalizeGiftCardCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+func newGiftCardCode() (string, error) {
+	b := make([]byte, giftCardCodeLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = giftCardCodeAlphabet[int(b[i])%len(giftCardCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// instrumentAccount returns the balance an instrument of userID pays from.
+func instrumentAccount(in *pb.PaymentInstrument, userID string) balanceAccount {
+	if in.GetMethod() == pb.PaymentMethod_PAYMENT_METHOD_STORE_CREDIT {
+		return balanceAccount{Method: in.GetMethod(), ID: userID}
+	}
+	return balanceAccount{Method: in.GetMethod(), ID: normalizeGiftCardCode(in.GetId())}
+}
+
+// lastFour returns the last four characters of s, which identify a card or
+// gift card without revealing it.
+func lastFour(s string) string {
+	if len(s) <= 4 {
+		return s
+	}
+	return s[len(s)-4:]
+}
+
+// cardDigits returns the digits of a card number without its separators.
+func cardDigits(number string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, number)
+}
+
+// balanceRecord is what the saga log keeps about the balances an order
+// took from so they can be refunded.
+type balanceRecord struct {
+	OrderID  string           `json:"order_id"`
+	Accounts []balanceAccount `json:"accounts"`
+}
+
+// redeemBalances takes what it can of total from the order's gift cards,
+// then its store credit, and returns the payments made. If one of them
+// fails, those already taken are given back.
+func (cs *checkoutService) redeemBalances(ctx context.Context, orderID, userID string, instruments []*pb.PaymentInstrument, total *pb.Money) ([]*pb.OrderPayment, balanceRecord, error) {
+	rec := balanceRecord{OrderID: orderID}
+	ordered := make([]int, len(instruments))
+	for i := range ordered {
+		ordered[i] = i
+	}
+	sort.SliceStable(ordered, func(a, b int) bool {
+		return instruments[ordered[a]].GetMethod() == pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD &&
+			instruments[ordered[b]].GetMethod() != pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD
+	})
+
+	var payments []*pb.OrderPayment
+	remaining := total
+	for _, i := range ordered {
+		if money.IsZero(remaining) {
+			break
+		}
+		in := instruments[i]
+		limit := remaining
+		if max := in.GetMaxAmount(); max != nil {
+			var err error
+			if limit, err = takeBalance(max, remaining); err != nil {
+				return nil, rec, cs.undoRedeem(ctx, rec, status.Errorf(codes.InvalidArgument, "payment_instruments[%d].max_amount: %v", i, err))
+			}
+		}
+		acct := instrumentAccount(in, userID)
+		took, err := cs.orders.RedeemBalance(ctx, acct, orderID, limit)
+		if errors.Is(err, errBalanceNotFound) && acct.Method == pb.PaymentMethod_PAYMENT_METHOD_STORE_CREDIT {
+			// A user without store credit has none to use.
+			continue
+		} else if err != nil {
+			return nil, rec, cs.undoRedeem(ctx, rec, balanceError(i, in, err))
+		}
+		rec.Accounts = append(rec.Accounts, acct)
+		if money.IsZero(took) {
+			continue
+		}
+		p := &pb.OrderPayment{Method: acct.Method, Amount: took}
+		if acct.Method == pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD {
+			p.Instrument = lastFour(acct.ID)
+		}
+		payments = append(payments, p)
+		left, err := money.Sum(remaining, money.Negate(took))
+		if err != nil {
+			return nil, rec, cs.undoRedeem(ctx, rec, status.Errorf(codes.Internal, "failed to apply balance: %+v", err))
+		}
+		remaining = left
+	}
+	return payments, rec, nil
+}
+
+// undoRedeem gives back the balances rec took after redeeming another one
+// failed with err, and returns err.
+func (cs *checkoutService) undoRedeem(ctx context.Context, rec balanceRecord, err error) error {
+	for _, acct := range rec.Accounts {
+		if rerr := cs.orders.RefundBalance(ctx, acct, rec.OrderID); rerr != nil {
+			log.Warnf("failed to give back balance of order %s: %+v", rec.OrderID, rerr)
+		}
+	}
+	return err
+}
+
+func (cs *checkoutService) compensateBalances(ctx context.Context, data json.RawMessage, reason string) error {
+	var rec balanceRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return fmt.Errorf("failed to decode balance record: %+v", err)
+	}
+	for _, acct := range rec.Accounts {
+		if err := cs.orders.RefundBalance(ctx, acct, rec.OrderID); err != nil {
+			return fmt.Errorf("failed to refund balance of order %s: %+v", rec.OrderID, err)
+		}
+	}
+	if len(rec.Accounts) > 0 {
+		log.Infof("balances refunded (order_id: %s)", rec.OrderID)
+		cs.markRefunded(ctx, rec.OrderID, reason, "refunded to gift card or store credit")
+	}
+	return nil
+}
+
+// balanceError returns the status for instrument i of an order that could
+// not be redeemed because of err.
+func balanceError(i int, in *pb.PaymentInstrument, err error) error {
+	if !errors.Is(err, errBalanceNotFound) && !errors.Is(err, errBalanceCurrency) {
+		return status.Errorf(codes.Internal, "failed to redeem balance: %+v", err)
+	}
+	desc := "gift card not found"
+	if errors.Is(err, errBalanceCurrency) {
+		desc = err.Error()
+	}
+	return detailedError(codes.FailedPrecondition, reasonBalanceUnavailable,
+		map[string]string{"method": in.GetMethod().String()}, "cannot pay with balance: "+desc,
+		&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{
+			{Type: "PAYMENT", Subject: fmt.Sprintf("payment_instruments[%d]", i), Description: desc},
+		}})
+}
+
+// insufficientFundsError returns the status for an order whose gift cards
+// and store credit leave remaining to pay and which has no card to charge.
+func insufficientFundsError(remaining *pb.Money) error {
+	desc := fmt.Sprintf("%d.%09d %s is left to pay and no credit card was given",
+		remaining.GetUnits(), remaining.GetNanos(), remaining.GetCurrencyCode())
+	return detailedError(codes.FailedPrecondition, reasonInsufficientFunds, nil, "insufficient funds: "+desc,
+		&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{
+			{Type: "PAYMENT", Subject: "credit_card", Description: desc},
+		}})
+}
+
+// balanceRequestAccount checks the account named in an admin request.
+func balanceRequestAccount(method pb.PaymentMethod, id string) (balanceAccount, error) {
+	switch method {
+	case pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD:
+		return balanceAccount{Method: method, ID: normalizeGiftCardCode(id)}, nil
+	case pb.PaymentMethod_PAYMENT_METHOD_STORE_CREDIT:
+		if id == "" {
+			return balanceAccount{}, status.Error(codes.InvalidArgument, "store credit needs a user ID")
+		}
+		return balanceAccount{Method: method, ID: id}, nil
+	}
+	return balanceAccount{}, status.Errorf(codes.InvalidArgument, "%v has no balance", method)
+}
+
+func (cs *checkoutService) IssueBalance(ctx context.Context, req *pb.IssueBalanceRequest) (*pb.IssueBalanceResponse, error) {
+	log.Infof("[IssueBalance] method=%v", req.GetMethod())
+	acct, err := balanceRequestAccount(req.GetMethod(), req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	amount := req.GetAmount()
+	if amount == nil || !money.IsValid(amount) || !money.IsPositive(amount) || len(amount.GetCurrencyCode()) != 3 {
+		return nil, status.Error(codes.InvalidArgument, "amount must be a positive amount of a currency")
+	}
+	if acct.Method == pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD && acct.ID == "" {
+		if acct.ID, err = newGiftCardCode(); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to generate gift card code: %+v", err)
+		}
+	}
+	balance, err := cs.orders.IssueBalance(ctx, acct, amount)
+	switch {
+	case errors.Is(err, errBalanceCurrency):
+		return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "failed to issue balance: %+v", err)
+	}
+	return &pb.IssueBalanceResponse{Account: &pb.BalanceAccount{Method: acct.Method, Id: acct.ID, Balance: balance}}, nil
+}
+
+func (cs *checkoutService) GetBalance(ctx context.Context, req *pb.GetBalanceRequest) (*pb.GetBalanceResponse, error) {
+	acct, err := balanceRequestAccount(req.GetMethod(), req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	balance, err := cs.orders.Balance(ctx, acct)
+	switch {
+	case errors.Is(err, errBalanceNotFound):
+		return nil, status.Errorf(codes.NotFound, "no %v %q", acct.Method, acct.ID)
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "failed to get balance: %+v", err)
+	}
+	return &pb.GetBalanceResponse{Account: &pb.BalanceAccount{Method: acct.Method, Id: acct.ID, Balance: balance}}, nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
+)
+
+const ledgerSchema = `
+CREATE TABLE IF NOT EXISTS balances (
+	method     INTEGER NOT NULL,
+	id         TEXT NOT NULL,
+	currency   TEXT NOT NULL,
+	units      INTEGER NOT NULL,
+	nanos      INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (method, id)
+);
+CREATE TABLE IF NOT EXISTS balance_entries (
+	method     INTEGER NOT NULL,
+	id         TEXT NOT NULL,
+	order_id   TEXT NOT NULL,
+	units      INTEGER NOT NULL,
+	nanos      INTEGER NOT NULL,
+	refunded   INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY (method, id, order_id)
+);
+`
+
+// queryBalance returns the balance of acct within tx.
+func queryBalance(ctx context.Context, tx *sql.Tx, acct balanceAccount) (*pb.Money, error) {
+	m := new(pb.Money)
+	err := tx.QueryRowContext(ctx, `SELECT currency, units, nanos FROM balances WHERE method = ? AND id = ?`,
+		int32(acct.Method), acct.ID).Scan(&m.CurrencyCode, &m.Units, &m.Nanos)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errBalanceNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query balance: %w", err)
+	}
+	return m, nil
+}
+
+// addBalance adds delta to the balance of acct within tx.
+func addBalance(ctx context.Context, tx *sql.Tx, acct balanceAccount, balance, delta *pb.Money) (*pb.Money, error) {
+	sum, err := money.Sum(balance, delta)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE balances SET units = ?, nanos = ?, updated_at = ? WHERE method = ? AND id = ?`,
+		sum.GetUnits(), sum.GetNanos(), time.Now().UnixNano(), int32(acct.Method), acct.ID); err != nil {
+		return nil, fmt.Errorf("failed to update balance: %w", err)
+	}
+	return copyMoney(sum), nil
+}
+
+func (r *sqlOrderRepository) IssueBalance(ctx context.Context, acct balanceAccount, amount *pb.Money) (*pb.Money, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO balances (method, id, currency, units, nanos, updated_at) VALUES (?, ?, ?, 0, 0, ?) ON CONFLICT (method, id) DO NOTHING`,
+		int32(acct.Method), acct.ID, amount.GetCurrencyCode(), time.Now().UnixNano()); err != nil {
+		return nil, fmt.Errorf("failed to open balance: %w", err)
+	}
+	balance, err := queryBalance(ctx, tx, acct)
+	if err != nil {
+		return nil, err
+	}
+	if balance.GetCurrencyCode() != amount.GetCurrencyCode() {
+		return nil, fmt.Errorf("%w: %s, not %s", errBalanceCurrency, balance.GetCurrencyCode(), amount.GetCurrencyCode())
+	}
+	if balance, err = addBalance(ctx, tx, acct, balance, amount); err != nil {
+		return nil, err
+	}
+	return balance, tx.Commit()
+}
+
+func (r *sqlOrderRepository) RedeemBalance(ctx context.Context, acct balanceAccount, orderID string, limit *pb.Money) (*pb.Money, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	balance, err := queryBalance(ctx, tx, acct)
+	if err != nil {
+		return nil, err
+	}
+	took := &pb.Money{CurrencyCode: balance.GetCurrencyCode()}
+	err = tx.QueryRowContext(ctx, `SELECT units, nanos FROM balance_entries WHERE method = ? AND id = ? AND order_id = ?`,
+		int32(acct.Method), acct.ID, orderID).Scan(&took.Units, &took.Nanos)
+	if err == nil {
+		return took, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to query balance entries: %w", err)
+	}
+
+	if took, err = takeBalance(balance, limit); err != nil {
+		return nil, err
+	}
+	neg := money.Negate(took)
+	if _, err := addBalance(ctx, tx, acct, balance, neg); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO balance_entries (method, id, order_id, units, nanos, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		int32(acct.Method), acct.ID, orderID, took.GetUnits(), took.GetNanos(), time.Now().UnixNano()); err != nil {
+		return nil, fmt.Errorf("failed to record balance entry: %w", err)
+	}
+	return took, tx.Commit()
+}
+
+func (r *sqlOrderRepository) RefundBalance(ctx context.Context, acct balanceAccount, orderID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	balance, err := queryBalance(ctx, tx, acct)
+	if errors.Is(err, errBalanceNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	took := &pb.Money{CurrencyCode: balance.GetCurrencyCode()}
+	err = tx.QueryRowContext(ctx,
+		`SELECT units, nanos FROM balance_entries WHERE method = ? AND id = ? AND order_id = ? AND refunded = 0`,
+		int32(acct.Method), acct.ID, orderID).Scan(&took.Units, &took.Nanos)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to query balance entries: %w", err)
+	}
+	if _, err := addBalance(ctx, tx, acct, balance, took); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE balance_entries SET refunded = 1 WHERE method = ? AND id = ? AND order_id = ?`,
+		int32(acct.Method), acct.ID, orderID); err != nil {
+		return fmt.Errorf("failed to record refund: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (r *sqlOrderRepository) Balance(ctx context.Context, acct balanceAccount) (*pb.Money, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return queryBalance(ctx, tx, acct)
+}
@@ -0,0 +1,258 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+var testGiftCard = balanceAccount{Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, ID: "GIFT1234"}
+
+// testBalanceLedger runs the same checks against every implementation.
+func testBalanceLedger(t *testing.T, l balanceLedger) {
+	ctx := context.Background()
+	if _, err := l.Balance(ctx, testGiftCard); !errors.Is(err, errBalanceNotFound) {
+		t.Errorf("Balance(unissued) error = %v, want %v", err, errBalanceNotFound)
+	}
+	if _, err := l.IssueBalance(ctx, testGiftCard, usd(20, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := l.IssueBalance(ctx, testGiftCard, usd(5, 500000000)); err != nil || !proto.Equal(got, usd(25, 500000000)) {
+		t.Errorf("IssueBalance() = %v, %v, want 25.5 USD", got, err)
+	}
+	if _, err := l.IssueBalance(ctx, testGiftCard, &pb.Money{CurrencyCode: "EUR", Units: 1}); !errors.Is(err, errBalanceCurrency) {
+		t.Errorf("IssueBalance(EUR) error = %v, want %v", err, errBalanceCurrency)
+	}
+
+	if got, err := l.RedeemBalance(ctx, testGiftCard, "o1", usd(10, 0)); err != nil || !proto.Equal(got, usd(10, 0)) {
+		t.Errorf("RedeemBalance(o1) = %v, %v, want 10 USD", got, err)
+	}
+	// Redeeming again for the same order takes nothing more.
+	if got, err := l.RedeemBalance(ctx, testGiftCard, "o1", usd(10, 0)); err != nil || !proto.Equal(got, usd(10, 0)) {
+		t.Errorf("RedeemBalance(o1) again = %v, %v, want 10 USD", got, err)
+	}
+	// o2 gets only what is left.
+	if got, err := l.RedeemBalance(ctx, testGiftCard, "o2", usd(40, 0)); err != nil || !proto.Equal(got, usd(15, 500000000)) {
+		t.Errorf("RedeemBalance(o2) = %v, %v, want 15.5 USD", got, err)
+	}
+	if got, err := l.RedeemBalance(ctx, testGiftCard, "o3", usd(1, 0)); err != nil || !proto.Equal(got, usd(0, 0)) {
+		t.Errorf("RedeemBalance(o3) of an empty card = %v, %v, want 0 USD", got, err)
+	}
+	if _, err := l.RedeemBalance(ctx, testGiftCard, "o4", &pb.Money{CurrencyCode: "EUR", Units: 1}); !errors.Is(err, errBalanceCurrency) {
+		t.Errorf("RedeemBalance(EUR) error = %v, want %v", err, errBalanceCurrency)
+	}
+	if _, err := l.RedeemBalance(ctx, balanceAccount{Method: testGiftCard.Method, ID: "NOPE"}, "o1", usd(1, 0)); !errors.Is(err, errBalanceNotFound) {
+		t.Errorf("RedeemBalance(unknown) error = %v, want %v", err, errBalanceNotFound)
+	}
+
+	// Refunds give back exactly what the order took, once.
+	for i := 0; i < 2; i++ {
+		if err := l.RefundBalance(ctx, testGiftCard, "o1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.RefundBalance(ctx, testGiftCard, "never"); err != nil {
+		t.Errorf("RefundBalance(unknown order) = %v, want nil", err)
+	}
+	if got, err := l.Balance(ctx, testGiftCard); err != nil || !proto.Equal(got, usd(10, 0)) {
+		t.Errorf("Balance() = %v, %v, want 10 USD", got, err)
+	}
+}
+
+func TestMemoryBalanceLedger(t *testing.T) {
+	testBalanceLedger(t, newMemoryOrderRepository())
+}
+
+func TestSQLBalanceLedger(t *testing.T) {
+	r, err := newSQLOrderRepository(filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	testBalanceLedger(t, r)
+}
+
+func TestRedeemBalanceIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	l := newMemoryOrderRepository()
+	if _, err := l.IssueBalance(ctx, testGiftCard, usd(10, 0)); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	took := make([]*pb.Money, 20)
+	for i := range took {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			took[i], _ = l.RedeemBalance(ctx, testGiftCard, string(rune('a'+i)), usd(1, 0))
+		}(i)
+	}
+	wg.Wait()
+	n := 0
+	for _, m := range took {
+		if m.GetUnits() == 1 {
+			n++
+		}
+	}
+	if n != 10 {
+		t.Errorf("%d orders took 1 USD from a 10 USD card, want 10", n)
+	}
+}
+
+// errorReason returns the reason in err's ErrorInfo, if it has one.
+func errorReason(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
+
+func issueGiftCard(t *testing.T, cs *checkoutService, code string, amount *pb.Money) {
+	t.Helper()
+	if _, err := cs.IssueBalance(context.Background(), &pb.IssueBalanceRequest{
+		Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, Id: code, Amount: amount,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlaceOrderSplitTender(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	cs := newTestCheckout(t, deps)
+	issueGiftCard(t, cs, "GIFT-0001", usd(20, 0))
+	if _, err := cs.IssueBalance(context.Background(), &pb.IssueBalanceRequest{
+		Method: pb.PaymentMethod_PAYMENT_METHOD_STORE_CREDIT, Id: "u1", Amount: usd(5, 500000000),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := testOrderRequest("u1")
+	// Store credit is listed first but gift cards are applied before it.
+	req.PaymentInstruments = []*pb.PaymentInstrument{
+		{Method: pb.PaymentMethod_PAYMENT_METHOD_STORE_CREDIT},
+		{Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, Id: "gift 0001"},
+	}
+	resp, err := cs.PlaceOrder(context.Background(), req)
+	if err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	// 48.97 - 20 - 5.50
+	if got := deps.charges[0].GetAmount(); !proto.Equal(got, usd(23, 470000000)) {
+		t.Errorf("charged %v, want 23.47 USD", got)
+	}
+	payments := resp.GetOrder().GetPayments()
+	want := []*pb.OrderPayment{
+		{Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, Instrument: "0001", Amount: usd(20, 0)},
+		{Method: pb.PaymentMethod_PAYMENT_METHOD_STORE_CREDIT, Amount: usd(5, 500000000)},
+		{Method: pb.PaymentMethod_PAYMENT_METHOD_CREDIT_CARD, Instrument: "0454", Amount: usd(23, 470000000), TransactionId: "tx-1"},
+	}
+	if len(payments) != len(want) {
+		t.Fatalf("payments = %v, want %v", payments, want)
+	}
+	for i := range want {
+		if !proto.Equal(payments[i], want[i]) {
+			t.Errorf("payments[%d] = %v, want %v", i, payments[i], want[i])
+		}
+	}
+}
+
+func TestPlaceOrderPaidByGiftCard(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	cs := newTestCheckout(t, deps)
+	issueGiftCard(t, cs, "BIGCARD", usd(100, 0))
+
+	req := testOrderRequest("u1")
+	req.CreditCard = nil
+	req.PaymentInstruments = []*pb.PaymentInstrument{{Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, Id: "BIGCARD"}}
+	if _, err := cs.PlaceOrder(context.Background(), req); err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	if len(deps.charges) != 0 {
+		t.Errorf("charged the card %d times for an order paid by gift card", len(deps.charges))
+	}
+	// 100 - (19.99 + 8.99)
+	if got, _ := cs.orders.Balance(context.Background(), balanceAccount{Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, ID: "BIGCARD"}); !proto.Equal(got, usd(71, 20000000)) {
+		t.Errorf("gift card balance = %v, want 71.02 USD", got)
+	}
+
+	// Without a card, a second order is more than the card has left.
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 4}}
+	_, err := cs.PlaceOrder(context.Background(), req)
+	if reason := errorReason(err); reason != reasonInsufficientFunds {
+		t.Errorf("PlaceOrder() error = %v, want reason %s", err, reasonInsufficientFunds)
+	}
+	if got, _ := cs.orders.Balance(context.Background(), balanceAccount{Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, ID: "BIGCARD"}); !proto.Equal(got, usd(71, 20000000)) {
+		t.Errorf("gift card balance after a failed order = %v, want 71.02 USD", got)
+	}
+}
+
+func TestPlaceOrderRefundsEveryInstrument(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 1}}
+	deps.shipErr = status.Error(codes.Unavailable, "no trucks")
+	cs := newTestCheckout(t, deps)
+	issueGiftCard(t, cs, "GIFT", usd(10, 0))
+
+	req := testOrderRequest("u1")
+	req.PaymentInstruments = []*pb.PaymentInstrument{{Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, Id: "GIFT"}}
+	if _, err := cs.PlaceOrder(context.Background(), req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("PlaceOrder() error = %v, want code %v", err, codes.Unavailable)
+	}
+	// 27.98 - 10 went on the card.
+	if len(deps.refunds) != 1 || !proto.Equal(deps.refunds[0].GetAmount(), usd(17, 980000000)) {
+		t.Errorf("refunds = %v, want 17.98 USD to the card", deps.refunds)
+	}
+	if got, _ := cs.orders.Balance(context.Background(), balanceAccount{Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, ID: "GIFT"}); !proto.Equal(got, usd(10, 0)) {
+		t.Errorf("gift card balance = %v, want 10 USD back", got)
+	}
+}
+
+func TestPlaceOrderUnknownGiftCard(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 1}}
+	cs := newTestCheckout(t, deps)
+	issueGiftCard(t, cs, "GOOD", usd(5, 0))
+
+	req := testOrderRequest("u1")
+	req.PaymentInstruments = []*pb.PaymentInstrument{
+		{Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, Id: "GOOD"},
+		{Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, Id: "BAD"},
+	}
+	_, err := cs.PlaceOrder(context.Background(), req)
+	if reason := errorReason(err); reason != reasonBalanceUnavailable {
+		t.Errorf("PlaceOrder() error = %v, want reason %s", err, reasonBalanceUnavailable)
+	}
+	if len(deps.charges) != 0 {
+		t.Errorf("charged the card %d times", len(deps.charges))
+	}
+	if got, _ := cs.orders.Balance(context.Background(), balanceAccount{Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, ID: "GOOD"}); !proto.Equal(got, usd(5, 0)) {
+		t.Errorf("balance of the good card = %v, want 5 USD back", got)
+	}
+}
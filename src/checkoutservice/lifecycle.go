@@ -0,0 +1,331 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+var errInvalidTransition = errors.New("invalid order status transition")
+
+// orderTransitions lists the statuses each status may move to. A PAID order
+// is only cancelled by PlaceOrder itself, when shipping it fails; CancelOrder
+// refuses it.
+var orderTransitions = map[pb.OrderStatus][]pb.OrderStatus{
+	pb.OrderStatus_ORDER_STATUS_PENDING:            {pb.OrderStatus_ORDER_STATUS_PAYMENT_AUTHORIZED, pb.OrderStatus_ORDER_STATUS_CANCELLED},
+	pb.OrderStatus_ORDER_STATUS_PAYMENT_AUTHORIZED: {pb.OrderStatus_ORDER_STATUS_PAID, pb.OrderStatus_ORDER_STATUS_CANCELLED},
+	pb.OrderStatus_ORDER_STATUS_PAID:               {pb.OrderStatus_ORDER_STATUS_SHIPPED, pb.OrderStatus_ORDER_STATUS_CANCELLED},
+	pb.OrderStatus_ORDER_STATUS_CANCELLED:          {pb.OrderStatus_ORDER_STATUS_REFUNDED},
+}
+
+func canTransition(from, to pb.OrderStatus) bool {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// applyTransition moves o to status to and records the change in its
+// history. It returns an error wrapping errInvalidTransition if the move is
+// not allowed, leaving o untouched.
+func applyTransition(o *pb.OrderResult, to pb.OrderStatus, reason string) error {
+	from := o.GetStatus()
+	if !canTransition(from, to) {
+		return fmt.Errorf("%w: %v to %v", errInvalidTransition, from, to)
+	}
+	now := timestamppb.Now()
+	o.Status = to
+	o.UpdatedAt = now
+	o.History = append(o.History, &pb.OrderStatusChange{From: from, To: to, Reason: reason, Time: now})
+	return nil
+}
+
+// newPendingOrder returns o as a freshly created PENDING order.
+func newPendingOrder(o *pb.OrderResult) *pb.OrderResult {
+	now := timestamppb.Now()
+	o.Status = pb.OrderStatus_ORDER_STATUS_PENDING
+	o.CreatedAt = now
+	o.UpdatedAt = now
+	o.History = []*pb.OrderStatusChange{{
+		From: pb.OrderStatus_ORDER_STATUS_UNSPECIFIED, To: pb.OrderStatus_ORDER_STATUS_PENDING,
+		Reason: "order created", Time: now,
+	}}
+	return o
+}
+
+// orderLocks serializes work on the same order across PlaceOrder and
+// CancelOrder, so that an order cannot be cancelled while it is being
+// handed over to shipping.
+type orderLocks struct {
+	mu    sync.Mutex
+	locks map[string]*orderLock
+}
+
+type orderLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks orderID and returns the function that unlocks it.
+func (l *orderLocks) lock(orderID string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*orderLock)
+	}
+	ol, ok := l.locks[orderID]
+	if !ok {
+		ol = new(orderLock)
+		l.locks[orderID] = ol
+	}
+	ol.refs++
+	l.mu.Unlock()
+
+	ol.Lock()
+	return func() {
+		ol.Unlock()
+		l.mu.Lock()
+		if ol.refs--; ol.refs == 0 {
+			delete(l.locks, orderID)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// runningSagas tracks the sagas PlaceOrder is running, by order id.
+type runningSagas struct {
+	mu    sync.Mutex
+	sagas map[string]*saga
+}
+
+// add tracks sg and returns the function that stops tracking it.
+func (r *runningSagas) add(sg *saga) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sagas == nil {
+		r.sagas = make(map[string]*saga)
+	}
+	r.sagas[sg.id] = sg
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.sagas, sg.id)
+	}
+}
+
+// get returns the saga PlaceOrder is running for orderID, or nil.
+func (r *runningSagas) get(orderID string) *saga {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sagas[orderID]
+}
+
+// sagaRecords keeps the saga events of each order until its saga ends. Unlike
+// the saga log they are kept with the order, so they outlive the process
+// that ran the saga even without SAGA_LOG_PATH.
+type sagaRecords interface {
+	// RecordSagaEvent adds e to the events of order e.SagaID.
+	RecordSagaEvent(ctx context.Context, e sagaEvent) error
+	// SagaEvents returns the events recorded for orderID, oldest first.
+	SagaEvents(ctx context.Context, orderID string) ([]sagaEvent, error)
+	// ForgetSaga drops the events recorded for orderID.
+	ForgetSaga(ctx context.Context, orderID string) error
+}
+
+func (r *memoryOrderRepository) RecordSagaEvent(_ context.Context, e sagaEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sagaEvents[e.SagaID] = append(r.sagaEvents[e.SagaID], e)
+	return nil
+}
+
+func (r *memoryOrderRepository) SagaEvents(_ context.Context, orderID string) ([]sagaEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]sagaEvent(nil), r.sagaEvents[orderID]...), nil
+}
+
+func (r *memoryOrderRepository) ForgetSaga(_ context.Context, orderID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sagaEvents, orderID)
+	return nil
+}
+
+// recordedSagaLog appends to a saga log and also records the steps of each
+// saga with its order, until the saga ends.
+type recordedSagaLog struct {
+	sagaLog
+	records sagaRecords
+}
+
+func (l recordedSagaLog) Append(e sagaEvent) error {
+	if err := l.sagaLog.Append(e); err != nil {
+		return err
+	}
+	// e is durable in the saga log already; only cancelling the order after
+	// its saga stopped running needs the record.
+	var err error
+	switch {
+	case e.ends():
+		err = l.records.ForgetSaga(context.Background(), e.SagaID)
+	case e.Type == sagaStepCompleted || e.Type == sagaStepCompensated:
+		err = l.records.RecordSagaEvent(context.Background(), e)
+	}
+	if err != nil {
+		log.Warnf("saga %s: failed to record %s with the order: %+v", e.SagaID, e.Type, err)
+	}
+	return nil
+}
+
+// orderSagaLog returns the log PlaceOrder sagas are written to.
+func (cs *checkoutService) orderSagaLog() sagaLog {
+	return recordedSagaLog{sagaLog: cs.sagaLog, records: cs.orders}
+}
+
+// CancelOrder cancels an order that has not been handed over to shipping
+// and undoes what PlaceOrder did for it: charges and balances are refunded,
+// loyalty points reversed, promotions given back and the stock reservation
+// released. An order that was paid for becomes REFUNDED.
+func (cs *checkoutService) CancelOrder(ctx context.Context, req *pb.CancelOrderRequest) (*pb.CancelOrderResponse, error) {
+	log.Infof("[CancelOrder] order_id=%q user_id=%q reason=%q", req.GetOrderId(), req.GetUserId(), req.GetReason())
+	if req.GetOrderId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "order_id is required")
+	}
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	reason := req.GetReason()
+	if reason == "" {
+		reason = "cancelled by customer"
+	}
+
+	unlock := cs.orderLocks.lock(req.GetOrderId())
+	defer unlock()
+	o, err := cs.orders.Get(ctx, req.GetOrderId())
+	if err == nil && o.GetUserId() != req.GetUserId() {
+		// Other users' orders are reported as missing, as on the order page.
+		err = errOrderNotFound
+	}
+	if err == nil && o.GetStatus() == pb.OrderStatus_ORDER_STATUS_PAID {
+		// PlaceOrder holds the order's lock from marking it paid until it
+		// has shipped, so a paid order seen here has shipped, or may have.
+		return nil, status.Errorf(codes.FailedPrecondition, "order %s can no longer be cancelled: it was handed over to shipping", req.GetOrderId())
+	}
+	if err == nil {
+		o, err = cs.orders.Transition(ctx, req.GetOrderId(), pb.OrderStatus_ORDER_STATUS_CANCELLED, reason, nil)
+	}
+	switch {
+	case errors.Is(err, errOrderNotFound):
+		return nil, status.Errorf(codes.NotFound, "no order with ID %s", req.GetOrderId())
+	case errors.Is(err, errInvalidTransition):
+		return nil, status.Errorf(codes.FailedPrecondition, "order %s can no longer be cancelled: %v", req.GetOrderId(), err)
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "failed to cancel order: %+v", err)
+	}
+
+	cs.compensateOrder(ctx, req.GetOrderId(), reason)
+	if refunded, err := cs.orders.Get(ctx, req.GetOrderId()); err == nil {
+		o = refunded
+	}
+	return &pb.CancelOrderResponse{Order: o}, nil
+}
+
+// compensateOrder undoes the saga of a cancelled order: the one PlaceOrder
+// is running, or else the one recorded with the order, if PlaceOrder no
+// longer runs it. A step PlaceOrder completes afterwards is undone by
+// PlaceOrder, as it then fails to advance the order. Failures are logged.
+func (cs *checkoutService) compensateOrder(ctx context.Context, orderID, reason string) {
+	sg := cs.running.get(orderID)
+	if sg == nil {
+		evs, err := cs.orders.SagaEvents(ctx, orderID)
+		if err != nil {
+			log.Errorf("order %s was not compensated: %+v", orderID, err)
+			return
+		}
+		if len(evs) == 0 {
+			return
+		}
+		sg = resumeSaga(evs, cs.orderSagaLog(), cs.compensators())
+	}
+	if err := sg.abort(ctx, reason); err != nil {
+		log.Errorf("order %s was not fully compensated: %+v", orderID, err)
+	}
+}
+
+// advanceOrder moves a PlaceOrder order to its next status, applying update
+// (if not nil) in the same write. It returns an error wrapping
+// errInvalidTransition if the order was cancelled meanwhile.
+func (cs *checkoutService) advanceOrder(ctx context.Context, orderID string, to pb.OrderStatus, reason string, update func(*pb.OrderResult)) (*pb.OrderResult, error) {
+	o, err := cs.orders.Transition(ctx, orderID, to, reason, update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move order %s to %v: %w", orderID, to, err)
+	}
+	return o, nil
+}
+
+// cancelOrder marks a failed order CANCELLED unless it already is.
+func (cs *checkoutService) cancelOrder(ctx context.Context, orderID, reason string) {
+	ctx = context.WithoutCancel(ctx)
+	o, err := cs.orders.Get(ctx, orderID)
+	if err == nil && o.GetStatus() == pb.OrderStatus_ORDER_STATUS_CANCELLED {
+		return
+	}
+	if errors.Is(err, errOrderNotFound) {
+		// The order failed before it was stored.
+		return
+	}
+	if err == nil {
+		_, err = cs.orders.Transition(ctx, orderID, pb.OrderStatus_ORDER_STATUS_CANCELLED, reason, nil)
+	}
+	if err != nil {
+		log.Errorf("failed to mark order %s cancelled: %+v", orderID, err)
+	}
+}
+
+// markRefunded marks a failed order CANCELLED for reason and then REFUNDED
+// with note, unless it already is. An order paid with several instruments
+// is refunded once per instrument; only the first refund moves it.
+func (cs *checkoutService) markRefunded(ctx context.Context, orderID, reason, note string) {
+	ctx = context.WithoutCancel(ctx)
+	if o, err := cs.orders.Get(ctx, orderID); err == nil && o.GetStatus() == pb.OrderStatus_ORDER_STATUS_REFUNDED {
+		return
+	}
+	cs.cancelOrder(ctx, orderID, reason)
+	if _, err := cs.advanceOrder(ctx, orderID, pb.OrderStatus_ORDER_STATUS_REFUNDED, note, nil); err != nil {
+		log.Errorf("failed to mark order %s refunded: %+v", orderID, err)
+	}
+}
+
+// failOrder aborts an order whose status could not be advanced and returns
+// the error for PlaceOrder to report.
+func (cs *checkoutService) failOrder(ctx context.Context, sg *saga, err error) error {
+	if errors.Is(err, errInvalidTransition) {
+		cs.abortOrder(ctx, sg, "order was cancelled")
+		return status.Errorf(codes.Aborted, "order %s was cancelled", sg.id)
+	}
+	cs.abortOrder(ctx, sg, err.Error())
+	return status.Errorf(codes.Internal, "%+v", err)
+}
@@ -0,0 +1,297 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+func statusHistory(o *pb.OrderResult) []pb.OrderStatus {
+	var out []pb.OrderStatus
+	for _, c := range o.GetHistory() {
+		out = append(out, c.GetTo())
+	}
+	return out
+}
+
+func TestApplyTransition(t *testing.T) {
+	o := newPendingOrder(&pb.OrderResult{OrderId: "o1"})
+	if err := applyTransition(o, pb.OrderStatus_ORDER_STATUS_SHIPPED, "skip payment"); !errors.Is(err, errInvalidTransition) {
+		t.Errorf("PENDING -> SHIPPED error = %v, want %v", err, errInvalidTransition)
+	}
+	if o.GetStatus() != pb.OrderStatus_ORDER_STATUS_PENDING || len(o.GetHistory()) != 1 {
+		t.Errorf("rejected transition changed the order to %v", o)
+	}
+	if err := applyTransition(o, pb.OrderStatus_ORDER_STATUS_CANCELLED, "changed my mind"); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyTransition(o, pb.OrderStatus_ORDER_STATUS_PAID, "too late"); !errors.Is(err, errInvalidTransition) {
+		t.Errorf("CANCELLED -> PAID error = %v, want %v", err, errInvalidTransition)
+	}
+	last := o.GetHistory()[len(o.GetHistory())-1]
+	if last.GetFrom() != pb.OrderStatus_ORDER_STATUS_PENDING || last.GetReason() != "changed my mind" || last.GetTime() == nil {
+		t.Errorf("recorded change %v", last)
+	}
+}
+
+func TestPlaceOrderRecordsHistory(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	cs := newTestCheckout(t, deps)
+
+	resp, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pb.OrderStatus{
+		pb.OrderStatus_ORDER_STATUS_PENDING,
+		pb.OrderStatus_ORDER_STATUS_PAYMENT_AUTHORIZED,
+		pb.OrderStatus_ORDER_STATUS_PAID,
+		pb.OrderStatus_ORDER_STATUS_SHIPPED,
+	}
+	if got := statusHistory(resp.GetOrder()); !reflect.DeepEqual(got, want) {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+
+	_, err = cs.CancelOrder(context.Background(), &pb.CancelOrderRequest{OrderId: resp.GetOrder().GetOrderId(), UserId: "u1"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("CancelOrder(shipped) error = %v, want code %v", err, codes.FailedPrecondition)
+	}
+}
+
+func TestCancelOrderBeforeShipmentRefunds(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	cs := newTestCheckout(t, deps)
+	ctx := context.Background()
+
+	// Cancel the order while its card is being charged.
+	var cancelErr error
+	deps.onCharge = func(*pb.ChargeRequest) {
+		list, err := cs.ListOrders(ctx, &pb.ListOrdersRequest{UserId: "u1"})
+		if err != nil || len(list.GetOrders()) != 1 {
+			cancelErr = errors.New("pending order not listed")
+			return
+		}
+		_, cancelErr = cs.CancelOrder(ctx, &pb.CancelOrderRequest{OrderId: list.GetOrders()[0].GetOrderId(), UserId: "u1", Reason: "wrong size"})
+	}
+
+	_, err := cs.PlaceOrder(ctx, testOrderRequest("u1"))
+	if cancelErr != nil {
+		t.Fatalf("CancelOrder() failed: %v", cancelErr)
+	}
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("PlaceOrder() error = %v, want code %v", err, codes.Aborted)
+	}
+	if len(deps.refunds) != 1 {
+		t.Errorf("issued %d refunds, want 1", len(deps.refunds))
+	}
+
+	list, err := cs.ListOrders(ctx, &pb.ListOrdersRequest{UserId: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := list.GetOrders()[0]
+	want := []pb.OrderStatus{
+		pb.OrderStatus_ORDER_STATUS_PENDING,
+		pb.OrderStatus_ORDER_STATUS_CANCELLED,
+		pb.OrderStatus_ORDER_STATUS_REFUNDED,
+	}
+	if got := statusHistory(o); !reflect.DeepEqual(got, want) {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+	if got := o.GetHistory()[1].GetReason(); got != "wrong size" {
+		t.Errorf("cancellation reason = %q, want %q", got, "wrong size")
+	}
+}
+
+// stallingOrders stops PlaceOrder as it marks an order paid, as if the
+// process running it had died there, until resume is closed.
+type stallingOrders struct {
+	orderRepository
+	stalled chan string
+	resume  chan struct{}
+}
+
+func (r *stallingOrders) Transition(ctx context.Context, orderID string, to pb.OrderStatus, reason string, update func(*pb.OrderResult)) (*pb.OrderResult, error) {
+	if to == pb.OrderStatus_ORDER_STATUS_PAID {
+		r.stalled <- orderID
+		<-r.resume
+		return nil, errors.New("checkout stopped")
+	}
+	return r.orderRepository.Transition(ctx, orderID, to, reason, update)
+}
+
+func TestCancelOrderAfterSagaStopped(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	ctx := context.Background()
+
+	stopped := newTestCheckout(t, deps)
+	orders := &stallingOrders{orderRepository: stopped.orders, stalled: make(chan string), resume: make(chan struct{})}
+	stopped.orders = orders
+	placed := make(chan struct{})
+	go func() {
+		defer close(placed)
+		stopped.PlaceOrder(ctx, testOrderRequest("u1"))
+	}()
+	orderID := <-orders.stalled
+
+	// Another checkout sharing the order store is not running the saga.
+	cs := newTestCheckout(t, deps)
+	cs.orders = orders.orderRepository
+	resp, err := cs.CancelOrder(ctx, &pb.CancelOrderRequest{OrderId: orderID, UserId: "u1", Reason: "wrong size"})
+	if err != nil {
+		t.Fatalf("CancelOrder() failed: %v", err)
+	}
+	want := []pb.OrderStatus{
+		pb.OrderStatus_ORDER_STATUS_PENDING,
+		pb.OrderStatus_ORDER_STATUS_PAYMENT_AUTHORIZED,
+		pb.OrderStatus_ORDER_STATUS_CANCELLED,
+		pb.OrderStatus_ORDER_STATUS_REFUNDED,
+	}
+	if got := statusHistory(resp.GetOrder()); !reflect.DeepEqual(got, want) {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+	if len(deps.refunds) != 1 || deps.refunds[0].GetTransactionId() != "tx-1" {
+		t.Errorf("refunds = %v, want one of tx-1", deps.refunds)
+	}
+	if want := []string{"res-1"}; !reflect.DeepEqual(deps.released, want) {
+		t.Errorf("released reservations = %v, want %v", deps.released, want)
+	}
+	if evs, err := cs.orders.SagaEvents(ctx, orderID); err != nil || len(evs) != 0 {
+		t.Errorf("SagaEvents() after compensating = %v, %v, want none", evs, err)
+	}
+
+	close(orders.resume)
+	<-placed
+}
+
+func TestCancelOrderRefusesPaidOrder(t *testing.T) {
+	cs := newTestCheckout(t, newFakeDeps())
+	ctx := context.Background()
+	o := newPendingOrder(&pb.OrderResult{OrderId: "o1", UserId: "u1"})
+	if err := cs.orders.Create(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []pb.OrderStatus{pb.OrderStatus_ORDER_STATUS_PAYMENT_AUTHORIZED, pb.OrderStatus_ORDER_STATUS_PAID} {
+		if _, err := cs.orders.Transition(ctx, "o1", to, "test", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := cs.CancelOrder(ctx, &pb.CancelOrderRequest{OrderId: "o1", UserId: "u1"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("CancelOrder(paid) error = %v, want code %v", err, codes.FailedPrecondition)
+	}
+}
+
+func TestCancelOrderChecksUser(t *testing.T) {
+	cs := newTestCheckout(t, newFakeDeps())
+	ctx := context.Background()
+	if err := cs.orders.Create(ctx, newPendingOrder(&pb.OrderResult{OrderId: "o1", UserId: "u1"})); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		userID string
+		want   codes.Code
+	}{
+		{"no user", "", codes.InvalidArgument},
+		{"another user", "u2", codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cs.CancelOrder(ctx, &pb.CancelOrderRequest{OrderId: "o1", UserId: tt.userID})
+			if status.Code(err) != tt.want {
+				t.Errorf("CancelOrder() error = %v, want code %v", err, tt.want)
+			}
+		})
+	}
+	if o, err := cs.orders.Get(ctx, "o1"); err != nil || o.GetStatus() != pb.OrderStatus_ORDER_STATUS_PENDING {
+		t.Errorf("order = %v, %v, want it still pending", o.GetStatus(), err)
+	}
+}
+
+func testSagaRecords(t *testing.T, r orderRepository) {
+	ctx := context.Background()
+	evs := []sagaEvent{
+		{SagaID: "o1", Type: sagaStepCompleted, Step: stepChargeCard, Data: json.RawMessage(`{"transaction_id":"tx-1"}`)},
+		{SagaID: "o2", Type: sagaStepCompleted, Step: stepReserveStock, Data: json.RawMessage(`{"reservation_id":"res-1"}`)},
+		{SagaID: "o1", Type: sagaStepCompensated, Step: stepChargeCard, Reason: "wrong size"},
+	}
+	for _, e := range evs {
+		if err := r.RecordSagaEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := r.SagaEvents(ctx, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []sagaEvent{evs[0], evs[2]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SagaEvents(o1) = %v, want %v", got, want)
+	}
+	if err := r.ForgetSaga(ctx, "o1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.SagaEvents(ctx, "o1"); err != nil || len(got) != 0 {
+		t.Errorf("SagaEvents(o1) after ForgetSaga = %v, %v, want none", got, err)
+	}
+	if got, err := r.SagaEvents(ctx, "o2"); err != nil || len(got) != 1 {
+		t.Errorf("SagaEvents(o2) = %v, %v, want 1 event", got, err)
+	}
+}
+
+func TestMemorySagaRecords(t *testing.T) {
+	testSagaRecords(t, newMemoryOrderRepository())
+}
+
+func TestSQLSagaRecords(t *testing.T) {
+	r, err := newSQLOrderRepository(filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	testSagaRecords(t, r)
+}
+
+func TestFailedShipmentEndsRefunded(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 1}}
+	deps.shipErr = status.Error(codes.Unavailable, "no trucks")
+	cs := newTestCheckout(t, deps)
+	ctx := context.Background()
+
+	if _, err := cs.PlaceOrder(ctx, testOrderRequest("u1")); err == nil {
+		t.Fatal("PlaceOrder() succeeded, want error")
+	}
+	list, err := cs.ListOrders(ctx, &pb.ListOrdersRequest{UserId: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := list.GetOrders()[0].GetStatus(); got != pb.OrderStatus_ORDER_STATUS_REFUNDED {
+		t.Errorf("status = %v, want %v", got, pb.OrderStatus_ORDER_STATUS_REFUNDED)
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
+)
+
+var errInsufficientPoints = errors.New("not enough settled loyalty points")
+
+// reasonInsufficientPoints is the reason of orders redeeming more points
+// than the user has settled.
+const reasonInsufficientPoints = "INSUFFICIENT_POINTS"
+
+const (
+	defaultPointsPerUSD      = 1
+	defaultPointsSettleAfter = 14 * 24 * time.Hour
+	// pointsPerUSDRedeemed is what points are worth: one US cent each.
+	pointsPerUSDRedeemed = 100
+
+	defaultLoyaltyPageSize = 20
+	maxLoyaltyPageSize     = 100
+)
+
+// pointsKind tells points an order earned from points it redeemed.
+type pointsKind string
+
+const (
+	pointsEarned pointsKind = "earned"
+	pointsSpent  pointsKind = "spent"
+)
+
+// pointsEntry is what one order earned or spent.
+type pointsEntry struct {
+	OrderID   string
+	Kind      pointsKind
+	Points    int64
+	CreatedAt time.Time
+	// SettlesAt is when earned points can be redeemed.
+	SettlesAt time.Time
+	Reversed  bool
+}
+
+func (e pointsEntry) state(now time.Time) pb.LoyaltyEntryState {
+	switch {
+	case e.Reversed:
+		return pb.LoyaltyEntryState_LOYALTY_ENTRY_STATE_REVERSED
+	case e.Kind == pointsEarned && now.Before(e.SettlesAt):
+		return pb.LoyaltyEntryState_LOYALTY_ENTRY_STATE_PENDING
+	}
+	return pb.LoyaltyEntryState_LOYALTY_ENTRY_STATE_SETTLED
+}
+
+// pointsBalance adds up entries into the points that can be redeemed at
+// now and those still pending.
+func pointsBalance(entries []pointsEntry, now time.Time) (settled, pending int64) {
+	for _, e := range entries {
+		switch e.state(now) {
+		case pb.LoyaltyEntryState_LOYALTY_ENTRY_STATE_PENDING:
+			pending += e.Points
+		case pb.LoyaltyEntryState_LOYALTY_ENTRY_STATE_SETTLED:
+			if e.Kind == pointsSpent {
+				settled -= e.Points
+			} else {
+				settled += e.Points
+			}
+		}
+	}
+	return settled, pending
+}
+
+// pointsLedger keeps the loyalty points of each user as entries of what
+// their orders earned and spent.
+type pointsLedger interface {
+	// AccruePoints records points earned by orderID of userID, pending
+	// until settlesAt. Accruing again for the same order is a no-op.
+	AccruePoints(ctx context.Context, userID, orderID string, points int64, now, settlesAt time.Time) error
+	// SpendPoints atomically takes points from what userID has settled at
+	// now for orderID, or returns an error wrapping errInsufficientPoints.
+	// Spending again for the same order is a no-op.
+	SpendPoints(ctx context.Context, userID, orderID string, points int64, now time.Time) error
+	// ReversePoints undoes what orderID earned or spent, as kind says.
+	// Reversing again, or what the order did not do, is a no-op.
+	ReversePoints(ctx context.Context, userID, orderID string, kind pointsKind) error
+	// PointsEntries returns up to limit of userID's entries, most recent
+	// first, or all of them if limit is zero.
+	PointsEntries(ctx context.Context, userID string, limit int) ([]pointsEntry, error)
+}
+
+func (r *memoryOrderRepository) AccruePoints(_ context.Context, userID, orderID string, points int64, now, settlesAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.findPoints(userID, orderID, pointsEarned) != nil {
+		return nil
+	}
+	r.points[userID] = append(r.points[userID], &pointsEntry{
+		OrderID: orderID, Kind: pointsEarned, Points: points, CreatedAt: now, SettlesAt: settlesAt,
+	})
+	return nil
+}
+
+func (r *memoryOrderRepository) SpendPoints(_ context.Context, userID, orderID string, points int64, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.findPoints(userID, orderID, pointsSpent) != nil {
+		return nil
+	}
+	entries := make([]pointsEntry, len(r.points[userID]))
+	for i, e := range r.points[userID] {
+		entries[i] = *e
+	}
+	if settled, _ := pointsBalance(entries, now); settled < points {
+		return fmt.Errorf("%w: %d to redeem, %d settled", errInsufficientPoints, points, settled)
+	}
+	r.points[userID] = append(r.points[userID], &pointsEntry{
+		OrderID: orderID, Kind: pointsSpent, Points: points, CreatedAt: now, SettlesAt: now,
+	})
+	return nil
+}
+
+func (r *memoryOrderRepository) ReversePoints(_ context.Context, userID, orderID string, kind pointsKind) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e := r.findPoints(userID, orderID, kind); e != nil {
+		e.Reversed = true
+	}
+	return nil
+}
+
+func (r *memoryOrderRepository) PointsEntries(_ context.Context, userID string, limit int) ([]pointsEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	all := r.points[userID]
+	var out []pointsEntry
+	for i := len(all) - 1; i >= 0 && (limit == 0 || len(out) < limit); i-- {
+		out = append(out, *all[i])
+	}
+	return out, nil
+}
+
+func (r *memoryOrderRepository) findPoints(userID, orderID string, kind pointsKind) *pointsEntry {
+	for _, e := range r.points[userID] {
+		if e.OrderID == orderID && e.Kind == kind {
+			return e
+		}
+	}
+	return nil
+}
+
+// loyaltyProgram decides the points orders earn and what redeemed points
+// are worth.
+type loyaltyProgram struct {
+	// pointsPerUSD is what each whole US dollar of an order's total earns.
+	pointsPerUSD int64
+	// settleAfter is how long earned points stay pending, so that they are
+	// not redeemed before the order could be returned.
+	settleAfter time.Duration
+}
+
+// loyaltyProgramFromEnv returns the loyalty program configured by
+// LOYALTY_POINTS_PER_USD and LOYALTY_SETTLE_AFTER, or the defaults.
+func loyaltyProgramFromEnv() (*loyaltyProgram, error) {
+	p := &loyaltyProgram{pointsPerUSD: defaultPointsPerUSD, settleAfter: defaultPointsSettleAfter}
+	if v := os.Getenv("LOYALTY_POINTS_PER_USD"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid LOYALTY_POINTS_PER_USD %q", v)
+		}
+		p.pointsPerUSD = n
+	}
+	if v := os.Getenv("LOYALTY_SETTLE_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid LOYALTY_SETTLE_AFTER %q", v)
+		}
+		p.settleAfter = d
+	}
+	return p, nil
+}
+
+// pointsFor returns the points an order of total earns.
+func (p *loyaltyProgram) pointsFor(ctx context.Context, conv *currencyConverter, total *pb.Money) (int64, error) {
+	usd, err := conv.convertOptional(ctx, total, usdCurrency)
+	if err != nil {
+		return 0, err
+	}
+	if usd.GetUnits() <= 0 {
+		return 0, nil
+	}
+	return usd.GetUnits() * p.pointsPerUSD, nil
+}
+
+// pointsValue returns what points are worth in currency.
+func pointsValue(ctx context.Context, conv *currencyConverter, points int64, currency string) (*pb.Money, error) {
+	usd := &pb.Money{
+		CurrencyCode: usdCurrency,
+		Units:        points / pointsPerUSDRedeemed,
+		Nanos:        int32(points%pointsPerUSDRedeemed) * (1e9 / pointsPerUSDRedeemed),
+	}
+	return conv.convertOptional(ctx, usd, currency)
+}
+
+// applyPoints takes the value of redeemed points off order as a discount.
+func applyPoints(order *pb.OrderResult, points int64, value *pb.Money) {
+	order.Discounts = append(order.Discounts, &pb.OrderDiscount{
+		Description: fmt.Sprintf("%d loyalty points", points),
+		Amount:      value,
+	})
+	order.Total = money.Must(money.Sum(order.Total, money.Negate(value)))
+	order.PointsRedeemed = points
+}
+
+// pointsDiscount returns what redeeming req's points takes off order, which
+// must not be more than its total. Errors are gRPC statuses.
+func (cs *checkoutService) pointsDiscount(ctx context.Context, req *pb.PlaceOrderRequest, order *pb.OrderResult, conv *currencyConverter) (*pb.Money, error) {
+	var v fieldViolations
+	if cs.loyalty == nil {
+		v.add("redeem_points", "loyalty points cannot be redeemed")
+		return nil, v.err()
+	}
+	value, err := pointsValue(ctx, conv, req.GetRedeemPoints(), req.GetUserCurrency())
+	if err != nil {
+		return nil, asStatus(err, codes.Internal, "failed to convert the value of loyalty points")
+	}
+	if c, err := money.Compare(value, order.GetTotal()); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to compare the value of loyalty points: %+v", err)
+	} else if c > 0 {
+		v.add("redeem_points", "%d points are worth more than the order", req.GetRedeemPoints())
+		return nil, v.err()
+	}
+	return value, nil
+}
+
+// accruePoints records the pending points an order of total earns as a saga
+// step, so that they are reversed if the order is cancelled, and returns
+// them. The order goes through even if they cannot be recorded; it then
+// earns none.
+func (cs *checkoutService) accruePoints(ctx context.Context, sg *saga, userID, orderID string, total *pb.Money, conv *currencyConverter) int64 {
+	if cs.loyalty == nil {
+		return 0
+	}
+	points, err := cs.loyalty.pointsFor(ctx, conv, total)
+	if err != nil {
+		log.Warnf("failed to work out the loyalty points of order %s: %+v", orderID, err)
+		return 0
+	}
+	if points == 0 {
+		return 0
+	}
+	now := time.Now()
+	if err := sg.step(ctx, stepAccruePoints, func(ctx context.Context) (interface{}, error) {
+		rec := pointsRecord{UserID: userID, OrderID: orderID, Points: points}
+		return rec, cs.orders.AccruePoints(ctx, userID, orderID, points, now, now.Add(cs.loyalty.settleAfter))
+	}); err != nil {
+		log.Warnf("failed to record the loyalty points of order %s: %+v", orderID, err)
+		return 0
+	}
+	return points
+}
+
+// insufficientPointsError returns the status for an order redeeming more
+// points than the user has settled, as err says.
+func insufficientPointsError(err error) error {
+	return detailedError(codes.FailedPrecondition, reasonInsufficientPoints, nil, err.Error(),
+		&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{
+			{Type: "LOYALTY", Subject: "redeem_points", Description: err.Error()},
+		}})
+}
+
+// pointsRecord is what the saga log keeps about points an order earned or
+// spent so they can be reversed.
+type pointsRecord struct {
+	UserID  string `json:"user_id"`
+	OrderID string `json:"order_id"`
+	Points  int64  `json:"points"`
+}
+
+func (cs *checkoutService) compensatePoints(kind pointsKind) compensator {
+	return func(ctx context.Context, data json.RawMessage, _ string) error {
+		var rec pointsRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("failed to decode points record: %+v", err)
+		}
+		if err := cs.orders.ReversePoints(ctx, rec.UserID, rec.OrderID, kind); err != nil {
+			return fmt.Errorf("failed to reverse %s points of order %s: %+v", kind, rec.OrderID, err)
+		}
+		log.Infof("%d %s loyalty points reversed (order_id: %s)", rec.Points, kind, rec.OrderID)
+		return nil
+	}
+}
+
+func (cs *checkoutService) GetLoyaltyBalance(ctx context.Context, req *pb.GetLoyaltyBalanceRequest) (*pb.GetLoyaltyBalanceResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	entries, err := cs.orders.PointsEntries(ctx, req.GetUserId(), 0)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get loyalty points: %+v", err)
+	}
+	settled, pending := pointsBalance(entries, time.Now())
+	return &pb.GetLoyaltyBalanceResponse{SettledPoints: settled, PendingPoints: pending}, nil
+}
+
+func (cs *checkoutService) ListLoyaltyEntries(ctx context.Context, req *pb.ListLoyaltyEntriesRequest) (*pb.ListLoyaltyEntriesResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	limit := int(req.GetPageSize())
+	if limit <= 0 {
+		limit = defaultLoyaltyPageSize
+	} else if limit > maxLoyaltyPageSize {
+		limit = maxLoyaltyPageSize
+	}
+	entries, err := cs.orders.PointsEntries(ctx, req.GetUserId(), limit)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list loyalty points: %+v", err)
+	}
+	now := time.Now()
+	resp := &pb.ListLoyaltyEntriesResponse{}
+	for _, e := range entries {
+		points := e.Points
+		if e.Kind == pointsSpent {
+			points = -points
+		}
+		resp.Entries = append(resp.Entries, &pb.LoyaltyEntry{
+			OrderId:   e.OrderID,
+			Points:    points,
+			State:     e.state(now),
+			CreatedAt: timestamppb.New(e.CreatedAt),
+			SettlesAt: timestamppb.New(e.SettlesAt),
+		})
+	}
+	return resp, nil
+}
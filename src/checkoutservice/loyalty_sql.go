@@ -0,0 +1,119 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const loyaltySchema = `
+CREATE TABLE IF NOT EXISTS loyalty_points (
+	user_id    TEXT NOT NULL,
+	order_id   TEXT NOT NULL,
+	kind       TEXT NOT NULL,
+	points     INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	settles_at INTEGER NOT NULL,
+	reversed   INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id, order_id, kind)
+);
+CREATE INDEX IF NOT EXISTS loyalty_points_by_user ON loyalty_points (user_id, created_at DESC);
+`
+
+// querier is a database or a transaction in it.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryPoints returns up to limit of userID's entries, most recent first,
+// or all of them if limit is zero.
+func queryPoints(ctx context.Context, q querier, userID string, limit int) ([]pointsEntry, error) {
+	if limit == 0 {
+		limit = -1 // no limit in SQLite
+	}
+	rows, err := q.QueryContext(ctx,
+		`SELECT order_id, kind, points, created_at, settles_at, reversed FROM loyalty_points
+		 WHERE user_id = ? ORDER BY created_at DESC, order_id DESC LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query loyalty points: %w", err)
+	}
+	defer rows.Close()
+	var out []pointsEntry
+	for rows.Next() {
+		var (
+			e                pointsEntry
+			created, settles int64
+		)
+		if err := rows.Scan(&e.OrderID, &e.Kind, &e.Points, &created, &settles, &e.Reversed); err != nil {
+			return nil, fmt.Errorf("failed to read loyalty points: %w", err)
+		}
+		e.CreatedAt, e.SettlesAt = time.Unix(0, created), time.Unix(0, settles)
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlOrderRepository) AccruePoints(ctx context.Context, userID, orderID string, points int64, now, settlesAt time.Time) error {
+	if _, err := r.db.ExecContext(ctx,
+		`INSERT INTO loyalty_points (user_id, order_id, kind, points, created_at, settles_at) VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT (user_id, order_id, kind) DO NOTHING`,
+		userID, orderID, string(pointsEarned), points, now.UnixNano(), settlesAt.UnixNano()); err != nil {
+		return fmt.Errorf("failed to record loyalty points: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlOrderRepository) SpendPoints(ctx context.Context, userID, orderID string, points int64, now time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	entries, err := queryPoints(ctx, tx, userID, 0)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.OrderID == orderID && e.Kind == pointsSpent {
+			return nil
+		}
+	}
+	if settled, _ := pointsBalance(entries, now); settled < points {
+		return fmt.Errorf("%w: %d to redeem, %d settled", errInsufficientPoints, points, settled)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO loyalty_points (user_id, order_id, kind, points, created_at, settles_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, orderID, string(pointsSpent), points, now.UnixNano(), now.UnixNano()); err != nil {
+		return fmt.Errorf("failed to record loyalty points: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (r *sqlOrderRepository) ReversePoints(ctx context.Context, userID, orderID string, kind pointsKind) error {
+	if _, err := r.db.ExecContext(ctx,
+		`UPDATE loyalty_points SET reversed = 1 WHERE user_id = ? AND order_id = ? AND kind = ?`,
+		userID, orderID, string(kind)); err != nil {
+		return fmt.Errorf("failed to reverse loyalty points: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlOrderRepository) PointsEntries(ctx context.Context, userID string, limit int) ([]pointsEntry, error) {
+	return queryPoints(ctx, r.db, userID, limit)
+}
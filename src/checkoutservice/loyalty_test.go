@@ -0,0 +1,309 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// testPointsLedger runs the same checks against every implementation.
+func testPointsLedger(t *testing.T, l pointsLedger) {
+	ctx := context.Background()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	balance := func(now time.Time) (int64, int64) {
+		t.Helper()
+		entries, err := l.PointsEntries(ctx, "u1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pointsBalance(entries, now)
+	}
+
+	if err := l.AccruePoints(ctx, "u1", "o1", 100, base, base.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// Accruing again for the same order adds nothing.
+	if err := l.AccruePoints(ctx, "u1", "o1", 100, base, base.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.AccruePoints(ctx, "u1", "o2", 30, base.Add(time.Minute), base.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if settled, pending := balance(base); settled != 0 || pending != 130 {
+		t.Errorf("balance = %d settled, %d pending, want 0 and 130", settled, pending)
+	}
+	if err := l.SpendPoints(ctx, "u1", "o3", 50, base); !errors.Is(err, errInsufficientPoints) {
+		t.Errorf("SpendPoints(pending) error = %v, want %v", err, errInsufficientPoints)
+	}
+
+	later := base.Add(90 * time.Minute)
+	if settled, pending := balance(later); settled != 100 || pending != 30 {
+		t.Errorf("balance once o1 settled = %d settled, %d pending, want 100 and 30", settled, pending)
+	}
+	if err := l.SpendPoints(ctx, "u1", "o3", 80, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.SpendPoints(ctx, "u1", "o4", 80, later); !errors.Is(err, errInsufficientPoints) {
+		t.Errorf("SpendPoints(more than settled) error = %v, want %v", err, errInsufficientPoints)
+	}
+	if settled, _ := balance(later); settled != 20 {
+		t.Errorf("settled after spending = %d, want 20", settled)
+	}
+
+	// Reversing gives spent points back and takes earned ones away, once.
+	for i := 0; i < 2; i++ {
+		if err := l.ReversePoints(ctx, "u1", "o3", pointsSpent); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.ReversePoints(ctx, "u1", "o2", pointsEarned); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if settled, pending := balance(later); settled != 100 || pending != 0 {
+		t.Errorf("balance after reversals = %d settled, %d pending, want 100 and 0", settled, pending)
+	}
+
+	entries, err := l.PointsEntries(ctx, "u1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].OrderID != "o3" || entries[1].OrderID != "o2" {
+		t.Errorf("PointsEntries(2) = %+v, want o3 and o2", entries)
+	}
+	if got := entries[0].state(later); got != pb.LoyaltyEntryState_LOYALTY_ENTRY_STATE_REVERSED {
+		t.Errorf("state of a reversed entry = %v", got)
+	}
+}
+
+func TestMemoryPointsLedger(t *testing.T) {
+	testPointsLedger(t, newMemoryOrderRepository())
+}
+
+func TestSQLPointsLedger(t *testing.T) {
+	r, err := newSQLOrderRepository(filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	testPointsLedger(t, r)
+}
+
+func newLoyaltyCheckout(t *testing.T, deps *fakeDeps) *checkoutService {
+	cs := newTestCheckout(t, deps)
+	cs.loyalty = &loyaltyProgram{pointsPerUSD: 2, settleAfter: time.Hour}
+	return cs
+}
+
+func TestPlaceOrderEarnsPoints(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	cs := newLoyaltyCheckout(t, deps)
+
+	resp, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1"))
+	if err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	// 48.97 USD earns 2 points for each whole dollar.
+	if got := resp.GetOrder().GetPointsEarned(); got != 96 {
+		t.Errorf("points earned = %d, want 96", got)
+	}
+	bal, err := cs.GetLoyaltyBalance(context.Background(), &pb.GetLoyaltyBalanceRequest{UserId: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bal.GetSettledPoints() != 0 || bal.GetPendingPoints() != 96 {
+		t.Errorf("GetLoyaltyBalance() = %v, want 96 pending", bal)
+	}
+	list, err := cs.ListLoyaltyEntries(context.Background(), &pb.ListLoyaltyEntriesRequest{UserId: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := list.GetEntries(); len(e) != 1 || e[0].GetOrderId() != resp.GetOrder().GetOrderId() ||
+		e[0].GetState() != pb.LoyaltyEntryState_LOYALTY_ENTRY_STATE_PENDING {
+		t.Errorf("ListLoyaltyEntries() = %v, want the order's pending points", e)
+	}
+}
+
+func TestPlaceOrderRedeemsPoints(t *testing.T) {
+	ctx := context.Background()
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	cs := newLoyaltyCheckout(t, deps)
+	past := time.Now().Add(-time.Hour)
+	if err := cs.orders.AccruePoints(ctx, "u1", "earlier", 650, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	req := testOrderRequest("u1")
+	req.RedeemPoints = 550
+	resp, err := cs.PlaceOrder(ctx, req)
+	if err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	// 48.97 - 5.50
+	if got := deps.charges[0].GetAmount(); !proto.Equal(got, usd(43, 470000000)) {
+		t.Errorf("charged %v, want 43.47 USD", got)
+	}
+	o := resp.GetOrder()
+	if d := o.GetDiscounts(); len(d) != 1 || !proto.Equal(d[0].GetAmount(), usd(5, 500000000)) {
+		t.Errorf("discounts = %v, want 5.50 USD of points", d)
+	}
+	if o.GetPointsRedeemed() != 550 || o.GetPointsEarned() != 86 {
+		t.Errorf("points redeemed %d and earned %d, want 550 and 86", o.GetPointsRedeemed(), o.GetPointsEarned())
+	}
+	bal, err := cs.GetLoyaltyBalance(ctx, &pb.GetLoyaltyBalanceRequest{UserId: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bal.GetSettledPoints() != 100 || bal.GetPendingPoints() != 86 {
+		t.Errorf("GetLoyaltyBalance() = %v, want 100 settled and 86 pending", bal)
+	}
+
+	// The 100 points left are not enough for another order of 550.
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	if _, err := cs.PlaceOrder(ctx, req); errorReason(err) != reasonInsufficientPoints {
+		t.Errorf("PlaceOrder() error = %v, want reason %s", err, reasonInsufficientPoints)
+	}
+	// Nor can points pay for more than the order.
+	req.RedeemPoints = 100000
+	if _, err := cs.PlaceOrder(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("PlaceOrder(points worth more than the order) error = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestPlaceOrderPaidByPointsWithStoreCredit(t *testing.T) {
+	ctx := context.Background()
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	cs := newLoyaltyCheckout(t, deps)
+	past := time.Now().Add(-time.Hour)
+	if err := cs.orders.AccruePoints(ctx, "u1", "earlier", 5000, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.IssueBalance(ctx, &pb.IssueBalanceRequest{
+		Method: pb.PaymentMethod_PAYMENT_METHOD_STORE_CREDIT, Id: "u1", Amount: usd(5, 0),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Points cover all 48.97, leaving nothing for the store credit.
+	req := testOrderRequest("u1")
+	req.RedeemPoints = 4897
+	req.PaymentInstruments = []*pb.PaymentInstrument{{Method: pb.PaymentMethod_PAYMENT_METHOD_STORE_CREDIT}}
+	resp, err := cs.PlaceOrder(ctx, req)
+	if err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	if p := resp.GetOrder().GetPayments(); len(p) != 0 {
+		t.Errorf("payments = %v, want none", p)
+	}
+	if len(deps.charges) != 0 {
+		t.Errorf("charged the card %d times for an order paid with points", len(deps.charges))
+	}
+	if got, _ := cs.orders.Balance(ctx, balanceAccount{Method: pb.PaymentMethod_PAYMENT_METHOD_STORE_CREDIT, ID: "u1"}); !proto.Equal(got, usd(5, 0)) {
+		t.Errorf("store credit = %v, want the 5 USD untouched", got)
+	}
+}
+
+func TestPlaceOrderReversesPointsWhenShippingFails(t *testing.T) {
+	ctx := context.Background()
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	deps.shipErr = status.Error(codes.Unavailable, "no trucks")
+	cs := newLoyaltyCheckout(t, deps)
+	past := time.Now().Add(-time.Hour)
+	if err := cs.orders.AccruePoints(ctx, "u1", "earlier", 300, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	req := testOrderRequest("u1")
+	req.RedeemPoints = 300
+	if _, err := cs.PlaceOrder(ctx, req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("PlaceOrder() error = %v, want code %v", err, codes.Unavailable)
+	}
+	bal, err := cs.GetLoyaltyBalance(ctx, &pb.GetLoyaltyBalanceRequest{UserId: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bal.GetSettledPoints() != 300 || bal.GetPendingPoints() != 0 {
+		t.Errorf("GetLoyaltyBalance() = %v, want the 300 points back and none earned", bal)
+	}
+}
+
+func TestCancelOrderReversesPoints(t *testing.T) {
+	ctx := context.Background()
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	stopped := newLoyaltyCheckout(t, deps)
+	past := time.Now().Add(-time.Hour)
+	if err := stopped.orders.AccruePoints(ctx, "u1", "earlier", 300, past, past); err != nil {
+		t.Fatal(err)
+	}
+	orders := &stallingOrders{orderRepository: stopped.orders, stalled: make(chan string), resume: make(chan struct{})}
+	stopped.orders = orders
+	req := testOrderRequest("u1")
+	req.RedeemPoints = 300
+	placed := make(chan struct{})
+	go func() {
+		defer close(placed)
+		stopped.PlaceOrder(ctx, req)
+	}()
+	orderID := <-orders.stalled
+
+	cs := newLoyaltyCheckout(t, deps)
+	cs.orders = orders.orderRepository
+	if _, err := cs.CancelOrder(ctx, &pb.CancelOrderRequest{OrderId: orderID, UserId: "u1"}); err != nil {
+		t.Fatalf("CancelOrder() failed: %v", err)
+	}
+	list, err := cs.ListLoyaltyEntries(ctx, &pb.ListLoyaltyEntriesRequest{UserId: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The order earned points and redeemed 300; both are reversed.
+	var entries []*pb.LoyaltyEntry
+	for _, e := range list.GetEntries() {
+		if e.GetOrderId() == orderID {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) != 2 {
+		t.Fatalf("order has %d loyalty entries, want 2: %v", len(entries), entries)
+	}
+	for _, e := range entries {
+		if e.GetState() != pb.LoyaltyEntryState_LOYALTY_ENTRY_STATE_REVERSED {
+			t.Errorf("entry of %d points is %v, want %v", e.GetPoints(), e.GetState(), pb.LoyaltyEntryState_LOYALTY_ENTRY_STATE_REVERSED)
+		}
+	}
+	bal, err := cs.GetLoyaltyBalance(ctx, &pb.GetLoyaltyBalanceRequest{UserId: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bal.GetSettledPoints() != 300 || bal.GetPendingPoints() != 0 {
+		t.Errorf("GetLoyaltyBalance() = %v, want the 300 points back and none earned", bal)
+	}
+
+	close(orders.resume)
+	<-placed
+}
@@ -16,296 +16,778 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"net"
-	"os"
+	"strings"
 	"time"
 
-	"cloud.google.com/go/profiler"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
-	pb "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/genproto"
-	money "github.com/GoogleCloudPlatform/microservices-demo/src/checkoutservice/money"
-	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/bootstrap"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/envconfig"
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
 
-	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/propagation"
-	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
 	listenPort  = "5050"
+	metricsPort = "9090"
 	usdCurrency = "USD"
-)
 
-var log *logrus.Logger
+	// maxPrepConcurrency bounds the catalog and currency lookups a single
+	// order makes at once.
+	maxPrepConcurrency = 8
+)
 
-func init() {
-	log = logrus.New()
-	log.Level = logrus.DebugLevel
-	log.Formatter = &logrus.JSONFormatter{
-		FieldMap: logrus.FieldMap{
-			logrus.FieldKeyTime:  "timestamp",
-			logrus.FieldKeyLevel: "severity",
-			logrus.FieldKeyMsg:   "message",
-		},
-		TimestampFormat: time.RFC3339Nano,
-	}
-	log.Out = os.Stdout
-}
+var log = bootstrap.NewLogger()
 
 type checkoutService struct {
 	pb.UnimplementedCheckoutServiceServer
+	pb.UnimplementedCheckoutAdminServiceServer
 
-	productCatalogSvcAddr string
 	productCatalogSvcConn *grpc.ClientConn
 
-	cartSvcAddr string
 	cartSvcConn *grpc.ClientConn
 
-	currencySvcAddr string
 	currencySvcConn *grpc.ClientConn
 
-	shippingSvcAddr string
 	shippingSvcConn *grpc.ClientConn
 
-	emailSvcAddr string
 	emailSvcConn *grpc.ClientConn
 
-	paymentSvcAddr string
 	paymentSvcConn *grpc.ClientConn
+
+	sagaLog     sagaLog
+	idempotency idempotencyStore
+	orders      orderRepository
+	orderLocks  orderLocks
+	running     runningSagas
+	outbox      *outboxDispatcher
+	promotions  *promotionRules
+	taxes       *taxRules
+	quotes      *quoteSigner
+	risk        *riskEngine
+	currencies  supportedCurrencies
+	rates       *money.ExchangeRates
+	loyalty     *loyaltyProgram
+	metrics     *checkoutMetrics
+	webhooks    *webhooks
+}
+
+// config is the environment checkout reads besides bootstrap.Config.
+type config struct {
+	ShippingAddr       string `env:"SHIPPING_SERVICE_ADDR" required:"true"`
+	ProductCatalogAddr string `env:"PRODUCT_CATALOG_SERVICE_ADDR" required:"true"`
+	CartAddr           string `env:"CART_SERVICE_ADDR" required:"true"`
+	CurrencyAddr       string `env:"CURRENCY_SERVICE_ADDR" required:"true"`
+	EmailAddr          string `env:"EMAIL_SERVICE_ADDR" required:"true"`
+	PaymentAddr        string `env:"PAYMENT_SERVICE_ADDR" required:"true"`
+
+	SagaLogPath          string `env:"SAGA_LOG_PATH"`
+	IdempotencyStorePath string `env:"IDEMPOTENCY_STORE_PATH"`
+	OrderDBPath          string `env:"ORDER_DB_PATH"`
+	WebhooksPath         string `env:"WEBHOOKS_PATH"`
+	PromotionsPath       string `env:"PROMOTIONS_PATH"`
+	TaxRulesPath         string `env:"TAX_RULES_PATH"`
+	RiskRulesPath        string `env:"RISK_RULES_PATH"`
+	RiskScorerURL        string `env:"RISK_SCORER_URL"`
+	QuoteSigningKey      string `env:"QUOTE_SIGNING_KEY"`
+
+	// AdminPort serves CheckoutAdminService, which is kept off the port
+	// shoppers' requests reach checkout on.
+	AdminPort string `env:"ADMIN_PORT" default:"5051"`
 }
 
 func main() {
 	ctx := context.Background()
-	if os.Getenv("ENABLE_TRACING") == "1" {
-		log.Info("Tracing enabled.")
-		initTracing()
+	app, err := bootstrap.Start(ctx, bootstrap.Options{
+		Name:        "checkoutservice",
+		Version:     "1.0.0",
+		Port:        listenPort,
+		MetricsPort: metricsPort,
+		Log:         log,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	var cfg config
+	if err := envconfig.Load(&cfg); err != nil {
+		log.Fatal(err)
+	}
 
+	svc := new(checkoutService)
+	if svc.metrics, err = newCheckoutMetrics(app.MeterProvider.Meter("checkoutservice")); err != nil {
+		log.Fatal(err)
+	}
+	svc.shippingSvcConn = app.MustDial(cfg.ShippingAddr, svc.metrics.unaryClientInterceptor(), mustCallPolicy("SHIPPING_SERVICE", 3*time.Second).unaryClientInterceptor())
+	svc.productCatalogSvcConn = app.MustDial(cfg.ProductCatalogAddr, svc.metrics.unaryClientInterceptor(), mustCallPolicy("PRODUCT_CATALOG_SERVICE", 2*time.Second).unaryClientInterceptor())
+	svc.cartSvcConn = app.MustDial(cfg.CartAddr, svc.metrics.unaryClientInterceptor(), mustCallPolicy("CART_SERVICE", 2*time.Second).unaryClientInterceptor())
+	svc.currencySvcConn = app.MustDial(cfg.CurrencyAddr, svc.metrics.unaryClientInterceptor(), mustCallPolicy("CURRENCY_SERVICE", 2*time.Second).unaryClientInterceptor())
+	svc.emailSvcConn = app.MustDial(cfg.EmailAddr, svc.metrics.unaryClientInterceptor(), mustCallPolicy("EMAIL_SERVICE", 3*time.Second).unaryClientInterceptor())
+	svc.paymentSvcConn = app.MustDial(cfg.PaymentAddr, svc.metrics.unaryClientInterceptor(), mustCallPolicy("PAYMENT_SERVICE", 5*time.Second).unaryClientInterceptor())
+
+	if p := cfg.SagaLogPath; p != "" {
+		l, err := newFileSagaLog(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		svc.sagaLog = l
 	} else {
-		log.Info("Tracing disabled.")
+		log.Warn("SAGA_LOG_PATH not set, orders in flight will not be compensated after a restart")
+		svc.sagaLog = newMemorySagaLog()
 	}
-
-	if os.Getenv("ENABLE_PROFILER") == "1" {
-		log.Info("Profiling enabled.")
-		go initProfiling("checkoutservice", "1.0.0")
+	if p := cfg.IdempotencyStorePath; p != "" {
+		st, err := newFileIdempotencyStore(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		svc.idempotency = st
 	} else {
-		log.Info("Profiling disabled.")
+		svc.idempotency = newMemoryIdempotencyStore()
 	}
-
-	port := listenPort
-	if os.Getenv("PORT") != "" {
-		port = os.Getenv("PORT")
+	if p := cfg.OrderDBPath; p != "" {
+		r, err := newSQLOrderRepository(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		svc.orders = r
+	} else {
+		log.Warn("ORDER_DB_PATH not set, placed orders and undelivered order messages will be lost on restart")
+		svc.orders = newMemoryOrderRepository()
 	}
-
-	svc := new(checkoutService)
-	mustMapEnv(&svc.shippingSvcAddr, "SHIPPING_SERVICE_ADDR")
-	mustMapEnv(&svc.productCatalogSvcAddr, "PRODUCT_CATALOG_SERVICE_ADDR")
-	mustMapEnv(&svc.cartSvcAddr, "CART_SERVICE_ADDR")
-	mustMapEnv(&svc.currencySvcAddr, "CURRENCY_SERVICE_ADDR")
-	mustMapEnv(&svc.emailSvcAddr, "EMAIL_SERVICE_ADDR")
-	mustMapEnv(&svc.paymentSvcAddr, "PAYMENT_SERVICE_ADDR")
-
-	mustConnGRPC(ctx, &svc.shippingSvcConn, svc.shippingSvcAddr)
-	mustConnGRPC(ctx, &svc.productCatalogSvcConn, svc.productCatalogSvcAddr)
-	mustConnGRPC(ctx, &svc.cartSvcConn, svc.cartSvcAddr)
-	mustConnGRPC(ctx, &svc.currencySvcConn, svc.currencySvcAddr)
-	mustConnGRPC(ctx, &svc.emailSvcConn, svc.emailSvcAddr)
-	mustConnGRPC(ctx, &svc.paymentSvcConn, svc.paymentSvcAddr)
-
-	log.Infof("service config: %+v", svc)
-
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
+	if p := cfg.WebhooksPath; p != "" {
+		w, err := newWebhooks(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		svc.webhooks = w
+	}
+	svc.outbox = newOutboxDispatcher(svc.orders, svc.outboxHandlers())
+	go svc.outbox.run(ctx)
+	if p := cfg.PromotionsPath; p != "" {
+		r, err := newPromotionRules(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		svc.promotions = r
+		go r.watch(ctx, promotionsPollInterval)
+	}
+	if p := cfg.TaxRulesPath; p != "" {
+		t, err := newTaxRules(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		svc.taxes = t
+	} else {
+		log.Warn("TAX_RULES_PATH not set, orders will not be taxed")
+	}
+	riskCfg := defaultRiskConfig()
+	if p := cfg.RiskRulesPath; p != "" {
+		c, err := loadRiskConfig(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		riskCfg = c
+	}
+	var scorer riskScorer
+	if u := cfg.RiskScorerURL; u != "" {
+		scorer = newHTTPRiskScorer(u)
+	}
+	risk, err := newRiskEngine(riskCfg, scorer)
 	if err != nil {
 		log.Fatal(err)
 	}
+	svc.risk = risk
+	if svc.loyalty, err = loyaltyProgramFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if svc.rates, err = money.ExchangeRatesFromEnv(); err != nil {
+		log.Fatal(err)
+	} else if svc.rates == nil {
+		log.Warn("EXCHANGE_RATES_PATH not set, orders in other currencies will fail while the currency service is unavailable")
+	}
+	if k := cfg.QuoteSigningKey; k != "" {
+		svc.quotes = newQuoteSigner([]byte(k))
+	} else {
+		log.Warn("QUOTE_SIGNING_KEY not set, order quotes are only valid on this replica until it restarts")
+		q, err := newRandomQuoteSigner()
+		if err != nil {
+			log.Fatal(err)
+		}
+		svc.quotes = q
+	}
+	if err := recoverSagas(ctx, svc.orderSagaLog(), svc.compensators(), stepShipOrder, svc.finishSaga); err != nil {
+		log.Fatal(err)
+	}
+	if err := svc.settleInterruptedOrders(ctx); err != nil {
+		log.Fatal(err)
+	}
 
-	var srv *grpc.Server
-
-	// Propagate trace context always
-	otel.SetTextMapPropagator(
-		propagation.NewCompositeTextMapPropagator(
-			propagation.TraceContext{}, propagation.Baggage{}))
-	srv = grpc.NewServer(
-		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
-		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
-	)
+	log.Infof("service config: %+v", svc)
 
+	srv := app.NewServer()
 	pb.RegisterCheckoutServiceServer(srv, svc)
-	healthpb.RegisterHealthServer(srv, svc)
-	log.Infof("starting to listen on tcp: %q", lis.Addr().String())
-	err = srv.Serve(lis)
-	log.Fatal(err)
+	admin := app.NewServer()
+	pb.RegisterCheckoutAdminServiceServer(admin, svc)
+	if err := app.ServeGRPC(ctx, srv, bootstrap.PortServer{Port: cfg.AdminPort, Server: admin}); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func initStats() {
-	//TODO(arbrown) Implement OpenTelemetry stats
+// mustCallPolicy returns the call policy for the downstream whose
+// environment variables start with prefix.
+func mustCallPolicy(prefix string, timeout time.Duration) callPolicy {
+	p, err := callPolicyFromEnv(prefix, defaultCallPolicy(timeout))
+	if err != nil {
+		panic(err)
+	}
+	return p
 }
 
-func initTracing() {
-	var (
-		collectorAddr string
-		collectorConn *grpc.ClientConn
-	)
+func (cs *checkoutService) PlaceOrder(ctx context.Context, req *pb.PlaceOrderRequest) (resp *pb.PlaceOrderResponse, err error) {
+	log.Infof("[PlaceOrder] user_id=%q user_currency=%q", req.UserId, req.UserCurrency)
+	ctx, attempt := withOrderAttempt(ctx)
+	defer func() {
+		if err != nil {
+			cs.metrics.orderFailed(ctx, attempt.failureStage(), err)
+		}
+	}()
+	if err := cs.validatePlaceOrderRequest(ctx, req); err != nil {
+		return nil, err
+	}
 
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
-	defer cancel()
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate order uuid")
+	}
+	orderID := id.String()
+	key := req.GetIdempotencyKey()
+	if key == "" {
+		return cs.placeOrder(ctx, orderID, req)
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		return nil, status.Errorf(codes.InvalidArgument, "idempotency key longer than %d characters", maxIdempotencyKeyLen)
+	}
+	fingerprint, err := requestFingerprint(req)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to fingerprint request: %+v", err)
+	}
+	prev, err := cs.idempotency.Begin(key, fingerprint, orderID)
+	switch {
+	case errors.Is(err, errIdempotencyConflict):
+		return nil, status.Errorf(codes.AlreadyExists, "idempotency key %q was already used for a different order", key)
+	case errors.Is(err, errIdempotencyInFlight):
+		return nil, status.Errorf(codes.Aborted, "order with idempotency key %q is still being placed", key)
+	case errors.Is(err, errIdempotencyInterrupted):
+		return nil, status.Errorf(codes.Unavailable, "order with idempotency key %q was interrupted and is not settled yet", key)
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "failed to check idempotency key: %+v", err)
+	case prev != nil:
+		log.Infof("replaying order %s for idempotency key %q", prev.GetOrder().GetOrderId(), key)
+		return prev, nil
+	}
 
-	mustMapEnv(&collectorAddr, "COLLECTOR_SERVICE_ADDR")
-	mustConnGRPC(ctx, &collectorConn, collectorAddr)
+	resp, err = cs.placeOrder(ctx, orderID, req)
+	if err != nil {
+		if rerr := cs.idempotency.Release(key); rerr != nil {
+			log.Warnf("failed to release idempotency key %q: %+v", key, rerr)
+		}
+		return nil, err
+	}
+	if err := cs.idempotency.Complete(key, resp); err != nil {
+		log.Warnf("failed to store response for idempotency key %q: %+v", key, err)
+	}
+	return resp, nil
+}
 
-	exporter, err := otlptracegrpc.New(
-		ctx,
-		otlptracegrpc.WithGRPCConn(collectorConn))
+func (cs *checkoutService) placeOrder(ctx context.Context, orderID string, req *pb.PlaceOrderRequest) (*pb.PlaceOrderResponse, error) {
+	prep, promotions, err := cs.priceOrder(ctx, req.UserId, req.UserCurrency, req.Address, req.GetPromoCode())
 	if err != nil {
-		log.Warnf("warn: Failed to create trace exporter: %v", err)
+		return nil, err
+	}
+	order, err := cs.pricedOrder(req.Address, prep, promotions)
+	if err != nil {
+		return nil, err
+	}
+	if t := req.GetQuoteToken(); t != "" {
+		if err := cs.checkQuote(t, req, order); err != nil {
+			return nil, quoteError(err)
+		}
 	}
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
-		sdktrace.WithSampler(sdktrace.AlwaysSample()))
-	otel.SetTracerProvider(tp)
 
-}
+	order.OrderId = orderID
+	order.ShippingAddress = req.Address
+	order.UserId = req.UserId
+	order.Email = req.Email
+	// Points are taken off after the quote check, as quotes are made
+	// without them.
+	var redeemed *pb.Money
+	if n := req.GetRedeemPoints(); n > 0 {
+		enterStage(ctx, stageLoyaltyPoints)
+		if redeemed, err = cs.pointsDiscount(ctx, req, order, prep.conv); err != nil {
+			return nil, err
+		}
+		applyPoints(order, n, redeemed)
+		prep.conv.markExchangeRates(order)
+	}
+	if cs.risk != nil {
+		done := startStage(ctx, pb.PlaceOrderStage_PLACE_ORDER_STAGE_RISK_CHECK)
+		if order.Risk, err = cs.risk.assess(ctx, cs.risk.newRiskInput(req, order), prep.conv); err != nil {
+			err = status.Errorf(codes.Internal, "failed to assess order risk: %+v", err)
+			done(err)
+			return nil, err
+		}
+		if order.Risk.GetDecision() == pb.RiskDecision_RISK_DECISION_DENY {
+			cs.recordDeniedOrder(ctx, order)
+			err := riskDeniedError(order.OrderId, order.Risk)
+			done(err)
+			return nil, err
+		}
+		done(nil)
+		if order.Risk.GetDecision() == pb.RiskDecision_RISK_DECISION_REVIEW {
+			log.Warnf("order %s flagged for review: score %d %v", order.OrderId, order.Risk.GetScore(), order.Risk.GetReasons())
+		}
+	}
 
-func initProfiling(service, version string) {
-	// TODO(ahmetb) this method is duplicated in other microservices using Go
-	// since they are not sharing packages.
-	for i := 1; i <= 3; i++ {
-		if err := profiler.Start(profiler.Config{
-			Service:        service,
-			ServiceVersion: version,
-			// ProjectID must be set if not running on GCP.
-			// ProjectID: "my-project",
+	// Redeeming promotions, reserving stock, charging and shipping run as a
+	// saga: if a later step fails, the charge is refunded, the stock released
+	// and the promotions given back rather than left in place.
+	sg, err := newSaga(orderID, cs.orderSagaLog(), cs.compensators())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to start order: %+v", err)
+	}
+	defer cs.running.add(sg)()
+
+	if len(promotions) > 0 {
+		enterStage(ctx, stagePromotions)
+		evaluated := len(promotions)
+		if err := sg.step(ctx, stepRedeemPromotions, func(ctx context.Context) (interface{}, error) {
+			var err error
+			promotions, err = cs.redeemPromotions(ctx, orderID, req.GetUserId(), promotions)
+			rec := promotionRecord{OrderID: orderID}
+			for _, p := range promotions {
+				rec.PromotionIDs = append(rec.PromotionIDs, p.ID)
+			}
+			return rec, err
 		}); err != nil {
-			log.Warnf("failed to start profiler: %+v", err)
-		} else {
-			log.Info("started Stackdriver profiler")
-			return
+			cs.abortOrder(ctx, sg, fmt.Sprintf("promotion failed: %v", err))
+			if errors.Is(err, errPromotionExhausted) {
+				return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to redeem promotions: %+v", err)
+		}
+		if len(promotions) != evaluated {
+			// A sale ran out since the order was priced.
+			if req.GetQuoteToken() != "" {
+				cs.abortOrder(ctx, sg, "a sale ran out since the quote")
+				return nil, quoteError(errQuoteStale)
+			}
+			repriced, err := cs.pricedOrder(req.Address, prep, promotions)
+			if err != nil {
+				cs.abortOrder(ctx, sg, "failed to reprice order")
+				return nil, err
+			}
+			order.Total, order.Discounts, order.Taxes = repriced.Total, repriced.Discounts, repriced.Taxes
+			if redeemed != nil {
+				applyPoints(order, req.GetRedeemPoints(), redeemed)
+			}
+		}
+	}
+	if redeemed != nil {
+		enterStage(ctx, stageLoyaltyPoints)
+		if err := sg.step(ctx, stepRedeemPoints, func(ctx context.Context) (interface{}, error) {
+			rec := pointsRecord{UserID: req.GetUserId(), OrderID: orderID, Points: req.GetRedeemPoints()}
+			return rec, cs.orders.SpendPoints(ctx, rec.UserID, rec.OrderID, rec.Points, time.Now())
+		}); err != nil {
+			cs.abortOrder(ctx, sg, fmt.Sprintf("redeeming loyalty points failed: %v", err))
+			if errors.Is(err, errInsufficientPoints) {
+				return nil, insufficientPointsError(err)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to redeem loyalty points: %+v", err)
 		}
-		d := time.Second * 10 * time.Duration(i)
-		log.Infof("sleeping %v to retry initializing Stackdriver profiler", d)
-		time.Sleep(d)
 	}
-	log.Warn("could not initialize Stackdriver profiler after retrying, giving up")
-}
 
-func mustMapEnv(target *string, envKey string) {
-	v := os.Getenv(envKey)
-	if v == "" {
-		panic(fmt.Sprintf("environment variable %q not set", envKey))
+	total := order.GetTotal()
+	order = newPendingOrder(order)
+	if err := cs.orders.Create(ctx, order); err != nil {
+		cs.abortOrder(ctx, sg, "failed to create order")
+		return nil, status.Errorf(codes.Internal, "failed to create order: %+v", err)
+	}
+
+	var reservationID string
+	done := startStage(ctx, pb.PlaceOrderStage_PLACE_ORDER_STAGE_RESERVE_STOCK)
+	err = sg.step(ctx, stepReserveStock, func(ctx context.Context) (interface{}, error) {
+		var err error
+		reservationID, err = cs.reserveStock(ctx, order.OrderId, prep.cartItems)
+		return reservationRecord{ReservationID: reservationID}, err
+	})
+	done(err)
+	if err != nil {
+		cs.abortOrder(ctx, sg, fmt.Sprintf("stock reservation failed: %v", err))
+		return nil, asStatus(err, codes.Internal, "failed to reserve stock")
 	}
-	*target = v
-}
 
-func mustConnGRPC(ctx context.Context, conn **grpc.ClientConn, addr string) {
-	var err error
-	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
-	defer cancel()
-	*conn, err = grpc.DialContext(ctx, addr,
-		grpc.WithInsecure(),
-		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
-		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()))
+	done = startStage(ctx, pb.PlaceOrderStage_PLACE_ORDER_STAGE_PAYMENT)
+	payments, err := cs.payOrder(ctx, sg, order.OrderId, req, total)
+	done(err)
 	if err != nil {
-		panic(errors.Wrapf(err, "grpc: failed to connect %s", addr))
+		cs.abortOrder(ctx, sg, fmt.Sprintf("payment failed: %v", err))
+		return nil, asStatus(err, codes.Internal, "failed to charge card")
 	}
+	// Points and promotions can leave nothing to pay, and then nothing is
+	// redeemed or charged.
+	paid := "nothing left to pay"
+	if len(payments) > 0 {
+		paid = "paid with gift card or store credit"
+		if txID := payments[len(payments)-1].GetTransactionId(); txID != "" {
+			log.Infof("payment went through (transaction_id: %s)", txID)
+			paid = "charged as transaction " + txID
+		}
+	}
+	setPayments := func(o *pb.OrderResult) { o.Payments = payments }
+	if _, err := cs.advanceOrder(ctx, order.OrderId, pb.OrderStatus_ORDER_STATUS_PAYMENT_AUTHORIZED, paid, setPayments); err != nil {
+		return nil, cs.failOrder(ctx, sg, err)
+	}
+	earned := cs.accruePoints(ctx, sg, req.GetUserId(), order.OrderId, total, prep.conv)
+	setEarned := func(o *pb.OrderResult) { o.PointsEarned = earned }
+
+	// The order must not be cancelled between being marked paid and being
+	// handed over to shipping.
+	unlock := cs.orderLocks.lock(order.OrderId)
+	if _, err := cs.advanceOrder(ctx, order.OrderId, pb.OrderStatus_ORDER_STATUS_PAID, "charge recorded", setEarned); err != nil {
+		unlock()
+		return nil, cs.failOrder(ctx, sg, err)
+	}
+
+	var shippingTrackingID string
+	done = startStage(ctx, pb.PlaceOrderStage_PLACE_ORDER_STAGE_SHIPPING)
+	err = sg.step(ctx, stepShipOrder, func(ctx context.Context) (interface{}, error) {
+		var err error
+		shippingTrackingID, err = cs.shipOrder(ctx, req.Address, prep.cartItems)
+		return shipmentRecord{TrackingID: shippingTrackingID}, err
+	})
+	done(err)
+	if err != nil {
+		unlock()
+		cs.abortOrder(ctx, sg, fmt.Sprintf("shipping failed: %v", err))
+		return nil, asStatus(err, codes.Unavailable, "shipping error")
+	}
+	cs.commitReservation(ctx, order.OrderId, reservationID)
+	if err := sg.complete(); err != nil {
+		log.Warnf("failed to record completion of order %s: %+v", orderID, err)
+	}
+
+	setTracking := func(o *pb.OrderResult) { o.ShippingTrackingId = shippingTrackingID }
+	done = startStage(ctx, pb.PlaceOrderStage_PLACE_ORDER_STAGE_CONFIRMATION)
+	o, err := cs.advanceOrder(ctx, order.OrderId, pb.OrderStatus_ORDER_STATUS_SHIPPED, "handed over to shipping", setTracking)
+	unlock()
+	if err != nil {
+		// The order has shipped regardless; report it as such, and send the
+		// confirmation directly since it did not make it into the outbox.
+		log.Errorf("failed to mark order %s shipped: %+v", order.OrderId, err)
+		setTracking(order)
+		order.Status = pb.OrderStatus_ORDER_STATUS_SHIPPED
+		err := cs.sendOrderConfirmation(ctx, req.Email, order)
+		if err != nil {
+			log.Warnf("failed to send order confirmation to %q: %+v", req.Email, err)
+		}
+		done(err)
+	} else {
+		// The confirmation email was queued with the status change.
+		order = o
+		cs.outbox.notify()
+		done(nil)
+	}
+
+	_ = cs.emptyUserCart(ctx, req.UserId)
+	cs.metrics.orderPlaced(ctx, order, prep.conv)
+
+	resp := &pb.PlaceOrderResponse{Order: order}
+	return resp, nil
+}
+
+const (
+	stepRedeemPromotions = "redeem_promotions"
+	stepRedeemPoints     = "redeem_points"
+	stepReserveStock     = "reserve_stock"
+	stepRedeemBalances   = "redeem_balances"
+	stepChargeCard       = "charge_card"
+	stepAccruePoints     = "accrue_points"
+	// stepShipOrder is the saga's pivot: once an order has shipped it is
+	// no longer compensated.
+	stepShipOrder = "ship_order"
+)
+
+// chargeRecord is what the saga log keeps about a charge so it can be
+// refunded. A charge that was interrupted has no TransactionID; it is found
+// by its order id, which the charge is made with as its idempotency key.
+type chargeRecord struct {
+	OrderID       string    `json:"order_id,omitempty"`
+	TransactionID string    `json:"transaction_id"`
+	Amount        *pb.Money `json:"amount"`
 }
 
-func (cs *checkoutService) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
-	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+type shipmentRecord struct {
+	TrackingID string `json:"tracking_id"`
 }
 
-func (cs *checkoutService) Watch(req *healthpb.HealthCheckRequest, ws healthpb.Health_WatchServer) error {
-	return status.Errorf(codes.Unimplemented, "health check via Watch not implemented")
+type reservationRecord struct {
+	ReservationID string `json:"reservation_id"`
 }
 
-func (cs *checkoutService) PlaceOrder(ctx context.Context, req *pb.PlaceOrderRequest) (*pb.PlaceOrderResponse, error) {
-	log.Infof("[PlaceOrder] user_id=%q user_currency=%q", req.UserId, req.UserCurrency)
+type promotionRecord struct {
+	OrderID      string   `json:"order_id"`
+	PromotionIDs []string `json:"promotion_ids"`
+}
 
-	orderID, err := uuid.NewUUID()
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to generate order uuid")
-	}
+// compensators returns how to undo each PlaceOrder saga step.
+func (cs *checkoutService) compensators() map[string]compensator {
+	return cs.metrics.countCompensations(map[string]compensator{
+		stepRedeemPromotions: cs.compensatePromotions,
+		stepRedeemPoints:     cs.compensatePoints(pointsSpent),
+		stepReserveStock:     cs.compensateReservation,
+		stepRedeemBalances:   cs.compensateBalances,
+		stepChargeCard:       cs.compensateCharge,
+		stepAccruePoints:     cs.compensatePoints(pointsEarned),
+	})
+}
 
-	prep, err := cs.prepareOrderItemsAndShippingQuoteFromCart(ctx, req.UserId, req.UserCurrency, req.Address)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, err.Error())
+func (cs *checkoutService) compensatePromotions(ctx context.Context, data json.RawMessage, _ string) error {
+	var rec promotionRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return fmt.Errorf("failed to decode promotion record: %+v", err)
 	}
+	for _, id := range rec.PromotionIDs {
+		if err := cs.orders.Unredeem(ctx, id, rec.OrderID); err != nil {
+			return fmt.Errorf("failed to give back promotion %s: %+v", id, err)
+		}
+	}
+	return nil
+}
 
-	total := pb.Money{CurrencyCode: req.UserCurrency,
-		Units: 0,
-		Nanos: 0}
-	total = money.Must(money.Sum(total, *prep.shippingCostLocalized))
-	for _, it := range prep.orderItems {
-		multPrice := money.MultiplySlow(*it.Cost, uint32(it.GetItem().GetQuantity()))
-		total = money.Must(money.Sum(total, multPrice))
+func (cs *checkoutService) compensateReservation(ctx context.Context, data json.RawMessage, _ string) error {
+	var rec reservationRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return fmt.Errorf("failed to decode reservation record: %+v", err)
 	}
+	if err := cs.releaseReservation(ctx, rec.ReservationID); err != nil {
+		return err
+	}
+	log.Infof("stock released (reservation_id: %s)", rec.ReservationID)
+	return nil
+}
 
-	txID, err := cs.chargeCard(ctx, &total, req.CreditCard)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to charge card: %+v", err)
+// finishSaga completes the work left after the pivot of a PlaceOrder saga
+// that was interrupted by a restart: the shipped order's stock reservation
+// is committed before it expires.
+func (cs *checkoutService) finishSaga(ctx context.Context, sg *saga) {
+	data, ok := sg.data(stepReserveStock)
+	if !ok {
+		return
+	}
+	var rec reservationRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		log.Errorf("saga %s: failed to decode reservation record: %+v", sg.id, err)
+		return
 	}
-	log.Infof("payment went through (transaction_id: %s)", txID)
+	cs.commitReservation(ctx, sg.id, rec.ReservationID)
+}
 
-	shippingTrackingID, err := cs.shipOrder(ctx, req.Address, prep.cartItems)
+func (cs *checkoutService) compensateCharge(ctx context.Context, data json.RawMessage, reason string) error {
+	var rec chargeRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return fmt.Errorf("failed to decode charge record: %+v", err)
+	}
+	if rec.TransactionID == "" {
+		return cs.voidCharge(ctx, rec, reason)
+	}
+	refundID, err := cs.refundCard(ctx, rec.TransactionID, rec.Amount, reason)
 	if err != nil {
-		return nil, status.Errorf(codes.Unavailable, "shipping error: %+v", err)
+		return err
+	}
+	log.Infof("payment refunded (transaction_id: %s, refund_id: %s)", rec.TransactionID, refundID)
+	if rec.OrderID != "" {
+		cs.markRefunded(ctx, rec.OrderID, reason, "refunded as "+refundID)
 	}
+	return nil
+}
 
-	_ = cs.emptyUserCart(ctx, req.UserId)
+// payOrder pays total with the request's gift cards and store credit, then
+// charges the card for what they leave, and returns the payments made, the
+// card's last. Each is a saga step, so that a later failure refunds them to
+// the same instruments.
+func (cs *checkoutService) payOrder(ctx context.Context, sg *saga, orderID string, req *pb.PlaceOrderRequest, total *pb.Money) ([]*pb.OrderPayment, error) {
+	var payments []*pb.OrderPayment
+	remaining := total
+	if len(req.GetPaymentInstruments()) > 0 {
+		if err := sg.step(ctx, stepRedeemBalances, func(ctx context.Context) (interface{}, error) {
+			var (
+				rec balanceRecord
+				err error
+			)
+			payments, rec, err = cs.redeemBalances(ctx, orderID, req.GetUserId(), req.GetPaymentInstruments(), total)
+			return rec, err
+		}); err != nil {
+			return nil, err
+		}
+		for _, p := range payments {
+			remaining = money.Must(money.Sum(remaining, money.Negate(p.Amount)))
+		}
+		if money.IsZero(remaining) {
+			return payments, nil
+		}
+	}
+	if req.GetCreditCard() == nil {
+		return nil, insufficientFundsError(remaining)
+	}
 
-	orderResult := &pb.OrderResult{
-		OrderId:            orderID.String(),
-		ShippingTrackingId: shippingTrackingID,
-		ShippingCost:       prep.shippingCostLocalized,
-		ShippingAddress:    req.Address,
-		Items:              prep.orderItems,
+	var txID string
+	intent := chargeRecord{OrderID: orderID, Amount: remaining}
+	if err := sg.stepWithIntent(ctx, stepChargeCard, intent, func(ctx context.Context) (interface{}, error) {
+		var err error
+		txID, err = cs.chargeCard(ctx, orderID, remaining, req.GetCreditCard())
+		return chargeRecord{OrderID: orderID, TransactionID: txID, Amount: remaining}, err
+	}); err != nil {
+		return nil, err
 	}
+	return append(payments, &pb.OrderPayment{
+		Method:        pb.PaymentMethod_PAYMENT_METHOD_CREDIT_CARD,
+		Instrument:    lastFour(cardDigits(req.GetCreditCard().GetCreditCardNumber())),
+		Amount:        remaining,
+		TransactionId: txID,
+	}), nil
+}
 
-	if err := cs.sendOrderConfirmation(ctx, req.Email, orderResult); err != nil {
-		log.Warnf("failed to send order confirmation to %q: %+v", req.Email, err)
-	} else {
-		log.Infof("order confirmation email sent to %q", req.Email)
+// abortOrder compensates the order's completed steps. Failures are logged;
+// the saga stays in the log so that they are retried on restart.
+func (cs *checkoutService) abortOrder(ctx context.Context, sg *saga, reason string) {
+	log.Warnf("aborting order %s: %s", sg.id, reason)
+	cs.cancelOrder(ctx, sg.id, reason)
+	if err := sg.abort(ctx, reason); err != nil {
+		log.Errorf("order %s was not fully compensated: %+v", sg.id, err)
 	}
-	resp := &pb.PlaceOrderResponse{Order: orderResult}
-	return resp, nil
 }
 
 type orderPrep struct {
 	orderItems            []*pb.OrderItem
 	cartItems             []*pb.CartItem
 	shippingCostLocalized *pb.Money
+	// categories maps the ids of the ordered products to their categories.
+	categories map[string][]string
+	// conv converts any other amounts the order needs.
+	conv *currencyConverter
 }
 
-func (cs *checkoutService) prepareOrderItemsAndShippingQuoteFromCart(ctx context.Context, userID, userCurrency string, address *pb.Address) (orderPrep, error) {
-	var out orderPrep
-	cartItems, err := cs.getUserCart(ctx, userID)
+// priceOrder prices the items in the user's cart and shipping to address,
+// and works out which promotions apply. Errors are gRPC statuses.
+func (cs *checkoutService) priceOrder(ctx context.Context, userID, userCurrency string, address *pb.Address, promoCode string) (orderPrep, []appliedPromotion, error) {
+	prep, err := cs.prepareOrderItemsAndShippingQuoteFromCart(ctx, userID, userCurrency, address)
 	if err != nil {
-		return out, fmt.Errorf("cart failure: %+v", err)
+		return orderPrep{}, nil, asStatus(err, codes.Internal, "failed to prepare order")
 	}
-	orderItems, err := cs.prepOrderItems(ctx, cartItems, userCurrency)
-	if err != nil {
-		return out, fmt.Errorf("failed to prepare order: %+v", err)
+
+	promotions, err := cs.evaluatePromotions(ctx, promoCode, prep)
+	switch {
+	case errors.Is(err, errUnknownPromoCode):
+		return orderPrep{}, nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	case errors.Is(err, errPromotionNotApplicable):
+		return orderPrep{}, nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+	case err != nil:
+		return orderPrep{}, nil, status.Errorf(codes.Internal, "failed to apply promotions: %+v", err)
 	}
-	shippingUSD, err := cs.quoteShipping(ctx, address, cartItems)
+	return prep, promotions, nil
+}
+
+// pricedOrder returns an order of the prepared items and shipping with the
+// promotions taken off and taxes for shipping to address added, its total
+// rounded to the minor units of its currency. Only its pricing is filled in.
+// Errors are gRPC statuses.
+func (cs *checkoutService) pricedOrder(address *pb.Address, prep orderPrep, promotions []appliedPromotion) (*pb.OrderResult, error) {
+	taxes, exclusiveTax, err := cs.orderTaxes(address, prep, promotions)
 	if err != nil {
-		return out, fmt.Errorf("shipping quote failure: %+v", err)
+		return nil, status.Errorf(codes.Internal, "failed to calculate taxes: %+v", err)
+	}
+
+	total := &pb.Money{CurrencyCode: prep.shippingCostLocalized.GetCurrencyCode()}
+	total = money.Must(money.Sum(total, prep.shippingCostLocalized))
+	for _, it := range prep.orderItems {
+		multPrice := money.Must(money.Multiply(it.Cost, int64(it.GetItem().GetQuantity())))
+		total = money.Must(money.Sum(total, multPrice))
+	}
+	for _, p := range promotions {
+		total = money.Must(money.Sum(total, money.Negate(p.amount)))
+	}
+	total = money.Must(money.Sum(total, exclusiveTax))
+	// Converted prices have more decimals than can be charged.
+	if total, err = money.Round(total, money.RoundHalfEven); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to round order total: %+v", err)
+	}
+
+	order := &pb.OrderResult{
+		ShippingCost: prep.shippingCostLocalized,
+		Items:        prep.orderItems,
+		Total:        total,
+		Discounts:    orderDiscounts(promotions),
+		Taxes:        taxes,
+	}
+	prep.conv.markExchangeRates(order)
+	return order, nil
+}
+
+func (cs *checkoutService) prepareOrderItemsAndShippingQuoteFromCart(ctx context.Context, userID, userCurrency string, address *pb.Address) (orderPrep, error) {
+	var out orderPrep
+	done := startStage(ctx, pb.PlaceOrderStage_PLACE_ORDER_STAGE_CART)
+	cartItems, err := cs.getUserCart(ctx, userID)
+	if err == nil {
+		err = validateCart(cartItems)
 	}
-	shippingPrice, err := cs.convertCurrency(ctx, shippingUSD, userCurrency)
+	done(err)
 	if err != nil {
-		return out, fmt.Errorf("failed to convert shipping cost to currency: %+v", err)
+		return out, err
+	}
+
+	// Pricing the items and quoting shipping are independent; the first
+	// failure cancels the rest.
+	g, gctx := errgroup.WithContext(ctx)
+	conv := newCurrencyConverter(cs)
+	var (
+		orderItems []*pb.OrderItem
+		categories map[string][]string
+	)
+	g.Go(func() error {
+		var err error
+		done := startStage(ctx, pb.PlaceOrderStage_PLACE_ORDER_STAGE_PRICING)
+		orderItems, categories, err = cs.prepOrderItems(gctx, cartItems, userCurrency, conv)
+		done(err)
+		return err
+	})
+	var shippingPrice *pb.Money
+	g.Go(func() error {
+		done := startStage(ctx, pb.PlaceOrderStage_PLACE_ORDER_STAGE_SHIPPING_QUOTE)
+		shippingUSD, err := cs.quoteShipping(gctx, address, cartItems)
+		if err == nil {
+			if shippingPrice, err = conv.convert(gctx, shippingUSD, userCurrency); err != nil {
+				err = asStatus(err, codes.Internal, "failed to convert shipping cost to currency")
+			}
+		}
+		done(err)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return out, err
 	}
 
 	out.shippingCostLocalized = shippingPrice
 	out.cartItems = cartItems
 	out.orderItems = orderItems
+	out.categories = categories
+	out.conv = conv
 	return out, nil
 }
 
@@ -315,7 +797,7 @@ func (cs *checkoutService) quoteShipping(ctx context.Context, address *pb.Addres
 			Address: address,
 			Items:   items})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get shipping quote: %+v", err)
+		return nil, shippingError(err, "failed to get shipping quote")
 	}
 	return shippingQuote.GetCostUsd(), nil
 }
@@ -323,7 +805,7 @@ func (cs *checkoutService) quoteShipping(ctx context.Context, address *pb.Addres
 func (cs *checkoutService) getUserCart(ctx context.Context, userID string) ([]*pb.CartItem, error) {
 	cart, err := pb.NewCartServiceClient(cs.cartSvcConn).GetCart(ctx, &pb.GetCartRequest{UserId: userID})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user cart during checkout: %+v", err)
+		return nil, upstreamError(err, "failed to get user cart during checkout")
 	}
 	return cart.GetItems(), nil
 }
@@ -335,46 +817,145 @@ func (cs *checkoutService) emptyUserCart(ctx context.Context, userID string) err
 	return nil
 }
 
-func (cs *checkoutService) prepOrderItems(ctx context.Context, items []*pb.CartItem, userCurrency string) ([]*pb.OrderItem, error) {
+// prepOrderItems prices the cart items in userCurrency, looking up at most
+// maxPrepConcurrency of them at a time. The result is in cart order. It also
+// returns the categories of every product.
+func (cs *checkoutService) prepOrderItems(ctx context.Context, items []*pb.CartItem, userCurrency string, conv *currencyConverter) ([]*pb.OrderItem, map[string][]string, error) {
 	out := make([]*pb.OrderItem, len(items))
+	categories := make([][]string, len(items))
 	cl := pb.NewProductCatalogServiceClient(cs.productCatalogSvcConn)
 
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxPrepConcurrency)
 	for i, item := range items {
-		product, err := cl.GetProduct(ctx, &pb.GetProductRequest{Id: item.GetProductId()})
-		if err != nil {
-			return nil, fmt.Errorf("failed to get product #%q", item.GetProductId())
-		}
-		price, err := cs.convertCurrency(ctx, product.GetPriceUsd(), userCurrency)
-		if err != nil {
-			return nil, fmt.Errorf("failed to convert price of %q to %s", item.GetProductId(), userCurrency)
-		}
-		out[i] = &pb.OrderItem{
-			Item: item,
-			Cost: price}
+		g.Go(func() error {
+			product, err := cl.GetProduct(ctx, &pb.GetProductRequest{Id: item.GetProductId()})
+			if status.Code(err) == codes.NotFound {
+				return productNotFoundError(item.GetProductId())
+			}
+			if err != nil {
+				return upstreamError(err, "failed to get product #%q", item.GetProductId())
+			}
+			price, err := conv.convert(ctx, product.GetPriceUsd(), userCurrency)
+			if err != nil {
+				return asStatus(err, codes.Internal, fmt.Sprintf("failed to convert price of %q to %s", item.GetProductId(), userCurrency))
+			}
+			out[i] = &pb.OrderItem{
+				Item: item,
+				Cost: price}
+			categories[i] = product.GetCategories()
+			return nil
+		})
 	}
-	return out, nil
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	byProduct := make(map[string][]string, len(items))
+	for i, item := range items {
+		byProduct[item.GetProductId()] = categories[i]
+	}
+	return out, byProduct, nil
 }
 
 func (cs *checkoutService) convertCurrency(ctx context.Context, from *pb.Money, toCurrency string) (*pb.Money, error) {
-	result, err := pb.NewCurrencyServiceClient(cs.currencySvcConn).Convert(context.TODO(), &pb.CurrencyConversionRequest{
+	result, err := pb.NewCurrencyServiceClient(cs.currencySvcConn).Convert(ctx, &pb.CurrencyConversionRequest{
 		From:   from,
 		ToCode: toCurrency})
+	if status.Code(err) == codes.InvalidArgument {
+		return nil, currencyUnsupportedError(toCurrency, err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert currency: %+v", err)
+		return nil, upstreamError(err, "failed to convert currency")
 	}
 	return result, err
 }
 
-func (cs *checkoutService) chargeCard(ctx context.Context, amount *pb.Money, paymentInfo *pb.CreditCardInfo) (string, error) {
-	paymentResp, err := pb.NewPaymentServiceClient(cs.paymentSvcConn).Charge(ctx, &pb.ChargeRequest{
-		Amount:     amount,
-		CreditCard: paymentInfo})
+func (cs *checkoutService) reserveStock(ctx context.Context, orderID string, items []*pb.CartItem) (string, error) {
+	resp, err := pb.NewProductCatalogServiceClient(cs.productCatalogSvcConn).ReserveStock(ctx, &pb.ReserveStockRequest{
+		OrderId: orderID,
+		Items:   items})
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.FailedPrecondition:
+		return "", outOfStockError(err)
+	case codes.NotFound:
+		// The catalog names the product it does not know: "unknown product: ID".
+		return "", productNotFoundError(strings.TrimPrefix(status.Convert(err).Message(), "unknown product: "))
+	default:
+		return "", upstreamError(err, "could not reserve stock")
+	}
+	return resp.GetReservationId(), nil
+}
+
+// commitReservation makes the stock of a shipped order permanently taken.
+// The order has shipped either way, so a failure is only logged: the
+// reservation then expires and its stock has to be corrected by hand.
+func (cs *checkoutService) commitReservation(ctx context.Context, orderID, reservationID string) {
+	_, err := pb.NewProductCatalogServiceClient(cs.productCatalogSvcConn).CommitReservation(ctx, &pb.CommitReservationRequest{
+		ReservationId: reservationID})
 	if err != nil {
-		return "", fmt.Errorf("could not charge the card: %+v", err)
+		log.Errorf("failed to commit stock reservation %s of shipped order %s: %+v", reservationID, orderID, err)
+	}
+}
+
+func (cs *checkoutService) releaseReservation(ctx context.Context, reservationID string) error {
+	_, err := pb.NewProductCatalogServiceClient(cs.productCatalogSvcConn).ReleaseReservation(ctx, &pb.ReleaseReservationRequest{
+		ReservationId: reservationID})
+	if err != nil {
+		return fmt.Errorf("could not release stock reservation %s: %+v", reservationID, err)
+	}
+	return nil
+}
+
+// chargeCard charges the card for the order. The order id is the charge's
+// idempotency key, so the charge is made at most once and can be voided by
+// it if its outcome is lost.
+func (cs *checkoutService) chargeCard(ctx context.Context, orderID string, amount *pb.Money, paymentInfo *pb.CreditCardInfo) (string, error) {
+	paymentResp, err := pb.NewPaymentServiceClient(cs.paymentSvcConn).Charge(ctx, &pb.ChargeRequest{
+		Amount:         amount,
+		CreditCard:     paymentInfo,
+		IdempotencyKey: orderID})
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		return "", cardDeclinedError(err)
+	default:
+		return "", upstreamError(err, "could not charge the card")
 	}
 	return paymentResp.GetTransactionId(), nil
 }
 
+func (cs *checkoutService) refundCard(ctx context.Context, transactionID string, amount *pb.Money, reason string) (string, error) {
+	resp, err := pb.NewPaymentServiceClient(cs.paymentSvcConn).Refund(ctx, &pb.RefundRequest{
+		TransactionId: transactionID,
+		Amount:        amount,
+		Reason:        reason})
+	if err != nil {
+		return "", fmt.Errorf("could not refund transaction %s: %+v", transactionID, err)
+	}
+	return resp.GetRefundId(), nil
+}
+
+// voidCharge refunds the charge an interrupted charge_card step may have
+// made, by the order id it was made with. If none was made, the payment
+// service voids the order id so that the charge cannot be made later.
+func (cs *checkoutService) voidCharge(ctx context.Context, rec chargeRecord, reason string) error {
+	resp, err := pb.NewPaymentServiceClient(cs.paymentSvcConn).Refund(ctx, &pb.RefundRequest{
+		IdempotencyKey: rec.OrderID,
+		Amount:         rec.Amount,
+		Reason:         reason})
+	if status.Code(err) == codes.NotFound {
+		log.Infof("no payment was made for order %s, voided it", rec.OrderID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not void the payment for order %s: %+v", rec.OrderID, err)
+	}
+	log.Infof("payment refunded (order_id: %s, refund_id: %s)", rec.OrderID, resp.GetRefundId())
+	cs.markRefunded(ctx, rec.OrderID, reason, "refunded as "+resp.GetRefundId())
+	return nil
+}
+
 func (cs *checkoutService) sendOrderConfirmation(ctx context.Context, email string, order *pb.OrderResult) error {
 	_, err := pb.NewEmailServiceClient(cs.emailSvcConn).SendOrderConfirmation(ctx, &pb.SendOrderConfirmationRequest{
 		Email: email,
@@ -387,7 +968,7 @@ func (cs *checkoutService) shipOrder(ctx context.Context, address *pb.Address, i
 		Address: address,
 		Items:   items})
 	if err != nil {
-		return "", fmt.Errorf("shipment failed: %+v", err)
+		return "", shippingError(err, "shipment failed")
 	}
 	return resp.GetTrackingId(), nil
 }
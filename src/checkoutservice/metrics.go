@@ -0,0 +1,199 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+const (
+	// stageRequest is the failure stage of orders rejected before any of
+	// their stages ran, such as invalid requests.
+	stageRequest = "request"
+	// stagePromotions and stageLoyaltyPoints are the failure stages of
+	// orders whose promotions or points could not be redeemed.
+	stagePromotions    = "promotions"
+	stageLoyaltyPoints = "loyalty_points"
+)
+
+// checkoutMetrics are the OpenTelemetry instruments checkout records its
+// orders, their failures and its calls to other services with.
+type checkoutMetrics struct {
+	ordersPlaced  metric.Int64Counter
+	ordersFailed  metric.Int64Counter
+	orderValue    metric.Float64Histogram
+	orderItems    metric.Int64Histogram
+	callDuration  metric.Float64Histogram
+	compensations metric.Int64Counter
+}
+
+func newCheckoutMetrics(m metric.Meter) (*checkoutMetrics, error) {
+	var (
+		cm   checkoutMetrics
+		errs [6]error
+	)
+	cm.ordersPlaced, errs[0] = m.Int64Counter("checkout.orders.placed",
+		metric.WithDescription("Orders placed, by currency."))
+	cm.ordersFailed, errs[1] = m.Int64Counter("checkout.orders.failed",
+		metric.WithDescription("Orders that failed, by the stage they failed in and their gRPC status code."))
+	cm.orderValue, errs[2] = m.Float64Histogram("checkout.order.value",
+		metric.WithDescription("Totals of placed orders in US dollars."),
+		metric.WithUnit("USD"),
+		metric.WithExplicitBucketBoundaries(5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000))
+	cm.orderItems, errs[3] = m.Int64Histogram("checkout.order.items",
+		metric.WithDescription("Items in placed orders, counting each unit."),
+		metric.WithExplicitBucketBoundaries(1, 2, 3, 5, 10, 20, 50, 100))
+	cm.callDuration, errs[4] = m.Float64Histogram("checkout.dependency.duration",
+		metric.WithDescription("Duration of calls to other services, including retries."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10))
+	cm.compensations, errs[5] = m.Int64Counter("checkout.saga.compensations",
+		metric.WithDescription("Order saga steps compensated, by step and whether compensating succeeded."))
+	if err := errors.Join(errs[:]...); err != nil {
+		return nil, err
+	}
+	return &cm, nil
+}
+
+// orderPlaced records a placed order. Its value is converted to US dollars
+// with conv; if that fails only the value is left out.
+func (m *checkoutMetrics) orderPlaced(ctx context.Context, order *pb.OrderResult, conv *currencyConverter) {
+	total := order.GetTotal()
+	m.ordersPlaced.Add(ctx, 1, metric.WithAttributes(attribute.String("currency", total.GetCurrencyCode())))
+	var items int64
+	for _, it := range order.GetItems() {
+		items += int64(it.GetItem().GetQuantity())
+	}
+	m.orderItems.Record(ctx, items)
+
+	usd, err := conv.convertOptional(ctx, total, usdCurrency)
+	if err != nil {
+		log.Warnf("failed to convert the total of order %s to %s for metrics: %+v", order.GetOrderId(), usdCurrency, err)
+		return
+	}
+	m.orderValue.Record(ctx, float64(usd.GetUnits())+float64(usd.GetNanos())/1e9)
+}
+
+// orderFailed records an order that failed with err in stage.
+func (m *checkoutMetrics) orderFailed(ctx context.Context, stage string, err error) {
+	m.ordersFailed.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("stage", stage),
+		attribute.String("rpc.grpc.status_code", status.Code(err).String()),
+	))
+}
+
+// unaryClientInterceptor records how long each call to another service
+// takes, retries included.
+func (m *checkoutMetrics) unaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		service, name, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+		m.callDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", name),
+			attribute.String("rpc.grpc.status_code", status.Code(err).String()),
+		))
+		return err
+	}
+}
+
+// countCompensations returns comps with every compensation counted.
+func (m *checkoutMetrics) countCompensations(comps map[string]compensator) map[string]compensator {
+	out := make(map[string]compensator, len(comps))
+	for step, comp := range comps {
+		out[step] = func(ctx context.Context, data json.RawMessage, reason string) error {
+			err := comp(ctx, data, reason)
+			result := "succeeded"
+			if err != nil {
+				result = "failed"
+			}
+			m.compensations.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("step", step),
+				attribute.String("result", result),
+			))
+			return err
+		}
+	}
+	return out
+}
+
+type orderAttemptKey struct{}
+
+// orderAttempt follows a PlaceOrder call through its stages so that a
+// failure can be put down to the stage it happened in.
+type orderAttempt struct {
+	mu      sync.Mutex
+	current string
+	failed  string
+}
+
+// withOrderAttempt returns a context that follows an order's stages.
+func withOrderAttempt(ctx context.Context) (context.Context, *orderAttempt) {
+	a := new(orderAttempt)
+	return context.WithValue(ctx, orderAttemptKey{}, a), a
+}
+
+// enterStage records that the order in ctx moved on to stage.
+func enterStage(ctx context.Context, stage string) {
+	if a, ok := ctx.Value(orderAttemptKey{}).(*orderAttempt); ok {
+		a.mu.Lock()
+		a.current = stage
+		a.mu.Unlock()
+	}
+}
+
+// failStage records that stage of the order in ctx failed. Stages may run
+// concurrently; the first to fail is kept.
+func failStage(ctx context.Context, stage string) {
+	if a, ok := ctx.Value(orderAttemptKey{}).(*orderAttempt); ok {
+		a.mu.Lock()
+		if a.failed == "" {
+			a.failed = stage
+		}
+		a.mu.Unlock()
+	}
+}
+
+// failureStage returns the stage that failed or, if none reported failing,
+// the one the order was in.
+func (a *orderAttempt) failureStage() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case a.failed != "":
+		return a.failed
+	case a.current != "":
+		return a.current
+	}
+	return stageRequest
+}
+
+// stageName returns the metric label of stage, e.g. "reserve_stock".
+func stageName(stage pb.PlaceOrderStage) string {
+	return strings.ToLower(strings.TrimPrefix(stage.String(), "PLACE_ORDER_STAGE_"))
+}
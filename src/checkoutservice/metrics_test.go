@@ -0,0 +1,199 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+func newTestMetrics(t *testing.T, mp metric.MeterProvider) *checkoutMetrics {
+	t.Helper()
+	m, err := newCheckoutMetrics(mp.Meter("checkoutservice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// newMeteredCheckout returns a test checkoutService whose metrics can be
+// read from the returned reader.
+func newMeteredCheckout(t *testing.T, deps *fakeDeps) (*checkoutService, *sdkmetric.ManualReader) {
+	r := sdkmetric.NewManualReader()
+	cs := newTestCheckout(t, deps)
+	cs.metrics = newTestMetrics(t, sdkmetric.NewMeterProvider(sdkmetric.WithReader(r)))
+	return cs, r
+}
+
+// collect returns the metrics r has, by name.
+func collect(t *testing.T, r sdkmetric.Reader) map[string]metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := r.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			out[m.Name] = m.Data
+		}
+	}
+	return out
+}
+
+// counted returns the count of the points of a counter that have attrs.
+func counted(data metricdata.Aggregation, attrs ...attribute.KeyValue) int64 {
+	sum, _ := data.(metricdata.Sum[int64])
+	var n int64
+	for _, p := range sum.DataPoints {
+		if hasAttributes(p.Attributes, attrs) {
+			n += p.Value
+		}
+	}
+	return n
+}
+
+func hasAttributes(set attribute.Set, attrs []attribute.KeyValue) bool {
+	for _, kv := range attrs {
+		if v, ok := set.Value(kv.Key); !ok || v != kv.Value {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMetricsRecordPlacedOrders(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	cs, r := newMeteredCheckout(t, deps)
+
+	if _, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1")); err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	got := collect(t, r)
+	if n := counted(got["checkout.orders.placed"], attribute.String("currency", "USD")); n != 1 {
+		t.Errorf("orders placed = %d, want 1", n)
+	}
+	if h, _ := got["checkout.order.value"].(metricdata.Histogram[float64]); len(h.DataPoints) != 1 || math.Abs(h.DataPoints[0].Sum-48.97) > 1e-9 {
+		t.Errorf("order values = %+v, want a single 48.97", h.DataPoints)
+	}
+	if h, _ := got["checkout.order.items"].(metricdata.Histogram[int64]); len(h.DataPoints) != 1 || h.DataPoints[0].Sum != 2 {
+		t.Errorf("order items = %+v, want a single 2", h.DataPoints)
+	}
+	if _, ok := got["checkout.orders.failed"]; ok {
+		t.Error("recorded a failed order")
+	}
+}
+
+func TestMetricsRecordFailureStages(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(*fakeDeps, *pb.PlaceOrderRequest)
+		stage string
+		code  codes.Code
+		comps []string
+	}{
+		{
+			name:  "invalid request",
+			setup: func(_ *fakeDeps, req *pb.PlaceOrderRequest) { req.Email = "" },
+			stage: stageRequest,
+			code:  codes.InvalidArgument,
+		},
+		{
+			name:  "empty cart",
+			setup: func(d *fakeDeps, _ *pb.PlaceOrderRequest) { d.carts["u1"] = nil },
+			stage: "cart",
+			code:  codes.InvalidArgument,
+		},
+		{
+			name: "out of stock",
+			setup: func(d *fakeDeps, _ *pb.PlaceOrderRequest) {
+				d.carts["u1"] = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 11}}
+			},
+			stage: "reserve_stock",
+			code:  codes.FailedPrecondition,
+		},
+		{
+			name: "shipping",
+			setup: func(d *fakeDeps, _ *pb.PlaceOrderRequest) {
+				d.shipErr = status.Error(codes.Unavailable, "no trucks")
+			},
+			stage: "shipping",
+			code:  codes.Unavailable,
+			comps: []string{stepReserveStock, stepChargeCard},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			deps := newFakeDeps()
+			deps.carts["u1"] = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 1}}
+			req := testOrderRequest("u1")
+			tc.setup(deps, req)
+			cs, r := newMeteredCheckout(t, deps)
+
+			if _, err := cs.PlaceOrder(context.Background(), req); status.Code(err) != tc.code {
+				t.Fatalf("PlaceOrder() error = %v, want code %v", err, tc.code)
+			}
+			got := collect(t, r)
+			failed := got["checkout.orders.failed"]
+			if n := counted(failed); n != 1 {
+				t.Errorf("failed orders = %d, want 1", n)
+			}
+			if n := counted(failed, attribute.String("stage", tc.stage), attribute.String("rpc.grpc.status_code", tc.code.String())); n != 1 {
+				t.Errorf("failed orders = %+v, want one in stage %q", failed, tc.stage)
+			}
+			for _, step := range tc.comps {
+				if n := counted(got["checkout.saga.compensations"], attribute.String("step", step), attribute.String("result", "succeeded")); n != 1 {
+					t.Errorf("compensations of %s = %d, want 1", step, n)
+				}
+			}
+			if n := counted(got["checkout.saga.compensations"]); n != int64(len(tc.comps)) {
+				t.Errorf("compensations = %d, want %d", n, len(tc.comps))
+			}
+		})
+	}
+}
+
+func TestMetricsRecordDependencyCalls(t *testing.T) {
+	r := sdkmetric.NewManualReader()
+	m := newTestMetrics(t, sdkmetric.NewMeterProvider(sdkmetric.WithReader(r)))
+	intercept := m.unaryClientInterceptor()
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.NotFound, "no such cart")
+	}
+
+	if err := intercept(context.Background(), "/hipstershop.CartService/GetCart", nil, nil, nil, invoker); status.Code(err) != codes.NotFound {
+		t.Fatalf("interceptor error = %v, want the call's", err)
+	}
+	h, _ := collect(t, r)["checkout.dependency.duration"].(metricdata.Histogram[float64])
+	want := []attribute.KeyValue{
+		attribute.String("rpc.service", "hipstershop.CartService"),
+		attribute.String("rpc.method", "GetCart"),
+		attribute.String("rpc.grpc.status_code", "NotFound"),
+	}
+	if len(h.DataPoints) != 1 || h.DataPoints[0].Count != 1 || !hasAttributes(h.DataPoints[0].Attributes, want) {
+		t.Errorf("call durations = %+v, want one call with %v", h.DataPoints, want)
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+const (
+	defaultOrdersPageSize = 20
+	maxOrdersPageSize     = 100
+)
+
+var (
+	errOrderNotFound    = errors.New("order not found")
+	errOrderExists      = errors.New("order already exists")
+	errInvalidPageToken = errors.New("invalid page token")
+)
+
+// orderRepository stores placed orders so they can be looked up afterwards.
+// Every change to an order also enqueues its outbox messages, atomically.
+// It also counts the orders' use of promotions, keeps the gift card and
+// store credit balances they pay with and the loyalty points they earn, and
+// logs the webhooks sent about them and the saga events of orders whose
+// saga has not ended.
+type orderRepository interface {
+	outboxStore
+	promotionUsage
+	balanceLedger
+	pointsLedger
+	webhookLog
+	sagaRecords
+
+	// Create stores a new order. It returns errOrderExists if an order with
+	// the same id is already stored.
+	Create(ctx context.Context, o *pb.OrderResult) error
+	// Get returns the order with the given id, or errOrderNotFound.
+	Get(ctx context.Context, orderID string) (*pb.OrderResult, error)
+	// List returns up to pageSize orders of userID, most recent first,
+	// starting after pageToken. The returned token is empty on the last page.
+	List(ctx context.Context, userID string, pageSize int, pageToken string) ([]*pb.OrderResult, string, error)
+	// Transition atomically moves the order to status to, recording reason
+	// in its history, and returns the updated order. update, if not nil, may
+	// change other fields in the same write. It returns an error wrapping
+	// errInvalidTransition if the order's current status cannot move to to.
+	Transition(ctx context.Context, orderID string, to pb.OrderStatus, reason string, update func(*pb.OrderResult)) (*pb.OrderResult, error)
+}
+
+// orderCursor is the position of an order in the most-recent-first listing
+// order, encoded into page tokens.
+type orderCursor struct {
+	createdAt int64 // unix nanos
+	orderID   string
+}
+
+func cursorOf(o *pb.OrderResult) orderCursor {
+	return orderCursor{createdAt: o.GetCreatedAt().AsTime().UnixNano(), orderID: o.GetOrderId()}
+}
+
+// before reports whether c is listed before d.
+func (c orderCursor) before(d orderCursor) bool {
+	if c.createdAt != d.createdAt {
+		return c.createdAt > d.createdAt
+	}
+	return c.orderID > d.orderID
+}
+
+func (c orderCursor) token() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.createdAt, 10) + ":" + c.orderID))
+}
+
+func parsePageToken(tok string) (*orderCursor, error) {
+	if tok == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(tok)
+	if err != nil {
+		return nil, errInvalidPageToken
+	}
+	ts, id, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return nil, errInvalidPageToken
+	}
+	n, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, errInvalidPageToken
+	}
+	return &orderCursor{createdAt: n, orderID: id}, nil
+}
+
+func clampPageSize(n int) int {
+	if n <= 0 {
+		return defaultOrdersPageSize
+	}
+	if n > maxOrdersPageSize {
+		return maxOrdersPageSize
+	}
+	return n
+}
+
+// memoryOrderRepository keeps orders in memory. It is used in tests and when
+// no ORDER_DB_PATH is configured.
+type memoryOrderRepository struct {
+	mu     sync.RWMutex
+	orders map[string]*pb.OrderResult
+	outbox map[string]*outboxMessage
+	// redemptions maps promotion ids to the orders that used them, and
+	// those to their users.
+	redemptions map[string]map[string]string
+	balances    map[balanceAccount]*memoryBalance
+	// points holds the loyalty points entries of each user, oldest first.
+	points map[string][]*pointsEntry
+	// webhookLog holds webhook delivery attempts, oldest first.
+	webhookLog []webhookAttempt
+	// sagaEvents holds the saga events recorded for each order, oldest
+	// first.
+	sagaEvents map[string][]sagaEvent
+}
+
+func newMemoryOrderRepository() *memoryOrderRepository {
+	return &memoryOrderRepository{
+		orders:      make(map[string]*pb.OrderResult),
+		outbox:      make(map[string]*outboxMessage),
+		redemptions: make(map[string]map[string]string),
+		balances:    make(map[balanceAccount]*memoryBalance),
+		points:      make(map[string][]*pointsEntry),
+		sagaEvents:  make(map[string][]sagaEvent),
+	}
+}
+
+func (r *memoryOrderRepository) Create(_ context.Context, o *pb.OrderResult) error {
+	msgs, err := outboxMessagesFor(o)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.orders[o.GetOrderId()]; ok {
+		return errOrderExists
+	}
+	r.orders[o.GetOrderId()] = proto.Clone(o).(*pb.OrderResult)
+	r.enqueue(msgs)
+	return nil
+}
+
+func (r *memoryOrderRepository) enqueue(msgs []outboxMessage) {
+	for _, m := range msgs {
+		if _, ok := r.outbox[m.ID]; !ok {
+			r.outbox[m.ID] = &m
+		}
+	}
+}
+
+func (r *memoryOrderRepository) Enqueue(_ context.Context, msgs []outboxMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enqueue(msgs)
+	return nil
+}
+
+func (r *memoryOrderRepository) Get(_ context.Context, orderID string) (*pb.OrderResult, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	o, ok := r.orders[orderID]
+	if !ok {
+		return nil, errOrderNotFound
+	}
+	return proto.Clone(o).(*pb.OrderResult), nil
+}
+
+func (r *memoryOrderRepository) Transition(_ context.Context, orderID string, to pb.OrderStatus, reason string, update func(*pb.OrderResult)) (*pb.OrderResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur, ok := r.orders[orderID]
+	if !ok {
+		return nil, errOrderNotFound
+	}
+	o := proto.Clone(cur).(*pb.OrderResult)
+	if err := applyTransition(o, to, reason); err != nil {
+		return nil, err
+	}
+	if update != nil {
+		update(o)
+	}
+	msgs, err := outboxMessagesFor(o)
+	if err != nil {
+		return nil, err
+	}
+	r.orders[orderID] = o
+	r.enqueue(msgs)
+	return proto.Clone(o).(*pb.OrderResult), nil
+}
+
+// outboxMessages returns the messages matching keep, oldest first.
+func (r *memoryOrderRepository) outboxMessages(keep func(*outboxMessage) bool, limit int) []outboxMessage {
+	var out []outboxMessage
+	for _, m := range r.outbox {
+		if keep(m) {
+			out = append(out, *m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func (r *memoryOrderRepository) Due(_ context.Context, now time.Time, limit int) ([]outboxMessage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.outboxMessages(func(m *outboxMessage) bool { return !m.Dead && !m.NextAttempt.After(now) }, limit), nil
+}
+
+func (r *memoryOrderRepository) Delivered(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.outbox, id)
+	return nil
+}
+
+func (r *memoryOrderRepository) Failed(_ context.Context, id string, lastErr string, next time.Time, dead bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.outbox[id]
+	if !ok {
+		return errDeliveryNotFound
+	}
+	m.Attempts++
+	m.LastError = lastErr
+	m.NextAttempt = next
+	m.Dead = dead
+	m.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *memoryOrderRepository) DeadLetters(_ context.Context, limit int) ([]outboxMessage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.outboxMessages(func(m *outboxMessage) bool { return m.Dead }, limit), nil
+}
+
+func (r *memoryOrderRepository) Replay(_ context.Context, ids []string, now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var revive []*outboxMessage
+	if len(ids) == 0 {
+		for _, m := range r.outbox {
+			if m.Dead {
+				revive = append(revive, m)
+			}
+		}
+	}
+	for _, id := range ids {
+		m, ok := r.outbox[id]
+		if !ok || !m.Dead {
+			return 0, fmt.Errorf("%w: %s", errDeliveryNotFound, id)
+		}
+		revive = append(revive, m)
+	}
+	for _, m := range revive {
+		m.Dead = false
+		m.Attempts = 0
+		m.NextAttempt = now
+		m.UpdatedAt = now
+	}
+	return len(revive), nil
+}
+
+func (r *memoryOrderRepository) Redeem(_ context.Context, promotionID, userID, orderID string, maxUses, maxPerUser int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	orders := r.redemptions[promotionID]
+	if _, ok := orders[orderID]; ok {
+		return nil
+	}
+	if maxUses > 0 && len(orders) >= maxUses {
+		return fmt.Errorf("%w: used by %d orders", errPromotionExhausted, len(orders))
+	}
+	if maxPerUser > 0 {
+		n := 0
+		for _, u := range orders {
+			if u == userID {
+				n++
+			}
+		}
+		if n >= maxPerUser {
+			return fmt.Errorf("%w: already used %d times by this user", errPromotionExhausted, n)
+		}
+	}
+	if orders == nil {
+		orders = make(map[string]string)
+		r.redemptions[promotionID] = orders
+	}
+	orders[orderID] = userID
+	return nil
+}
+
+func (r *memoryOrderRepository) Unredeem(_ context.Context, promotionID, orderID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.redemptions[promotionID], orderID)
+	return nil
+}
+
+func (r *memoryOrderRepository) List(_ context.Context, userID string, pageSize int, pageToken string) ([]*pb.OrderResult, string, error) {
+	after, err := parsePageToken(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	pageSize = clampPageSize(pageSize)
+
+	r.mu.RLock()
+	var matches []*pb.OrderResult
+	for _, o := range r.orders {
+		if o.GetUserId() == userID && (after == nil || after.before(cursorOf(o))) {
+			matches = append(matches, o)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool { return cursorOf(matches[i]).before(cursorOf(matches[j])) })
+	var next string
+	if len(matches) > pageSize {
+		matches = matches[:pageSize]
+		next = cursorOf(matches[pageSize-1]).token()
+	}
+	out := make([]*pb.OrderResult, len(matches))
+	for i, o := range matches {
+		out[i] = proto.Clone(o).(*pb.OrderResult)
+	}
+	return out, next, nil
+}
+
+func (cs *checkoutService) GetOrder(ctx context.Context, req *pb.GetOrderRequest) (*pb.GetOrderResponse, error) {
+	if req.GetOrderId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "order_id is required")
+	}
+	o, err := cs.orders.Get(ctx, req.GetOrderId())
+	if errors.Is(err, errOrderNotFound) {
+		return nil, status.Errorf(codes.NotFound, "no order with ID %s", req.GetOrderId())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up order: %+v", err)
+	}
+	return &pb.GetOrderResponse{Order: o}, nil
+}
+
+func (cs *checkoutService) ListOrders(ctx context.Context, req *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	orders, next, err := cs.orders.List(ctx, req.GetUserId(), int(req.GetPageSize()), req.GetPageToken())
+	if errors.Is(err, errInvalidPageToken) {
+		return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list orders: %+v", err)
+	}
+	return &pb.ListOrdersResponse{Orders: orders, NextPageToken: next}, nil
+}
@@ -0,0 +1,426 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+const ordersSchema = `
+CREATE TABLE IF NOT EXISTS orders (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	status     INTEGER NOT NULL,
+	data       BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS orders_by_user ON orders (user_id, created_at DESC, id DESC);
+CREATE TABLE IF NOT EXISTS outbox (
+	id           TEXT PRIMARY KEY,
+	kind         TEXT NOT NULL,
+	order_id     TEXT NOT NULL,
+	payload      BLOB NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	next_attempt INTEGER NOT NULL,
+	last_error   TEXT NOT NULL DEFAULT '',
+	dead         INTEGER NOT NULL DEFAULT 0,
+	created_at   INTEGER NOT NULL,
+	updated_at   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS outbox_due ON outbox (dead, next_attempt);
+CREATE TABLE IF NOT EXISTS promotion_redemptions (
+	promotion_id TEXT NOT NULL,
+	order_id     TEXT NOT NULL,
+	user_id      TEXT NOT NULL,
+	created_at   INTEGER NOT NULL,
+	PRIMARY KEY (promotion_id, order_id)
+);
+CREATE INDEX IF NOT EXISTS promotion_redemptions_by_user ON promotion_redemptions (promotion_id, user_id);
+CREATE TABLE IF NOT EXISTS order_saga_events (
+	seq      INTEGER PRIMARY KEY AUTOINCREMENT,
+	order_id TEXT NOT NULL,
+	event    BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS order_saga_events_by_order ON order_saga_events (order_id, seq);
+`
+
+// sqlOrderRepository stores orders in an embedded SQLite database. Each row
+// keeps the encoded OrderResult next to the columns needed to query it.
+type sqlOrderRepository struct {
+	db *sql.DB
+}
+
+func newSQLOrderRepository(path string) (*sqlOrderRepository, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open order database: %w", err)
+	}
+	// SQLite allows a single writer; sharing one connection serializes
+	// transitions instead of failing them with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(ordersSchema + ledgerSchema + loyaltySchema + webhookSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create order schema: %w", err)
+	}
+	return &sqlOrderRepository{db: db}, nil
+}
+
+func (r *sqlOrderRepository) Create(ctx context.Context, o *pb.OrderResult) error {
+	b, err := proto.Marshal(o)
+	if err != nil {
+		return err
+	}
+	msgs, err := outboxMessagesFor(o)
+	if err != nil {
+		return err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO orders (id, user_id, created_at, status, data) VALUES (?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		o.GetOrderId(), o.GetUserId(), cursorOf(o).createdAt, int32(o.GetStatus()), b)
+	if err != nil {
+		return fmt.Errorf("failed to insert order: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errOrderExists
+	}
+	if err := enqueueOutbox(ctx, tx, msgs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *sqlOrderRepository) Get(ctx context.Context, orderID string) (*pb.OrderResult, error) {
+	var b []byte
+	err := r.db.QueryRowContext(ctx, `SELECT data FROM orders WHERE id = ?`, orderID).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errOrderNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query order: %w", err)
+	}
+	return decodeOrder(b)
+}
+
+func (r *sqlOrderRepository) List(ctx context.Context, userID string, pageSize int, pageToken string) ([]*pb.OrderResult, string, error) {
+	after, err := parsePageToken(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	pageSize = clampPageSize(pageSize)
+
+	// Fetch one extra row to learn whether there is another page.
+	var rows *sql.Rows
+	if after == nil {
+		rows, err = r.db.QueryContext(ctx,
+			`SELECT data FROM orders WHERE user_id = ? ORDER BY created_at DESC, id DESC LIMIT ?`,
+			userID, pageSize+1)
+	} else {
+		rows, err = r.db.QueryContext(ctx,
+			`SELECT data FROM orders WHERE user_id = ? AND (created_at < ? OR (created_at = ? AND id < ?))
+			 ORDER BY created_at DESC, id DESC LIMIT ?`,
+			userID, after.createdAt, after.createdAt, after.orderID, pageSize+1)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query orders: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*pb.OrderResult
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return nil, "", err
+		}
+		o, err := decodeOrder(b)
+		if err != nil {
+			return nil, "", err
+		}
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	var next string
+	if len(out) > pageSize {
+		out = out[:pageSize]
+		next = cursorOf(out[pageSize-1]).token()
+	}
+	return out, next, nil
+}
+
+func (r *sqlOrderRepository) Transition(ctx context.Context, orderID string, to pb.OrderStatus, reason string, update func(*pb.OrderResult)) (*pb.OrderResult, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var b []byte
+	err = tx.QueryRowContext(ctx, `SELECT data FROM orders WHERE id = ?`, orderID).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errOrderNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query order: %w", err)
+	}
+	o, err := decodeOrder(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyTransition(o, to, reason); err != nil {
+		return nil, err
+	}
+	if update != nil {
+		update(o)
+	}
+	if b, err = proto.Marshal(o); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE orders SET status = ?, data = ? WHERE id = ?`, int32(o.GetStatus()), b, orderID); err != nil {
+		return nil, fmt.Errorf("failed to update order: %w", err)
+	}
+	msgs, err := outboxMessagesFor(o)
+	if err != nil {
+		return nil, err
+	}
+	if err := enqueueOutbox(ctx, tx, msgs); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit order update: %w", err)
+	}
+	return o, nil
+}
+
+func enqueueOutbox(ctx context.Context, tx *sql.Tx, msgs []outboxMessage) error {
+	for _, m := range msgs {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO outbox (id, kind, order_id, payload, next_attempt, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+			 ON CONFLICT (id) DO NOTHING`,
+			m.ID, m.Kind, m.OrderID, m.Payload, m.NextAttempt.UnixNano(), m.CreatedAt.UnixNano(), m.UpdatedAt.UnixNano()); err != nil {
+			return fmt.Errorf("failed to enqueue %s message: %w", m.Kind, err)
+		}
+	}
+	return nil
+}
+
+func (r *sqlOrderRepository) Enqueue(ctx context.Context, msgs []outboxMessage) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := enqueueOutbox(ctx, tx, msgs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const outboxColumns = `id, kind, order_id, payload, attempts, next_attempt, last_error, dead, created_at, updated_at`
+
+func (r *sqlOrderRepository) queryOutbox(ctx context.Context, query string, args ...interface{}) ([]outboxMessage, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+outboxColumns+` FROM outbox `+query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	defer rows.Close()
+	var out []outboxMessage
+	for rows.Next() {
+		var m outboxMessage
+		var next, created, updated int64
+		if err := rows.Scan(&m.ID, &m.Kind, &m.OrderID, &m.Payload, &m.Attempts, &next, &m.LastError, &m.Dead, &created, &updated); err != nil {
+			return nil, err
+		}
+		m.NextAttempt, m.CreatedAt, m.UpdatedAt = time.Unix(0, next), time.Unix(0, created), time.Unix(0, updated)
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlOrderRepository) Due(ctx context.Context, now time.Time, limit int) ([]outboxMessage, error) {
+	return r.queryOutbox(ctx, `WHERE dead = 0 AND next_attempt <= ? ORDER BY created_at, id LIMIT ?`, now.UnixNano(), limit)
+}
+
+func (r *sqlOrderRepository) Delivered(ctx context.Context, id string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM outbox WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to remove delivered message: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlOrderRepository) Failed(ctx context.Context, id string, lastErr string, next time.Time, dead bool) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE outbox SET attempts = attempts + 1, last_error = ?, next_attempt = ?, dead = ?, updated_at = ? WHERE id = ?`,
+		lastErr, next.UnixNano(), dead, time.Now().UnixNano(), id)
+	if err != nil {
+		return fmt.Errorf("failed to record delivery failure: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errDeliveryNotFound
+	}
+	return nil
+}
+
+func (r *sqlOrderRepository) DeadLetters(ctx context.Context, limit int) ([]outboxMessage, error) {
+	return r.queryOutbox(ctx, `WHERE dead = 1 ORDER BY created_at, id LIMIT ?`, limit)
+}
+
+func (r *sqlOrderRepository) Replay(ctx context.Context, ids []string, now time.Time) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	const revive = `UPDATE outbox SET dead = 0, attempts = 0, next_attempt = ?, updated_at = ? WHERE dead = 1`
+	n := 0
+	if len(ids) == 0 {
+		res, err := tx.ExecContext(ctx, revive, now.UnixNano(), now.UnixNano())
+		if err != nil {
+			return 0, fmt.Errorf("failed to replay deliveries: %w", err)
+		}
+		affected, _ := res.RowsAffected()
+		n = int(affected)
+	}
+	for _, id := range ids {
+		res, err := tx.ExecContext(ctx, revive+` AND id = ?`, now.UnixNano(), now.UnixNano(), id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to replay delivery %s: %w", id, err)
+		}
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			return 0, fmt.Errorf("%w: %s", errDeliveryNotFound, id)
+		}
+		n++
+	}
+	return n, tx.Commit()
+}
+
+func (r *sqlOrderRepository) Redeem(ctx context.Context, promotionID, userID, orderID string, maxUses, maxPerUser int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM promotion_redemptions WHERE promotion_id = ? AND order_id = ?`,
+		promotionID, orderID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to query redemptions: %w", err)
+	}
+	if exists > 0 {
+		return nil
+	}
+	if maxUses > 0 {
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM promotion_redemptions WHERE promotion_id = ?`,
+			promotionID).Scan(&n); err != nil {
+			return fmt.Errorf("failed to query redemptions: %w", err)
+		}
+		if n >= maxUses {
+			return fmt.Errorf("%w: used by %d orders", errPromotionExhausted, n)
+		}
+	}
+	if maxPerUser > 0 {
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM promotion_redemptions WHERE promotion_id = ? AND user_id = ?`,
+			promotionID, userID).Scan(&n); err != nil {
+			return fmt.Errorf("failed to query redemptions: %w", err)
+		}
+		if n >= maxPerUser {
+			return fmt.Errorf("%w: already used %d times by this user", errPromotionExhausted, n)
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO promotion_redemptions (promotion_id, order_id, user_id, created_at) VALUES (?, ?, ?, ?)`,
+		promotionID, orderID, userID, time.Now().UnixNano()); err != nil {
+		return fmt.Errorf("failed to record redemption: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (r *sqlOrderRepository) Unredeem(ctx context.Context, promotionID, orderID string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM promotion_redemptions WHERE promotion_id = ? AND order_id = ?`,
+		promotionID, orderID); err != nil {
+		return fmt.Errorf("failed to remove redemption: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlOrderRepository) RecordSagaEvent(ctx context.Context, e sagaEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := r.db.ExecContext(ctx, `INSERT INTO order_saga_events (order_id, event) VALUES (?, ?)`,
+		e.SagaID, b); err != nil {
+		return fmt.Errorf("failed to record saga event: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlOrderRepository) SagaEvents(ctx context.Context, orderID string) ([]sagaEvent, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT event FROM order_saga_events WHERE order_id = ? ORDER BY seq`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query saga events: %w", err)
+	}
+	defer rows.Close()
+	var out []sagaEvent
+	for rows.Next() {
+		var (
+			b []byte
+			e sagaEvent
+		)
+		if err := rows.Scan(&b); err != nil {
+			return nil, fmt.Errorf("failed to read saga event: %w", err)
+		}
+		if err := json.Unmarshal(b, &e); err != nil {
+			return nil, fmt.Errorf("failed to decode saga event: %w", err)
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlOrderRepository) ForgetSaga(ctx context.Context, orderID string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM order_saga_events WHERE order_id = ?`, orderID); err != nil {
+		return fmt.Errorf("failed to forget saga events: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database.
+func (r *sqlOrderRepository) Close() error { return r.db.Close() }
+
+func decodeOrder(b []byte) (*pb.OrderResult, error) {
+	o := new(pb.OrderResult)
+	if err := proto.Unmarshal(b, o); err != nil {
+		return nil, fmt.Errorf("failed to decode order: %w", err)
+	}
+	return o, nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+func testOrder(id, userID string, createdAt time.Time) *pb.OrderResult {
+	return &pb.OrderResult{
+		OrderId:   id,
+		UserId:    userID,
+		Total:     &pb.Money{CurrencyCode: "USD", Units: 10},
+		Status:    pb.OrderStatus_ORDER_STATUS_SHIPPED,
+		CreatedAt: timestamppb.New(createdAt),
+		UpdatedAt: timestamppb.New(createdAt),
+	}
+}
+
+// testOrderRepository runs the same checks against every implementation.
+func testOrderRepository(t *testing.T, r orderRepository) {
+	ctx := context.Background()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// o0..o4 for u1, one second apart; o2 and o3 share a timestamp to
+	// exercise the id tie-break.
+	for i := 0; i < 5; i++ {
+		at := base.Add(time.Duration(i) * time.Second)
+		if i == 3 {
+			at = base.Add(2 * time.Second)
+		}
+		if err := r.Create(ctx, testOrder(fmt.Sprintf("o%d", i), "u1", at)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Create(ctx, testOrder("other", "u2", base)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Create(ctx, testOrder("o1", "u1", base)); !errors.Is(err, errOrderExists) {
+		t.Errorf("Create(duplicate) error = %v, want %v", err, errOrderExists)
+	}
+	got, err := r.Get(ctx, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testOrder("o1", "u1", base.Add(time.Second)); !proto.Equal(got, want) {
+		t.Errorf("Get(o1) = %v, want %v", got, want)
+	}
+	if _, err := r.Get(ctx, "missing"); !errors.Is(err, errOrderNotFound) {
+		t.Errorf("Get(missing) error = %v, want %v", err, errOrderNotFound)
+	}
+
+	var ids []string
+	tok := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not terminate")
+		}
+		page, next, err := r.List(ctx, "u1", 2, tok)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range page {
+			ids = append(ids, o.GetOrderId())
+		}
+		if next == "" {
+			break
+		}
+		tok = next
+	}
+	if want := "[o4 o3 o2 o1 o0]"; fmt.Sprint(ids) != want {
+		t.Errorf("listed %v, want %s", ids, want)
+	}
+	if _, _, err := r.List(ctx, "u1", 2, "not a token"); !errors.Is(err, errInvalidPageToken) {
+		t.Errorf("List(bad token) error = %v, want %v", err, errInvalidPageToken)
+	}
+}
+
+func TestMemoryOrderRepository(t *testing.T) {
+	testOrderRepository(t, newMemoryOrderRepository())
+}
+
+func TestSQLOrderRepository(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	r, err := newSQLOrderRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOrderRepository(t, r)
+
+	// Orders survive reopening the database.
+	r.Close()
+	r, err = newSQLOrderRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Get(context.Background(), "o4"); err != nil {
+		t.Errorf("Get(o4) after reopen: %v", err)
+	}
+}
+
+func TestGetAndListPlacedOrders(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	cs := newTestCheckout(t, deps)
+	ctx := context.Background()
+
+	placed, err := cs.PlaceOrder(ctx, testOrderRequest("u1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := placed.GetOrder().GetOrderId()
+
+	got, err := cs.GetOrder(ctx, &pb.GetOrderRequest{OrderId: id})
+	if err != nil {
+		t.Fatalf("GetOrder() failed: %v", err)
+	}
+	if !proto.Equal(got.GetOrder(), placed.GetOrder()) {
+		t.Errorf("GetOrder() = %v, want %v", got.GetOrder(), placed.GetOrder())
+	}
+	if o := got.GetOrder(); o.GetStatus() != pb.OrderStatus_ORDER_STATUS_SHIPPED || o.GetCreatedAt() == nil ||
+		o.GetTotal().GetUnits() != 28 || o.GetTotal().GetNanos() != 980000000 {
+		t.Errorf("stored order has status %v, created_at %v, total %v", o.GetStatus(), o.GetCreatedAt(), o.GetTotal())
+	}
+	if _, err := cs.GetOrder(ctx, &pb.GetOrderRequest{OrderId: "nope"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetOrder(nope) error = %v, want code %v", err, codes.NotFound)
+	}
+
+	list, err := cs.ListOrders(ctx, &pb.ListOrdersRequest{UserId: "u1"})
+	if err != nil {
+		t.Fatalf("ListOrders() failed: %v", err)
+	}
+	if len(list.GetOrders()) != 1 || list.GetOrders()[0].GetOrderId() != id {
+		t.Errorf("ListOrders() = %v, want [%s]", list.GetOrders(), id)
+	}
+	if _, err := cs.ListOrders(ctx, &pb.ListOrdersRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ListOrders(no user) error = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
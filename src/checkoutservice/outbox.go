@@ -0,0 +1,332 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+const (
+	// outboxKindOrderConfirmation messages are SendOrderConfirmationRequests.
+	outboxKindOrderConfirmation = "order_confirmation"
+	// outboxKindOrderEvent messages are the OrderResult after a status change.
+	outboxKindOrderEvent = "order_event"
+	// outboxKindWebhook messages are webhookPayloads, encoded as JSON.
+	outboxKindWebhook = "webhook"
+
+	defaultFailedDeliveriesPageSize = 50
+	maxFailedDeliveriesPageSize     = 500
+)
+
+var errDeliveryNotFound = errors.New("delivery not found")
+
+// outboxMessage is a message waiting to be delivered on behalf of an order.
+type outboxMessage struct {
+	ID          string
+	Kind        string
+	OrderID     string
+	Payload     []byte // encoding of the message, as its kind says
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	Dead        bool // delivery was given up until replayed
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// outboxStore keeps outbox messages. Order repositories implement it so that
+// messages are written in the same transaction as the order change that
+// caused them.
+type outboxStore interface {
+	// Enqueue adds msgs to the outbox, except those already in it.
+	Enqueue(ctx context.Context, msgs []outboxMessage) error
+	// Due returns up to limit live messages whose next attempt is not after
+	// now, oldest first.
+	Due(ctx context.Context, now time.Time, limit int) ([]outboxMessage, error)
+	// Delivered removes a delivered message.
+	Delivered(ctx context.Context, id string) error
+	// Failed records a failed attempt: the message is retried at next, or
+	// dead-lettered if dead is set.
+	Failed(ctx context.Context, id string, lastErr string, next time.Time, dead bool) error
+	// DeadLetters returns up to limit dead messages, oldest first.
+	DeadLetters(ctx context.Context, limit int) ([]outboxMessage, error)
+	// Replay makes the dead messages with the given ids, or all of them if
+	// ids is empty, due again with a fresh attempt count. It returns how
+	// many messages it revived.
+	Replay(ctx context.Context, ids []string, now time.Time) (int, error)
+}
+
+// outboxMessagesFor returns the messages to enqueue with a change of o to
+// its current status: an order event, plus the confirmation email once the
+// order has shipped.
+func outboxMessagesFor(o *pb.OrderResult) ([]outboxMessage, error) {
+	now := o.GetUpdatedAt().AsTime()
+	event, err := proto.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []outboxMessage{{Kind: outboxKindOrderEvent, Payload: event}}
+	if o.GetStatus() == pb.OrderStatus_ORDER_STATUS_SHIPPED {
+		conf, err := proto.Marshal(&pb.SendOrderConfirmationRequest{Email: o.GetEmail(), Order: o})
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, outboxMessage{Kind: outboxKindOrderConfirmation, Payload: conf})
+	}
+	for i := range msgs {
+		msgs[i].ID = uuid.New().String()
+		msgs[i].OrderID = o.GetOrderId()
+		msgs[i].NextAttempt = now
+		msgs[i].CreatedAt = now
+		msgs[i].UpdatedAt = now
+	}
+	return msgs, nil
+}
+
+// outboxHandler delivers one kind of message.
+type outboxHandler func(ctx context.Context, m outboxMessage) error
+
+// outboxDispatcher delivers outbox messages in the background, retrying
+// failures with exponential backoff and dead-lettering messages that keep
+// failing.
+type outboxDispatcher struct {
+	store    outboxStore
+	handlers map[string]outboxHandler
+
+	pollInterval   time.Duration
+	batchSize      int
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	attemptTimeout time.Duration
+	now            func() time.Time
+
+	wake chan struct{}
+}
+
+func newOutboxDispatcher(store outboxStore, handlers map[string]outboxHandler) *outboxDispatcher {
+	return &outboxDispatcher{
+		store:          store,
+		handlers:       handlers,
+		pollInterval:   time.Second,
+		batchSize:      100,
+		maxAttempts:    8,
+		initialBackoff: time.Second,
+		maxBackoff:     5 * time.Minute,
+		attemptTimeout: 10 * time.Second,
+		now:            time.Now,
+		wake:           make(chan struct{}, 1),
+	}
+}
+
+// notify asks the dispatcher to look for due messages now rather than at the
+// next poll.
+func (d *outboxDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run dispatches due messages until ctx is done.
+func (d *outboxDispatcher) run(ctx context.Context) {
+	t := time.NewTicker(d.pollInterval)
+	defer t.Stop()
+	for {
+		if err := d.dispatchOnce(ctx); err != nil {
+			log.Warnf("failed to dispatch outbox: %+v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// dispatchOnce attempts every message that is due. Each lane's messages
+// are delivered one after another, and the lanes at the same time.
+func (d *outboxDispatcher) dispatchOnce(ctx context.Context) error {
+	msgs, err := d.store.Due(ctx, d.now(), d.batchSize)
+	if err != nil {
+		return err
+	}
+	var lanes [][]outboxMessage
+	index := make(map[string]int)
+	for _, m := range msgs {
+		l := outboxLane(m)
+		i, ok := index[l]
+		if !ok {
+			i = len(lanes)
+			index[l] = i
+			lanes = append(lanes, nil)
+		}
+		lanes[i] = append(lanes[i], m)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(lanes))
+	for i, lane := range lanes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, m := range lane {
+				if errs[i] = d.deliver(ctx, m); errs[i] != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// outboxLane returns the lane m is delivered in, so that a slow downstream
+// only holds up its own messages: each kind of message has a lane, and
+// each webhook subscription has its own.
+func outboxLane(m outboxMessage) string {
+	if m.Kind != outboxKindWebhook {
+		return m.Kind
+	}
+	var p webhookPayload
+	if err := json.Unmarshal(m.Payload, &p); err != nil {
+		return m.Kind
+	}
+	return m.Kind + "/" + p.Subscription
+}
+
+func (d *outboxDispatcher) deliver(ctx context.Context, m outboxMessage) error {
+	h, ok := d.handlers[m.Kind]
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler for %q messages", m.Kind)
+	} else {
+		hctx, cancel := context.WithTimeout(ctx, d.attemptTimeout)
+		err = h(hctx, m)
+		cancel()
+	}
+	if err == nil {
+		return d.store.Delivered(ctx, m.ID)
+	}
+
+	attempts := m.Attempts + 1
+	dead := !ok || attempts >= d.maxAttempts
+	fields := logrus.Fields{"outbox_id": m.ID, "kind": m.Kind, "order_id": m.OrderID, "attempt": attempts}
+	if dead {
+		log.WithFields(fields).Errorf("giving up on outbox message: %+v", err)
+	} else {
+		log.WithFields(fields).Warnf("failed to deliver outbox message: %+v", err)
+	}
+	return d.store.Failed(ctx, m.ID, err.Error(), d.now().Add(d.backoff(attempts)), dead)
+}
+
+// backoff returns the wait after the given number of failed attempts.
+func (d *outboxDispatcher) backoff(attempts int) time.Duration {
+	b := d.initialBackoff
+	for i := 1; i < attempts && b < d.maxBackoff; i++ {
+		b *= 2
+	}
+	if b > d.maxBackoff {
+		b = d.maxBackoff
+	}
+	return b
+}
+
+// outboxHandlers returns how checkout delivers each kind of outbox message.
+func (cs *checkoutService) outboxHandlers() map[string]outboxHandler {
+	return map[string]outboxHandler{
+		outboxKindOrderConfirmation: cs.deliverOrderConfirmation,
+		outboxKindOrderEvent:        cs.publishOrderEvent,
+		outboxKindWebhook:           cs.deliverWebhook,
+	}
+}
+
+func (cs *checkoutService) deliverOrderConfirmation(ctx context.Context, m outboxMessage) error {
+	req := new(pb.SendOrderConfirmationRequest)
+	if err := proto.Unmarshal(m.Payload, req); err != nil {
+		return fmt.Errorf("failed to decode confirmation: %w", err)
+	}
+	if err := cs.sendOrderConfirmation(ctx, req.GetEmail(), req.GetOrder()); err != nil {
+		return err
+	}
+	log.Infof("order confirmation email sent to %q", req.GetEmail())
+	return nil
+}
+
+// publishOrderEvent announces an order status change: it is logged, and
+// queued for delivery to the webhooks subscribed to it.
+func (cs *checkoutService) publishOrderEvent(ctx context.Context, m outboxMessage) error {
+	o := new(pb.OrderResult)
+	if err := proto.Unmarshal(m.Payload, o); err != nil {
+		return fmt.Errorf("failed to decode order event: %w", err)
+	}
+	log.WithFields(logrus.Fields{"order_id": o.GetOrderId(), "status": o.GetStatus().String()}).Info("order event")
+	return cs.enqueueWebhooks(ctx, m, o)
+}
+
+func (cs *checkoutService) ListFailedDeliveries(ctx context.Context, req *pb.ListFailedDeliveriesRequest) (*pb.ListFailedDeliveriesResponse, error) {
+	n := int(req.GetPageSize())
+	if n <= 0 {
+		n = defaultFailedDeliveriesPageSize
+	} else if n > maxFailedDeliveriesPageSize {
+		n = maxFailedDeliveriesPageSize
+	}
+	msgs, err := cs.orders.DeadLetters(ctx, n)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list failed deliveries: %+v", err)
+	}
+	resp := &pb.ListFailedDeliveriesResponse{}
+	for _, m := range msgs {
+		resp.Deliveries = append(resp.Deliveries, &pb.FailedDelivery{
+			Id:        m.ID,
+			Kind:      m.Kind,
+			OrderId:   m.OrderID,
+			Attempts:  int32(m.Attempts),
+			LastError: m.LastError,
+			CreatedAt: timestamppb.New(m.CreatedAt),
+			FailedAt:  timestamppb.New(m.UpdatedAt),
+		})
+	}
+	return resp, nil
+}
+
+func (cs *checkoutService) ReplayFailedDeliveries(ctx context.Context, req *pb.ReplayFailedDeliveriesRequest) (*pb.ReplayFailedDeliveriesResponse, error) {
+	log.Infof("[ReplayFailedDeliveries] ids=%q", req.GetIds())
+	n, err := cs.orders.Replay(ctx, req.GetIds(), time.Now())
+	if errors.Is(err, errDeliveryNotFound) {
+		return nil, status.Errorf(codes.NotFound, "%v", err)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to replay deliveries: %+v", err)
+	}
+	if cs.outbox != nil {
+		cs.outbox.notify()
+	}
+	return &pb.ReplayFailedDeliveriesResponse{Replayed: int32(n)}, nil
+}
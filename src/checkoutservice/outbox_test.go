@@ -0,0 +1,248 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+func kinds(msgs []outboxMessage) []string {
+	var out []string
+	for _, m := range msgs {
+		out = append(out, m.Kind)
+	}
+	return out
+}
+
+// testOutboxStore runs the same checks against every order repository.
+func testOutboxStore(t *testing.T, r orderRepository) {
+	ctx := context.Background()
+	o := newPendingOrder(&pb.OrderResult{OrderId: "o1", UserId: "u1", Email: "someone@example.com"})
+	if err := r.Create(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []pb.OrderStatus{
+		pb.OrderStatus_ORDER_STATUS_PAYMENT_AUTHORIZED,
+		pb.OrderStatus_ORDER_STATUS_PAID,
+		pb.OrderStatus_ORDER_STATUS_SHIPPED,
+	} {
+		if _, err := r.Transition(ctx, "o1", to, "test", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A rejected transition enqueues nothing.
+	if _, err := r.Transition(ctx, "o1", pb.OrderStatus_ORDER_STATUS_PAID, "test", nil); err == nil {
+		t.Fatal("SHIPPED -> PAID succeeded")
+	}
+
+	now := time.Now()
+	due, err := r.Due(ctx, now, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One event per status, plus the confirmation.
+	if len(due) != 5 {
+		t.Fatalf("found %d due messages (%v), want 5", len(due), kinds(due))
+	}
+	var conf outboxMessage
+	for _, m := range due {
+		if m.Kind == outboxKindOrderConfirmation {
+			conf = m
+		} else if err := r.Delivered(ctx, m.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conf.ID == "" || conf.OrderID != "o1" {
+		t.Fatalf("no confirmation for o1 among %v", kinds(due))
+	}
+
+	if err := r.Failed(ctx, conf.ID, "email down", now.Add(time.Minute), false); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ := r.Due(ctx, now, 100); len(due) != 0 {
+		t.Errorf("found %d due messages during backoff, want 0", len(due))
+	}
+	if err := r.Failed(ctx, conf.ID, "email still down", now, true); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ := r.Due(ctx, now.Add(time.Hour), 100); len(due) != 0 {
+		t.Errorf("found %d due messages after dead-lettering, want 0", len(due))
+	}
+	dead, err := r.DeadLetters(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Attempts != 2 || dead[0].LastError != "email still down" {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+
+	if _, err := r.Replay(ctx, []string{"nope"}, now); !errors.Is(err, errDeliveryNotFound) {
+		t.Errorf("Replay(nope) error = %v, want %v", err, errDeliveryNotFound)
+	}
+	if n, err := r.Replay(ctx, nil, now); err != nil || n != 1 {
+		t.Fatalf("Replay() = %d, %v; want 1", n, err)
+	}
+	due, _ = r.Due(ctx, now, 100)
+	if len(due) != 1 || due[0].ID != conf.ID || due[0].Attempts != 0 {
+		t.Errorf("due after replay = %+v", due)
+	}
+}
+
+func TestMemoryOutboxStore(t *testing.T) {
+	testOutboxStore(t, newMemoryOrderRepository())
+}
+
+func TestSQLOutboxStore(t *testing.T) {
+	r, err := newSQLOrderRepository(filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	testOutboxStore(t, r)
+}
+
+func TestOutboxDeadLettersAndReplaysConfirmation(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	deps.emailErr = status.Error(codes.Unavailable, "email down")
+	cs := newTestCheckout(t, deps)
+	ctx := context.Background()
+
+	if _, err := cs.PlaceOrder(ctx, testOrderRequest("u1")); err != nil {
+		t.Fatalf("PlaceOrder() failed while email is down: %v", err)
+	}
+
+	clock := time.Now()
+	cs.outbox.now = func() time.Time { return clock }
+	cs.outbox.maxAttempts = 3
+	for i := 0; i < 3; i++ {
+		if err := cs.outbox.dispatchOnce(ctx); err != nil {
+			t.Fatal(err)
+		}
+		clock = clock.Add(time.Hour)
+	}
+
+	failed, err := cs.ListFailedDeliveries(ctx, &pb.ListFailedDeliveriesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed.GetDeliveries()) != 1 {
+		t.Fatalf("listed %d failed deliveries, want 1", len(failed.GetDeliveries()))
+	}
+	d := failed.GetDeliveries()[0]
+	if d.GetKind() != outboxKindOrderConfirmation || d.GetAttempts() != 3 {
+		t.Errorf("failed delivery = %v", d)
+	}
+
+	deps.emailErr = nil
+	resp, err := cs.ReplayFailedDeliveries(ctx, &pb.ReplayFailedDeliveriesRequest{Ids: []string{d.GetId()}})
+	if err != nil || resp.GetReplayed() != 1 {
+		t.Fatalf("ReplayFailedDeliveries() = %v, %v", resp, err)
+	}
+	cs.outbox.now = time.Now
+	if err := cs.outbox.dispatchOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(deps.emails) != 1 {
+		t.Errorf("sent %d confirmations after replay, want 1", len(deps.emails))
+	}
+	if failed, _ := cs.ListFailedDeliveries(ctx, &pb.ListFailedDeliveriesRequest{}); len(failed.GetDeliveries()) != 0 {
+		t.Errorf("still %d failed deliveries after replay", len(failed.GetDeliveries()))
+	}
+}
+
+func TestOutboxDeliversKindsConcurrently(t *testing.T) {
+	r := newMemoryOrderRepository()
+	ctx := context.Background()
+	if err := r.Create(ctx, newPendingOrder(&pb.OrderResult{OrderId: "o1", UserId: "u1"})); err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []pb.OrderStatus{
+		pb.OrderStatus_ORDER_STATUS_PAYMENT_AUTHORIZED,
+		pb.OrderStatus_ORDER_STATUS_PAID,
+		pb.OrderStatus_ORDER_STATUS_SHIPPED,
+	} {
+		if _, err := r.Transition(ctx, "o1", to, "test", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each kind's handler only returns once the other kind's has started,
+	// so delivering one kind after the other times out.
+	started := map[string]chan struct{}{
+		outboxKindOrderConfirmation: make(chan struct{}),
+		outboxKindOrderEvent:        make(chan struct{}),
+	}
+	var once sync.Map
+	handler := func(other string) outboxHandler {
+		return func(ctx context.Context, m outboxMessage) error {
+			if _, done := once.LoadOrStore(m.Kind, true); !done {
+				close(started[m.Kind])
+			}
+			select {
+			case <-started[other]:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	d := newOutboxDispatcher(r, map[string]outboxHandler{
+		outboxKindOrderConfirmation: handler(outboxKindOrderEvent),
+		outboxKindOrderEvent:        handler(outboxKindOrderConfirmation),
+	})
+	d.attemptTimeout = time.Second
+	if err := d.dispatchOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ := r.Due(ctx, time.Now().Add(time.Hour), 100); len(due) != 0 {
+		t.Errorf("%v still due after dispatching", kinds(due))
+	}
+}
+
+func TestOutboxLane(t *testing.T) {
+	for _, tc := range []struct {
+		m    outboxMessage
+		want string
+	}{
+		{outboxMessage{Kind: outboxKindOrderEvent}, outboxKindOrderEvent},
+		{outboxMessage{Kind: outboxKindWebhook, Payload: []byte(`{"subscription":"crm"}`)}, "webhook/crm"},
+		{outboxMessage{Kind: outboxKindWebhook, Payload: []byte("{")}, outboxKindWebhook},
+	} {
+		if got := outboxLane(tc.m); got != tc.want {
+			t.Errorf("outboxLane(%s %s) = %q, want %q", tc.m.Kind, tc.m.Payload, got, tc.want)
+		}
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	d := newOutboxDispatcher(nil, nil)
+	d.initialBackoff, d.maxBackoff = time.Second, 5*time.Second
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
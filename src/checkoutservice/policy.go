@@ -0,0 +1,176 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryableMethods are the idempotent calls that may be sent more than once.
+// Anything else, in particular PaymentService/Charge, gets a single attempt.
+var retryableMethods = map[string]bool{
+	"/hipstershop.CartService/GetCart":                      true,
+	"/hipstershop.ProductCatalogService/GetProduct":         true,
+	"/hipstershop.ProductCatalogService/ReleaseReservation": true,
+	"/hipstershop.CurrencyService/Convert":                  true,
+	"/hipstershop.ShippingService/GetQuote":                 true,
+	"/hipstershop.CurrencyService/GetSupportedCurrencies":   true,
+}
+
+// callPolicy is how checkout calls one downstream service.
+type callPolicy struct {
+	// Timeout bounds each attempt. Zero leaves the caller's deadline alone.
+	Timeout time.Duration
+	// MaxAttempts is the most times a retryable method is tried.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry; later waits double
+	// up to MaxBackoff. Each wait is jittered by up to 20%.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// RetryableCodes are the status codes worth another attempt.
+	RetryableCodes []codes.Code
+}
+
+// defaultCallPolicy returns the policy used for a downstream unless its
+// environment overrides it.
+func defaultCallPolicy(timeout time.Duration) callPolicy {
+	return callPolicy{
+		Timeout:        timeout,
+		MaxAttempts:    3,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		RetryableCodes: []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted},
+	}
+}
+
+// callPolicyFromEnv overrides def with the <prefix>_TIMEOUT,
+// <prefix>_MAX_ATTEMPTS, <prefix>_INITIAL_BACKOFF, <prefix>_MAX_BACKOFF and
+// <prefix>_RETRY_CODES environment variables, e.g. PAYMENT_SERVICE_TIMEOUT=5s
+// or CURRENCY_SERVICE_RETRY_CODES=UNAVAILABLE,ABORTED.
+func callPolicyFromEnv(prefix string, def callPolicy) (callPolicy, error) {
+	p := def
+	for _, d := range []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"_TIMEOUT", &p.Timeout},
+		{"_INITIAL_BACKOFF", &p.InitialBackoff},
+		{"_MAX_BACKOFF", &p.MaxBackoff},
+	} {
+		if v := os.Getenv(prefix + d.key); v != "" {
+			t, err := time.ParseDuration(v)
+			if err != nil {
+				return p, fmt.Errorf("invalid %s%s: %w", prefix, d.key, err)
+			}
+			*d.dst = t
+		}
+	}
+	if v := os.Getenv(prefix + "_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("invalid %s_MAX_ATTEMPTS %q", prefix, v)
+		}
+		p.MaxAttempts = n
+	}
+	if v := os.Getenv(prefix + "_RETRY_CODES"); v != "" {
+		p.RetryableCodes = nil
+		for _, name := range strings.Split(v, ",") {
+			var c codes.Code
+			if err := c.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(strings.TrimSpace(name))))); err != nil {
+				return p, fmt.Errorf("invalid %s_RETRY_CODES: %w", prefix, err)
+			}
+			p.RetryableCodes = append(p.RetryableCodes, c)
+		}
+	}
+	return p, nil
+}
+
+func (p callPolicy) retryable(method string, err error) bool {
+	if !retryableMethods[method] {
+		return false
+	}
+	code := status.Code(err)
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before retry number n (starting at 1).
+func (p callPolicy) backoff(n int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d - time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+// unaryClientInterceptor applies p to every call: each attempt gets its own
+// timeout, retryable methods are retried with backoff, and every attempt is
+// recorded as an event on the caller's span.
+func (p callPolicy) unaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		span := trace.SpanFromContext(ctx)
+		for attempt := 1; ; attempt++ {
+			actx, cancel := ctx, context.CancelFunc(func() {})
+			if p.Timeout > 0 {
+				actx, cancel = context.WithTimeout(ctx, p.Timeout)
+			}
+			start := time.Now()
+			err := invoker(actx, method, req, reply, cc, opts...)
+			cancel()
+
+			retry := err != nil && attempt < p.MaxAttempts && ctx.Err() == nil && p.retryable(method, err)
+			span.AddEvent("rpc.attempt", trace.WithAttributes(
+				attribute.String("rpc.method", method),
+				attribute.Int("rpc.attempt", attempt),
+				attribute.String("rpc.grpc.status_code", status.Code(err).String()),
+				attribute.Int64("rpc.duration_ms", time.Since(start).Milliseconds()),
+				attribute.Bool("rpc.will_retry", retry),
+			))
+			if !retry {
+				return err
+			}
+
+			t := time.NewTimer(p.backoff(attempt))
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// flakyDeps fails the first failures calls to each method with code, and
+// sleeps for delay before answering.
+type flakyDeps struct {
+	pb.UnimplementedProductCatalogServiceServer
+	pb.UnimplementedPaymentServiceServer
+
+	failures int
+	code     codes.Code
+	delay    time.Duration
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *flakyDeps) call(ctx context.Context, method string) error {
+	f.mu.Lock()
+	f.calls[method]++
+	n := f.calls[method]
+	f.mu.Unlock()
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if n <= f.failures {
+		return status.Error(f.code, "try again")
+	}
+	return nil
+}
+
+// count returns how many times method has been called.
+func (f *flakyDeps) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *flakyDeps) GetProduct(ctx context.Context, req *pb.GetProductRequest) (*pb.Product, error) {
+	if err := f.call(ctx, "GetProduct"); err != nil {
+		return nil, err
+	}
+	return &pb.Product{Id: req.GetId()}, nil
+}
+
+func (f *flakyDeps) Charge(ctx context.Context, _ *pb.ChargeRequest) (*pb.ChargeResponse, error) {
+	if err := f.call(ctx, "Charge"); err != nil {
+		return nil, err
+	}
+	return &pb.ChargeResponse{TransactionId: "tx"}, nil
+}
+
+// dialFlaky connects to f through p, then through interceptors for each
+// attempt p makes.
+func dialFlaky(t *testing.T, f *flakyDeps, p callPolicy, interceptors ...grpc.UnaryClientInterceptor) *grpc.ClientConn {
+	f.calls = map[string]int{}
+	return dialTestServer(t, func(srv *grpc.Server) {
+		pb.RegisterProductCatalogServiceServer(srv, f)
+		pb.RegisterPaymentServiceServer(srv, f)
+	}, grpc.WithChainUnaryInterceptor(append([]grpc.UnaryClientInterceptor{p.unaryClientInterceptor()}, interceptors...)...))
+}
+
+func testPolicy() callPolicy {
+	p := defaultCallPolicy(time.Second)
+	p.InitialBackoff = time.Millisecond
+	return p
+}
+
+func TestPolicyRetriesIdempotentReads(t *testing.T) {
+	f := &flakyDeps{failures: 2, code: codes.Unavailable}
+	conn := dialFlaky(t, f, testPolicy())
+
+	rec := tracetest.NewSpanRecorder()
+	ctx, span := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)).Tracer("test").Start(context.Background(), "checkout")
+	_, err := pb.NewProductCatalogServiceClient(conn).GetProduct(ctx, &pb.GetProductRequest{Id: "OLJCESPC7Z"})
+	span.End()
+	if err != nil {
+		t.Fatalf("GetProduct() failed: %v", err)
+	}
+	if got := f.count("GetProduct"); got != 3 {
+		t.Errorf("GetProduct was called %d times, want 3", got)
+	}
+	if got := len(rec.Ended()[0].Events()); got != 3 {
+		t.Errorf("recorded %d attempt events, want 3", got)
+	}
+}
+
+func TestPolicyGivesUpAfterMaxAttempts(t *testing.T) {
+	f := &flakyDeps{failures: 5, code: codes.Unavailable}
+	conn := dialFlaky(t, f, testPolicy())
+
+	_, err := pb.NewProductCatalogServiceClient(conn).GetProduct(context.Background(), &pb.GetProductRequest{Id: "OLJCESPC7Z"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("GetProduct() error = %v, want code %v", err, codes.Unavailable)
+	}
+	if got := f.count("GetProduct"); got != 3 {
+		t.Errorf("GetProduct was called %d times, want 3", got)
+	}
+}
+
+func TestPolicyNeverRetriesCharge(t *testing.T) {
+	f := &flakyDeps{failures: 1, code: codes.Unavailable}
+	conn := dialFlaky(t, f, testPolicy())
+
+	if _, err := pb.NewPaymentServiceClient(conn).Charge(context.Background(), &pb.ChargeRequest{}); err == nil {
+		t.Error("Charge() succeeded, want error")
+	}
+	if got := f.count("Charge"); got != 1 {
+		t.Errorf("Charge was called %d times, want 1", got)
+	}
+}
+
+func TestPolicyTimesOutEachAttempt(t *testing.T) {
+	f := &flakyDeps{delay: time.Second}
+	p := testPolicy()
+	p.Timeout = 20 * time.Millisecond
+	p.MaxAttempts = 2
+	// An attempt can time out before it reaches the server, so count
+	// attempts as they are sent.
+	var attempts atomic.Int32
+	conn := dialFlaky(t, f, p, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		attempts.Add(1)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+
+	start := time.Now()
+	_, err := pb.NewProductCatalogServiceClient(conn).GetProduct(context.Background(), &pb.GetProductRequest{Id: "OLJCESPC7Z"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("GetProduct() error = %v, want code %v", err, codes.DeadlineExceeded)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("GetProduct() took %v, want the per-attempt timeout to apply", time.Since(start))
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("GetProduct was attempted %d times, want 2", got)
+	}
+}
+
+func TestCallPolicyFromEnv(t *testing.T) {
+	t.Setenv("PAYMENT_SERVICE_TIMEOUT", "7s")
+	t.Setenv("PAYMENT_SERVICE_MAX_ATTEMPTS", "1")
+	t.Setenv("PAYMENT_SERVICE_RETRY_CODES", "unavailable, aborted")
+	p, err := callPolicyFromEnv("PAYMENT_SERVICE", defaultCallPolicy(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Timeout != 7*time.Second || p.MaxAttempts != 1 || len(p.RetryableCodes) != 2 || p.RetryableCodes[1] != codes.Aborted {
+		t.Errorf("policy = %+v", p)
+	}
+
+	t.Setenv("PAYMENT_SERVICE_RETRY_CODES", "SOMETIMES")
+	if _, err := callPolicyFromEnv("PAYMENT_SERVICE", defaultCallPolicy(time.Second)); err == nil {
+		t.Error("callPolicyFromEnv() accepted an unknown code")
+	}
+}
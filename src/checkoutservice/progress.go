@@ -0,0 +1,103 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+type progressKey struct{}
+
+// progressReporter passes checkout progress events to a PlaceOrderStream
+// client. Stages may run concurrently, so sends are serialized; once a send
+// fails, the client is gone and later events are dropped.
+type progressReporter struct {
+	mu     sync.Mutex
+	stream grpc.ServerStreamingServer[pb.PlaceOrderProgress]
+	err    error
+}
+
+func (p *progressReporter) send(ev *pb.PlaceOrderProgress) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	ev.Time = timestamppb.Now()
+	if p.err = p.stream.Send(ev); p.err != nil {
+		log.Warnf("failed to send order progress: %+v", p.err)
+	}
+	return p.err
+}
+
+// withProgress returns a context whose checkout stages are reported to p.
+func withProgress(ctx context.Context, p *progressReporter) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// startStage reports that stage started, if ctx has a progress reporter,
+// and returns a function to report how it ended. Errors passed to it that
+// are not gRPC statuses are reported as INTERNAL. The stage is also noted
+// for the order's metrics.
+func startStage(ctx context.Context, stage pb.PlaceOrderStage) func(err error) {
+	enterStage(ctx, stageName(stage))
+	p, ok := ctx.Value(progressKey{}).(*progressReporter)
+	if ok {
+		p.send(&pb.PlaceOrderProgress{Stage: stage, State: pb.PlaceOrderStageState_PLACE_ORDER_STAGE_STATE_STARTED})
+	}
+	return func(err error) {
+		if err != nil {
+			failStage(ctx, stageName(stage))
+		}
+		if !ok {
+			return
+		}
+		ev := &pb.PlaceOrderProgress{Stage: stage, State: pb.PlaceOrderStageState_PLACE_ORDER_STAGE_STATE_SUCCEEDED}
+		if err != nil {
+			st, ok := status.FromError(err)
+			if !ok {
+				st = status.New(codes.Internal, err.Error())
+			}
+			ev.State = pb.PlaceOrderStageState_PLACE_ORDER_STAGE_STATE_FAILED
+			ev.ErrorCode = int32(st.Code())
+			ev.ErrorMessage = st.Message()
+		}
+		p.send(ev)
+	}
+}
+
+func (cs *checkoutService) PlaceOrderStream(req *pb.PlaceOrderRequest, stream grpc.ServerStreamingServer[pb.PlaceOrderProgress]) error {
+	p := &progressReporter{stream: stream}
+	resp, err := cs.PlaceOrder(withProgress(stream.Context(), p), req)
+	if err != nil {
+		return err
+	}
+	// The order is placed even if the client has gone; its error is only
+	// logged.
+	p.send(&pb.PlaceOrderProgress{
+		Stage: pb.PlaceOrderStage_PLACE_ORDER_STAGE_COMPLETE,
+		State: pb.PlaceOrderStageState_PLACE_ORDER_STAGE_STATE_SUCCEEDED,
+		Order: resp.GetOrder(),
+	})
+	return nil
+}
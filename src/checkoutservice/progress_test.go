@@ -0,0 +1,120 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// streamOrder places req through PlaceOrderStream and returns the events it
+// sent and the error that ended the stream.
+func streamOrder(t *testing.T, cs *checkoutService, req *pb.PlaceOrderRequest) ([]*pb.PlaceOrderProgress, error) {
+	t.Helper()
+	conn := dialTestServer(t, func(srv *grpc.Server) { pb.RegisterCheckoutServiceServer(srv, cs) })
+	stream, err := pb.NewCheckoutServiceClient(conn).PlaceOrderStream(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*pb.PlaceOrderProgress
+	for {
+		ev, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, ev)
+	}
+}
+
+// stageStates returns the states each stage went through, in order.
+func stageStates(events []*pb.PlaceOrderProgress) map[pb.PlaceOrderStage][]pb.PlaceOrderStageState {
+	out := map[pb.PlaceOrderStage][]pb.PlaceOrderStageState{}
+	for _, ev := range events {
+		out[ev.GetStage()] = append(out[ev.GetStage()], ev.GetState())
+	}
+	return out
+}
+
+func TestPlaceOrderStream(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	cs := newTestCheckout(t, deps)
+
+	events, err := streamOrder(t, cs, testOrderRequest("u1"))
+	if err != nil {
+		t.Fatalf("PlaceOrderStream() failed: %v", err)
+	}
+	states := stageStates(events)
+	for _, stage := range []pb.PlaceOrderStage{
+		pb.PlaceOrderStage_PLACE_ORDER_STAGE_CART,
+		pb.PlaceOrderStage_PLACE_ORDER_STAGE_PRICING,
+		pb.PlaceOrderStage_PLACE_ORDER_STAGE_SHIPPING_QUOTE,
+		pb.PlaceOrderStage_PLACE_ORDER_STAGE_RESERVE_STOCK,
+		pb.PlaceOrderStage_PLACE_ORDER_STAGE_PAYMENT,
+		pb.PlaceOrderStage_PLACE_ORDER_STAGE_SHIPPING,
+		pb.PlaceOrderStage_PLACE_ORDER_STAGE_CONFIRMATION,
+	} {
+		got := states[stage]
+		if len(got) != 2 || got[0] != pb.PlaceOrderStageState_PLACE_ORDER_STAGE_STATE_STARTED ||
+			got[1] != pb.PlaceOrderStageState_PLACE_ORDER_STAGE_STATE_SUCCEEDED {
+			t.Errorf("stage %v went through %v, want started and succeeded", stage, got)
+		}
+	}
+
+	last := events[len(events)-1]
+	if last.GetStage() != pb.PlaceOrderStage_PLACE_ORDER_STAGE_COMPLETE || last.GetOrder().GetOrderId() == "" {
+		t.Errorf("last event = %v, want the completed order", last)
+	}
+	if last.GetTime() == nil {
+		t.Error("events have no time")
+	}
+	if len(deps.charges) != 1 {
+		t.Errorf("%d charges, want 1", len(deps.charges))
+	}
+}
+
+func TestPlaceOrderStreamReportsFailure(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	deps.chargeErr = status.Error(codes.InvalidArgument, "card declined")
+	cs := newTestCheckout(t, deps)
+
+	events, err := streamOrder(t, cs, testOrderRequest("u1"))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("PlaceOrderStream() error = %v, want code %v", err, codes.FailedPrecondition)
+	}
+	states := stageStates(events)
+	payment := states[pb.PlaceOrderStage_PLACE_ORDER_STAGE_PAYMENT]
+	if len(payment) != 2 || payment[1] != pb.PlaceOrderStageState_PLACE_ORDER_STAGE_STATE_FAILED {
+		t.Fatalf("payment went through %v, want started and failed", payment)
+	}
+	if ev := events[len(events)-1]; ev.GetErrorCode() == int32(codes.OK) || ev.GetErrorMessage() == "" {
+		t.Errorf("failed event = %v, want an error code and message", ev)
+	}
+	if _, ok := states[pb.PlaceOrderStage_PLACE_ORDER_STAGE_SHIPPING]; ok {
+		t.Error("shipping started after the payment failed")
+	}
+}
@@ -0,0 +1,458 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
+)
+
+const (
+	promotionPercentOff = "percent_off"
+	promotionAmountOff  = "amount_off"
+	promotionBuyXGetY   = "buy_x_get_y"
+
+	// promotionsPollInterval is how often the promotions file is checked
+	// for changes.
+	promotionsPollInterval = 10 * time.Second
+)
+
+var (
+	errUnknownPromoCode       = errors.New("unknown promo code")
+	errPromotionNotApplicable = errors.New("promotion does not apply")
+	errPromotionExhausted     = errors.New("promotion usage limit reached")
+)
+
+// promotion is a rule in the promotions file. Promotions without a code are
+// sales that apply to every order they match; the others apply only when
+// their code is entered.
+type promotion struct {
+	// ID identifies the promotion when counting its uses.
+	ID          string `json:"id"`
+	Code        string `json:"code,omitempty"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+
+	// Percent is taken off eligible items by percent_off promotions.
+	Percent int64 `json:"percent,omitempty"`
+	// AmountOff is taken off eligible items by amount_off promotions, at
+	// most down to zero.
+	AmountOff *pb.Money `json:"amount_off,omitempty"`
+	// A buy_x_get_y promotion makes Get of every Buy+Get eligible units
+	// free, cheapest first.
+	Buy int32 `json:"buy,omitempty"`
+	Get int32 `json:"get,omitempty"`
+
+	// ProductIDs and Categories restrict the promotion to matching items.
+	// If both are empty, every item is eligible.
+	ProductIDs []string `json:"product_ids,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+
+	// MinOrder is the least the items must add up to, before discounts.
+	MinOrder *pb.Money `json:"min_order,omitempty"`
+	// MaxUses and MaxUsesPerUser limit how many orders may use the
+	// promotion, in total and per user. Zero means no limit.
+	MaxUses        int `json:"max_uses,omitempty"`
+	MaxUsesPerUser int `json:"max_uses_per_user,omitempty"`
+
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+}
+
+func (p *promotion) validate() error {
+	if p.ID == "" {
+		return errors.New("promotion without id")
+	}
+	switch p.Type {
+	case promotionPercentOff:
+		if p.Percent <= 0 || p.Percent > 100 {
+			return fmt.Errorf("promotion %s: percent must be between 1 and 100", p.ID)
+		}
+	case promotionAmountOff:
+		if p.AmountOff == nil || !money.IsPositive(p.AmountOff) || p.AmountOff.GetCurrencyCode() == "" {
+			return fmt.Errorf("promotion %s: amount_off must be a positive amount with a currency", p.ID)
+		}
+	case promotionBuyXGetY:
+		if p.Buy <= 0 || p.Get <= 0 {
+			return fmt.Errorf("promotion %s: buy and get must be positive", p.ID)
+		}
+	default:
+		return fmt.Errorf("promotion %s: unknown type %q", p.ID, p.Type)
+	}
+	if p.MinOrder != nil && (!money.IsValid(p.MinOrder) || p.MinOrder.GetCurrencyCode() == "") {
+		return fmt.Errorf("promotion %s: invalid min_order", p.ID)
+	}
+	if p.MaxUses < 0 || p.MaxUsesPerUser < 0 {
+		return fmt.Errorf("promotion %s: usage limits must not be negative", p.ID)
+	}
+	return nil
+}
+
+// active reports whether the promotion runs at t.
+func (p *promotion) active(t time.Time) bool {
+	return (p.StartsAt == nil || !t.Before(*p.StartsAt)) && (p.EndsAt == nil || t.Before(*p.EndsAt))
+}
+
+func (p *promotion) eligible(productID string, categories []string) bool {
+	if len(p.ProductIDs) == 0 && len(p.Categories) == 0 {
+		return true
+	}
+	for _, id := range p.ProductIDs {
+		if id == productID {
+			return true
+		}
+	}
+	for _, c := range p.Categories {
+		for _, pc := range categories {
+			if strings.EqualFold(c, pc) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// discount returns what p takes off items, whose costs are all in the same
+// currency. categories maps product ids to their categories. AmountOff and
+// MinOrder must already be in the items' currency. It returns an error
+// wrapping errPromotionNotApplicable if the order does not qualify.
+func (p *promotion) discount(items []*pb.OrderItem, categories map[string][]string) (*pb.Money, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: no items", errPromotionNotApplicable)
+	}
+	currency := items[0].GetCost().GetCurrencyCode()
+	subtotal := &pb.Money{CurrencyCode: currency}
+	eligibleSubtotal := &pb.Money{CurrencyCode: currency}
+	var eligible []*pb.OrderItem
+	for _, it := range items {
+		line, err := money.Multiply(it.GetCost(), int64(it.GetItem().GetQuantity()))
+		if err != nil {
+			return nil, err
+		}
+		if subtotal, err = money.Sum(subtotal, line); err != nil {
+			return nil, err
+		}
+		if p.eligible(it.GetItem().GetProductId(), categories[it.GetItem().GetProductId()]) {
+			eligible = append(eligible, it)
+			if eligibleSubtotal, err = money.Sum(eligibleSubtotal, line); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.MinOrder != nil {
+		if c, err := money.Compare(subtotal, p.MinOrder); err != nil {
+			return nil, err
+		} else if c < 0 {
+			min, err := money.Format(p.MinOrder, "en")
+			if err != nil {
+				// Not a currency we know the minor units of.
+				min = money.FromProto(p.MinOrder).String()
+			}
+			return nil, fmt.Errorf("%w: the order is below the minimum of %s", errPromotionNotApplicable, min)
+		}
+	}
+	if len(eligible) == 0 {
+		return nil, fmt.Errorf("%w: no eligible items", errPromotionNotApplicable)
+	}
+
+	switch p.Type {
+	case promotionPercentOff:
+		d, err := money.Fraction(eligibleSubtotal, p.Percent, 100)
+		if err != nil {
+			return nil, err
+		}
+		return money.Round(d, money.RoundHalfUp)
+	case promotionAmountOff:
+		if c, err := money.Compare(p.AmountOff, eligibleSubtotal); err != nil {
+			return nil, err
+		} else if c > 0 {
+			return eligibleSubtotal, nil
+		}
+		return p.AmountOff, nil
+	case promotionBuyXGetY:
+		return p.freeUnits(eligible)
+	}
+	return nil, fmt.Errorf("unknown promotion type %q", p.Type)
+}
+
+// freeUnits returns the cost of the units a buy_x_get_y promotion gives
+// away: Get of every Buy+Get units, choosing the cheapest.
+func (p *promotion) freeUnits(items []*pb.OrderItem) (*pb.Money, error) {
+	var units int32
+	for _, it := range items {
+		units += it.GetItem().GetQuantity()
+	}
+	free := units / (p.Buy + p.Get) * p.Get
+	if free == 0 {
+		return nil, fmt.Errorf("%w: buy %d to get %d free", errPromotionNotApplicable, p.Buy+p.Get, p.Get)
+	}
+
+	cheapest := append([]*pb.OrderItem(nil), items...)
+	sort.SliceStable(cheapest, func(i, j int) bool {
+		c, _ := money.Compare(cheapest[i].GetCost(), cheapest[j].GetCost())
+		return c < 0
+	})
+	out := &pb.Money{CurrencyCode: items[0].GetCost().GetCurrencyCode()}
+	for _, it := range cheapest {
+		n := it.GetItem().GetQuantity()
+		if n > free {
+			n = free
+		}
+		line, err := money.Multiply(it.GetCost(), int64(n))
+		if err != nil {
+			return nil, err
+		}
+		if out, err = money.Sum(out, line); err != nil {
+			return nil, err
+		}
+		if free -= n; free == 0 {
+			break
+		}
+	}
+	return out, nil
+}
+
+// normalizePromoCode returns code the way promo codes are compared: without
+// surrounding space and in upper case.
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// promotionRules holds the promotions read from a file, re-reading it
+// whenever it changes.
+type promotionRules struct {
+	path string
+
+	mu      sync.RWMutex
+	rules   []promotion
+	modTime time.Time
+	size    int64
+}
+
+// newPromotionRules reads the promotions in path, which must be valid.
+func newPromotionRules(path string) (*promotionRules, error) {
+	r := &promotionRules{path: path}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload re-reads the file if it changed since it was last read. It reports
+// whether new rules were loaded; invalid files leave the rules unchanged.
+func (r *promotionRules) reload() (bool, error) {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat promotions file: %w", err)
+	}
+	r.mu.RLock()
+	unchanged := fi.ModTime().Equal(r.modTime) && fi.Size() == r.size
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	b, err := os.ReadFile(r.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read promotions file: %w", err)
+	}
+	var file struct {
+		Promotions []promotion `json:"promotions"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return false, fmt.Errorf("failed to parse promotions file: %w", err)
+	}
+	ids := make(map[string]bool)
+	codes := make(map[string]bool)
+	for i := range file.Promotions {
+		p := &file.Promotions[i]
+		if err := p.validate(); err != nil {
+			return false, err
+		}
+		p.Code = normalizePromoCode(p.Code)
+		if ids[p.ID] {
+			return false, fmt.Errorf("duplicate promotion id %q", p.ID)
+		}
+		ids[p.ID] = true
+		if p.Code != "" && codes[p.Code] {
+			return false, fmt.Errorf("duplicate promo code %q", p.Code)
+		}
+		if p.Code != "" {
+			codes[p.Code] = true
+		}
+	}
+
+	r.mu.Lock()
+	r.rules, r.modTime, r.size = file.Promotions, fi.ModTime(), fi.Size()
+	r.mu.Unlock()
+	log.Infof("loaded %d promotions from %s", len(file.Promotions), r.path)
+	return true, nil
+}
+
+// watch re-reads the promotions file every interval until ctx is done.
+func (r *promotionRules) watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := r.reload(); err != nil {
+				log.Warnf("keeping previous promotions: %+v", err)
+			}
+		}
+	}
+}
+
+// forOrder returns the promotions that could apply to an order placed at t
+// with promoCode: every active sale, plus the promotion with that code. It
+// returns errUnknownPromoCode if no active promotion has the code.
+func (r *promotionRules) forOrder(promoCode string, t time.Time) ([]promotion, error) {
+	promoCode = normalizePromoCode(promoCode)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []promotion
+	found := promoCode == ""
+	for _, p := range r.rules {
+		if !p.active(t) {
+			continue
+		}
+		if p.Code == "" || p.Code == promoCode {
+			out = append(out, p)
+			found = found || p.Code == promoCode
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w %q", errUnknownPromoCode, promoCode)
+	}
+	return out, nil
+}
+
+// promotionUsage counts how many orders used each promotion.
+type promotionUsage interface {
+	// Redeem records that orderID of userID used the promotion, unless that
+	// takes it over maxUses orders in total or maxPerUser orders of userID
+	// (zero meaning no limit), in which case it returns an error wrapping
+	// errPromotionExhausted. Redeeming again for the same order is a no-op.
+	Redeem(ctx context.Context, promotionID, userID, orderID string, maxUses, maxPerUser int) error
+	// Unredeem forgets that orderID used the promotion.
+	Unredeem(ctx context.Context, promotionID, orderID string) error
+}
+
+// appliedPromotion is a promotion that takes amount off an order.
+type appliedPromotion struct {
+	promotion
+	amount *pb.Money
+}
+
+// evaluatePromotions works out which promotions apply to the order and
+// what they take off, in the order's currency. A promo code that does not
+// apply is an error; sales that do not apply are skipped. The discounts
+// never add up to more than the items cost.
+func (cs *checkoutService) evaluatePromotions(ctx context.Context, promoCode string, prep orderPrep) ([]appliedPromotion, error) {
+	if cs.promotions == nil {
+		if promoCode != "" {
+			return nil, fmt.Errorf("%w %q", errUnknownPromoCode, promoCode)
+		}
+		return nil, nil
+	}
+	candidates, err := cs.promotions.forOrder(promoCode, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	subtotal := &pb.Money{}
+	if len(prep.orderItems) > 0 {
+		subtotal.CurrencyCode = prep.orderItems[0].GetCost().GetCurrencyCode()
+	}
+	for _, it := range prep.orderItems {
+		line, err := money.Multiply(it.GetCost(), int64(it.GetItem().GetQuantity()))
+		if err != nil {
+			return nil, err
+		}
+		if subtotal, err = money.Sum(subtotal, line); err != nil {
+			return nil, err
+		}
+	}
+	remaining := subtotal
+
+	var out []appliedPromotion
+	for _, p := range candidates {
+		if p.AmountOff, err = prep.conv.convertOptional(ctx, p.AmountOff, subtotal.GetCurrencyCode()); err != nil {
+			return nil, err
+		}
+		if p.MinOrder, err = prep.conv.convertOptional(ctx, p.MinOrder, subtotal.GetCurrencyCode()); err != nil {
+			return nil, err
+		}
+		amount, err := p.discount(prep.orderItems, prep.categories)
+		if errors.Is(err, errPromotionNotApplicable) && p.Code == "" {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("promo code %s: %w", p.Code, err)
+		}
+		if c, _ := money.Compare(amount, remaining); c > 0 {
+			amount = remaining
+		}
+		if money.IsZero(amount) {
+			continue
+		}
+		remaining = money.Must(money.Sum(remaining, money.Negate(amount)))
+		out = append(out, appliedPromotion{promotion: p, amount: copyMoney(amount)})
+	}
+	return out, nil
+}
+
+// redeemPromotions counts the order's use of each promotion. A sale that has
+// run out is dropped from the order; a promo code that has run out is an
+// error.
+func (cs *checkoutService) redeemPromotions(ctx context.Context, orderID, userID string, applied []appliedPromotion) ([]appliedPromotion, error) {
+	var out []appliedPromotion
+	for _, p := range applied {
+		err := cs.orders.Redeem(ctx, p.ID, userID, orderID, p.MaxUses, p.MaxUsesPerUser)
+		if errors.Is(err, errPromotionExhausted) && p.Code == "" {
+			log.Infof("sale %s ran out before order %s", p.ID, orderID)
+			continue
+		} else if err != nil {
+			for _, r := range out {
+				if uerr := cs.orders.Unredeem(ctx, r.ID, orderID); uerr != nil {
+					log.Warnf("failed to release promotion %s of order %s: %+v", r.ID, orderID, uerr)
+				}
+			}
+			return nil, fmt.Errorf("promotion %s: %w", p.ID, err)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// orderDiscounts returns the discount lines of an order.
+func orderDiscounts(applied []appliedPromotion) []*pb.OrderDiscount {
+	var out []*pb.OrderDiscount
+	for _, p := range applied {
+		out = append(out, &pb.OrderDiscount{Code: p.Code, Description: p.Description, Amount: p.amount})
+	}
+	return out
+}
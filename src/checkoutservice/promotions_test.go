@@ -0,0 +1,257 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+func usd(units int64, nanos int32) *pb.Money {
+	return &pb.Money{CurrencyCode: "USD", Units: units, Nanos: nanos}
+}
+
+func orderItem(productID string, quantity int32, cost *pb.Money) *pb.OrderItem {
+	return &pb.OrderItem{Item: &pb.CartItem{ProductId: productID, Quantity: quantity}, Cost: cost}
+}
+
+func TestPromotionDiscount(t *testing.T) {
+	items := []*pb.OrderItem{
+		orderItem("sunglasses", 2, usd(19, 990000000)),
+		orderItem("tanktop", 1, usd(18, 990000000)),
+		orderItem("mug", 3, usd(8, 990000000)),
+	}
+	categories := map[string][]string{
+		"sunglasses": {"accessories"},
+		"tanktop":    {"clothing", "tops"},
+		"mug":        {"kitchen"},
+	}
+	tests := []struct {
+		name    string
+		p       promotion
+		want    *pb.Money
+		wantErr error
+	}{
+		{"percent off everything", promotion{Type: promotionPercentOff, Percent: 10},
+			usd(8, 590000000), nil}, // 10% of 85.94, rounded to the cent
+		{"percent off a category", promotion{Type: promotionPercentOff, Percent: 50, Categories: []string{"Clothing"}},
+			usd(9, 500000000), nil}, // half of 18.99, rounded up
+		{"amount off", promotion{Type: promotionAmountOff, AmountOff: usd(5, 0)},
+			usd(5, 0), nil},
+		{"amount off capped at eligible items", promotion{Type: promotionAmountOff, AmountOff: usd(50, 0), ProductIDs: []string{"tanktop"}},
+			usd(18, 990000000), nil},
+		{"buy 2 get 1 gives the cheapest away", promotion{Type: promotionBuyXGetY, Buy: 2, Get: 1},
+			usd(17, 980000000), nil}, // 6 units: 2 free mugs
+		{"buy 2 get 1 in a category", promotion{Type: promotionBuyXGetY, Buy: 1, Get: 1, Categories: []string{"accessories"}},
+			usd(19, 990000000), nil},
+		{"min order met", promotion{Type: promotionAmountOff, AmountOff: usd(1, 0), MinOrder: usd(85, 0)},
+			usd(1, 0), nil},
+		{"min order not met", promotion{Type: promotionAmountOff, AmountOff: usd(1, 0), MinOrder: usd(100, 0)},
+			nil, errPromotionNotApplicable},
+		{"no eligible items", promotion{Type: promotionPercentOff, Percent: 10, Categories: []string{"footwear"}},
+			nil, errPromotionNotApplicable},
+		{"not enough units", promotion{Type: promotionBuyXGetY, Buy: 2, Get: 1, ProductIDs: []string{"sunglasses"}},
+			nil, errPromotionNotApplicable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.discount(items, categories)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("discount() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.want != nil && (got.GetUnits() != tt.want.GetUnits() || got.GetNanos() != tt.want.GetNanos() || got.GetCurrencyCode() != "USD") {
+				t.Errorf("discount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromotionMinOrderMessage(t *testing.T) {
+	items := []*pb.OrderItem{orderItem("mug", 1, &pb.Money{CurrencyCode: "EUR", Units: 8, Nanos: 990000000})}
+	p := promotion{Type: promotionAmountOff,
+		AmountOff: &pb.Money{CurrencyCode: "EUR", Units: 1},
+		MinOrder:  &pb.Money{CurrencyCode: "EUR", Units: 1234, Nanos: 500000000}}
+	_, err := p.discount(items, nil)
+	if want := "below the minimum of €1,234.50"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("discount() error = %v, want it to contain %q", err, want)
+	}
+}
+
+func writePromotions(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPromotionRulesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "promotions.json")
+	base := time.Now().Add(-time.Hour)
+	writePromotions(t, path, `{"promotions": [
+		{"id": "ten", "code": "save10", "type": "percent_off", "percent": 10},
+		{"id": "sale", "type": "amount_off", "amount_off": {"currency_code": "USD", "units": 2}},
+		{"id": "later", "code": "LATER", "type": "percent_off", "percent": 5, "starts_at": "2999-01-01T00:00:00Z"}
+	]}`, base)
+	r, err := newPromotionRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.forOrder(" Save10 ", time.Now())
+	if err != nil || len(got) != 2 {
+		t.Fatalf("forOrder(save10) = %v, %v; want the sale and the code", got, err)
+	}
+	if _, err := r.forOrder("LATER", time.Now()); !errors.Is(err, errUnknownPromoCode) {
+		t.Errorf("forOrder(LATER) error = %v, want %v before it starts", err, errUnknownPromoCode)
+	}
+
+	// A broken file keeps the rules that were loaded.
+	writePromotions(t, path, `{"promotions": [{"id": "x", "type": "bogus"}]}`, base.Add(time.Minute))
+	if _, err := r.reload(); err == nil {
+		t.Error("reload() accepted an unknown promotion type")
+	}
+	if _, err := r.forOrder("SAVE10", time.Now()); err != nil {
+		t.Errorf("forOrder(SAVE10) after a bad reload: %v", err)
+	}
+
+	writePromotions(t, path, `{"promotions": [{"id": "twenty", "code": "SAVE20", "type": "percent_off", "percent": 20}]}`, base.Add(2*time.Minute))
+	if changed, err := r.reload(); err != nil || !changed {
+		t.Fatalf("reload() = %v, %v; want new rules", changed, err)
+	}
+	if _, err := r.forOrder("SAVE10", time.Now()); !errors.Is(err, errUnknownPromoCode) {
+		t.Errorf("forOrder(SAVE10) error = %v, want %v after reload", err, errUnknownPromoCode)
+	}
+	if changed, _ := r.reload(); changed {
+		t.Error("reload() reloaded an unchanged file")
+	}
+}
+
+// testPromotionUsage runs the same checks against every order repository.
+func testPromotionUsage(t *testing.T, r orderRepository) {
+	ctx := context.Background()
+	for _, o := range []string{"o1", "o2"} {
+		if err := r.Redeem(ctx, "p", "u1", o, 3, 2); err != nil {
+			t.Fatalf("Redeem(%s) failed: %v", o, err)
+		}
+	}
+	if err := r.Redeem(ctx, "p", "u1", "o1", 3, 2); err != nil {
+		t.Errorf("Redeem(o1) again failed: %v", err)
+	}
+	if err := r.Redeem(ctx, "p", "u1", "o3", 3, 2); !errors.Is(err, errPromotionExhausted) {
+		t.Errorf("Redeem(third order of u1) error = %v, want %v", err, errPromotionExhausted)
+	}
+	if err := r.Redeem(ctx, "p", "u2", "o4", 3, 2); err != nil {
+		t.Fatalf("Redeem(o4 of u2) failed: %v", err)
+	}
+	if err := r.Redeem(ctx, "p", "u3", "o5", 3, 2); !errors.Is(err, errPromotionExhausted) {
+		t.Errorf("Redeem(fourth order) error = %v, want %v", err, errPromotionExhausted)
+	}
+	if err := r.Redeem(ctx, "other", "u1", "o3", 0, 0); err != nil {
+		t.Errorf("Redeem(unlimited) failed: %v", err)
+	}
+
+	if err := r.Unredeem(ctx, "p", "o1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Redeem(ctx, "p", "u1", "o3", 3, 2); err != nil {
+		t.Errorf("Redeem(o3) after giving back o1 failed: %v", err)
+	}
+}
+
+func TestMemoryPromotionUsage(t *testing.T) {
+	testPromotionUsage(t, newMemoryOrderRepository())
+}
+
+func TestSQLPromotionUsage(t *testing.T) {
+	r, err := newSQLOrderRepository(filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	testPromotionUsage(t, r)
+}
+
+func TestPlaceOrderAppliesPromotions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "promotions.json")
+	writePromotions(t, path, `{"promotions": [
+		{"id": "welcome", "code": "WELCOME", "description": "5 off your first order", "type": "amount_off",
+		 "amount_off": {"currency_code": "USD", "units": 5}, "max_uses_per_user": 1},
+		{"id": "tops", "description": "10% off tops", "type": "percent_off", "percent": 10, "categories": ["tops"]}
+	]}`, time.Now())
+	rules, err := newPromotionRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := newFakeDeps()
+	cs := newTestCheckout(t, deps)
+	cs.promotions = rules
+	ctx := context.Background()
+	cart := []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 1}, {ProductId: "OLJCESPC7Z", Quantity: 1}}
+
+	req := testOrderRequest("u1")
+	req.PromoCode = "welcome"
+	deps.carts["u1"] = cart
+	resp, err := cs.PlaceOrder(ctx, req)
+	if err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	d := resp.GetOrder().GetDiscounts()
+	if len(d) != 2 || d[0].GetCode() != "WELCOME" || d[1].GetCode() != "" || d[1].GetDescription() != "10% off tops" {
+		t.Fatalf("discounts = %v, want WELCOME and the tops sale", d)
+	}
+	if a := d[1].GetAmount(); a.GetUnits() != 1 || a.GetNanos() != 900000000 {
+		t.Errorf("tops discount = %v, want 1.90", a)
+	}
+	// 18.99 + 19.99 + 8.99 shipping - 5 - 1.90
+	if got := deps.charges[0].GetAmount(); got.GetUnits() != 41 || got.GetNanos() != 70000000 {
+		t.Errorf("charged %d.%09d, want 41.070000000", got.GetUnits(), got.GetNanos())
+	}
+
+	// The code is good for one order per user.
+	deps.carts["u1"] = cart
+	if _, err := cs.PlaceOrder(ctx, req); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("PlaceOrder(used code) error = %v, want code %v", err, codes.FailedPrecondition)
+	}
+	req.PromoCode = "NOPE"
+	if _, err := cs.PlaceOrder(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("PlaceOrder(unknown code) error = %v, want code %v", err, codes.InvalidArgument)
+	}
+
+	// A failed order gives its code back.
+	deps.shipErr = status.Error(codes.Unavailable, "no trucks")
+	req = testOrderRequest("u2")
+	req.PromoCode = "WELCOME"
+	deps.carts["u2"] = cart
+	if _, err := cs.PlaceOrder(ctx, req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("PlaceOrder() error = %v, want code %v", err, codes.Unavailable)
+	}
+	deps.shipErr = nil
+	if _, err := cs.PlaceOrder(ctx, req); err != nil {
+		t.Errorf("PlaceOrder() after a failed order with the same code: %v", err)
+	}
+}
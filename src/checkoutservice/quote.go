@@ -0,0 +1,218 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// quoteTTL is how long a quote from PreviewOrder can be placed.
+const quoteTTL = 15 * time.Minute
+
+var (
+	errQuoteInvalid  = errors.New("invalid quote token")
+	errQuoteExpired  = errors.New("quote expired")
+	errQuoteMismatch = errors.New("quote is for a different order")
+	errQuoteStale    = errors.New("cart or prices changed since the quote")
+)
+
+// quoteClaims is what a quote token vouches for. The address and pricing
+// are digests, which keeps tokens short.
+type quoteClaims struct {
+	UserID    string `json:"u"`
+	Currency  string `json:"c"`
+	PromoCode string `json:"p,omitempty"`
+	Address   string `json:"a"`
+	Pricing   string `json:"d"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// quoteSigner signs and verifies quote tokens with HMAC-SHA256. A token is
+// the base64 encoded claims and signature, joined by a dot.
+type quoteSigner struct {
+	key []byte
+	now func() time.Time
+}
+
+func newQuoteSigner(key []byte) *quoteSigner {
+	return &quoteSigner{key: key, now: time.Now}
+}
+
+// newRandomQuoteSigner signs with a random key, for when no key is
+// configured. Its tokens are only good on this replica until it restarts.
+func newRandomQuoteSigner() (*quoteSigner, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate quote signing key: %w", err)
+	}
+	return newQuoteSigner(key), nil
+}
+
+func (s *quoteSigner) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+func (s *quoteSigner) sign(c quoteClaims) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload)), nil
+}
+
+// verify checks the signature and expiry of token and returns its claims.
+func (s *quoteSigner) verify(token string) (quoteClaims, error) {
+	var c quoteClaims
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return c, errQuoteInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.mac(payload)) {
+		return c, errQuoteInvalid
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return c, errQuoteInvalid
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, errQuoteInvalid
+	}
+	if !s.now().Before(time.Unix(c.ExpiresAt, 0)) {
+		return c, errQuoteExpired
+	}
+	return c, nil
+}
+
+// digest returns a hash of m that is the same for equal messages.
+func digest(m proto.Message) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// pricingDigest hashes what order costs: its items, shipping, discounts,
+// taxes and total.
+func pricingDigest(order *pb.OrderResult) (string, error) {
+	return digest(&pb.OrderResult{
+		Items:        order.GetItems(),
+		ShippingCost: order.GetShippingCost(),
+		Discounts:    order.GetDiscounts(),
+		Taxes:        order.GetTaxes(),
+		Total:        order.GetTotal(),
+	})
+}
+
+// newQuoteClaims returns the claims of a quote for order, priced for
+// userID in currency with promoCode and shipped to address.
+func newQuoteClaims(userID, currency, promoCode string, address *pb.Address, order *pb.OrderResult, expires time.Time) (quoteClaims, error) {
+	addr, err := digest(address)
+	if err != nil {
+		return quoteClaims{}, err
+	}
+	pricing, err := pricingDigest(order)
+	if err != nil {
+		return quoteClaims{}, err
+	}
+	return quoteClaims{
+		UserID:    userID,
+		Currency:  currency,
+		PromoCode: normalizePromoCode(promoCode),
+		Address:   addr,
+		Pricing:   pricing,
+		ExpiresAt: expires.Unix(),
+	}, nil
+}
+
+// checkQuote verifies that token is a quote for the order req places, and
+// that order still costs what was quoted.
+func (cs *checkoutService) checkQuote(token string, req *pb.PlaceOrderRequest, order *pb.OrderResult) error {
+	claims, err := cs.quotes.verify(token)
+	if err != nil {
+		return err
+	}
+	want, err := newQuoteClaims(req.GetUserId(), req.GetUserCurrency(), req.GetPromoCode(), req.GetAddress(), order, time.Time{})
+	if err != nil {
+		return err
+	}
+	if claims.UserID != want.UserID || claims.Currency != want.Currency ||
+		claims.PromoCode != want.PromoCode || claims.Address != want.Address {
+		return errQuoteMismatch
+	}
+	if claims.Pricing != want.Pricing {
+		return errQuoteStale
+	}
+	return nil
+}
+
+// quoteError converts an error from checkQuote to a gRPC status.
+func quoteError(err error) error {
+	switch {
+	case errors.Is(err, errQuoteInvalid), errors.Is(err, errQuoteMismatch):
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	case errors.Is(err, errQuoteExpired), errors.Is(err, errQuoteStale):
+		return status.Errorf(codes.FailedPrecondition, "%v", err)
+	}
+	return status.Errorf(codes.Internal, "failed to check quote: %+v", err)
+}
+
+func (cs *checkoutService) PreviewOrder(ctx context.Context, req *pb.PreviewOrderRequest) (*pb.PreviewOrderResponse, error) {
+	log.Infof("[PreviewOrder] user_id=%q user_currency=%q", req.GetUserId(), req.GetUserCurrency())
+
+	prep, promotions, err := cs.priceOrder(ctx, req.GetUserId(), req.GetUserCurrency(), req.GetAddress(), req.GetPromoCode())
+	if err != nil {
+		return nil, err
+	}
+	order, err := cs.pricedOrder(req.GetAddress(), prep, promotions)
+	if err != nil {
+		return nil, err
+	}
+	order.UserId = req.GetUserId()
+	order.ShippingAddress = req.GetAddress()
+
+	expires := cs.quotes.now().Add(quoteTTL)
+	claims, err := newQuoteClaims(req.GetUserId(), req.GetUserCurrency(), req.GetPromoCode(), req.GetAddress(), order, expires)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to make quote: %+v", err)
+	}
+	token, err := cs.quotes.sign(claims)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to sign quote: %+v", err)
+	}
+	return &pb.PreviewOrderResponse{Order: order, QuoteToken: token, ExpiresAt: timestamppb.New(expires)}, nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+func TestQuoteSigner(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newQuoteSigner([]byte("key"))
+	s.now = func() time.Time { return now }
+
+	claims := quoteClaims{UserID: "u1", Currency: "EUR", Pricing: "abc", ExpiresAt: now.Add(time.Minute).Unix()}
+	token, err := s.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.verify(token); err != nil || got != claims {
+		t.Fatalf("verify() = %+v, %v; want %+v", got, err, claims)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	forged, _ := s.sign(quoteClaims{UserID: "u1", Currency: "EUR", Pricing: "cheaper", ExpiresAt: claims.ExpiresAt})
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	for _, bad := range []string{
+		"", "nodot", payload + ".", forgedPayload + "." + sig, payload + "." + sig + "x",
+	} {
+		if _, err := s.verify(bad); !errors.Is(err, errQuoteInvalid) {
+			t.Errorf("verify(%q) error = %v, want %v", bad, err, errQuoteInvalid)
+		}
+	}
+	if _, err := newQuoteSigner([]byte("other key")).verify(token); !errors.Is(err, errQuoteInvalid) {
+		t.Errorf("verify() with another key error = %v, want %v", err, errQuoteInvalid)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := s.verify(token); !errors.Is(err, errQuoteExpired) {
+		t.Errorf("verify() after expiry error = %v, want %v", err, errQuoteExpired)
+	}
+}
+
+func previewFor(req *pb.PlaceOrderRequest) *pb.PreviewOrderRequest {
+	return &pb.PreviewOrderRequest{
+		UserId:       req.GetUserId(),
+		UserCurrency: req.GetUserCurrency(),
+		Address:      req.GetAddress(),
+		PromoCode:    req.GetPromoCode(),
+	}
+}
+
+func TestPlaceOrderHonoursQuote(t *testing.T) {
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	cs := newTestCheckout(t, deps)
+	cs.taxes = loadTestTaxRules(t)
+	ctx := context.Background()
+
+	req := testOrderRequest("u1")
+	preview, err := cs.PreviewOrder(ctx, previewFor(req))
+	if err != nil {
+		t.Fatalf("PreviewOrder() failed: %v", err)
+	}
+	quoted := preview.GetOrder().GetTotal()
+	if len(preview.GetOrder().GetTaxes()) == 0 || preview.GetOrder().GetOrderId() != "" {
+		t.Errorf("preview = %v, want a taxed order without id", preview.GetOrder())
+	}
+	if len(deps.charges) != 0 || len(deps.reservations) != 0 {
+		t.Error("PreviewOrder() charged or reserved stock")
+	}
+
+	req.QuoteToken = preview.GetQuoteToken()
+	resp, err := cs.PlaceOrder(ctx, req)
+	if err != nil {
+		t.Fatalf("PlaceOrder() with quote failed: %v", err)
+	}
+	got := deps.charges[0].GetAmount()
+	if got.GetUnits() != quoted.GetUnits() || got.GetNanos() != quoted.GetNanos() {
+		t.Errorf("charged %v, want the quoted %v", got, quoted)
+	}
+	if total := resp.GetOrder().GetTotal(); total.GetUnits() != quoted.GetUnits() || total.GetNanos() != quoted.GetNanos() {
+		t.Errorf("order total = %v, want the quoted %v", total, quoted)
+	}
+}
+
+func TestPlaceOrderRejectsQuote(t *testing.T) {
+	deps := newFakeDeps()
+	cs := newTestCheckout(t, deps)
+	ctx := context.Background()
+	cart := []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+
+	tests := []struct {
+		name   string
+		change func(req *pb.PlaceOrderRequest)
+		want   codes.Code
+	}{
+		{"price changed", func(*pb.PlaceOrderRequest) {
+			deps.products["OLJCESPC7Z"] = &pb.Product{Id: "OLJCESPC7Z", Name: "Sunglasses",
+				PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 24, Nanos: 990000000}}
+		}, codes.FailedPrecondition},
+		{"cart changed", func(*pb.PlaceOrderRequest) {
+			deps.carts["u1"] = append(deps.carts["u1"], &pb.CartItem{ProductId: "66VCHSJNUP", Quantity: 1})
+		}, codes.FailedPrecondition},
+		{"expired", func(*pb.PlaceOrderRequest) {
+			cs.quotes.now = func() time.Time { return time.Now().Add(quoteTTL) }
+		}, codes.FailedPrecondition},
+		{"other address", func(req *pb.PlaceOrderRequest) { req.Address.ZipCode = 94044 }, codes.InvalidArgument},
+		{"other user", func(req *pb.PlaceOrderRequest) { req.UserId = "u2"; deps.carts["u2"] = cart }, codes.InvalidArgument},
+		{"tampered", func(req *pb.PlaceOrderRequest) { req.QuoteToken = "x" + req.QuoteToken }, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := deps.products["OLJCESPC7Z"]
+			defer func() {
+				deps.products["OLJCESPC7Z"] = saved
+				cs.quotes.now = time.Now
+			}()
+			deps.carts["u1"] = cart
+			req := testOrderRequest("u1")
+			preview, err := cs.PreviewOrder(ctx, previewFor(req))
+			if err != nil {
+				t.Fatalf("PreviewOrder() failed: %v", err)
+			}
+			req.QuoteToken = preview.GetQuoteToken()
+			tt.change(req)
+			if _, err := cs.PlaceOrder(ctx, req); status.Code(err) != tt.want {
+				t.Errorf("PlaceOrder() error = %v, want code %v", err, tt.want)
+			}
+		})
+	}
+	if len(deps.charges) != 0 {
+		t.Errorf("%d orders were charged, want none", len(deps.charges))
+	}
+}
@@ -0,0 +1,461 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
+)
+
+// Reason codes of risk assessments. Velocity checks use "VELOCITY_" and
+// their upper-cased key, such as VELOCITY_EMAIL.
+const (
+	riskCountryMismatch = "COUNTRY_MISMATCH"
+	riskHighOrderValue  = "HIGH_ORDER_VALUE"
+	riskDenyListed      = "DENY_LISTED"
+	// riskHighScore is the reason given for orders denied on their score.
+	riskHighScore = "HIGH_RISK_SCORE"
+
+	riskScorerTimeout = 2 * time.Second
+)
+
+// Keys velocity rules can count orders by.
+const (
+	velocityUserID  = "user_id"
+	velocityEmail   = "email"
+	velocityCardBIN = "card_bin"
+)
+
+// riskConfig configures the risk rules. Every check that triggers adds its
+// score; orders scoring review_score or more are flagged for review, and
+// those scoring deny_score or more, or matching a deny list, are refused.
+type riskConfig struct {
+	ReviewScore int `json:"review_score"`
+	DenyScore   int `json:"deny_score"`
+
+	Velocity []velocityRule `json:"velocity,omitempty"`
+	// CountryMismatchScore is added when the billing and shipping
+	// countries differ.
+	CountryMismatchScore int              `json:"country_mismatch_score,omitempty"`
+	ValueThresholds      []valueThreshold `json:"value_thresholds,omitempty"`
+	Deny                 denyLists        `json:"deny"`
+
+	// BINCountries maps card BINs to the country that issued the card,
+	// taken as the billing country when no billing address is given.
+	BINCountries map[string]string `json:"bin_countries,omitempty"`
+	// CountryAliases maps country names to codes, so that "United States"
+	// and "US" are the same country. Keys are matched case-insensitively.
+	CountryAliases map[string]string `json:"country_aliases,omitempty"`
+}
+
+// velocityRule adds Score to orders when more than MaxOrders orders with
+// the same Key were attempted within Window.
+type velocityRule struct {
+	Key       string `json:"key"`
+	Window    string `json:"window"`
+	MaxOrders int    `json:"max_orders"`
+	Score     int    `json:"score"`
+
+	window time.Duration
+}
+
+// valueThreshold adds Score to orders that cost at least Min. Only the
+// highest threshold an order reaches counts.
+type valueThreshold struct {
+	Min   *pb.Money `json:"min"`
+	Score int       `json:"score"`
+}
+
+type denyLists struct {
+	UserIDs      []string `json:"user_ids,omitempty"`
+	Emails       []string `json:"emails,omitempty"`
+	EmailDomains []string `json:"email_domains,omitempty"`
+	CardBINs     []string `json:"card_bins,omitempty"`
+	// Countries are shipping countries that are not served.
+	Countries []string `json:"countries,omitempty"`
+}
+
+// defaultRiskConfig is used when no risk rules file is given.
+func defaultRiskConfig() riskConfig {
+	return riskConfig{
+		ReviewScore: 50,
+		DenyScore:   80,
+		Velocity: []velocityRule{
+			{Key: velocityUserID, Window: "1h", MaxOrders: 5, Score: 40},
+			{Key: velocityEmail, Window: "1h", MaxOrders: 5, Score: 40},
+			{Key: velocityCardBIN, Window: "10m", MaxOrders: 20, Score: 30},
+		},
+		CountryMismatchScore: 30,
+		ValueThresholds: []valueThreshold{
+			{Min: &pb.Money{CurrencyCode: "USD", Units: 1000}, Score: 20},
+			{Min: &pb.Money{CurrencyCode: "USD", Units: 5000}, Score: 50},
+		},
+		CountryAliases: countryNames,
+	}
+}
+
+func (c *riskConfig) validate() error {
+	if c.ReviewScore <= 0 || c.ReviewScore > c.DenyScore || c.DenyScore > 100 {
+		return fmt.Errorf("risk scores must satisfy 0 < review_score <= deny_score <= 100")
+	}
+	for i := range c.Velocity {
+		r := &c.Velocity[i]
+		switch r.Key {
+		case velocityUserID, velocityEmail, velocityCardBIN:
+		default:
+			return fmt.Errorf("unknown velocity key %q", r.Key)
+		}
+		d, err := time.ParseDuration(r.Window)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("velocity rule for %s: invalid window %q", r.Key, r.Window)
+		}
+		if r.MaxOrders <= 0 {
+			return fmt.Errorf("velocity rule for %s: max_orders must be positive", r.Key)
+		}
+		r.window = d
+	}
+	for _, t := range c.ValueThresholds {
+		if t.Min == nil || !money.IsValid(t.Min) || t.Min.GetCurrencyCode() == "" {
+			return fmt.Errorf("value threshold without a valid min")
+		}
+	}
+	return nil
+}
+
+// loadRiskConfig reads the risk rules in path.
+func loadRiskConfig(path string) (riskConfig, error) {
+	var c riskConfig
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return c, fmt.Errorf("failed to read risk rules file: %w", err)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("failed to parse risk rules file: %w", err)
+	}
+	return c, nil
+}
+
+// riskInput is what an order is assessed on. It is also what is sent to an
+// external scorer.
+type riskInput struct {
+	OrderID         string    `json:"order_id"`
+	UserID          string    `json:"user_id"`
+	Email           string    `json:"email"`
+	CardBIN         string    `json:"card_bin,omitempty"`
+	ShippingCountry string    `json:"shipping_country"`
+	BillingCountry  string    `json:"billing_country,omitempty"`
+	Total           *pb.Money `json:"total"`
+	Items           int32     `json:"items"`
+}
+
+// riskScore is a score with the reasons for it.
+type riskScore struct {
+	Score   int      `json:"score"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// riskScorer is an external risk model consulted in addition to the rules.
+type riskScorer interface {
+	Score(ctx context.Context, in riskInput) (riskScore, error)
+}
+
+// httpRiskScorer posts orders as JSON to a scoring service, which answers
+// with a riskScore.
+type httpRiskScorer struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPRiskScorer(url string) *httpRiskScorer {
+	return &httpRiskScorer{url: url, client: &http.Client{Timeout: riskScorerTimeout}}
+}
+
+func (s *httpRiskScorer) Score(ctx context.Context, in riskInput) (riskScore, error) {
+	var out riskScore
+	b, err := json.Marshal(in)
+	if err != nil {
+		return out, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return out, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return out, fmt.Errorf("risk scorer request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return out, fmt.Errorf("risk scorer returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return out, fmt.Errorf("failed to decode risk score: %w", err)
+	}
+	return out, nil
+}
+
+// velocityTracker remembers when orders were attempted, by key, for as long
+// as the longest velocity window. It is kept in memory, so each replica
+// counts only the orders it saw.
+type velocityTracker struct {
+	maxWindow time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	seen      map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newVelocityTracker(maxWindow time.Duration) *velocityTracker {
+	return &velocityTracker{maxWindow: maxWindow, now: time.Now, seen: make(map[string][]time.Time)}
+}
+
+// add records an attempt under each of keys and returns, for each key, the
+// times of the attempts made under it within the longest window, this one
+// included.
+func (v *velocityTracker) add(keys ...string) map[string][]time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.now()
+	cutoff := now.Add(-v.maxWindow)
+	if now.Sub(v.lastSweep) > v.maxWindow {
+		for k, ts := range v.seen {
+			if len(ts) == 0 || !ts[len(ts)-1].After(cutoff) {
+				delete(v.seen, k)
+			}
+		}
+		v.lastSweep = now
+	}
+
+	out := make(map[string][]time.Time, len(keys))
+	for _, k := range keys {
+		ts := v.seen[k]
+		i := sort.Search(len(ts), func(i int) bool { return ts[i].After(cutoff) })
+		ts = append(ts[i:], now)
+		v.seen[k] = ts
+		out[k] = append([]time.Time(nil), ts...)
+	}
+	return out
+}
+
+// riskEngine assesses orders before they are charged.
+type riskEngine struct {
+	cfg      riskConfig
+	velocity *velocityTracker
+	// scorer is optional.
+	scorer riskScorer
+}
+
+func newRiskEngine(cfg riskConfig, scorer riskScorer) (*riskEngine, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	var maxWindow time.Duration
+	for _, r := range cfg.Velocity {
+		if r.window > maxWindow {
+			maxWindow = r.window
+		}
+	}
+	aliases := make(map[string]string, len(cfg.CountryAliases))
+	for k, v := range cfg.CountryAliases {
+		aliases[strings.ToLower(k)] = strings.ToUpper(v)
+	}
+	cfg.CountryAliases = aliases
+	sort.Slice(cfg.ValueThresholds, func(i, j int) bool {
+		c, _ := money.Compare(cfg.ValueThresholds[i].Min, cfg.ValueThresholds[j].Min)
+		return c > 0
+	})
+	return &riskEngine{cfg: cfg, velocity: newVelocityTracker(maxWindow), scorer: scorer}, nil
+}
+
+// country returns the code of a country name or code.
+func (e *riskEngine) country(name string) string {
+	name = strings.TrimSpace(name)
+	if c, ok := e.cfg.CountryAliases[strings.ToLower(name)]; ok {
+		return c
+	}
+	return strings.ToUpper(name)
+}
+
+// cardBIN returns the first six digits of a card number.
+func cardBIN(number string) string {
+	var b strings.Builder
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			if b.Len() == 6 {
+				return b.String()
+			}
+		}
+	}
+	return ""
+}
+
+// newRiskInput collects what an order is assessed on.
+func (e *riskEngine) newRiskInput(req *pb.PlaceOrderRequest, order *pb.OrderResult) riskInput {
+	in := riskInput{
+		OrderID:         order.GetOrderId(),
+		UserID:          req.GetUserId(),
+		Email:           strings.ToLower(strings.TrimSpace(req.GetEmail())),
+		CardBIN:         cardBIN(req.GetCreditCard().GetCreditCardNumber()),
+		ShippingCountry: e.country(req.GetAddress().GetCountry()),
+		Total:           order.GetTotal(),
+	}
+	if c := req.GetBillingAddress().GetCountry(); c != "" {
+		in.BillingCountry = e.country(c)
+	} else if c, ok := e.cfg.BINCountries[in.CardBIN]; ok {
+		in.BillingCountry = e.country(c)
+	}
+	for _, it := range order.GetItems() {
+		in.Items += it.GetItem().GetQuantity()
+	}
+	return in
+}
+
+// denied reports whether in matches a deny list.
+func (e *riskEngine) denied(in riskInput) bool {
+	d := e.cfg.Deny
+	domain := in.Email[strings.LastIndex(in.Email, "@")+1:]
+	if containsFold(d.UserIDs, in.UserID) || containsFold(d.Emails, in.Email) || containsFold(d.EmailDomains, domain) {
+		return true
+	}
+	if in.CardBIN != "" && containsFold(d.CardBINs, in.CardBIN) {
+		return true
+	}
+	for _, c := range d.Countries {
+		if e.country(c) == in.ShippingCountry {
+			return true
+		}
+	}
+	return false
+}
+
+// assess scores an order and decides whether it may be charged. conv
+// converts the value thresholds to the order's currency. The order counts
+// towards the velocity of its user, email and card whatever the decision.
+func (e *riskEngine) assess(ctx context.Context, in riskInput, conv *currencyConverter) (*pb.RiskAssessment, error) {
+	var (
+		score   int
+		reasons []string
+	)
+	add := func(points int, reason string) {
+		score += points
+		reasons = append(reasons, reason)
+	}
+
+	keys := map[string]string{velocityUserID: in.UserID, velocityEmail: in.Email, velocityCardBIN: in.CardBIN}
+	var tracked []string
+	for k, v := range keys {
+		if v != "" {
+			tracked = append(tracked, k+":"+v)
+		}
+	}
+	seen := e.velocity.add(tracked...)
+	now := e.velocity.now()
+	for _, r := range e.cfg.Velocity {
+		var n int
+		for _, t := range seen[r.Key+":"+keys[r.Key]] {
+			if now.Sub(t) < r.window {
+				n++
+			}
+		}
+		if keys[r.Key] != "" && n > r.MaxOrders {
+			add(r.Score, "VELOCITY_"+strings.ToUpper(r.Key))
+		}
+	}
+
+	if e.cfg.CountryMismatchScore > 0 && in.BillingCountry != "" && in.BillingCountry != in.ShippingCountry {
+		add(e.cfg.CountryMismatchScore, riskCountryMismatch)
+	}
+
+	for _, t := range e.cfg.ValueThresholds {
+		min, err := conv.convertOptional(ctx, t.Min, in.Total.GetCurrencyCode())
+		if err != nil {
+			return nil, err
+		}
+		if c, err := money.Compare(in.Total, min); err != nil {
+			return nil, err
+		} else if c >= 0 {
+			add(t.Score, riskHighOrderValue)
+			break
+		}
+	}
+
+	if e.scorer != nil {
+		ext, err := e.scorer.Score(ctx, in)
+		if err != nil {
+			// The rules still apply when the scorer is down.
+			log.Warnf("external risk scorer failed for order %s: %+v", in.OrderID, err)
+		} else {
+			score += ext.Score
+			reasons = append(reasons, ext.Reasons...)
+		}
+	}
+
+	if score > 100 {
+		score = 100
+	} else if score < 0 {
+		score = 0
+	}
+	out := &pb.RiskAssessment{Score: int32(score), Reasons: reasons, Decision: pb.RiskDecision_RISK_DECISION_ALLOW}
+	switch {
+	case e.denied(in):
+		out.Decision = pb.RiskDecision_RISK_DECISION_DENY
+		out.Reasons = append(out.Reasons, riskDenyListed)
+	case score >= e.cfg.DenyScore:
+		out.Decision = pb.RiskDecision_RISK_DECISION_DENY
+	case score >= e.cfg.ReviewScore:
+		out.Decision = pb.RiskDecision_RISK_DECISION_REVIEW
+	}
+	return out, nil
+}
+
+// riskDeniedError returns the status of an order denied by risk, with an
+// ErrorInfo carrying the reason code.
+func riskDeniedError(orderID string, risk *pb.RiskAssessment) error {
+	reason := riskHighScore
+	for _, r := range risk.GetReasons() {
+		if r == riskDenyListed {
+			reason = riskDenyListed
+		}
+	}
+	return detailedError(codes.PermissionDenied, reason, nil,
+		fmt.Sprintf("order %s was declined by risk assessment: %s", orderID, reason))
+}
+
+// recordDeniedOrder stores an order denied by risk assessment, cancelled,
+// so that the decision can be looked up later.
+func (cs *checkoutService) recordDeniedOrder(ctx context.Context, order *pb.OrderResult) {
+	log.Warnf("order %s declined by risk assessment: score %d %v", order.GetOrderId(), order.GetRisk().GetScore(), order.GetRisk().GetReasons())
+	if err := cs.orders.Create(ctx, newPendingOrder(order)); err != nil {
+		log.Errorf("failed to record declined order %s: %+v", order.GetOrderId(), err)
+		return
+	}
+	cs.cancelOrder(ctx, order.GetOrderId(), "declined by risk assessment")
+}
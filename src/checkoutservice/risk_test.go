@@ -0,0 +1,265 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+type fakeScorer struct {
+	score riskScore
+	err   error
+}
+
+func (s fakeScorer) Score(context.Context, riskInput) (riskScore, error) { return s.score, s.err }
+
+func testRiskConfig() riskConfig {
+	return riskConfig{
+		ReviewScore: 50,
+		DenyScore:   80,
+		Velocity: []velocityRule{
+			{Key: velocityUserID, Window: "1h", MaxOrders: 2, Score: 40},
+			{Key: velocityCardBIN, Window: "1m", MaxOrders: 3, Score: 30},
+		},
+		CountryMismatchScore: 30,
+		ValueThresholds: []valueThreshold{
+			{Min: usd(100, 0), Score: 20},
+			{Min: usd(1000, 0), Score: 50},
+		},
+		Deny: denyLists{
+			EmailDomains: []string{"fraud.example"},
+			CardBINs:     []string{"400000"},
+			Countries:    []string{"Atlantis"},
+		},
+		BINCountries:   map[string]string{"443280": "US", "510510": "GB"},
+		CountryAliases: map[string]string{"United States": "US", "United Kingdom": "gb"},
+	}
+}
+
+func riskRequest(userID, card string, total *pb.Money) (*pb.PlaceOrderRequest, *pb.OrderResult) {
+	req := testOrderRequest(userID)
+	req.CreditCard.CreditCardNumber = card
+	return req, &pb.OrderResult{OrderId: "o-" + userID, Total: total}
+}
+
+func TestRiskAssess(t *testing.T) {
+	tests := []struct {
+		name         string
+		scorer       riskScorer
+		change       func(req *pb.PlaceOrderRequest)
+		total        *pb.Money
+		wantScore    int32
+		wantDecision pb.RiskDecision
+		wantReasons  []string
+	}{
+		{"clean order", nil, nil, usd(50, 0),
+			0, pb.RiskDecision_RISK_DECISION_ALLOW, nil},
+		{"highest value threshold only", nil, nil, usd(1500, 0),
+			50, pb.RiskDecision_RISK_DECISION_REVIEW, []string{riskHighOrderValue}},
+		{"card issued abroad", nil, func(req *pb.PlaceOrderRequest) { req.CreditCard.CreditCardNumber = "5105-1051-0510-5100" }, usd(150, 0),
+			50, pb.RiskDecision_RISK_DECISION_REVIEW, []string{riskCountryMismatch, riskHighOrderValue}},
+		{"billing address abroad", nil, func(req *pb.PlaceOrderRequest) { req.BillingAddress = &pb.Address{Country: "United Kingdom"} }, usd(50, 0),
+			30, pb.RiskDecision_RISK_DECISION_ALLOW, []string{riskCountryMismatch}},
+		{"billing address at home", nil, func(req *pb.PlaceOrderRequest) { req.BillingAddress = &pb.Address{Country: "us"} }, usd(50, 0),
+			0, pb.RiskDecision_RISK_DECISION_ALLOW, nil},
+		{"deny listed email domain", nil, func(req *pb.PlaceOrderRequest) { req.Email = "Someone@Fraud.Example" }, usd(50, 0),
+			0, pb.RiskDecision_RISK_DECISION_DENY, []string{riskDenyListed}},
+		{"deny listed shipping country", nil, func(req *pb.PlaceOrderRequest) { req.Address.Country = "atlantis" }, usd(50, 0),
+			30, pb.RiskDecision_RISK_DECISION_DENY, []string{riskCountryMismatch, riskDenyListed}},
+		{"external score", fakeScorer{score: riskScore{Score: 70, Reasons: []string{"DEVICE_REUSED"}}}, nil, usd(150, 0),
+			90, pb.RiskDecision_RISK_DECISION_DENY, []string{riskHighOrderValue, "DEVICE_REUSED"}},
+		{"external scorer down", fakeScorer{err: errors.New("down")}, nil, usd(150, 0),
+			20, pb.RiskDecision_RISK_DECISION_ALLOW, []string{riskHighOrderValue}},
+		{"score capped", fakeScorer{score: riskScore{Score: 500}}, nil, usd(50, 0),
+			100, pb.RiskDecision_RISK_DECISION_DENY, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := newRiskEngine(testRiskConfig(), tt.scorer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, order := riskRequest("u1", "4432-8015-6152-0454", tt.total)
+			if tt.change != nil {
+				tt.change(req)
+			}
+			got, err := e.assess(context.Background(), e.newRiskInput(req, order), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.GetScore() != tt.wantScore || got.GetDecision() != tt.wantDecision || !reflect.DeepEqual(got.GetReasons(), tt.wantReasons) {
+				t.Errorf("assess() = %d %v %v, want %d %v %v", got.GetScore(), got.GetDecision(), got.GetReasons(),
+					tt.wantScore, tt.wantDecision, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestRiskVelocity(t *testing.T) {
+	e, err := newRiskEngine(testRiskConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.velocity.now = func() time.Time { return now }
+	assess := func(userID, card string) *pb.RiskAssessment {
+		t.Helper()
+		req, order := riskRequest(userID, card, usd(10, 0))
+		r, err := e.assess(context.Background(), e.newRiskInput(req, order), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// Same card, different users: the fourth attempt within a minute trips
+	// the card velocity check.
+	for i, u := range []string{"a", "b", "c"} {
+		if r := assess(u, "4111111111111111"); len(r.GetReasons()) != 0 {
+			t.Fatalf("attempt %d flagged: %v", i+1, r.GetReasons())
+		}
+	}
+	if r := assess("d", "4111 1111 1111 1112"); !reflect.DeepEqual(r.GetReasons(), []string{"VELOCITY_CARD_BIN"}) {
+		t.Errorf("fourth attempt with the same BIN: reasons = %v", r.GetReasons())
+	}
+	now = now.Add(time.Minute)
+	if r := assess("e", "4111111111111111"); len(r.GetReasons()) != 0 {
+		t.Errorf("attempt after the window: reasons = %v", r.GetReasons())
+	}
+
+	// A user's third order within the hour trips the user check.
+	if r := assess("a", "5555555555554444"); len(r.GetReasons()) != 0 {
+		t.Errorf("second order of a: reasons = %v", r.GetReasons())
+	}
+	now = now.Add(30 * time.Minute)
+	if r := assess("a", "5555555555554444"); !reflect.DeepEqual(r.GetReasons(), []string{"VELOCITY_USER_ID"}) || r.GetScore() != 40 {
+		t.Errorf("third order of a: %d %v", r.GetScore(), r.GetReasons())
+	}
+	now = now.Add(31 * time.Minute)
+	if r := assess("a", "5555555555554444"); len(r.GetReasons()) != 0 {
+		t.Errorf("order of a after the first ones expired: reasons = %v", r.GetReasons())
+	}
+}
+
+func TestRiskConfigValidation(t *testing.T) {
+	for name, change := range map[string]func(c *riskConfig){
+		"review above deny": func(c *riskConfig) { c.ReviewScore = 90 },
+		"deny above 100":    func(c *riskConfig) { c.DenyScore = 101 },
+		"unknown key":       func(c *riskConfig) { c.Velocity[0].Key = "ip" },
+		"bad window":        func(c *riskConfig) { c.Velocity[0].Window = "soon" },
+		"no max orders":     func(c *riskConfig) { c.Velocity[0].MaxOrders = 0 },
+		"threshold no min":  func(c *riskConfig) { c.ValueThresholds[0].Min = nil },
+	} {
+		c := testRiskConfig()
+		change(&c)
+		if _, err := newRiskEngine(c, nil); err == nil {
+			t.Errorf("%s: newRiskEngine() succeeded, want an error", name)
+		}
+	}
+	if _, err := newRiskEngine(defaultRiskConfig(), nil); err != nil {
+		t.Errorf("default config: %v", err)
+	}
+}
+
+func TestHTTPRiskScorer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in riskInput
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if in.CardBIN == "400000" {
+			http.Error(w, "no", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(riskScore{Score: int(in.Items) * 10, Reasons: []string{"MANY_ITEMS"}})
+	}))
+	defer srv.Close()
+
+	s := newHTTPRiskScorer(srv.URL)
+	got, err := s.Score(context.Background(), riskInput{Items: 3, CardBIN: "443280"})
+	if err != nil || got.Score != 30 || !reflect.DeepEqual(got.Reasons, []string{"MANY_ITEMS"}) {
+		t.Errorf("Score() = %+v, %v; want 30 MANY_ITEMS", got, err)
+	}
+	if _, err := s.Score(context.Background(), riskInput{CardBIN: "400000"}); err == nil {
+		t.Error("Score() succeeded on a server error")
+	}
+}
+
+func TestPlaceOrderDeniedByRisk(t *testing.T) {
+	deps := newFakeDeps()
+	cs := newTestCheckout(t, deps)
+	risk, err := newRiskEngine(testRiskConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.risk = risk
+	ctx := context.Background()
+
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	req := testOrderRequest("u1")
+	req.Email = "mallory@fraud.example"
+	_, err = cs.PlaceOrder(ctx, req)
+	st := status.Convert(err)
+	if st.Code() != codes.PermissionDenied {
+		t.Fatalf("PlaceOrder() error = %v, want code %v", err, codes.PermissionDenied)
+	}
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		if i, ok := d.(*errdetails.ErrorInfo); ok {
+			info = i
+		}
+	}
+	if info.GetReason() != riskDenyListed || info.GetDomain() != errorDomain {
+		t.Errorf("error details = %v, want ErrorInfo with reason %s", st.Details(), riskDenyListed)
+	}
+	if len(deps.charges) != 0 || len(deps.reservations) != 0 {
+		t.Error("a denied order was charged or reserved stock")
+	}
+	orders, _, err := cs.orders.List(ctx, "u1", 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].GetStatus() != pb.OrderStatus_ORDER_STATUS_CANCELLED ||
+		orders[0].GetRisk().GetDecision() != pb.RiskDecision_RISK_DECISION_DENY {
+		t.Errorf("orders = %v, want one cancelled order with the denial", orders)
+	}
+
+	// An order flagged for review is placed, with its assessment.
+	deps.carts["u2"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}
+	req = testOrderRequest("u2")
+	req.BillingAddress = &pb.Address{Country: "United Kingdom"}
+	cs.risk.cfg.CountryMismatchScore = 60
+	resp, err := cs.PlaceOrder(ctx, req)
+	if err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	if r := resp.GetOrder().GetRisk(); r.GetDecision() != pb.RiskDecision_RISK_DECISION_REVIEW || r.GetScore() != 60 {
+		t.Errorf("risk = %v, want review with score 60", r)
+	}
+}
@@ -0,0 +1,395 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// compensationTimeout bounds how long undoing a single saga step may take.
+	// Compensations run detached from the request context so that a client
+	// hanging up does not leave a customer charged.
+	compensationTimeout = 10 * time.Second
+
+	// sagaLogCompactMin is how many events the saga log file holds before
+	// it is compacted while running.
+	sagaLogCompactMin = 1000
+)
+
+type sagaEventType string
+
+const (
+	sagaStarted            sagaEventType = "saga_started"
+	sagaStepStarted        sagaEventType = "step_started"
+	sagaStepCompleted      sagaEventType = "step_completed"
+	sagaStepCompensated    sagaEventType = "step_compensated"
+	sagaCompensationFailed sagaEventType = "step_compensation_failed"
+	sagaCompleted          sagaEventType = "saga_completed"
+	sagaAborted            sagaEventType = "saga_aborted"
+)
+
+// sagaEvent is a single entry in the saga log. Data carries whatever the
+// step's compensator needs to undo it, e.g. the payment transaction id.
+type sagaEvent struct {
+	SagaID string          `json:"saga_id"`
+	Type   sagaEventType   `json:"type"`
+	Step   string          `json:"step,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Reason string          `json:"reason,omitempty"`
+	Time   time.Time       `json:"time"`
+}
+
+func (e sagaEvent) ends() bool { return e.Type == sagaCompleted || e.Type == sagaAborted }
+
+// sagaLog durably records saga progress so that an order interrupted by a
+// crash can be compensated or finished when checkout restarts.
+type sagaLog interface {
+	// Append records e. It must not return before e is durable.
+	Append(e sagaEvent) error
+	// Pending returns the events of every saga that has neither completed
+	// nor been aborted, grouped by saga and in the order they were appended.
+	Pending() ([][]sagaEvent, error)
+}
+
+// memorySagaLog keeps saga progress in memory only. It is used in tests and
+// when no SAGA_LOG_PATH is configured.
+type memorySagaLog struct {
+	mu     sync.Mutex
+	events map[string][]sagaEvent
+	order  []string
+}
+
+func newMemorySagaLog() *memorySagaLog {
+	return &memorySagaLog{events: make(map[string][]sagaEvent)}
+}
+
+func (l *memorySagaLog) Append(e sagaEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.add(e)
+	return nil
+}
+
+func (l *memorySagaLog) add(e sagaEvent) {
+	if e.ends() {
+		delete(l.events, e.SagaID)
+		return
+	}
+	if _, ok := l.events[e.SagaID]; !ok {
+		l.order = append(l.order, e.SagaID)
+	}
+	l.events[e.SagaID] = append(l.events[e.SagaID], e)
+}
+
+// live returns the events of every pending saga, grouped by saga.
+func (l *memorySagaLog) live() []sagaEvent {
+	pending, _ := l.Pending()
+	var out []sagaEvent
+	for _, evs := range pending {
+		out = append(out, evs...)
+	}
+	return out
+}
+
+func (l *memorySagaLog) Pending() ([][]sagaEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out [][]sagaEvent
+	live := l.order[:0]
+	for _, id := range l.order {
+		if evs, ok := l.events[id]; ok {
+			live = append(live, id)
+			out = append(out, append([]sagaEvent(nil), evs...))
+		}
+	}
+	l.order = live
+	return out, nil
+}
+
+// fileSagaLog appends saga events to a journal file. Sagas that have ended
+// are dropped from the file when it is reopened, and whenever a saga ends
+// once the file holds at least compactMin events, more than half of them
+// from ended sagas. The file so grows with the number of orders in flight,
+// not with every order placed.
+type fileSagaLog struct {
+	// mu keeps appends out of the file while it is compacted.
+	mu         sync.Mutex
+	mem        *memorySagaLog
+	j          *journal[sagaEvent]
+	written    int
+	compactMin int
+}
+
+func newFileSagaLog(path string) (*fileSagaLog, error) {
+	l := &fileSagaLog{mem: newMemorySagaLog(), compactMin: sagaLogCompactMin}
+	j, err := openJournal(path, l.mem.add)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open saga log: %w", err)
+	}
+	live := l.mem.live()
+	if err := j.Rewrite(live); err != nil {
+		return nil, fmt.Errorf("failed to compact saga log: %w", err)
+	}
+	l.j = j
+	l.written = len(live)
+	return l, nil
+}
+
+func (l *fileSagaLog) Append(e sagaEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.j.Append(e); err != nil {
+		return err
+	}
+	l.written++
+	if err := l.mem.Append(e); err != nil {
+		return err
+	}
+	if e.ends() && l.written >= l.compactMin {
+		l.compact()
+	}
+	return nil
+}
+
+// compact rewrites the file with only the pending sagas if most of its
+// events are from sagas that have ended. The file is left as it was if that
+// fails, so a failure is only logged.
+func (l *fileSagaLog) compact() {
+	live := l.mem.live()
+	if 2*len(live) > l.written {
+		return
+	}
+	if err := l.j.Rewrite(live); err != nil {
+		log.Warnf("failed to compact saga log: %v", err)
+		return
+	}
+	l.written = len(live)
+}
+
+func (l *fileSagaLog) Pending() ([][]sagaEvent, error) { return l.mem.Pending() }
+
+// Close closes the log file.
+func (l *fileSagaLog) Close() error { return l.j.Close() }
+
+// compensator undoes a completed saga step given the data the step recorded
+// and the reason the saga is being aborted.
+type compensator func(ctx context.Context, data json.RawMessage, reason string) error
+
+// saga runs a sequence of steps and, if one of them fails, undoes the ones
+// that already completed in reverse order. Every transition is written to the
+// saga log before it is acted upon. CancelOrder may abort a saga while one
+// of its steps runs; that step is then left for the next abort.
+type saga struct {
+	id    string
+	log   sagaLog
+	comps map[string]compensator
+
+	mu   sync.Mutex
+	done []sagaEvent
+}
+
+func newSaga(id string, l sagaLog, comps map[string]compensator) (*saga, error) {
+	s := &saga{id: id, log: l, comps: comps}
+	if err := s.append(sagaEvent{Type: sagaStarted}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *saga) append(e sagaEvent) error {
+	e.SagaID = s.id
+	e.Time = time.Now().UTC()
+	if err := s.log.Append(e); err != nil {
+		return fmt.Errorf("saga %s: %w", s.id, err)
+	}
+	return nil
+}
+
+// step runs fn as the named step. The value fn returns is recorded with the
+// step so that its compensator can undo it, even after a restart.
+func (s *saga) step(ctx context.Context, name string, fn func(ctx context.Context) (interface{}, error)) error {
+	return s.stepWithIntent(ctx, name, nil, fn)
+}
+
+// stepWithIntent is step for a step whose effect can be looked up by what
+// it is about to do, e.g. a charge by its idempotency key. intent is
+// recorded when the step starts; if the process dies before the step
+// completes, recovery compensates the step with intent instead of its
+// result, so its compensator must cope with the step not having happened.
+func (s *saga) stepWithIntent(ctx context.Context, name string, intent interface{}, fn func(ctx context.Context) (interface{}, error)) error {
+	started := sagaEvent{Type: sagaStepStarted, Step: name}
+	if intent != nil {
+		data, err := json.Marshal(intent)
+		if err != nil {
+			return fmt.Errorf("saga %s: failed to encode %s intent: %w", s.id, name, err)
+		}
+		started.Data = data
+	}
+	if err := s.append(started); err != nil {
+		return err
+	}
+	v, err := fn(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("saga %s: failed to encode %s result: %w", s.id, name, err)
+	}
+	done := sagaEvent{SagaID: s.id, Type: sagaStepCompleted, Step: name, Data: data}
+	// Remember the step even if it cannot be logged, so the caller's abort
+	// still compensates it.
+	s.mu.Lock()
+	s.done = append(s.done, done)
+	s.mu.Unlock()
+	return s.append(done)
+}
+
+// complete marks the saga as finished; nothing will be compensated.
+func (s *saga) complete() error {
+	return s.append(sagaEvent{Type: sagaCompleted})
+}
+
+// abort compensates all completed steps, most recent first, and records
+// reason as the cause. It reports the first compensation that failed; those
+// are left in the log for an operator to resolve.
+func (s *saga) abort(ctx context.Context, reason string) error {
+	ctx = context.WithoutCancel(ctx)
+	s.mu.Lock()
+	done := s.done
+	s.done = nil
+	s.mu.Unlock()
+	var firstErr error
+	for i := len(done) - 1; i >= 0; i-- {
+		st := done[i]
+		comp, ok := s.comps[st.Step]
+		if !ok {
+			continue
+		}
+		cctx, cancel := context.WithTimeout(ctx, compensationTimeout)
+		err := comp(cctx, st.Data, reason)
+		cancel()
+		if err != nil {
+			log.Errorf("saga %s: failed to compensate %s: %+v", s.id, st.Step, err)
+			if lerr := s.append(sagaEvent{Type: sagaCompensationFailed, Step: st.Step, Data: st.Data, Reason: err.Error()}); lerr != nil {
+				log.Error(lerr)
+			}
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to compensate %s: %w", st.Step, err)
+			}
+			continue
+		}
+		log.Infof("saga %s: compensated %s (reason: %s)", s.id, st.Step, reason)
+		if lerr := s.append(sagaEvent{Type: sagaStepCompensated, Step: st.Step, Reason: reason}); lerr != nil {
+			log.Error(lerr)
+		}
+	}
+	if firstErr != nil {
+		// Leave the saga pending so that the next restart retries the
+		// compensations that failed.
+		return firstErr
+	}
+	return s.append(sagaEvent{Type: sagaAborted, Reason: reason})
+}
+
+// resumeSaga rebuilds a saga from its logged events. Steps that were already
+// compensated are not compensated again. A step that started with an intent
+// but did not complete is kept with its intent, so that it is compensated
+// too.
+func resumeSaga(events []sagaEvent, l sagaLog, comps map[string]compensator) *saga {
+	s := &saga{id: events[0].SagaID, log: l, comps: comps}
+	compensated := make(map[string]bool)
+	for _, e := range events {
+		if e.Type == sagaStepCompensated {
+			compensated[e.Step] = true
+		}
+	}
+	var interrupted *sagaEvent
+	for _, e := range events {
+		switch {
+		case compensated[e.Step]:
+		case e.Type == sagaStepStarted && len(e.Data) > 0:
+			interrupted = &e
+		case e.Type == sagaStepCompleted:
+			interrupted = nil
+			s.done = append(s.done, e)
+		}
+	}
+	if interrupted != nil {
+		s.done = append(s.done, *interrupted)
+	}
+	return s
+}
+
+// has reports whether the named step completed.
+func (s *saga) has(step string) bool {
+	_, ok := s.data(step)
+	return ok
+}
+
+// data returns what the named step recorded, if it completed.
+func (s *saga) data(step string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.done {
+		if e.Step == step && e.Type == sagaStepCompleted {
+			return e.Data, true
+		}
+	}
+	return nil, false
+}
+
+// recoverSagas settles every saga left pending by a previous process. Sagas
+// that got past pivot (the step after which an order can no longer be
+// undone) are finished, if finish is not nil, and marked completed; all
+// others are compensated.
+func recoverSagas(ctx context.Context, l sagaLog, comps map[string]compensator, pivot string, finish func(context.Context, *saga)) error {
+	pending, err := l.Pending()
+	if err != nil {
+		return fmt.Errorf("failed to read saga log: %w", err)
+	}
+	for _, evs := range pending {
+		s := resumeSaga(evs, l, comps)
+		for _, e := range evs {
+			switch {
+			case e.Type != sagaStepStarted || s.has(e.Step):
+			case len(e.Data) > 0:
+				log.Warnf("saga %s: step %s was interrupted, undoing it in case it took effect", s.id, e.Step)
+			default:
+				log.Warnf("saga %s: step %s was interrupted, its outcome is unknown", s.id, e.Step)
+			}
+		}
+		if s.has(pivot) {
+			log.Infof("saga %s: resuming, %s completed before restart", s.id, pivot)
+			if finish != nil {
+				finish(ctx, s)
+			}
+			if err := s.complete(); err != nil {
+				return err
+			}
+			continue
+		}
+		log.Infof("saga %s: compensating order interrupted by restart", s.id)
+		if err := s.abort(ctx, "checkout restarted before the order completed"); err != nil {
+			log.Errorf("saga %s: %+v", s.id, err)
+		}
+	}
+	return nil
+}
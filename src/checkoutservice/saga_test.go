@@ -0,0 +1,190 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type undoRecorder struct {
+	undone  []string
+	reasons []string
+}
+
+func (u *undoRecorder) comp(name string) compensator {
+	return func(_ context.Context, data json.RawMessage, reason string) error {
+		var v string
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		u.undone = append(u.undone, name+":"+v)
+		u.reasons = append(u.reasons, reason)
+		return nil
+	}
+}
+
+func value(v string) func(context.Context) (interface{}, error) {
+	return func(context.Context) (interface{}, error) { return v, nil }
+}
+
+func TestSagaAbortCompensatesInReverse(t *testing.T) {
+	u := &undoRecorder{}
+	comps := map[string]compensator{"a": u.comp("a"), "b": u.comp("b")}
+	s, err := newSaga("s1", newMemorySagaLog(), comps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := s.step(ctx, "a", value("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.step(ctx, "b", value("2")); err != nil {
+		t.Fatal(err)
+	}
+	failed := s.step(ctx, "c", func(context.Context) (interface{}, error) { return nil, errors.New("boom") })
+	if failed == nil {
+		t.Fatal("step c succeeded, want error")
+	}
+	if err := s.abort(ctx, "c failed"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b:2", "a:1"}; !reflect.DeepEqual(u.undone, want) {
+		t.Errorf("compensated %v, want %v", u.undone, want)
+	}
+	if u.reasons[0] != "c failed" {
+		t.Errorf("reason = %q, want %q", u.reasons[0], "c failed")
+	}
+}
+
+func TestRecoverSagasUndoesInterruptedStepWithIntent(t *testing.T) {
+	l := newMemorySagaLog()
+	ctx := context.Background()
+	crash := func(context.Context) (interface{}, error) { return nil, errors.New("crashed") }
+
+	// Both sagas died while their last step ran: "charging" logged what it
+	// was about to charge, "reserving" did not.
+	charging, _ := newSaga("charging", l, nil)
+	charging.step(ctx, "reserve", value("r1"))
+	charging.stepWithIntent(ctx, "charge", "o1", crash)
+	reserving, _ := newSaga("reserving", l, nil)
+	reserving.step(ctx, "charge", value("tx-1"))
+	reserving.step(ctx, "reserve", crash)
+
+	u := &undoRecorder{}
+	comps := map[string]compensator{"charge": u.comp("charge"), "reserve": u.comp("reserve")}
+	if err := recoverSagas(ctx, l, comps, "ship", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"charge:o1", "reserve:r1", "charge:tx-1"}; !reflect.DeepEqual(u.undone, want) {
+		t.Errorf("compensated %v, want %v", u.undone, want)
+	}
+	if pending, _ := l.Pending(); len(pending) != 0 {
+		t.Errorf("found %d pending sagas after recovery, want 0", len(pending))
+	}
+}
+
+func TestFileSagaLogCompactsWhileRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saga.log")
+	l, err := newFileSagaLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.compactMin = 10
+	ctx := context.Background()
+
+	pending, _ := newSaga("pending", l, nil)
+	pending.step(ctx, "charge", value("tx-pending"))
+	for i := 0; i < 20; i++ {
+		s, _ := newSaga(fmt.Sprintf("done-%d", i), l, nil)
+		s.step(ctx, "charge", value("tx"))
+		s.complete()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines >= l.compactMin {
+		t.Errorf("saga log has %d events after 20 sagas ended, want fewer than %d", lines, l.compactMin)
+	}
+	l.Close()
+	if l, err = newFileSagaLog(path); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := l.Pending(); len(pending) != 1 || pending[0][0].SagaID != "pending" {
+		t.Errorf("pending sagas after compaction = %v, want only %q", pending, "pending")
+	}
+}
+
+func TestFileSagaLogRecoversAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saga.log")
+	l, err := newFileSagaLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// "done" finishes; "charged" crashes after its first step; "shipped"
+	// crashes after passing the pivot.
+	done, _ := newSaga("done", l, nil)
+	done.step(ctx, "charge", value("tx-done"))
+	done.complete()
+	charged, _ := newSaga("charged", l, nil)
+	charged.step(ctx, "charge", value("tx-charged"))
+	shipped, _ := newSaga("shipped", l, nil)
+	shipped.step(ctx, "charge", value("tx-shipped"))
+	shipped.step(ctx, "ship", value("track"))
+
+	// Simulate a restart by reopening the log.
+	l.Close()
+	l, err = newFileSagaLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := l.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("found %d pending sagas, want 2", len(pending))
+	}
+
+	u := &undoRecorder{}
+	if err := recoverSagas(ctx, l, map[string]compensator{"charge": u.comp("charge")}, "ship", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"charge:tx-charged"}; !reflect.DeepEqual(u.undone, want) {
+		t.Errorf("compensated %v, want %v", u.undone, want)
+	}
+
+	l.Close()
+	l, err = newFileSagaLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if pending, _ := l.Pending(); len(pending) != 0 {
+		t.Errorf("found %d pending sagas after recovery, want 0", len(pending))
+	}
+}
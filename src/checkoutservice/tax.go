@@ -0,0 +1,303 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
+)
+
+// taxRule is a tax in the tax rules file. Every rule matching the shipping
+// address applies, so a state tax and a county tax on top of it are two
+// rules.
+type taxRule struct {
+	Name string `json:"name"`
+	// Rate is a percentage, such as "7.25".
+	Rate string `json:"rate"`
+	// Inclusive taxes, such as VAT, are already part of the prices and are
+	// only broken out; the others are added on top.
+	Inclusive bool `json:"inclusive,omitempty"`
+	// Shipping reports whether shipping is taxed too.
+	Shipping bool `json:"shipping,omitempty"`
+	// ExemptCategories are product categories the tax does not apply to.
+	ExemptCategories []string `json:"exempt_categories,omitempty"`
+
+	// Countries are the names or codes of the countries the rule applies
+	// in. States and Zips narrow it down further; if empty, the rule
+	// applies to the whole country. All are matched case-insensitively.
+	Countries []string   `json:"countries"`
+	States    []string   `json:"states,omitempty"`
+	Zips      []zipRange `json:"zips,omitempty"`
+
+	rate *big.Rat
+}
+
+// zipRange is an inclusive range of zip codes.
+type zipRange struct {
+	From int32 `json:"from"`
+	To   int32 `json:"to"`
+}
+
+func (r *taxRule) validate() error {
+	if r.Name == "" {
+		return errors.New("tax rule without name")
+	}
+	if len(r.Countries) == 0 {
+		return fmt.Errorf("tax rule %s: no countries", r.Name)
+	}
+	rate, ok := new(big.Rat).SetString(strings.TrimSpace(r.Rate))
+	if !ok || rate.Sign() < 0 || rate.Cmp(big.NewRat(100, 1)) > 0 {
+		return fmt.Errorf("tax rule %s: rate must be a percentage between 0 and 100", r.Name)
+	}
+	for _, z := range r.Zips {
+		if z.From > z.To {
+			return fmt.Errorf("tax rule %s: zip range %d-%d is empty", r.Name, z.From, z.To)
+		}
+	}
+	r.Rate = strings.TrimSpace(r.Rate)
+	r.rate = rate
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	s = strings.TrimSpace(s)
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the rule applies to orders shipped to addr.
+func (r *taxRule) matches(addr *pb.Address) bool {
+	if !containsFold(r.Countries, addr.GetCountry()) {
+		return false
+	}
+	if len(r.States) > 0 && !containsFold(r.States, addr.GetState()) {
+		return false
+	}
+	if len(r.Zips) == 0 {
+		return true
+	}
+	for _, z := range r.Zips {
+		if z.From <= addr.GetZipCode() && addr.GetZipCode() <= z.To {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesTo reports whether the tax is charged on l.
+func (r *taxRule) appliesTo(l taxableLine) bool {
+	if l.shipping {
+		return r.Shipping
+	}
+	for _, c := range l.categories {
+		if containsFold(r.ExemptCategories, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// taxRules is the table of tax rules read from a file.
+type taxRules struct {
+	rules []taxRule
+}
+
+// newTaxRules reads the tax rules in path.
+func newTaxRules(path string) (*taxRules, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tax rules file: %w", err)
+	}
+	var file struct {
+		Rules []taxRule `json:"rules"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse tax rules file: %w", err)
+	}
+	for i := range file.Rules {
+		if err := file.Rules[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("loaded %d tax rules from %s", len(file.Rules), path)
+	return &taxRules{rules: file.Rules}, nil
+}
+
+// forAddress returns the rules that apply to orders shipped to addr.
+func (t *taxRules) forAddress(addr *pb.Address) []taxRule {
+	var out []taxRule
+	for _, r := range t.rules {
+		if r.matches(addr) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// taxableLine is an amount taxes are charged on: an order item, all units
+// together, or shipping.
+type taxableLine struct {
+	amount     *pb.Money
+	categories []string
+	shipping   bool
+}
+
+// computeTaxes works out the taxes rules charge on lines, which are all in
+// currency. It returns a tax line, rounded half up to the currency's minor
+// units, for every rule that applies to any of the lines, and the sum of the
+// exclusive ones, which is to be added to the order total.
+func computeTaxes(rules []taxRule, lines []taxableLine, currency string) ([]*pb.OrderTax, *pb.Money, error) {
+	exclusive := &pb.Money{CurrencyCode: currency}
+	taxable := make([]*pb.Money, len(rules))
+	amounts := make([]*pb.Money, len(rules))
+	applied := make([]bool, len(rules))
+	for i := range rules {
+		taxable[i] = &pb.Money{CurrencyCode: currency}
+		amounts[i] = &pb.Money{CurrencyCode: currency}
+	}
+
+	hundred := big.NewRat(100, 1)
+	for _, l := range lines {
+		// The inclusive taxes on a line together make up rate/(100+rate)
+		// of its price; each one takes its share of that.
+		inclusive := new(big.Rat)
+		for _, r := range rules {
+			if r.Inclusive && r.appliesTo(l) {
+				inclusive.Add(inclusive, r.rate)
+			}
+		}
+		for i, r := range rules {
+			if !r.appliesTo(l) {
+				continue
+			}
+			share := new(big.Rat).Quo(r.rate, hundred)
+			if r.Inclusive {
+				share.Quo(r.rate, new(big.Rat).Add(hundred, inclusive))
+			}
+			if !share.Num().IsInt64() || !share.Denom().IsInt64() {
+				return nil, nil, fmt.Errorf("tax rule %s: rate too precise", r.Name)
+			}
+			tax, err := money.Fraction(l.amount, share.Num().Int64(), share.Denom().Int64())
+			if err != nil {
+				return nil, nil, err
+			}
+			if taxable[i], err = money.Sum(taxable[i], l.amount); err != nil {
+				return nil, nil, err
+			}
+			if amounts[i], err = money.Sum(amounts[i], tax); err != nil {
+				return nil, nil, err
+			}
+			applied[i] = true
+		}
+	}
+
+	var out []*pb.OrderTax
+	for i, r := range rules {
+		if !applied[i] {
+			continue
+		}
+		amount, err := money.Round(amounts[i], money.RoundHalfUp)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = append(out, &pb.OrderTax{
+			Name:      r.Name,
+			Rate:      r.Rate,
+			Inclusive: r.Inclusive,
+			Taxable:   taxable[i],
+			Amount:    amount,
+		})
+		if !r.Inclusive {
+			if exclusive, err = money.Sum(exclusive, amount); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return out, exclusive, nil
+}
+
+// toNanos returns m in billionths of a unit.
+func toNanos(m *pb.Money) (int64, error) {
+	const nanosPerUnit = 1000000000
+	if m.GetUnits() >= math.MaxInt64/nanosPerUnit || m.GetUnits() <= math.MinInt64/nanosPerUnit {
+		return 0, money.ErrInvalidValue
+	}
+	return m.GetUnits()*nanosPerUnit + int64(m.GetNanos()), nil
+}
+
+// orderTaxes works out the taxes of an order shipped to addr. Discounts
+// lower the taxable amount of every item in proportion to its price, to the
+// nano, so that the items still add up to the discounted subtotal. It
+// returns the tax lines and the exclusive taxes to add to the total, in the
+// order's currency.
+func (cs *checkoutService) orderTaxes(addr *pb.Address, prep orderPrep, promotions []appliedPromotion) ([]*pb.OrderTax, *pb.Money, error) {
+	currency := prep.shippingCostLocalized.GetCurrencyCode()
+	if cs.taxes == nil {
+		return nil, &pb.Money{CurrencyCode: currency}, nil
+	}
+	rules := cs.taxes.forAddress(addr)
+	if len(rules) == 0 {
+		return nil, &pb.Money{CurrencyCode: currency}, nil
+	}
+
+	var err error
+	lines := make([]taxableLine, 0, len(prep.orderItems)+1)
+	subtotal := &pb.Money{CurrencyCode: currency}
+	for _, it := range prep.orderItems {
+		line, err := money.Multiply(it.GetCost(), int64(it.GetItem().GetQuantity()))
+		if err != nil {
+			return nil, nil, err
+		}
+		if subtotal, err = money.Sum(subtotal, line); err != nil {
+			return nil, nil, err
+		}
+		lines = append(lines, taxableLine{amount: line, categories: prep.categories[it.GetItem().GetProductId()]})
+	}
+	net := subtotal
+	for _, p := range promotions {
+		if net, err = money.Sum(net, money.Negate(p.amount)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(lines) > 0 && !money.AreEquals(net, subtotal) {
+		ratios := make([]int64, len(lines))
+		for i, l := range lines {
+			if ratios[i], err = toNanos(l.amount); err != nil {
+				return nil, nil, err
+			}
+		}
+		shares, err := money.Allocate(net, ratios...)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range lines {
+			lines[i].amount = shares[i]
+		}
+	}
+	lines = append(lines, taxableLine{amount: prep.shippingCostLocalized, shipping: true})
+	return computeTaxes(rules, lines, currency)
+}
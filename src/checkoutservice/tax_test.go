@@ -0,0 +1,203 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+const testTaxRules = `{"rules": [
+	{"name": "California sales tax", "rate": "7.25", "countries": ["US", "United States"], "states": ["CA", "California"],
+	 "exempt_categories": ["kitchen"]},
+	{"name": "Santa Clara County tax", "rate": "2", "countries": ["US", "United States"], "states": ["CA"],
+	 "zips": [{"from": 94022, "to": 94089}, {"from": 95002, "to": 95196}], "shipping": true},
+	{"name": "VAT", "rate": "19", "inclusive": true, "shipping": true, "countries": ["DE", "Germany"],
+	 "exempt_categories": ["books"]},
+	{"name": "Reduced VAT", "rate": "7", "inclusive": true, "countries": ["DE", "Germany"],
+	 "exempt_categories": ["accessories"]}
+]}`
+
+func loadTestTaxRules(t *testing.T) *taxRules {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tax.json")
+	if err := os.WriteFile(path, []byte(testTaxRules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newTaxRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestTaxRulesForAddress(t *testing.T) {
+	rules := loadTestTaxRules(t)
+	tests := []struct {
+		addr *pb.Address
+		want []string
+	}{
+		{&pb.Address{Country: "United States", State: "CA", ZipCode: 94043}, []string{"California sales tax", "Santa Clara County tax"}},
+		{&pb.Address{Country: "us", State: "california", ZipCode: 90210}, []string{"California sales tax"}},
+		{&pb.Address{Country: "United States", State: "NY", ZipCode: 10001}, nil},
+		{&pb.Address{Country: " Germany ", ZipCode: 10115}, []string{"VAT", "Reduced VAT"}},
+	}
+	for _, tt := range tests {
+		got := rules.forAddress(tt.addr)
+		var names []string
+		for _, r := range got {
+			names = append(names, r.Name)
+		}
+		if len(names) != len(tt.want) {
+			t.Errorf("forAddress(%v) = %v, want %v", tt.addr, names, tt.want)
+			continue
+		}
+		for i := range names {
+			if names[i] != tt.want[i] {
+				t.Errorf("forAddress(%v) = %v, want %v", tt.addr, names, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestTaxRulesRejectsInvalidRules(t *testing.T) {
+	for _, content := range []string{
+		`{"rules": [{"name": "x", "rate": "5"}]}`,
+		`{"rules": [{"name": "x", "rate": "abc", "countries": ["US"]}]}`,
+		`{"rules": [{"name": "x", "rate": "-1", "countries": ["US"]}]}`,
+		`{"rules": [{"name": "x", "rate": "5", "countries": ["US"], "zips": [{"from": 2, "to": 1}]}]}`,
+	} {
+		path := filepath.Join(t.TempDir(), "tax.json")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newTaxRules(path); err == nil {
+			t.Errorf("newTaxRules(%s) succeeded, want an error", content)
+		}
+	}
+}
+
+func TestComputeTaxes(t *testing.T) {
+	rules := loadTestTaxRules(t)
+	type line struct {
+		name   string
+		amount *pb.Money
+	}
+	tests := []struct {
+		name          string
+		addr          *pb.Address
+		lines         []taxableLine
+		want          []line
+		wantExclusive *pb.Money
+	}{
+		{
+			name: "exclusive state and county taxes",
+			addr: &pb.Address{Country: "US", State: "CA", ZipCode: 94043},
+			lines: []taxableLine{
+				{amount: usd(39, 980000000), categories: []string{"accessories"}},
+				{amount: usd(8, 990000000), categories: []string{"kitchen"}},
+				{amount: usd(8, 990000000), shipping: true},
+			},
+			// 7.25% of 39.98 and 2% of 57.96.
+			want:          []line{{"California sales tax", usd(2, 900000000)}, {"Santa Clara County tax", usd(1, 160000000)}},
+			wantExclusive: usd(4, 60000000),
+		},
+		{
+			name: "inclusive VAT at two rates",
+			addr: &pb.Address{Country: "DE"},
+			lines: []taxableLine{
+				{amount: usd(39, 980000000), categories: []string{"accessories"}},
+				{amount: usd(10, 700000000), categories: []string{"kitchen"}},
+				{amount: usd(5, 0), categories: []string{"books"}},
+				{amount: usd(5, 0), shipping: true},
+			},
+			// Where both rates apply, each takes its share of 26/126 of
+			// the price: VAT is 19/119 of 39.98 and 5 plus 19/126 of
+			// 10.70, reduced VAT 7/126 of 10.70 plus 7/107 of 5.
+			want:          []line{{"VAT", usd(8, 800000000)}, {"Reduced VAT", usd(0, 920000000)}},
+			wantExclusive: usd(0, 0),
+		},
+		{
+			name:          "exempt items only",
+			addr:          &pb.Address{Country: "US", State: "CA", ZipCode: 90210},
+			lines:         []taxableLine{{amount: usd(8, 990000000), categories: []string{"Kitchen"}}},
+			wantExclusive: usd(0, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, exclusive, err := computeTaxes(rules.forAddress(tt.addr), tt.lines, "USD")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("computeTaxes() = %v, want %d lines", got, len(tt.want))
+			}
+			for i, w := range tt.want {
+				if got[i].GetName() != w.name || got[i].GetAmount().GetUnits() != w.amount.GetUnits() || got[i].GetAmount().GetNanos() != w.amount.GetNanos() {
+					t.Errorf("tax line %d = %s %v, want %s %v", i, got[i].GetName(), got[i].GetAmount(), w.name, w.amount)
+				}
+			}
+			if exclusive.GetUnits() != tt.wantExclusive.GetUnits() || exclusive.GetNanos() != tt.wantExclusive.GetNanos() {
+				t.Errorf("exclusive taxes = %v, want %v", &exclusive, tt.wantExclusive)
+			}
+		})
+	}
+}
+
+func TestPlaceOrderAddsTax(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "promotions.json")
+	if err := os.WriteFile(path, []byte(`{"promotions": [
+		{"id": "sale", "description": "Half off", "type": "percent_off", "percent": 50}
+	]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	promotions, err := newPromotionRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	cs := newTestCheckout(t, deps)
+	cs.taxes = loadTestTaxRules(t)
+	cs.promotions = promotions
+
+	resp, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1"))
+	if err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	// Half of 39.98 is taxed: 1.45 state tax, and county tax on that plus
+	// 8.99 shipping comes to 0.58.
+	taxes := resp.GetOrder().GetTaxes()
+	if len(taxes) != 2 {
+		t.Fatalf("taxes = %v, want state and county tax", taxes)
+	}
+	if got := taxes[0].GetTaxable(); got.GetUnits() != 19 || got.GetNanos() != 990000000 {
+		t.Errorf("state taxable amount = %v, want 19.99", got)
+	}
+	// 19.99 + 8.99 + 1.45 + 0.58
+	total := resp.GetOrder().GetTotal()
+	if total.GetUnits() != 31 || total.GetNanos() != 10000000 {
+		t.Errorf("total = %d.%09d, want 31.010000000", total.GetUnits(), total.GetNanos())
+	}
+	if got := deps.charges[0].GetAmount(); got.GetUnits() != total.GetUnits() || got.GetNanos() != total.GetNanos() {
+		t.Errorf("charged %v, want the total %v", got, total)
+	}
+}
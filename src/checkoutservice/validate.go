@@ -0,0 +1,268 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	money "github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
+)
+
+// reasonInvalidRequest is the reason of requests failing validation.
+const reasonInvalidRequest = "INVALID_REQUEST"
+
+// countryNames maps the names customers commonly enter for countries, in
+// lower case, to their ISO 3166 codes.
+var countryNames = map[string]string{
+	"united states": "US", "usa": "US", "united kingdom": "GB", "uk": "GB",
+	"germany": "DE", "france": "FR", "canada": "CA", "japan": "JP",
+	"india": "IN", "australia": "AU",
+}
+
+// countryCode returns the ISO 3166 code of a country name or code.
+func countryCode(country string) string {
+	country = strings.TrimSpace(country)
+	if c, ok := countryNames[strings.ToLower(country)]; ok {
+		return c
+	}
+	return strings.ToUpper(country)
+}
+
+// addressRule is what an address in a country needs besides a street, city
+// and country.
+type addressRule struct {
+	state bool
+	// Zip codes are numeric, so only countries with numeric postal codes
+	// have a range; others accept any.
+	minZip, maxZip int32
+}
+
+var addressRules = map[string]addressRule{
+	"US": {state: true, minZip: 501, maxZip: 99950},
+	"CA": {state: true},
+	"AU": {state: true, minZip: 200, maxZip: 9999},
+	"IN": {state: true, minZip: 110000, maxZip: 999999},
+	"DE": {minZip: 1067, maxZip: 99998},
+	"FR": {minZip: 1000, maxZip: 98999},
+	"JP": {minZip: 1, maxZip: 9999999},
+}
+
+// fieldViolations collects what is wrong with the fields of a request.
+type fieldViolations []*errdetails.BadRequest_FieldViolation
+
+func (v *fieldViolations) add(field, format string, args ...interface{}) {
+	*v = append(*v, &errdetails.BadRequest_FieldViolation{Field: field, Description: fmt.Sprintf(format, args...)})
+}
+
+// err returns an INVALID_ARGUMENT status listing the violations in a
+// BadRequest, or nil if there are none.
+func (v fieldViolations) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(v))
+	for i, fv := range v {
+		msgs[i] = fv.GetField() + ": " + fv.GetDescription()
+	}
+	return detailedError(codes.InvalidArgument, reasonInvalidRequest, nil,
+		"invalid request: "+strings.Join(msgs, "; "), &errdetails.BadRequest{FieldViolations: v})
+}
+
+// validatePlaceOrderRequest checks that req is complete and well formed
+// before anything is priced or charged. The user's cart is checked by
+// validateCart once it is read.
+func (cs *checkoutService) validatePlaceOrderRequest(ctx context.Context, req *pb.PlaceOrderRequest) error {
+	var v fieldViolations
+	if strings.TrimSpace(req.GetUserId()) == "" {
+		v.add("user_id", "is required")
+	}
+	validateEmail(&v, "email", req.GetEmail())
+	validateAddress(&v, "address", req.GetAddress())
+	// The billing address is only used in fraud checks, which need no
+	// more than its country.
+	if b := req.GetBillingAddress(); b != nil && strings.TrimSpace(b.GetCountry()) == "" {
+		v.add("billing_address.country", "is required")
+	}
+	// Gift cards and store credit may cover the whole order; if they do not,
+	// paying fails for want of a card.
+	if req.GetCreditCard() != nil || len(req.GetPaymentInstruments()) == 0 {
+		validateCard(&v, "credit_card", req.GetCreditCard(), time.Now())
+	}
+	validateInstruments(&v, "payment_instruments", req.GetPaymentInstruments(), req.GetUserCurrency())
+	if req.GetRedeemPoints() < 0 {
+		v.add("redeem_points", "must not be negative")
+	}
+	cs.validateCurrency(ctx, &v, "user_currency", req.GetUserCurrency())
+	return v.err()
+}
+
+func validateEmail(v *fieldViolations, field, email string) {
+	if email == "" {
+		v.add(field, "is required")
+		return
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@")+1:], ".") {
+		v.add(field, "is not a valid email address")
+	}
+}
+
+func validateAddress(v *fieldViolations, field string, addr *pb.Address) {
+	if addr == nil {
+		v.add(field, "is required")
+		return
+	}
+	if strings.TrimSpace(addr.GetStreetAddress()) == "" {
+		v.add(field+".street_address", "is required")
+	}
+	if strings.TrimSpace(addr.GetCity()) == "" {
+		v.add(field+".city", "is required")
+	}
+	if strings.TrimSpace(addr.GetCountry()) == "" {
+		v.add(field+".country", "is required")
+		return
+	}
+	country := countryCode(addr.GetCountry())
+	rule := addressRules[country]
+	if rule.state && strings.TrimSpace(addr.GetState()) == "" {
+		v.add(field+".state", "is required in %s", country)
+	}
+	if zip := addr.GetZipCode(); zip < 0 || (rule.maxZip > 0 && (zip < rule.minZip || zip > rule.maxZip)) {
+		v.add(field+".zip_code", "%d is not a valid postal code in %s", zip, country)
+	}
+}
+
+func validateCard(v *fieldViolations, field string, card *pb.CreditCardInfo, now time.Time) {
+	if card == nil {
+		v.add(field, "is required")
+		return
+	}
+	if !validCardNumber(card.GetCreditCardNumber()) {
+		v.add(field+".credit_card_number", "is not a valid card number")
+	}
+	if cvv := card.GetCreditCardCvv(); cvv < 0 || cvv > 9999 {
+		v.add(field+".credit_card_cvv", "must have 3 or 4 digits")
+	}
+	month, year := card.GetCreditCardExpirationMonth(), card.GetCreditCardExpirationYear()
+	if month < 1 || month > 12 {
+		v.add(field+".credit_card_expiration_month", "must be from 1 to 12")
+		return
+	}
+	// A card is good through the end of its expiration month.
+	if year*12+month < int32(now.Year())*12+int32(now.Month()) {
+		v.add(field+".credit_card_expiration_year", "the card expired in %02d/%d", month, year)
+	}
+}
+
+// validateInstruments checks the gift cards and store credit of an order
+// in currency. Each may be used once.
+func validateInstruments(v *fieldViolations, field string, instruments []*pb.PaymentInstrument, currency string) {
+	seen := make(map[balanceAccount]bool)
+	for i, in := range instruments {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		switch in.GetMethod() {
+		case pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD:
+			if normalizeGiftCardCode(in.GetId()) == "" {
+				v.add(f+".id", "is required for a gift card")
+			}
+		case pb.PaymentMethod_PAYMENT_METHOD_STORE_CREDIT:
+		default:
+			v.add(f+".method", "must be a gift card or store credit")
+			continue
+		}
+		acct := instrumentAccount(in, "")
+		if seen[acct] {
+			v.add(f, "is used more than once")
+		}
+		seen[acct] = true
+		if m := in.GetMaxAmount(); m != nil {
+			if !money.IsValid(m) || !money.IsPositive(m) {
+				v.add(f+".max_amount", "must be positive")
+			} else if m.GetCurrencyCode() != currency {
+				v.add(f+".max_amount", "must be in %s", currency)
+			}
+		}
+	}
+}
+
+// validCardNumber reports whether number, ignoring spaces and dashes, has
+// 12 to 19 digits and passes the Luhn check.
+func validCardNumber(number string) bool {
+	var digits []int
+	for _, r := range number {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, int(r-'0'))
+		case r == ' ' || r == '-':
+		default:
+			return false
+		}
+	}
+	if len(digits) < 12 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range digits {
+		d := digits[len(digits)-1-i]
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validateCurrency checks that currency is one CurrencyService supports.
+// If the list of currencies cannot be fetched, any well-formed code is let
+// through; converting prices to it fails later if it is not supported.
+func (cs *checkoutService) validateCurrency(ctx context.Context, v *fieldViolations, field, currency string) {
+	if len(currency) != 3 || strings.ToUpper(currency) != currency {
+		v.add(field, "%q is not an ISO 4217 currency code", currency)
+		return
+	}
+	ok, err := cs.currencySupported(ctx, currency)
+	if err != nil {
+		log.Warnf("failed to get supported currencies, not checking %s: %+v", currency, err)
+		return
+	}
+	if !ok {
+		v.add(field, "currency %s is not supported", currency)
+	}
+}
+
+// validateCart checks that a cart being ordered has items, and only
+// positive quantities of them.
+func validateCart(items []*pb.CartItem) error {
+	var v fieldViolations
+	if len(items) == 0 {
+		v.add("cart", "the user's cart is empty")
+	}
+	for i, it := range items {
+		if it.GetQuantity() <= 0 {
+			v.add(fmt.Sprintf("cart.items[%d].quantity", i), "the cart has %d of product %s", it.GetQuantity(), it.GetProductId())
+		}
+	}
+	return v.err()
+}
@@ -0,0 +1,130 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// violatedFields returns the fields of the BadRequest details of err.
+func violatedFields(err error) []string {
+	var fields []string
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.GetFieldViolations() {
+				fields = append(fields, v.GetField())
+			}
+		}
+	}
+	return fields
+}
+
+func TestValidatePlaceOrderRequest(t *testing.T) {
+	cs := newTestCheckout(t, newFakeDeps())
+	tests := []struct {
+		name   string
+		change func(req *pb.PlaceOrderRequest)
+		want   []string
+	}{
+		{"valid", func(*pb.PlaceOrderRequest) {}, nil},
+		{"no user", func(req *pb.PlaceOrderRequest) { req.UserId = " " }, []string{"user_id"}},
+		{"bad email", func(req *pb.PlaceOrderRequest) { req.Email = "someone@localhost" }, []string{"email"}},
+		{"named email", func(req *pb.PlaceOrderRequest) { req.Email = "Someone <someone@example.com>" }, []string{"email"}},
+		{"no address", func(req *pb.PlaceOrderRequest) { req.Address = nil }, []string{"address"}},
+		{"incomplete address", func(req *pb.PlaceOrderRequest) {
+			req.Address = &pb.Address{Country: "US", ZipCode: 94043}
+		}, []string{"address.street_address", "address.city", "address.state"}},
+		{"bad US zip", func(req *pb.PlaceOrderRequest) { req.Address.ZipCode = 123456 }, []string{"address.zip_code"}},
+		{"German address without state", func(req *pb.PlaceOrderRequest) {
+			req.Address = &pb.Address{StreetAddress: "Unter den Linden 1", City: "Berlin", Country: "Germany", ZipCode: 10117}
+		}, nil},
+		{"unknown country", func(req *pb.PlaceOrderRequest) { req.Address.Country = "Atlantis"; req.Address.State = "" }, nil},
+		{"billing without country", func(req *pb.PlaceOrderRequest) { req.BillingAddress = &pb.Address{ZipCode: 1} }, []string{"billing_address.country"}},
+		{"bad card number", func(req *pb.PlaceOrderRequest) { req.CreditCard.CreditCardNumber = "4432-8015-6152-0455" }, []string{"credit_card.credit_card_number"}},
+		{"expired card", func(req *pb.PlaceOrderRequest) {
+			last := time.Now().AddDate(0, -1, 0)
+			req.CreditCard.CreditCardExpirationYear = int32(last.Year())
+			req.CreditCard.CreditCardExpirationMonth = int32(last.Month())
+		}, []string{"credit_card.credit_card_expiration_year"}},
+		{"card expiring this month", func(req *pb.PlaceOrderRequest) {
+			req.CreditCard.CreditCardExpirationYear = int32(time.Now().Year())
+			req.CreditCard.CreditCardExpirationMonth = int32(time.Now().Month())
+		}, nil},
+		{"bad month", func(req *pb.PlaceOrderRequest) { req.CreditCard.CreditCardExpirationMonth = 13 }, []string{"credit_card.credit_card_expiration_month"}},
+		{"malformed currency", func(req *pb.PlaceOrderRequest) { req.UserCurrency = "usd" }, []string{"user_currency"}},
+		{"unsupported currency", func(req *pb.PlaceOrderRequest) { req.UserCurrency = "XYZ" }, []string{"user_currency"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testOrderRequest("u1")
+			tt.change(req)
+			err := cs.validatePlaceOrderRequest(context.Background(), req)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("validatePlaceOrderRequest() = %v, want nil", err)
+				}
+				return
+			}
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("validatePlaceOrderRequest() = %v, want code %v", err, codes.InvalidArgument)
+			}
+			got := violatedFields(err)
+			if len(got) != len(tt.want) {
+				t.Fatalf("violated fields = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("violated fields = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestPlaceOrderRejectsInvalidCart(t *testing.T) {
+	deps := newFakeDeps()
+	cs := newTestCheckout(t, deps)
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		name string
+		cart []*pb.CartItem
+		want string
+	}{
+		{"empty", nil, "cart"},
+		{"zero quantity", []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}, {ProductId: "66VCHSJNUP", Quantity: 0}}, "cart.items[1].quantity"},
+	} {
+		deps.carts["u1"] = tt.cart
+		_, err := cs.PlaceOrder(ctx, testOrderRequest("u1"))
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("PlaceOrder(%s cart) error = %v, want code %v", tt.name, err, codes.InvalidArgument)
+		}
+		if got := violatedFields(err); len(got) != 1 || got[0] != tt.want {
+			t.Errorf("PlaceOrder(%s cart) violated fields = %v, want [%s]", tt.name, got, tt.want)
+		}
+	}
+	if len(deps.charges) != 0 {
+		t.Errorf("%d orders were charged, want none", len(deps.charges))
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+const (
+	webhookEventPlaced    = "order.placed"
+	webhookEventShipped   = "order.shipped"
+	webhookEventCancelled = "order.cancelled"
+
+	// Headers of webhook requests. The signature is of the timestamp and
+	// the body, so a receiver can reject requests replayed later on.
+	webhookIDHeader        = "X-Webhook-Id"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+
+	// webhookLogRetention is how long attempts stay in the delivery log.
+	webhookLogRetention = 30 * 24 * time.Hour
+	// maxWebhookErrorBody is how much of a failed response is kept.
+	maxWebhookErrorBody = 512
+)
+
+// webhookEvents maps order statuses to the events announcing them.
+var webhookEvents = map[pb.OrderStatus]string{
+	pb.OrderStatus_ORDER_STATUS_PAID:      webhookEventPlaced,
+	pb.OrderStatus_ORDER_STATUS_SHIPPED:   webhookEventShipped,
+	pb.OrderStatus_ORDER_STATUS_CANCELLED: webhookEventCancelled,
+}
+
+// webhookSubscription is an endpoint notified of order events.
+type webhookSubscription struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Secret signs the requests. SecretEnv names an environment variable
+	// holding it instead.
+	Secret    string   `json:"secret,omitempty"`
+	SecretEnv string   `json:"secret_env,omitempty"`
+	Events    []string `json:"events"`
+}
+
+func (s *webhookSubscription) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("webhook subscription to %q has no name", s.URL)
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook subscription %q: invalid url %q", s.Name, s.URL)
+	}
+	if s.SecretEnv != "" {
+		s.Secret = os.Getenv(s.SecretEnv)
+	}
+	if s.Secret == "" {
+		return fmt.Errorf("webhook subscription %q has no secret", s.Name)
+	}
+	if len(s.Events) == 0 {
+		return fmt.Errorf("webhook subscription %q has no events", s.Name)
+	}
+	for _, e := range s.Events {
+		switch e {
+		case webhookEventPlaced, webhookEventShipped, webhookEventCancelled:
+		default:
+			return fmt.Errorf("webhook subscription %q: unknown event %q", s.Name, e)
+		}
+	}
+	return nil
+}
+
+func (s *webhookSubscription) wants(event string) bool {
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookPayload is the outbox payload of a webhook delivery.
+type webhookPayload struct {
+	Subscription string          `json:"subscription"`
+	Event        string          `json:"event"`
+	Body         json.RawMessage `json:"body"`
+}
+
+// webhookAttempt is an entry in the delivery log.
+type webhookAttempt struct {
+	EventID      string
+	Subscription string
+	Event        string
+	OrderID      string
+	Attempt      int
+	StatusCode   int
+	Error        string
+	AttemptedAt  time.Time
+	Duration     time.Duration
+}
+
+// webhookLog records attempts to deliver webhooks. Order repositories
+// implement it; attempts older than webhookLogRetention are dropped.
+type webhookLog interface {
+	// LogWebhookAttempt adds a to the log.
+	LogWebhookAttempt(ctx context.Context, a webhookAttempt) error
+	// WebhookAttempts returns up to limit attempts, most recent first, for
+	// subscription and orderID if they are not empty.
+	WebhookAttempts(ctx context.Context, subscription, orderID string, limit int) ([]webhookAttempt, error)
+}
+
+// webhooks notifies subscribed endpoints of order events.
+type webhooks struct {
+	subs   []*webhookSubscription // by name
+	client *http.Client
+	now    func() time.Time
+}
+
+// newWebhooks reads the webhook subscriptions in path.
+func newWebhooks(path string) (*webhooks, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks file: %w", err)
+	}
+	var file struct {
+		Subscriptions []*webhookSubscription `json:"subscriptions"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse webhooks file: %w", err)
+	}
+	seen := make(map[string]bool)
+	for _, s := range file.Subscriptions {
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("duplicate webhook subscription %q", s.Name)
+		}
+		seen[s.Name] = true
+	}
+	sort.Slice(file.Subscriptions, func(i, j int) bool { return file.Subscriptions[i].Name < file.Subscriptions[j].Name })
+	log.Infof("loaded %d webhook subscriptions from %s", len(file.Subscriptions), path)
+	return &webhooks{subs: file.Subscriptions, client: &http.Client{}, now: time.Now}, nil
+}
+
+func (w *webhooks) subscription(name string) *webhookSubscription {
+	for _, s := range w.subs {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// messagesFor returns an outbox message for each subscription to the event
+// of order event m, which changed the order to o. Their ids derive from m's,
+// so that enqueueing them again adds nothing. A retried message can arrive
+// after a later event, so receivers order an order's events by updatedAt.
+func (w *webhooks) messagesFor(m outboxMessage, o *pb.OrderResult) ([]outboxMessage, error) {
+	event, ok := webhookEvents[o.GetStatus()]
+	if !ok {
+		return nil, nil
+	}
+	body, err := protojson.Marshal(o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render order as JSON: %w", err)
+	}
+	now := w.now()
+	var msgs []outboxMessage
+	for _, s := range w.subs {
+		if !s.wants(event) {
+			continue
+		}
+		payload, err := json.Marshal(webhookPayload{Subscription: s.Name, Event: event, Body: body})
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, outboxMessage{
+			ID:          m.ID + "/" + s.Name,
+			Kind:        outboxKindWebhook,
+			OrderID:     o.GetOrderId(),
+			Payload:     payload,
+			NextAttempt: now,
+			CreatedAt:   m.CreatedAt,
+			UpdatedAt:   now,
+		})
+	}
+	return msgs, nil
+}
+
+// webhookSignature returns the signature header of body sent at t.
+func webhookSignature(secret string, t time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(t.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// post sends the event with the given id to s once, signed with the time
+// it is sent, and returns the attempt to log. Any response other than 2xx
+// is an error.
+func (w *webhooks) post(ctx context.Context, s *webhookSubscription, id string, p webhookPayload) (webhookAttempt, error) {
+	a := webhookAttempt{EventID: id, Subscription: s.Name, Event: p.Event, AttemptedAt: w.now()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(p.Body))
+	if err != nil {
+		return a, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookIDHeader, id)
+	req.Header.Set(webhookEventHeader, p.Event)
+	req.Header.Set(webhookTimestampHeader, strconv.FormatInt(a.AttemptedAt.Unix(), 10))
+	req.Header.Set(webhookSignatureHeader, webhookSignature(s.Secret, a.AttemptedAt, p.Body))
+
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	a.Duration = time.Since(start)
+	if err != nil {
+		return a, fmt.Errorf("webhook %s request failed: %w", s.Name, err)
+	}
+	defer resp.Body.Close()
+	a.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookErrorBody))
+		return a, fmt.Errorf("webhook %s returned %s: %s", s.Name, resp.Status, bytes.TrimSpace(b))
+	}
+	return a, nil
+}
+
+// enqueueWebhooks adds the webhook deliveries of order event m, which
+// changed the order to o, to the outbox.
+func (cs *checkoutService) enqueueWebhooks(ctx context.Context, m outboxMessage, o *pb.OrderResult) error {
+	if cs.webhooks == nil {
+		return nil
+	}
+	msgs, err := cs.webhooks.messagesFor(m, o)
+	if err != nil || len(msgs) == 0 {
+		return err
+	}
+	if err := cs.orders.Enqueue(ctx, msgs); err != nil {
+		return fmt.Errorf("failed to enqueue webhooks: %w", err)
+	}
+	cs.outbox.notify()
+	return nil
+}
+
+// deliverWebhook posts a webhook outbox message to its subscription and
+// logs the attempt. Deliveries to subscriptions no longer configured are
+// dropped.
+func (cs *checkoutService) deliverWebhook(ctx context.Context, m outboxMessage) error {
+	var p webhookPayload
+	if err := json.Unmarshal(m.Payload, &p); err != nil {
+		return fmt.Errorf("failed to decode webhook: %w", err)
+	}
+	var s *webhookSubscription
+	if cs.webhooks != nil {
+		s = cs.webhooks.subscription(p.Subscription)
+	}
+	if s == nil {
+		log.Warnf("dropping %s webhook of order %s: subscription %q is not configured", p.Event, m.OrderID, p.Subscription)
+		return nil
+	}
+	a, err := cs.webhooks.post(ctx, s, m.ID, p)
+	a.OrderID, a.Attempt = m.OrderID, m.Attempts+1
+	if err != nil {
+		a.Error = err.Error()
+	}
+	if lerr := cs.orders.LogWebhookAttempt(ctx, a); lerr != nil {
+		log.Warnf("failed to log webhook delivery %s: %+v", m.ID, lerr)
+	}
+	if err == nil {
+		log.Infof("%s webhook of order %s delivered to %s", p.Event, m.OrderID, s.Name)
+	}
+	return err
+}
+
+func (cs *checkoutService) ListWebhookDeliveries(ctx context.Context, req *pb.ListWebhookDeliveriesRequest) (*pb.ListWebhookDeliveriesResponse, error) {
+	n := int(req.GetPageSize())
+	if n <= 0 {
+		n = defaultFailedDeliveriesPageSize
+	} else if n > maxFailedDeliveriesPageSize {
+		n = maxFailedDeliveriesPageSize
+	}
+	attempts, err := cs.orders.WebhookAttempts(ctx, req.GetSubscription(), req.GetOrderId(), n)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list webhook deliveries: %+v", err)
+	}
+	resp := &pb.ListWebhookDeliveriesResponse{}
+	for _, a := range attempts {
+		resp.Deliveries = append(resp.Deliveries, &pb.WebhookDelivery{
+			EventId:      a.EventID,
+			Subscription: a.Subscription,
+			Event:        a.Event,
+			OrderId:      a.OrderID,
+			Attempt:      int32(a.Attempt),
+			StatusCode:   int32(a.StatusCode),
+			Error:        a.Error,
+			AttemptedAt:  timestamppb.New(a.AttemptedAt),
+			DurationMs:   a.Duration.Milliseconds(),
+		})
+	}
+	return resp, nil
+}
+
+func (r *memoryOrderRepository) LogWebhookAttempt(_ context.Context, a webhookAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cutoff := a.AttemptedAt.Add(-webhookLogRetention)
+	keep := r.webhookLog[:0]
+	for _, old := range r.webhookLog {
+		if !old.AttemptedAt.Before(cutoff) {
+			keep = append(keep, old)
+		}
+	}
+	r.webhookLog = append(keep, a)
+	return nil
+}
+
+func (r *memoryOrderRepository) WebhookAttempts(_ context.Context, subscription, orderID string, limit int) ([]webhookAttempt, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []webhookAttempt
+	for i := len(r.webhookLog) - 1; i >= 0 && len(out) < limit; i-- {
+		a := r.webhookLog[i]
+		if (subscription == "" || a.Subscription == subscription) && (orderID == "" || a.OrderID == orderID) {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
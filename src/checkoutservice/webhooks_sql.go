@@ -0,0 +1,82 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const webhookSchema = `
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	event_id     TEXT NOT NULL,
+	subscription TEXT NOT NULL,
+	event        TEXT NOT NULL,
+	order_id     TEXT NOT NULL,
+	attempt      INTEGER NOT NULL,
+	status_code  INTEGER NOT NULL,
+	error        TEXT NOT NULL,
+	attempted_at INTEGER NOT NULL,
+	duration     INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_by_time ON webhook_deliveries (attempted_at DESC);
+`
+
+func (r *sqlOrderRepository) LogWebhookAttempt(ctx context.Context, a webhookAttempt) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE attempted_at < ?`,
+		a.AttemptedAt.Add(-webhookLogRetention).UnixNano()); err != nil {
+		return fmt.Errorf("failed to prune webhook deliveries: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (event_id, subscription, event, order_id, attempt, status_code, error, attempted_at, duration)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.EventID, a.Subscription, a.Event, a.OrderID, a.Attempt, a.StatusCode, a.Error,
+		a.AttemptedAt.UnixNano(), int64(a.Duration)); err != nil {
+		return fmt.Errorf("failed to log webhook delivery: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (r *sqlOrderRepository) WebhookAttempts(ctx context.Context, subscription, orderID string, limit int) ([]webhookAttempt, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT event_id, subscription, event, order_id, attempt, status_code, error, attempted_at, duration
+		 FROM webhook_deliveries WHERE (? = '' OR subscription = ?) AND (? = '' OR order_id = ?)
+		 ORDER BY attempted_at DESC, rowid DESC LIMIT ?`,
+		subscription, subscription, orderID, orderID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+	var out []webhookAttempt
+	for rows.Next() {
+		var (
+			a                  webhookAttempt
+			attempted, elapsed int64
+		)
+		if err := rows.Scan(&a.EventID, &a.Subscription, &a.Event, &a.OrderID, &a.Attempt, &a.StatusCode, &a.Error,
+			&attempted, &elapsed); err != nil {
+			return nil, fmt.Errorf("failed to read webhook deliveries: %w", err)
+		}
+		a.AttemptedAt, a.Duration = time.Unix(0, attempted), time.Duration(elapsed)
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,357 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// verifyWebhook checks a webhook request the way a receiver should: the
+// signature must match and the timestamp be within tolerance of now.
+func verifyWebhook(secret string, h http.Header, body []byte, now time.Time, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(h.Get(webhookTimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad timestamp: %w", err)
+	}
+	sent := time.Unix(ts, 0)
+	if now.Sub(sent) > tolerance || sent.Sub(now) > tolerance {
+		return errors.New("timestamp outside tolerance")
+	}
+	if !hmac.Equal([]byte(h.Get(webhookSignatureHeader)), []byte(webhookSignature(secret, sent, body))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// webhookReceiver is an endpoint that records the webhooks it is sent and
+// fails the first few.
+type webhookReceiver struct {
+	t      *testing.T
+	secret string
+	srv    *httptest.Server
+
+	mu       sync.Mutex
+	failures int
+	ids      []string // of every request
+	received []receivedWebhook
+}
+
+type receivedWebhook struct {
+	id, event string
+	order     *pb.OrderResult
+}
+
+func newWebhookReceiver(t *testing.T, secret string, failures int) *webhookReceiver {
+	r := &webhookReceiver{t: t, secret: secret, failures: failures}
+	r.srv = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.srv.Close)
+	return r
+}
+
+func (r *webhookReceiver) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ids = append(r.ids, req.Header.Get(webhookIDHeader))
+	if err := verifyWebhook(r.secret, req.Header, body, time.Now(), 5*time.Minute); err != nil {
+		r.t.Errorf("webhook failed verification: %v", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "try again later", http.StatusServiceUnavailable)
+		return
+	}
+	o := new(pb.OrderResult)
+	if err := protojson.Unmarshal(body, o); err != nil {
+		r.t.Errorf("webhook body is not an OrderResult: %v", err)
+	}
+	r.received = append(r.received, receivedWebhook{id: req.Header.Get(webhookIDHeader), event: req.Header.Get(webhookEventHeader), order: o})
+}
+
+func (r *webhookReceiver) events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	for _, w := range r.received {
+		out = append(out, w.event)
+	}
+	return out
+}
+
+// newWebhookCheckout returns a test checkoutService with webhooks to the
+// given subscriptions. dispatch moves the outbox's clock on by d and
+// delivers what is due.
+func newWebhookCheckout(t *testing.T, deps *fakeDeps, subs string) (cs *checkoutService, dispatch func(time.Duration)) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	if err := os.WriteFile(path, []byte(`{"subscriptions": [`+subs+`]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWebhooks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var skew time.Duration
+	clock := func() time.Time { return time.Now().Add(skew) }
+	w.now = clock
+	cs = newTestCheckout(t, deps)
+	cs.webhooks = w
+	cs.outbox.now = clock
+	return cs, func(d time.Duration) {
+		t.Helper()
+		skew += d
+		// Order events enqueue webhooks, which are due straight away.
+		for i := 0; i < 2; i++ {
+			if err := cs.outbox.dispatchOnce(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestPlaceOrderSendsWebhooks(t *testing.T) {
+	t.Setenv("ACCOUNTING_SECRET", "s3cret")
+	warehouse := newWebhookReceiver(t, "w", 0)
+	accounting := newWebhookReceiver(t, "s3cret", 1)
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}}
+	cs, dispatch := newWebhookCheckout(t, deps, `
+		{"name": "warehouse", "url": "`+warehouse.srv.URL+`", "secret": "w", "events": ["order.placed", "order.shipped", "order.cancelled"]},
+		{"name": "accounting", "url": "`+accounting.srv.URL+`", "secret_env": "ACCOUNTING_SECRET", "events": ["order.placed"]}`)
+
+	resp, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1"))
+	if err != nil {
+		t.Fatalf("PlaceOrder() failed: %v", err)
+	}
+	orderID := resp.GetOrder().GetOrderId()
+	dispatch(0)
+
+	if got, want := strings.Join(warehouse.events(), ","), "order.placed,order.shipped"; got != want {
+		t.Errorf("warehouse received %s, want %s", got, want)
+	}
+	for _, w := range warehouse.received {
+		if w.order.GetOrderId() != orderID {
+			t.Errorf("%s webhook is for order %q, want %q", w.event, w.order.GetOrderId(), orderID)
+		}
+	}
+	if shipped := warehouse.received[1].order; shipped.GetStatus() != pb.OrderStatus_ORDER_STATUS_SHIPPED || shipped.GetShippingTrackingId() == "" {
+		t.Errorf("order.shipped webhook has order %v, want it shipped with a tracking id", shipped)
+	}
+	// Receivers order events by updatedAt, since retries can reorder them.
+	if placed, shipped := warehouse.received[0].order, warehouse.received[1].order; !placed.GetUpdatedAt().AsTime().Before(shipped.GetUpdatedAt().AsTime()) {
+		t.Errorf("order.placed updatedAt %v is not before order.shipped updatedAt %v", placed.GetUpdatedAt().AsTime(), shipped.GetUpdatedAt().AsTime())
+	}
+	// Accounting failed the first time; it is retried on its own after a
+	// backoff, with the same id.
+	if len(accounting.received) != 0 {
+		t.Fatalf("accounting received %v before its retry", accounting.events())
+	}
+	dispatch(time.Minute)
+	if got := accounting.events(); len(got) != 1 || got[0] != webhookEventPlaced {
+		t.Errorf("accounting received %v, want one order.placed", got)
+	}
+	if len(accounting.ids) != 2 || accounting.ids[0] != accounting.ids[1] {
+		t.Errorf("accounting was sent ids %v, want the same id twice", accounting.ids)
+	}
+	if len(warehouse.ids) != 2 {
+		t.Errorf("warehouse was sent %d requests, want 2", len(warehouse.ids))
+	}
+
+	log, err := cs.ListWebhookDeliveries(context.Background(), &pb.ListWebhookDeliveriesRequest{Subscription: "accounting"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := log.GetDeliveries()
+	if len(d) != 2 {
+		t.Fatalf("accounting has %d logged deliveries, want 2", len(d))
+	}
+	if d[0].GetAttempt() != 2 || d[0].GetStatusCode() != http.StatusOK || d[0].GetError() != "" {
+		t.Errorf("latest delivery = %v, want a successful second attempt", d[0])
+	}
+	if d[1].GetAttempt() != 1 || d[1].GetStatusCode() != http.StatusServiceUnavailable || !strings.Contains(d[1].GetError(), "try again later") {
+		t.Errorf("first delivery = %v, want a failed first attempt", d[1])
+	}
+	if d[0].GetOrderId() != orderID || d[0].GetEventId() != accounting.ids[0] {
+		t.Errorf("latest delivery = %v, want order %s and event %s", d[0], orderID, accounting.ids[0])
+	}
+}
+
+func TestFailedOrderSendsCancelledWebhook(t *testing.T) {
+	warehouse := newWebhookReceiver(t, "w", 0)
+	deps := newFakeDeps()
+	deps.carts["u1"] = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 1}}
+	deps.shipErr = status.Error(codes.Unavailable, "no trucks")
+	cs, dispatch := newWebhookCheckout(t, deps, `
+		{"name": "warehouse", "url": "`+warehouse.srv.URL+`", "secret": "w", "events": ["order.shipped", "order.cancelled"]}`)
+
+	if _, err := cs.PlaceOrder(context.Background(), testOrderRequest("u1")); status.Code(err) != codes.Unavailable {
+		t.Fatalf("PlaceOrder() error = %v, want code %v", err, codes.Unavailable)
+	}
+	dispatch(0)
+	if got := warehouse.events(); len(got) != 1 || got[0] != webhookEventCancelled {
+		t.Errorf("warehouse received %v, want one order.cancelled", got)
+	}
+}
+
+func TestWebhookSignatureRejectsReplaysAndTampering(t *testing.T) {
+	sent := time.Unix(1700000000, 0)
+	body := []byte(`{"orderId":"o1"}`)
+	h := http.Header{}
+	h.Set(webhookTimestampHeader, strconv.FormatInt(sent.Unix(), 10))
+	h.Set(webhookSignatureHeader, webhookSignature("secret", sent, body))
+
+	if err := verifyWebhook("secret", h, body, sent.Add(time.Minute), 5*time.Minute); err != nil {
+		t.Errorf("fresh webhook rejected: %v", err)
+	}
+	if err := verifyWebhook("secret", h, body, sent.Add(time.Hour), 5*time.Minute); err == nil {
+		t.Error("webhook replayed an hour later was accepted")
+	}
+	if err := verifyWebhook("secret", h, []byte(`{"orderId":"o2"}`), sent, 5*time.Minute); err == nil {
+		t.Error("webhook with a changed body was accepted")
+	}
+	if err := verifyWebhook("other", h, body, sent, 5*time.Minute); err == nil {
+		t.Error("webhook signed with another secret was accepted")
+	}
+	h.Set(webhookTimestampHeader, strconv.FormatInt(sent.Add(time.Hour).Unix(), 10))
+	if err := verifyWebhook("secret", h, body, sent.Add(time.Hour), 5*time.Minute); err == nil {
+		t.Error("webhook with a changed timestamp was accepted")
+	}
+}
+
+func TestNewWebhooksRejectsInvalidSubscriptions(t *testing.T) {
+	for name, subs := range map[string]string{
+		"no name":       `{"url": "http://example.com", "secret": "s", "events": ["order.placed"]}`,
+		"bad url":       `{"name": "a", "url": "example.com/hook", "secret": "s", "events": ["order.placed"]}`,
+		"no secret":     `{"name": "a", "url": "http://example.com", "secret_env": "UNSET_WEBHOOK_SECRET", "events": ["order.placed"]}`,
+		"no events":     `{"name": "a", "url": "http://example.com", "secret": "s"}`,
+		"unknown event": `{"name": "a", "url": "http://example.com", "secret": "s", "events": ["order.lost"]}`,
+		"duplicate": `{"name": "a", "url": "http://example.com", "secret": "s", "events": ["order.placed"]},
+			{"name": "a", "url": "http://example.org", "secret": "s", "events": ["order.shipped"]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "webhooks.json")
+			if err := os.WriteFile(path, []byte(`{"subscriptions": [`+subs+`]}`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := newWebhooks(path); err == nil {
+				t.Error("newWebhooks() succeeded, want error")
+			}
+		})
+	}
+}
+
+// testWebhookLog runs the same checks against every order repository.
+func testWebhookLog(t *testing.T, r orderRepository) {
+	ctx := context.Background()
+	base := time.Now()
+	for i, a := range []webhookAttempt{
+		{EventID: "e1", Subscription: "warehouse", OrderID: "o1", Attempt: 1, StatusCode: 500, Error: "down"},
+		{EventID: "e1", Subscription: "warehouse", OrderID: "o1", Attempt: 2, StatusCode: 200},
+		{EventID: "e2", Subscription: "accounting", OrderID: "o1", Attempt: 1, StatusCode: 200},
+		{EventID: "e3", Subscription: "warehouse", OrderID: "o2", Attempt: 1, StatusCode: 200, Duration: 30 * time.Millisecond},
+	} {
+		a.Event = webhookEventPlaced
+		a.AttemptedAt = base.Add(time.Duration(i) * time.Second)
+		if err := r.LogWebhookAttempt(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(attempts []webhookAttempt, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, a := range attempts {
+			out = append(out, fmt.Sprintf("%s#%d", a.EventID, a.Attempt))
+		}
+		return strings.Join(out, ",")
+	}
+	for _, tc := range []struct {
+		subscription, orderID string
+		limit                 int
+		want                  string
+	}{
+		{"", "", 10, "e3#1,e2#1,e1#2,e1#1"},
+		{"", "", 2, "e3#1,e2#1"},
+		{"warehouse", "", 10, "e3#1,e1#2,e1#1"},
+		{"", "o1", 10, "e2#1,e1#2,e1#1"},
+		{"warehouse", "o1", 10, "e1#2,e1#1"},
+	} {
+		if got := ids(r.WebhookAttempts(ctx, tc.subscription, tc.orderID, tc.limit)); got != tc.want {
+			t.Errorf("WebhookAttempts(%q, %q, %d) = %s, want %s", tc.subscription, tc.orderID, tc.limit, got, tc.want)
+		}
+	}
+	latest, err := r.WebhookAttempts(ctx, "", "o2", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := latest[0]; a.Duration != 30*time.Millisecond || !a.AttemptedAt.Equal(base.Add(3*time.Second)) || a.Event != webhookEventPlaced {
+		t.Errorf("WebhookAttempts() = %+v, want the attempt as logged", a)
+	}
+
+	// Logging drops attempts past the retention period.
+	if err := r.LogWebhookAttempt(ctx, webhookAttempt{EventID: "e4", Subscription: "warehouse", OrderID: "o3", Attempt: 1,
+		AttemptedAt: base.Add(webhookLogRetention + 2500*time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(r.WebhookAttempts(ctx, "", "", 10)); got != "e4#1,e3#1" {
+		t.Errorf("WebhookAttempts() after retention = %s, want e4#1,e3#1", got)
+	}
+
+	// Enqueueing a message again adds nothing.
+	m := outboxMessage{ID: "e5", Kind: outboxKindWebhook, OrderID: "o3", Payload: []byte("{}"), NextAttempt: base, CreatedAt: base, UpdatedAt: base}
+	for i := 0; i < 2; i++ {
+		if err := r.Enqueue(ctx, []outboxMessage{m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if due, err := r.Due(ctx, base, 10); err != nil || len(due) != 1 {
+		t.Errorf("Due() = %v, %v; want the message once", kinds(due), err)
+	}
+}
+
+func TestMemoryWebhookLog(t *testing.T) {
+	testWebhookLog(t, newMemoryOrderRepository())
+}
+
+func TestSQLWebhookLog(t *testing.T) {
+	r, err := newSQLOrderRepository(filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	testWebhookLog(t, r)
+}
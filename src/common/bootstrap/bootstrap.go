@@ -0,0 +1,396 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bootstrap starts a Go service the way they all start: logging,
+// tracing, metrics, profiling, health checks, shutting down on SIGINT and
+// SIGTERM, and gRPC clients with the standard interceptors.
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/profiler"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/circuitbreaker"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/envconfig"
+)
+
+// shutdownTimeout is how long in-flight requests get to finish once a
+// service is told to stop.
+const shutdownTimeout = 10 * time.Second
+
+// Config is the environment every service reads.
+type Config struct {
+	Port     string `env:"PORT"`
+	LogLevel string `env:"LOG_LEVEL"`
+	// Tracing exports traces, and Stats metrics, over OTLP to
+	// CollectorAddr.
+	Tracing       bool   `env:"ENABLE_TRACING"`
+	Stats         bool   `env:"ENABLE_STATS"`
+	CollectorAddr string `env:"COLLECTOR_SERVICE_ADDR"`
+	// EnableProfiler and DisableProfiler turn the profiler on or off
+	// whatever Options.Profile says.
+	EnableProfiler  bool `env:"ENABLE_PROFILER"`
+	DisableProfiler bool `env:"DISABLE_PROFILER"`
+	// MetricsPort serves Prometheus metrics on /metrics if set.
+	MetricsPort string `env:"METRICS_PORT"`
+}
+
+// Options describe the service being started.
+type Options struct {
+	Name    string
+	Version string
+	// Port and MetricsPort are the defaults for PORT and METRICS_PORT.
+	Port        string
+	MetricsPort string
+	// Profile runs the profiler unless DISABLE_PROFILER is set. Without it,
+	// the profiler only runs with ENABLE_PROFILER.
+	Profile bool
+	// Log, if set, is the logger to set up rather than a new one, for
+	// services that log before they start.
+	Log *logrus.Logger
+}
+
+// Service is a started service.
+type Service struct {
+	Name   string
+	Config Config
+	Log    *logrus.Logger
+	// MeterProvider serves metrics to Prometheus and, with ENABLE_STATS,
+	// exports them over OTLP.
+	MeterProvider *sdkmetric.MeterProvider
+	// Health is the gRPC health server NewServer registers. It reports
+	// serving until the service is told to stop.
+	Health *health.Server
+
+	tracerProvider *sdktrace.TracerProvider
+	breakers       circuitbreaker.Config
+}
+
+// NewLogger returns a logger writing JSON to stdout with the field names
+// Cloud Logging expects.
+func NewLogger() *logrus.Logger {
+	log := logrus.New()
+	log.Level = logrus.DebugLevel
+	log.Formatter = &logrus.JSONFormatter{
+		FieldMap: logrus.FieldMap{
+			logrus.FieldKeyTime:  "timestamp",
+			logrus.FieldKeyLevel: "severity",
+			logrus.FieldKeyMsg:   "message",
+		},
+		TimestampFormat: time.RFC3339Nano,
+	}
+	log.Out = os.Stdout
+	return log
+}
+
+// Start loads the service's Config and sets up its logger, tracer and
+// meter providers and profiler.
+func Start(ctx context.Context, opts Options) (*Service, error) {
+	s := &Service{
+		Name:   opts.Name,
+		Config: Config{Port: opts.Port, MetricsPort: opts.MetricsPort},
+		Log:    opts.Log,
+		Health: health.NewServer(),
+	}
+	if s.Log == nil {
+		s.Log = NewLogger()
+	}
+	if err := envconfig.Load(&s.Config); err != nil {
+		return nil, err
+	}
+	if s.Config.LogLevel != "" {
+		level, err := logrus.ParseLevel(s.Config.LogLevel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q", s.Config.LogLevel)
+		}
+		s.Log.Level = level
+	}
+	var err error
+	if s.breakers, err = circuitbreaker.ConfigFromEnv(); err != nil {
+		return nil, err
+	}
+
+	otel.SetTextMapPropagator(
+		propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{}, propagation.Baggage{}))
+	if s.Config.Tracing {
+		s.Log.Info("Tracing enabled.")
+		if err := s.initTracing(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		s.Log.Info("Tracing disabled.")
+	}
+	if err := s.initStats(ctx); err != nil {
+		return nil, err
+	}
+	if s.Config.MetricsPort != "" {
+		go s.serveMetrics()
+	}
+
+	if s.Config.EnableProfiler || (opts.Profile && !s.Config.DisableProfiler) {
+		s.Log.Info("Profiling enabled.")
+		go s.initProfiling(opts.Version)
+	} else {
+		s.Log.Info("Profiling disabled.")
+	}
+	return s, nil
+}
+
+func (s *Service) initTracing(ctx context.Context) error {
+	if s.Config.CollectorAddr == "" {
+		return errors.New(`environment variable "COLLECTOR_SERVICE_ADDR" not set`)
+	}
+	exporter, err := otlptracegrpc.New(ctx,
+		otlptracegrpc.WithEndpoint(s.Config.CollectorAddr),
+		otlptracegrpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("failed to create trace exporter: %+v", err)
+	}
+	s.tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	otel.SetTracerProvider(s.tracerProvider)
+	return nil
+}
+
+// initStats sets up the meter provider. Its metrics are always served to
+// Prometheus and, with ENABLE_STATS, also exported to the collector.
+func (s *Service) initStats(ctx context.Context) error {
+	prom, err := otelprometheus.New()
+	if err != nil {
+		return fmt.Errorf("failed to create Prometheus metrics exporter: %+v", err)
+	}
+	opts := []sdkmetric.Option{sdkmetric.WithReader(prom)}
+	if s.Config.Stats {
+		s.Log.Info("Stats enabled.")
+		if s.Config.CollectorAddr == "" {
+			return errors.New(`environment variable "COLLECTOR_SERVICE_ADDR" not set`)
+		}
+		exporter, err := otlpmetricgrpc.New(ctx,
+			otlpmetricgrpc.WithEndpoint(s.Config.CollectorAddr),
+			otlpmetricgrpc.WithInsecure())
+		if err != nil {
+			return fmt.Errorf("failed to create OTLP metrics exporter: %+v", err)
+		}
+		opts = append(opts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)))
+	} else {
+		s.Log.Info("Stats disabled.")
+	}
+	s.MeterProvider = sdkmetric.NewMeterProvider(opts...)
+	otel.SetMeterProvider(s.MeterProvider)
+	return nil
+}
+
+// serveMetrics exposes Prometheus metrics, such as the state of the
+// circuit breakers and the service's own metrics, on /metrics.
+func (s *Service) serveMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	s.Log.Infof("serving metrics on tcp: %q", ":"+s.Config.MetricsPort)
+	if err := http.ListenAndServe(":"+s.Config.MetricsPort, mux); err != nil {
+		s.Log.Warnf("metrics server stopped: %+v", err)
+	}
+}
+
+func (s *Service) initProfiling(version string) {
+	for i := 1; i <= 3; i++ {
+		if err := profiler.Start(profiler.Config{
+			Service:        s.Name,
+			ServiceVersion: version,
+			// ProjectID must be set if not running on GCP.
+			// ProjectID: "my-project",
+		}); err != nil {
+			s.Log.Warnf("failed to start profiler: %+v", err)
+		} else {
+			s.Log.Info("started Stackdriver profiler")
+			return
+		}
+		d := time.Second * 10 * time.Duration(i)
+		s.Log.Infof("sleeping %v to retry initializing Stackdriver profiler", d)
+		time.Sleep(d)
+	}
+	s.Log.Warn("could not initialize Stackdriver profiler after retrying, giving up")
+}
+
+// Dial returns a client for the gRPC server at addr. Calls go through the
+// given interceptors, in order, then a circuit breaker for addr and then
+// tracing, so that every attempt an interceptor makes is checked against
+// the breaker and traced. Streams share the breaker.
+func (s *Service) Dial(addr string, interceptors ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, error) {
+	breaker := circuitbreaker.New(addr, s.breakers)
+	interceptors = append(interceptors, breaker.UnaryClientInterceptor())
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(interceptors...),
+		grpc.WithChainStreamInterceptor(breaker.StreamClientInterceptor()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	if err != nil {
+		return nil, fmt.Errorf("grpc: failed to connect %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+// MustDial is Dial for addresses known at startup; it panics if addr is
+// not a valid target.
+func (s *Service) MustDial(addr string, interceptors ...grpc.UnaryClientInterceptor) *grpc.ClientConn {
+	conn, err := s.Dial(addr, interceptors...)
+	if err != nil {
+		panic(err)
+	}
+	return conn
+}
+
+// NewServer returns a gRPC server that traces its calls and serves the
+// health service.
+func (s *Service) NewServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler())}, opts...)
+	srv := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(srv, s.Health)
+	return srv
+}
+
+// PortServer is a gRPC server to serve on a port of its own, such as an
+// admin API that should not share the port the service is reached on.
+type PortServer struct {
+	Port   string
+	Server *grpc.Server
+}
+
+// ServeGRPC serves srv on the configured port, and each of more on its own
+// port, until ctx is done or the process gets SIGINT or SIGTERM. It then
+// reports not serving, lets calls in flight finish and flushes telemetry.
+func (s *Service) ServeGRPC(ctx context.Context, srv *grpc.Server, more ...PortServer) error {
+	servers := append([]PortServer{{Port: s.Config.Port, Server: srv}}, more...)
+	lis := make([]net.Listener, len(servers))
+	for i, ps := range servers {
+		l, err := net.Listen("tcp", ":"+ps.Port)
+		if err != nil {
+			for _, l := range lis[:i] {
+				l.Close()
+			}
+			return err
+		}
+		lis[i] = l
+	}
+	srvs := make([]*grpc.Server, len(servers))
+	for i, ps := range servers {
+		srvs[i] = ps.Server
+	}
+	return s.serveGRPC(ctx, srvs, lis)
+}
+
+// serveGRPC serves each of srvs on the listener at the same index.
+func (s *Service) serveGRPC(ctx context.Context, srvs []*grpc.Server, lis []net.Listener) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, len(srvs))
+	for i, srv := range srvs {
+		go func() { errc <- srv.Serve(lis[i]) }()
+		s.Log.Infof("starting to listen on tcp: %q", lis[i].Addr().String())
+	}
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+	}
+
+	s.Log.Info("shutting down")
+	s.Health.Shutdown()
+	var wg sync.WaitGroup
+	for _, srv := range srvs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				srv.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-time.After(shutdownTimeout):
+				srv.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	return s.Shutdown(context.Background())
+}
+
+// ServeHTTP serves h on addr and the configured port until ctx is done or
+// the process gets SIGINT or SIGTERM, then lets requests in flight finish
+// and flushes telemetry.
+func (s *Service) ServeHTTP(ctx context.Context, addr string, h http.Handler) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{Addr: addr + ":" + s.Config.Port, Handler: h}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	s.Log.Infof("starting server on %s", srv.Addr)
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.Log.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		s.Log.Warnf("failed to shut down server gracefully: %+v", err)
+	}
+	return s.Shutdown(context.Background())
+}
+
+// Shutdown flushes and stops the tracer and meter providers.
+func (s *Service) Shutdown(ctx context.Context) error {
+	var errs []error
+	if s.tracerProvider != nil {
+		errs = append(errs, s.tracerProvider.Shutdown(ctx))
+	}
+	if s.MeterProvider != nil {
+		errs = append(errs, s.MeterProvider.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
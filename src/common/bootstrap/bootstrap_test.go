@@ -0,0 +1,188 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/circuitbreaker"
+)
+
+func newTestService() *Service {
+	log := logrus.New()
+	log.Out = io.Discard
+	return &Service{
+		Name:     "testservice",
+		Log:      log,
+		Health:   health.NewServer(),
+		breakers: circuitbreaker.DefaultConfig(),
+	}
+}
+
+func TestServeGRPC(t *testing.T) {
+	s := newTestService()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- s.serveGRPC(ctx, []*grpc.Server{s.NewServer()}, []net.Listener{lis}) }()
+
+	var calls []string
+	conn, err := s.Dial(lis.Addr().String(), func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		calls = append(calls, method)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Check() failed: %v", err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health status = %v, want SERVING", resp.GetStatus())
+	}
+	if len(calls) != 1 || calls[0] != "/grpc.health.v1.Health/Check" {
+		t.Errorf("interceptor saw calls %v, want the health check", calls)
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("serveGRPC() = %v, want nil after a clean shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveGRPC() did not return after its context was done")
+	}
+	if resp, err := s.Health.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("health after shutdown = %v, %v, want NOT_SERVING", resp.GetStatus(), err)
+	}
+}
+
+func TestServeGRPCServesEachServerOnItsOwnPort(t *testing.T) {
+	s := newTestService()
+	var lis []net.Listener
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis = append(lis, l)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Only the first server serves the health service.
+	errc := make(chan error, 1)
+	go func() { errc <- s.serveGRPC(ctx, []*grpc.Server{s.NewServer(), grpc.NewServer()}, lis) }()
+
+	for i, want := range []codes.Code{codes.OK, codes.Unimplemented} {
+		conn, err := s.Dial(lis[i].Addr().String())
+		if err != nil {
+			t.Fatalf("Dial() failed: %v", err)
+		}
+		defer conn.Close()
+		if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); status.Code(err) != want {
+			t.Errorf("Check() on listener %d = %v, want %v", i, err, want)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("serveGRPC() = %v, want nil after a clean shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveGRPC() did not stop both servers after its context was done")
+	}
+}
+
+func TestServeGRPCFailsOnBadPort(t *testing.T) {
+	s := newTestService()
+	s.Config.Port = "0"
+	if err := s.ServeGRPC(context.Background(), s.NewServer(), PortServer{Port: "-1", Server: grpc.NewServer()}); err == nil {
+		t.Error("ServeGRPC() with an invalid extra port succeeded, want an error")
+	}
+}
+
+func TestDialInvalidTarget(t *testing.T) {
+	if _, err := newTestService().Dial("dns://a b c/%"); err == nil {
+		t.Error("Dial() of an invalid target succeeded, want an error")
+	}
+}
+
+func TestStart(t *testing.T) {
+	t.Setenv("PORT", "")
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("ENABLE_TRACING", "")
+	t.Setenv("ENABLE_STATS", "")
+	t.Setenv("ENABLE_PROFILER", "")
+	t.Setenv("METRICS_PORT", "")
+
+	log := logrus.New()
+	log.Out = io.Discard
+	s, err := Start(context.Background(), Options{Name: "testservice", Version: "1.0.0", Port: "5050", Log: log})
+	if err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	if s.Config.Port != "5050" {
+		t.Errorf("port = %q, want the default 5050", s.Config.Port)
+	}
+	if s.Log != log || log.Level != logrus.WarnLevel {
+		t.Errorf("logger level = %v, want the given logger at LOG_LEVEL warn", s.Log.Level)
+	}
+	if s.MeterProvider == nil {
+		t.Error("Start() did not set up a meter provider")
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"invalid log level", map[string]string{"LOG_LEVEL": "loud"}},
+		{"invalid flag", map[string]string{"ENABLE_TRACING": "sometimes"}},
+		{"tracing without collector", map[string]string{"ENABLE_TRACING": "1", "COLLECTOR_SERVICE_ADDR": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, err := Start(context.Background(), Options{Name: "testservice", Log: logrus.New()}); err == nil {
+				t.Error("Start() succeeded, want an error")
+			}
+		})
+	}
+}
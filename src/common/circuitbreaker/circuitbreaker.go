@@ -0,0 +1,293 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package circuitbreaker stops calls to a gRPC target that keeps failing,
+// so that callers fail fast instead of waiting for their deadlines.
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// Closed lets every call through.
+	Closed State = iota
+	// Open rejects every call until Config.OpenTimeout has passed.
+	Open
+	// HalfOpen lets a few probe calls through to see if the target recovered.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half_open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// ErrOpen is returned by Allow while the breaker rejects calls.
+var ErrOpen = errors.New("circuit breaker is open")
+
+var (
+	stateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "grpc_client_circuit_breaker_state",
+			Help: "State of the circuit breaker of a gRPC target: 0 closed, 1 open, 2 half-open",
+		},
+		[]string{"target"},
+	)
+	transitionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "grpc_client_circuit_breaker_transitions_total",
+			Help: "Number of circuit breaker state changes",
+		},
+		[]string{"target", "state"},
+	)
+	rejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "grpc_client_circuit_breaker_rejected_total",
+			Help: "Number of calls failed fast by an open circuit breaker",
+		},
+		[]string{"target"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(stateGauge, transitionsTotal, rejectedTotal)
+}
+
+// Config tunes a Breaker.
+type Config struct {
+	// FailureThreshold is the number of consecutive failures that opens a
+	// closed breaker.
+	FailureThreshold int
+	// OpenTimeout is how long an open breaker waits before letting probes
+	// through.
+	OpenTimeout time.Duration
+	// HalfOpenMaxCalls is the number of probes let through while half-open.
+	// The breaker closes once that many succeed, and opens again as soon as
+	// one fails.
+	HalfOpenMaxCalls int
+}
+
+// DefaultConfig returns the configuration used unless overridden.
+func DefaultConfig() Config {
+	return Config{FailureThreshold: 5, OpenTimeout: 10 * time.Second, HalfOpenMaxCalls: 1}
+}
+
+// ConfigFromEnv returns DefaultConfig overridden by the
+// CIRCUIT_BREAKER_FAILURE_THRESHOLD, CIRCUIT_BREAKER_OPEN_TIMEOUT and
+// CIRCUIT_BREAKER_HALF_OPEN_MAX_CALLS environment variables.
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	for _, v := range []struct {
+		key string
+		dst *int
+	}{
+		{"CIRCUIT_BREAKER_FAILURE_THRESHOLD", &cfg.FailureThreshold},
+		{"CIRCUIT_BREAKER_HALF_OPEN_MAX_CALLS", &cfg.HalfOpenMaxCalls},
+	} {
+		if s := os.Getenv(v.key); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return cfg, fmt.Errorf("invalid %s %q", v.key, s)
+			}
+			*v.dst = n
+		}
+	}
+	if s := os.Getenv("CIRCUIT_BREAKER_OPEN_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid CIRCUIT_BREAKER_OPEN_TIMEOUT: %w", err)
+		}
+		cfg.OpenTimeout = d
+	}
+	return cfg, nil
+}
+
+// Breaker tracks the health of one target.
+type Breaker struct {
+	target string
+	cfg    Config
+	now    func() time.Time
+
+	mu        sync.Mutex
+	state     State
+	failures  int // consecutive failures while closed
+	openedAt  time.Time
+	probes    int // probes in flight while half-open
+	successes int // successful probes while half-open
+}
+
+// New returns a closed breaker for target.
+func New(target string, cfg Config) *Breaker {
+	b := &Breaker{target: target, cfg: cfg, now: time.Now}
+	stateGauge.WithLabelValues(target).Set(float64(Closed))
+	return b
+}
+
+// State returns the breaker's current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireOpen()
+	return b.state
+}
+
+// Allow asks to make a call. It returns ErrOpen if the call must not be
+// made; otherwise the caller must report the call's error to done.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireOpen()
+	switch b.state {
+	case Open:
+		rejectedTotal.WithLabelValues(b.target).Inc()
+		return nil, ErrOpen
+	case HalfOpen:
+		if b.probes >= b.cfg.HalfOpenMaxCalls {
+			rejectedTotal.WithLabelValues(b.target).Inc()
+			return nil, ErrOpen
+		}
+		b.probes++
+		return func(err error) { b.done(true, err) }, nil
+	}
+	return func(err error) { b.done(false, err) }, nil
+}
+
+func (b *Breaker) done(probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failed := IsFailure(err)
+	if probe && b.state == HalfOpen {
+		b.probes--
+		if failed {
+			b.setState(Open)
+		} else if b.successes++; b.successes >= b.cfg.HalfOpenMaxCalls {
+			b.setState(Closed)
+		}
+		return
+	}
+	if b.state != Closed {
+		return
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	if b.failures++; b.failures >= b.cfg.FailureThreshold {
+		b.setState(Open)
+	}
+}
+
+// expireOpen moves an open breaker to half-open once its timeout passed.
+func (b *Breaker) expireOpen() {
+	if b.state == Open && !b.now().Before(b.openedAt.Add(b.cfg.OpenTimeout)) {
+		b.setState(HalfOpen)
+	}
+}
+
+func (b *Breaker) setState(s State) {
+	b.state = s
+	b.failures, b.probes, b.successes = 0, 0, 0
+	if s == Open {
+		b.openedAt = b.now()
+	}
+	stateGauge.WithLabelValues(b.target).Set(float64(s))
+	transitionsTotal.WithLabelValues(b.target, s.String()).Inc()
+}
+
+// IsFailure reports whether err suggests the target is unhealthy, as
+// opposed to the call itself being wrong.
+func IsFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
+
+// UnaryClientInterceptor guards every call on a connection with b. Calls
+// rejected by an open breaker fail with codes.Unavailable.
+func (b *Breaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done, err := b.Allow()
+		if err != nil {
+			return status.Errorf(codes.Unavailable, "circuit breaker for %s is open, failing %s fast", b.target, method)
+		}
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		done(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor guards every stream on a connection with b. A
+// stream counts against the breaker once it ends, that is when RecvMsg
+// returns an error; io.EOF counts as success. Streams rejected by an open
+// breaker fail with codes.Unavailable.
+func (b *Breaker) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		done, err := b.Allow()
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "circuit breaker for %s is open, failing %s fast", b.target, method)
+		}
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			done(err)
+			return nil, err
+		}
+		return &guardedStream{ClientStream: s, done: done}, nil
+	}
+}
+
+// guardedStream reports the end of a stream to its breaker.
+type guardedStream struct {
+	grpc.ClientStream
+	once sync.Once
+	done func(err error)
+}
+
+func (s *guardedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(func() {
+			if err == io.EOF {
+				s.done(nil)
+			} else {
+				s.done(err)
+			}
+		})
+	}
+	return err
+}
@@ -0,0 +1,170 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errDown = status.Error(codes.Unavailable, "down")
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestBreaker(target string) (*Breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	b := New(target, Config{FailureThreshold: 3, OpenTimeout: time.Second, HalfOpenMaxCalls: 1})
+	b.now = clock.now
+	return b, clock
+}
+
+func call(t *testing.T, b *Breaker, err error) error {
+	t.Helper()
+	done, aerr := b.Allow()
+	if aerr != nil {
+		return aerr
+	}
+	done(err)
+	return nil
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b, _ := newTestBreaker("opens")
+	call(t, b, errDown)
+	call(t, b, errDown)
+	call(t, b, nil) // resets the count
+	call(t, b, status.Error(codes.NotFound, "no such product"))
+	call(t, b, errDown)
+	call(t, b, errDown)
+	if b.State() != Closed {
+		t.Fatalf("state = %v after 2 consecutive failures, want closed", b.State())
+	}
+	call(t, b, errDown)
+	if b.State() != Open {
+		t.Fatalf("state = %v after 3 consecutive failures, want open", b.State())
+	}
+	if err := call(t, b, nil); !errors.Is(err, ErrOpen) {
+		t.Errorf("Allow() error = %v, want %v", err, ErrOpen)
+	}
+	if got := testutil.ToFloat64(stateGauge.WithLabelValues("opens")); got != float64(Open) {
+		t.Errorf("state gauge = %v, want %v", got, float64(Open))
+	}
+}
+
+func TestBreakerHalfOpenProbes(t *testing.T) {
+	b, clock := newTestBreaker("probes")
+	for i := 0; i < 3; i++ {
+		call(t, b, errDown)
+	}
+	clock.t = clock.t.Add(time.Second)
+	if b.State() != HalfOpen {
+		t.Fatalf("state = %v after the open timeout, want half-open", b.State())
+	}
+
+	// Only one probe at a time; a failed probe reopens the breaker.
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("second probe error = %v, want %v", err, ErrOpen)
+	}
+	done(errDown)
+	if b.State() != Open {
+		t.Fatalf("state = %v after a failed probe, want open", b.State())
+	}
+
+	clock.t = clock.t.Add(time.Second)
+	if err := call(t, b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.State() != Closed {
+		t.Errorf("state = %v after a successful probe, want closed", b.State())
+	}
+}
+
+func TestInterceptorFailsFast(t *testing.T) {
+	b, _ := newTestBreaker("interceptor")
+	intercept := b.UnaryClientInterceptor()
+	calls := 0
+	invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		calls++
+		return errDown
+	}
+	for i := 0; i < 5; i++ {
+		err := intercept(context.Background(), "/hipstershop.CurrencyService/Convert", nil, nil, nil, invoker)
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("call %d error = %v, want code %v", i, err, codes.Unavailable)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("invoked the target %d times, want 3", calls)
+	}
+}
+
+type fakeStream struct {
+	grpc.ClientStream
+	err error
+}
+
+func (s fakeStream) RecvMsg(interface{}) error { return s.err }
+
+func TestStreamInterceptorReportsStreamEnd(t *testing.T) {
+	b, _ := newTestBreaker("stream")
+	intercept := b.StreamClientInterceptor()
+	streams := 0
+	open := func(streamErr error) error {
+		t.Helper()
+		streamer := func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+			streams++
+			return fakeStream{err: streamErr}, nil
+		}
+		s, err := intercept(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/hipstershop.CheckoutService/PlaceOrderStream", streamer)
+		if err != nil {
+			return err
+		}
+		if err := s.RecvMsg(nil); err != streamErr {
+			t.Fatalf("RecvMsg() = %v, want %v", err, streamErr)
+		}
+		s.RecvMsg(nil) // reported once only
+		return nil
+	}
+
+	open(errDown)
+	open(errDown)
+	open(io.EOF) // a finished stream resets the count
+	for i := 0; i < 3; i++ {
+		open(errDown)
+	}
+	if b.State() != Open {
+		t.Fatalf("state = %v after 3 failed streams, want open", b.State())
+	}
+	if err := open(io.EOF); status.Code(err) != codes.Unavailable {
+		t.Errorf("stream error = %v, want code %v", err, codes.Unavailable)
+	}
+	if streams != 6 {
+		t.Errorf("opened %d streams, want 6", streams)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package envconfig loads a service's configuration from environment
+// variables into a struct.
+package envconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load sets the fields of the struct v points to from the environment
+// variables their `env` tags name:
+//
+//	type config struct {
+//		Addr    string        `env:"CART_SERVICE_ADDR" required:"true"`
+//		Port    string        `env:"PORT" default:"7070"`
+//		Tracing bool          `env:"ENABLE_TRACING"`
+//		Timeout time.Duration `env:"CART_SERVICE_TIMEOUT" default:"2s"`
+//	}
+//
+// A variable that is unset or empty leaves its field at its `default` tag,
+// if it has one, and at the value it had otherwise; one tagged
+// `required:"true"` is an error. Fields may be strings, bools, ints, uints,
+// floats or time.Durations, and nested structs are loaded the same way.
+// Every variable that is missing or invalid is reported in the error.
+func Load(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("envconfig: want a pointer to a struct, got %T", v)
+	}
+	return load(rv.Elem())
+}
+
+func load(rv reflect.Value) error {
+	var errs []error
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f, fv := rt.Field(i), rv.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, ok := f.Tag.Lookup("env")
+		if !ok {
+			if f.Type.Kind() == reflect.Struct && f.Type != durationType {
+				errs = append(errs, load(fv))
+			}
+			continue
+		}
+		s := os.Getenv(name)
+		if s == "" {
+			if f.Tag.Get("required") == "true" {
+				errs = append(errs, fmt.Errorf("environment variable %q not set", name))
+				continue
+			}
+			if s, ok = f.Tag.Lookup("default"); !ok {
+				continue
+			}
+		}
+		if err := set(fv, s); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q: %w", name, s, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// set parses s into v according to v's type.
+func set(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envconfig
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Addr    string        `env:"TEST_SERVICE_ADDR" required:"true"`
+	Port    string        `env:"TEST_PORT" default:"7070"`
+	Tracing bool          `env:"TEST_ENABLE_TRACING"`
+	Retries int           `env:"TEST_RETRIES"`
+	Ratio   float64       `env:"TEST_RATIO"`
+	Timeout time.Duration `env:"TEST_TIMEOUT" default:"2s"`
+	Store   struct {
+		Path string `env:"TEST_STORE_PATH"`
+	}
+	Untagged string
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv("TEST_SERVICE_ADDR", "cart:7070")
+	t.Setenv("TEST_ENABLE_TRACING", "1")
+	t.Setenv("TEST_RETRIES", "3")
+	t.Setenv("TEST_RATIO", "0.5")
+	t.Setenv("TEST_TIMEOUT", "")
+	t.Setenv("TEST_STORE_PATH", "/var/lib/store")
+
+	cfg := testConfig{Retries: 1, Untagged: "kept"}
+	if err := Load(&cfg); err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	want := testConfig{Addr: "cart:7070", Port: "7070", Tracing: true, Retries: 3, Ratio: 0.5, Timeout: 2 * time.Second, Untagged: "kept"}
+	want.Store.Path = "/var/lib/store"
+	if cfg != want {
+		t.Errorf("Load() = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadKeepsValueWhenUnset(t *testing.T) {
+	t.Setenv("TEST_SERVICE_ADDR", "cart:7070")
+	t.Setenv("TEST_PORT", "")
+	t.Setenv("TEST_RETRIES", "")
+
+	cfg := testConfig{Retries: 5}
+	if err := Load(&cfg); err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.Retries != 5 {
+		t.Errorf("Retries = %d, want the 5 it had", cfg.Retries)
+	}
+	if cfg.Port != "7070" {
+		t.Errorf("Port = %q, want default 7070", cfg.Port)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Setenv("TEST_SERVICE_ADDR", "")
+	t.Setenv("TEST_ENABLE_TRACING", "maybe")
+	t.Setenv("TEST_TIMEOUT", "soon")
+
+	var cfg testConfig
+	err := Load(&cfg)
+	if err == nil {
+		t.Fatal("Load() succeeded, want an error")
+	}
+	for _, want := range []string{`"TEST_SERVICE_ADDR" not set`, "TEST_ENABLE_TRACING", "TEST_TIMEOUT"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Load() error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestLoadNotStructPointer(t *testing.T) {
+	var cfg testConfig
+	if err := Load(cfg); err == nil {
+		t.Error("Load() of a struct value succeeded, want an error")
+	}
+	var s string
+	if err := Load(&s); err == nil {
+		t.Error("Load() of a string pointer succeeded, want an error")
+	}
+}
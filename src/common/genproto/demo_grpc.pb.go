@@ -313,9 +313,12 @@ var RecommendationService_ServiceDesc = grpc.ServiceDesc{
 }
 
 const (
-	ProductCatalogService_ListProducts_FullMethodName   = "/hipstershop.ProductCatalogService/ListProducts"
-	ProductCatalogService_GetProduct_FullMethodName     = "/hipstershop.ProductCatalogService/GetProduct"
-	ProductCatalogService_SearchProducts_FullMethodName = "/hipstershop.ProductCatalogService/SearchProducts"
+	ProductCatalogService_ListProducts_FullMethodName       = "/hipstershop.ProductCatalogService/ListProducts"
+	ProductCatalogService_GetProduct_FullMethodName         = "/hipstershop.ProductCatalogService/GetProduct"
+	ProductCatalogService_SearchProducts_FullMethodName     = "/hipstershop.ProductCatalogService/SearchProducts"
+	ProductCatalogService_ReserveStock_FullMethodName       = "/hipstershop.ProductCatalogService/ReserveStock"
+	ProductCatalogService_CommitReservation_FullMethodName  = "/hipstershop.ProductCatalogService/CommitReservation"
+	ProductCatalogService_ReleaseReservation_FullMethodName = "/hipstershop.ProductCatalogService/ReleaseReservation"
 )
 
 // ProductCatalogServiceClient is the client API for ProductCatalogService service.
@@ -325,6 +328,16 @@ type ProductCatalogServiceClient interface {
 	ListProducts(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ListProductsResponse, error)
 	GetProduct(ctx context.Context, in *GetProductRequest, opts ...grpc.CallOption) (*Product, error)
 	SearchProducts(ctx context.Context, in *SearchProductsRequest, opts ...grpc.CallOption) (*SearchProductsResponse, error)
+	// ReserveStock holds back stock for every item, or for none of them if
+	// any is short. A reservation lasts until it is committed, released or
+	// its TTL runs out.
+	ReserveStock(ctx context.Context, in *ReserveStockRequest, opts ...grpc.CallOption) (*ReserveStockResponse, error)
+	// CommitReservation turns a reservation into a permanent decrement of
+	// stock.
+	CommitReservation(ctx context.Context, in *CommitReservationRequest, opts ...grpc.CallOption) (*Empty, error)
+	// ReleaseReservation returns reserved stock. Releasing an unknown or
+	// expired reservation is not an error.
+	ReleaseReservation(ctx context.Context, in *ReleaseReservationRequest, opts ...grpc.CallOption) (*Empty, error)
 }
 
 type productCatalogServiceClient struct {
@@ -365,6 +378,36 @@ func (c *productCatalogServiceClient) SearchProducts(ctx context.Context, in *Se
 	return out, nil
 }
 
+func (c *productCatalogServiceClient) ReserveStock(ctx context.Context, in *ReserveStockRequest, opts ...grpc.CallOption) (*ReserveStockResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReserveStockResponse)
+	err := c.cc.Invoke(ctx, ProductCatalogService_ReserveStock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *productCatalogServiceClient) CommitReservation(ctx context.Context, in *CommitReservationRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, ProductCatalogService_CommitReservation_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *productCatalogServiceClient) ReleaseReservation(ctx context.Context, in *ReleaseReservationRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, ProductCatalogService_ReleaseReservation_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ProductCatalogServiceServer is the server API for ProductCatalogService service.
 // All implementations must embed UnimplementedProductCatalogServiceServer
 // for forward compatibility.
@@ -372,6 +415,16 @@ type ProductCatalogServiceServer interface {
 	ListProducts(context.Context, *Empty) (*ListProductsResponse, error)
 	GetProduct(context.Context, *GetProductRequest) (*Product, error)
 	SearchProducts(context.Context, *SearchProductsRequest) (*SearchProductsResponse, error)
+	// ReserveStock holds back stock for every item, or for none of them if
+	// any is short. A reservation lasts until it is committed, released or
+	// its TTL runs out.
+	ReserveStock(context.Context, *ReserveStockRequest) (*ReserveStockResponse, error)
+	// CommitReservation turns a reservation into a permanent decrement of
+	// stock.
+	CommitReservation(context.Context, *CommitReservationRequest) (*Empty, error)
+	// ReleaseReservation returns reserved stock. Releasing an unknown or
+	// expired reservation is not an error.
+	ReleaseReservation(context.Context, *ReleaseReservationRequest) (*Empty, error)
 	mustEmbedUnimplementedProductCatalogServiceServer()
 }
 
@@ -391,6 +444,15 @@ func (UnimplementedProductCatalogServiceServer) GetProduct(context.Context, *Get
 func (UnimplementedProductCatalogServiceServer) SearchProducts(context.Context, *SearchProductsRequest) (*SearchProductsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SearchProducts not implemented")
 }
+func (UnimplementedProductCatalogServiceServer) ReserveStock(context.Context, *ReserveStockRequest) (*ReserveStockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReserveStock not implemented")
+}
+func (UnimplementedProductCatalogServiceServer) CommitReservation(context.Context, *CommitReservationRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CommitReservation not implemented")
+}
+func (UnimplementedProductCatalogServiceServer) ReleaseReservation(context.Context, *ReleaseReservationRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseReservation not implemented")
+}
 func (UnimplementedProductCatalogServiceServer) mustEmbedUnimplementedProductCatalogServiceServer() {}
 func (UnimplementedProductCatalogServiceServer) testEmbeddedByValue()                               {}
 
@@ -466,6 +528,60 @@ func _ProductCatalogService_SearchProducts_Handler(srv interface{}, ctx context.
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ProductCatalogService_ReserveStock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReserveStockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProductCatalogServiceServer).ReserveStock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ProductCatalogService_ReserveStock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProductCatalogServiceServer).ReserveStock(ctx, req.(*ReserveStockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ProductCatalogService_CommitReservation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommitReservationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProductCatalogServiceServer).CommitReservation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ProductCatalogService_CommitReservation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProductCatalogServiceServer).CommitReservation(ctx, req.(*CommitReservationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ProductCatalogService_ReleaseReservation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseReservationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProductCatalogServiceServer).ReleaseReservation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ProductCatalogService_ReleaseReservation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProductCatalogServiceServer).ReleaseReservation(ctx, req.(*ReleaseReservationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ProductCatalogService_ServiceDesc is the grpc.ServiceDesc for ProductCatalogService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -485,6 +601,18 @@ var ProductCatalogService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SearchProducts",
 			Handler:    _ProductCatalogService_SearchProducts_Handler,
 		},
+		{
+			MethodName: "ReserveStock",
+			Handler:    _ProductCatalogService_ReserveStock_Handler,
+		},
+		{
+			MethodName: "CommitReservation",
+			Handler:    _ProductCatalogService_CommitReservation_Handler,
+		},
+		{
+			MethodName: "ReleaseReservation",
+			Handler:    _ProductCatalogService_ReleaseReservation_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "demo.proto",
@@ -772,6 +900,7 @@ var CurrencyService_ServiceDesc = grpc.ServiceDesc{
 
 const (
 	PaymentService_Charge_FullMethodName = "/hipstershop.PaymentService/Charge"
+	PaymentService_Refund_FullMethodName = "/hipstershop.PaymentService/Refund"
 )
 
 // PaymentServiceClient is the client API for PaymentService service.
@@ -779,6 +908,7 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type PaymentServiceClient interface {
 	Charge(ctx context.Context, in *ChargeRequest, opts ...grpc.CallOption) (*ChargeResponse, error)
+	Refund(ctx context.Context, in *RefundRequest, opts ...grpc.CallOption) (*RefundResponse, error)
 }
 
 type paymentServiceClient struct {
@@ -799,11 +929,22 @@ func (c *paymentServiceClient) Charge(ctx context.Context, in *ChargeRequest, op
 	return out, nil
 }
 
+func (c *paymentServiceClient) Refund(ctx context.Context, in *RefundRequest, opts ...grpc.CallOption) (*RefundResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RefundResponse)
+	err := c.cc.Invoke(ctx, PaymentService_Refund_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PaymentServiceServer is the server API for PaymentService service.
 // All implementations must embed UnimplementedPaymentServiceServer
 // for forward compatibility.
 type PaymentServiceServer interface {
 	Charge(context.Context, *ChargeRequest) (*ChargeResponse, error)
+	Refund(context.Context, *RefundRequest) (*RefundResponse, error)
 	mustEmbedUnimplementedPaymentServiceServer()
 }
 
@@ -817,6 +958,9 @@ type UnimplementedPaymentServiceServer struct{}
 func (UnimplementedPaymentServiceServer) Charge(context.Context, *ChargeRequest) (*ChargeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Charge not implemented")
 }
+func (UnimplementedPaymentServiceServer) Refund(context.Context, *RefundRequest) (*RefundResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Refund not implemented")
+}
 func (UnimplementedPaymentServiceServer) mustEmbedUnimplementedPaymentServiceServer() {}
 func (UnimplementedPaymentServiceServer) testEmbeddedByValue()                        {}
 
@@ -856,6 +1000,24 @@ func _PaymentService_Charge_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PaymentService_Refund_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefundRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PaymentServiceServer).Refund(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PaymentService_Refund_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PaymentServiceServer).Refund(ctx, req.(*RefundRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PaymentService_ServiceDesc is the grpc.ServiceDesc for PaymentService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -867,6 +1029,10 @@ var PaymentService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Charge",
 			Handler:    _PaymentService_Charge_Handler,
 		},
+		{
+			MethodName: "Refund",
+			Handler:    _PaymentService_Refund_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "demo.proto",
@@ -975,7 +1141,14 @@ var EmailService_ServiceDesc = grpc.ServiceDesc{
 }
 
 const (
-	CheckoutService_PlaceOrder_FullMethodName = "/hipstershop.CheckoutService/PlaceOrder"
+	CheckoutService_PlaceOrder_FullMethodName         = "/hipstershop.CheckoutService/PlaceOrder"
+	CheckoutService_PlaceOrderStream_FullMethodName   = "/hipstershop.CheckoutService/PlaceOrderStream"
+	CheckoutService_PreviewOrder_FullMethodName       = "/hipstershop.CheckoutService/PreviewOrder"
+	CheckoutService_GetOrder_FullMethodName           = "/hipstershop.CheckoutService/GetOrder"
+	CheckoutService_ListOrders_FullMethodName         = "/hipstershop.CheckoutService/ListOrders"
+	CheckoutService_CancelOrder_FullMethodName        = "/hipstershop.CheckoutService/CancelOrder"
+	CheckoutService_GetLoyaltyBalance_FullMethodName  = "/hipstershop.CheckoutService/GetLoyaltyBalance"
+	CheckoutService_ListLoyaltyEntries_FullMethodName = "/hipstershop.CheckoutService/ListLoyaltyEntries"
 )
 
 // CheckoutServiceClient is the client API for CheckoutService service.
@@ -983,6 +1156,21 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type CheckoutServiceClient interface {
 	PlaceOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*PlaceOrderResponse, error)
+	// Places an order like PlaceOrder, sending an event as each stage of
+	// checkout starts, succeeds or fails. A placed order ends the stream
+	// with a PLACE_ORDER_STAGE_COMPLETE event carrying it; a failed one
+	// with the error PlaceOrder would have returned.
+	PlaceOrderStream(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PlaceOrderProgress], error)
+	// Prices the user's cart the way PlaceOrder would, without placing the
+	// order, and returns a quote token PlaceOrder can be held to.
+	PreviewOrder(ctx context.Context, in *PreviewOrderRequest, opts ...grpc.CallOption) (*PreviewOrderResponse, error)
+	GetOrder(ctx context.Context, in *GetOrderRequest, opts ...grpc.CallOption) (*GetOrderResponse, error)
+	ListOrders(ctx context.Context, in *ListOrdersRequest, opts ...grpc.CallOption) (*ListOrdersResponse, error)
+	CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*CancelOrderResponse, error)
+	// Returns the loyalty points a user has to redeem and has yet to.
+	GetLoyaltyBalance(ctx context.Context, in *GetLoyaltyBalanceRequest, opts ...grpc.CallOption) (*GetLoyaltyBalanceResponse, error)
+	// Lists the points a user earned and redeemed, most recent first.
+	ListLoyaltyEntries(ctx context.Context, in *ListLoyaltyEntriesRequest, opts ...grpc.CallOption) (*ListLoyaltyEntriesResponse, error)
 }
 
 type checkoutServiceClient struct {
@@ -1003,11 +1191,105 @@ func (c *checkoutServiceClient) PlaceOrder(ctx context.Context, in *PlaceOrderRe
 	return out, nil
 }
 
+func (c *checkoutServiceClient) PlaceOrderStream(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[PlaceOrderProgress], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CheckoutService_ServiceDesc.Streams[0], CheckoutService_PlaceOrderStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PlaceOrderRequest, PlaceOrderProgress]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CheckoutService_PlaceOrderStreamClient = grpc.ServerStreamingClient[PlaceOrderProgress]
+
+func (c *checkoutServiceClient) PreviewOrder(ctx context.Context, in *PreviewOrderRequest, opts ...grpc.CallOption) (*PreviewOrderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PreviewOrderResponse)
+	err := c.cc.Invoke(ctx, CheckoutService_PreviewOrder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *checkoutServiceClient) GetOrder(ctx context.Context, in *GetOrderRequest, opts ...grpc.CallOption) (*GetOrderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetOrderResponse)
+	err := c.cc.Invoke(ctx, CheckoutService_GetOrder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *checkoutServiceClient) ListOrders(ctx context.Context, in *ListOrdersRequest, opts ...grpc.CallOption) (*ListOrdersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListOrdersResponse)
+	err := c.cc.Invoke(ctx, CheckoutService_ListOrders_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *checkoutServiceClient) CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*CancelOrderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CancelOrderResponse)
+	err := c.cc.Invoke(ctx, CheckoutService_CancelOrder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *checkoutServiceClient) GetLoyaltyBalance(ctx context.Context, in *GetLoyaltyBalanceRequest, opts ...grpc.CallOption) (*GetLoyaltyBalanceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetLoyaltyBalanceResponse)
+	err := c.cc.Invoke(ctx, CheckoutService_GetLoyaltyBalance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *checkoutServiceClient) ListLoyaltyEntries(ctx context.Context, in *ListLoyaltyEntriesRequest, opts ...grpc.CallOption) (*ListLoyaltyEntriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListLoyaltyEntriesResponse)
+	err := c.cc.Invoke(ctx, CheckoutService_ListLoyaltyEntries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CheckoutServiceServer is the server API for CheckoutService service.
 // All implementations must embed UnimplementedCheckoutServiceServer
 // for forward compatibility.
 type CheckoutServiceServer interface {
 	PlaceOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error)
+	// Places an order like PlaceOrder, sending an event as each stage of
+	// checkout starts, succeeds or fails. A placed order ends the stream
+	// with a PLACE_ORDER_STAGE_COMPLETE event carrying it; a failed one
+	// with the error PlaceOrder would have returned.
+	PlaceOrderStream(*PlaceOrderRequest, grpc.ServerStreamingServer[PlaceOrderProgress]) error
+	// Prices the user's cart the way PlaceOrder would, without placing the
+	// order, and returns a quote token PlaceOrder can be held to.
+	PreviewOrder(context.Context, *PreviewOrderRequest) (*PreviewOrderResponse, error)
+	GetOrder(context.Context, *GetOrderRequest) (*GetOrderResponse, error)
+	ListOrders(context.Context, *ListOrdersRequest) (*ListOrdersResponse, error)
+	CancelOrder(context.Context, *CancelOrderRequest) (*CancelOrderResponse, error)
+	// Returns the loyalty points a user has to redeem and has yet to.
+	GetLoyaltyBalance(context.Context, *GetLoyaltyBalanceRequest) (*GetLoyaltyBalanceResponse, error)
+	// Lists the points a user earned and redeemed, most recent first.
+	ListLoyaltyEntries(context.Context, *ListLoyaltyEntriesRequest) (*ListLoyaltyEntriesResponse, error)
 	mustEmbedUnimplementedCheckoutServiceServer()
 }
 
@@ -1021,6 +1303,27 @@ type UnimplementedCheckoutServiceServer struct{}
 func (UnimplementedCheckoutServiceServer) PlaceOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PlaceOrder not implemented")
 }
+func (UnimplementedCheckoutServiceServer) PlaceOrderStream(*PlaceOrderRequest, grpc.ServerStreamingServer[PlaceOrderProgress]) error {
+	return status.Errorf(codes.Unimplemented, "method PlaceOrderStream not implemented")
+}
+func (UnimplementedCheckoutServiceServer) PreviewOrder(context.Context, *PreviewOrderRequest) (*PreviewOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PreviewOrder not implemented")
+}
+func (UnimplementedCheckoutServiceServer) GetOrder(context.Context, *GetOrderRequest) (*GetOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOrder not implemented")
+}
+func (UnimplementedCheckoutServiceServer) ListOrders(context.Context, *ListOrdersRequest) (*ListOrdersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListOrders not implemented")
+}
+func (UnimplementedCheckoutServiceServer) CancelOrder(context.Context, *CancelOrderRequest) (*CancelOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelOrder not implemented")
+}
+func (UnimplementedCheckoutServiceServer) GetLoyaltyBalance(context.Context, *GetLoyaltyBalanceRequest) (*GetLoyaltyBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLoyaltyBalance not implemented")
+}
+func (UnimplementedCheckoutServiceServer) ListLoyaltyEntries(context.Context, *ListLoyaltyEntriesRequest) (*ListLoyaltyEntriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLoyaltyEntries not implemented")
+}
 func (UnimplementedCheckoutServiceServer) mustEmbedUnimplementedCheckoutServiceServer() {}
 func (UnimplementedCheckoutServiceServer) testEmbeddedByValue()                         {}
 
@@ -1060,6 +1363,125 @@ func _CheckoutService_PlaceOrder_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CheckoutService_PlaceOrderStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PlaceOrderRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CheckoutServiceServer).PlaceOrderStream(m, &grpc.GenericServerStream[PlaceOrderRequest, PlaceOrderProgress]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CheckoutService_PlaceOrderStreamServer = grpc.ServerStreamingServer[PlaceOrderProgress]
+
+func _CheckoutService_PreviewOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PreviewOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckoutServiceServer).PreviewOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckoutService_PreviewOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckoutServiceServer).PreviewOrder(ctx, req.(*PreviewOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CheckoutService_GetOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckoutServiceServer).GetOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckoutService_GetOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckoutServiceServer).GetOrder(ctx, req.(*GetOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CheckoutService_ListOrders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListOrdersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckoutServiceServer).ListOrders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckoutService_ListOrders_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckoutServiceServer).ListOrders(ctx, req.(*ListOrdersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CheckoutService_CancelOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckoutServiceServer).CancelOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckoutService_CancelOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckoutServiceServer).CancelOrder(ctx, req.(*CancelOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CheckoutService_GetLoyaltyBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLoyaltyBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckoutServiceServer).GetLoyaltyBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckoutService_GetLoyaltyBalance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckoutServiceServer).GetLoyaltyBalance(ctx, req.(*GetLoyaltyBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CheckoutService_ListLoyaltyEntries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListLoyaltyEntriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckoutServiceServer).ListLoyaltyEntries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckoutService_ListLoyaltyEntries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckoutServiceServer).ListLoyaltyEntries(ctx, req.(*ListLoyaltyEntriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CheckoutService_ServiceDesc is the grpc.ServiceDesc for CheckoutService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -1071,6 +1493,302 @@ var CheckoutService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "PlaceOrder",
 			Handler:    _CheckoutService_PlaceOrder_Handler,
 		},
+		{
+			MethodName: "PreviewOrder",
+			Handler:    _CheckoutService_PreviewOrder_Handler,
+		},
+		{
+			MethodName: "GetOrder",
+			Handler:    _CheckoutService_GetOrder_Handler,
+		},
+		{
+			MethodName: "ListOrders",
+			Handler:    _CheckoutService_ListOrders_Handler,
+		},
+		{
+			MethodName: "CancelOrder",
+			Handler:    _CheckoutService_CancelOrder_Handler,
+		},
+		{
+			MethodName: "GetLoyaltyBalance",
+			Handler:    _CheckoutService_GetLoyaltyBalance_Handler,
+		},
+		{
+			MethodName: "ListLoyaltyEntries",
+			Handler:    _CheckoutService_ListLoyaltyEntries_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PlaceOrderStream",
+			Handler:       _CheckoutService_PlaceOrderStream_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "demo.proto",
+}
+
+const (
+	CheckoutAdminService_ListFailedDeliveries_FullMethodName   = "/hipstershop.CheckoutAdminService/ListFailedDeliveries"
+	CheckoutAdminService_ReplayFailedDeliveries_FullMethodName = "/hipstershop.CheckoutAdminService/ReplayFailedDeliveries"
+	CheckoutAdminService_IssueBalance_FullMethodName           = "/hipstershop.CheckoutAdminService/IssueBalance"
+	CheckoutAdminService_GetBalance_FullMethodName             = "/hipstershop.CheckoutAdminService/GetBalance"
+	CheckoutAdminService_ListWebhookDeliveries_FullMethodName  = "/hipstershop.CheckoutAdminService/ListWebhookDeliveries"
+)
+
+// CheckoutAdminServiceClient is the client API for CheckoutAdminService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// CheckoutAdminService lets operators inspect and retry the order messages,
+// such as confirmation emails, that checkout failed to deliver.
+type CheckoutAdminServiceClient interface {
+	ListFailedDeliveries(ctx context.Context, in *ListFailedDeliveriesRequest, opts ...grpc.CallOption) (*ListFailedDeliveriesResponse, error)
+	ReplayFailedDeliveries(ctx context.Context, in *ReplayFailedDeliveriesRequest, opts ...grpc.CallOption) (*ReplayFailedDeliveriesResponse, error)
+	// Adds to the balance of a gift card or a user's store credit, opening
+	// it if needed.
+	IssueBalance(ctx context.Context, in *IssueBalanceRequest, opts ...grpc.CallOption) (*IssueBalanceResponse, error)
+	GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*GetBalanceResponse, error)
+	// Lists attempts to deliver order webhooks, most recent first.
+	ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (*ListWebhookDeliveriesResponse, error)
+}
+
+type checkoutAdminServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCheckoutAdminServiceClient(cc grpc.ClientConnInterface) CheckoutAdminServiceClient {
+	return &checkoutAdminServiceClient{cc}
+}
+
+func (c *checkoutAdminServiceClient) ListFailedDeliveries(ctx context.Context, in *ListFailedDeliveriesRequest, opts ...grpc.CallOption) (*ListFailedDeliveriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListFailedDeliveriesResponse)
+	err := c.cc.Invoke(ctx, CheckoutAdminService_ListFailedDeliveries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *checkoutAdminServiceClient) ReplayFailedDeliveries(ctx context.Context, in *ReplayFailedDeliveriesRequest, opts ...grpc.CallOption) (*ReplayFailedDeliveriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReplayFailedDeliveriesResponse)
+	err := c.cc.Invoke(ctx, CheckoutAdminService_ReplayFailedDeliveries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *checkoutAdminServiceClient) IssueBalance(ctx context.Context, in *IssueBalanceRequest, opts ...grpc.CallOption) (*IssueBalanceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IssueBalanceResponse)
+	err := c.cc.Invoke(ctx, CheckoutAdminService_IssueBalance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *checkoutAdminServiceClient) GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*GetBalanceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetBalanceResponse)
+	err := c.cc.Invoke(ctx, CheckoutAdminService_GetBalance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *checkoutAdminServiceClient) ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (*ListWebhookDeliveriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListWebhookDeliveriesResponse)
+	err := c.cc.Invoke(ctx, CheckoutAdminService_ListWebhookDeliveries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CheckoutAdminServiceServer is the server API for CheckoutAdminService service.
+// All implementations must embed UnimplementedCheckoutAdminServiceServer
+// for forward compatibility.
+//
+// CheckoutAdminService lets operators inspect and retry the order messages,
+// such as confirmation emails, that checkout failed to deliver.
+type CheckoutAdminServiceServer interface {
+	ListFailedDeliveries(context.Context, *ListFailedDeliveriesRequest) (*ListFailedDeliveriesResponse, error)
+	ReplayFailedDeliveries(context.Context, *ReplayFailedDeliveriesRequest) (*ReplayFailedDeliveriesResponse, error)
+	// Adds to the balance of a gift card or a user's store credit, opening
+	// it if needed.
+	IssueBalance(context.Context, *IssueBalanceRequest) (*IssueBalanceResponse, error)
+	GetBalance(context.Context, *GetBalanceRequest) (*GetBalanceResponse, error)
+	// Lists attempts to deliver order webhooks, most recent first.
+	ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesRequest) (*ListWebhookDeliveriesResponse, error)
+	mustEmbedUnimplementedCheckoutAdminServiceServer()
+}
+
+// UnimplementedCheckoutAdminServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCheckoutAdminServiceServer struct{}
+
+func (UnimplementedCheckoutAdminServiceServer) ListFailedDeliveries(context.Context, *ListFailedDeliveriesRequest) (*ListFailedDeliveriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFailedDeliveries not implemented")
+}
+func (UnimplementedCheckoutAdminServiceServer) ReplayFailedDeliveries(context.Context, *ReplayFailedDeliveriesRequest) (*ReplayFailedDeliveriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplayFailedDeliveries not implemented")
+}
+func (UnimplementedCheckoutAdminServiceServer) IssueBalance(context.Context, *IssueBalanceRequest) (*IssueBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IssueBalance not implemented")
+}
+func (UnimplementedCheckoutAdminServiceServer) GetBalance(context.Context, *GetBalanceRequest) (*GetBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBalance not implemented")
+}
+func (UnimplementedCheckoutAdminServiceServer) ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesRequest) (*ListWebhookDeliveriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWebhookDeliveries not implemented")
+}
+func (UnimplementedCheckoutAdminServiceServer) mustEmbedUnimplementedCheckoutAdminServiceServer() {}
+func (UnimplementedCheckoutAdminServiceServer) testEmbeddedByValue()                              {}
+
+// UnsafeCheckoutAdminServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CheckoutAdminServiceServer will
+// result in compilation errors.
+type UnsafeCheckoutAdminServiceServer interface {
+	mustEmbedUnimplementedCheckoutAdminServiceServer()
+}
+
+func RegisterCheckoutAdminServiceServer(s grpc.ServiceRegistrar, srv CheckoutAdminServiceServer) {
+	// If the following call pancis, it indicates UnimplementedCheckoutAdminServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&CheckoutAdminService_ServiceDesc, srv)
+}
+
+func _CheckoutAdminService_ListFailedDeliveries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFailedDeliveriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckoutAdminServiceServer).ListFailedDeliveries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckoutAdminService_ListFailedDeliveries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckoutAdminServiceServer).ListFailedDeliveries(ctx, req.(*ListFailedDeliveriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CheckoutAdminService_ReplayFailedDeliveries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplayFailedDeliveriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckoutAdminServiceServer).ReplayFailedDeliveries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckoutAdminService_ReplayFailedDeliveries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckoutAdminServiceServer).ReplayFailedDeliveries(ctx, req.(*ReplayFailedDeliveriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CheckoutAdminService_IssueBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IssueBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckoutAdminServiceServer).IssueBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckoutAdminService_IssueBalance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckoutAdminServiceServer).IssueBalance(ctx, req.(*IssueBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CheckoutAdminService_GetBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckoutAdminServiceServer).GetBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckoutAdminService_GetBalance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckoutAdminServiceServer).GetBalance(ctx, req.(*GetBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CheckoutAdminService_ListWebhookDeliveries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhookDeliveriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CheckoutAdminServiceServer).ListWebhookDeliveries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CheckoutAdminService_ListWebhookDeliveries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CheckoutAdminServiceServer).ListWebhookDeliveries(ctx, req.(*ListWebhookDeliveriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CheckoutAdminService_ServiceDesc is the grpc.ServiceDesc for CheckoutAdminService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CheckoutAdminService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hipstershop.CheckoutAdminService",
+	HandlerType: (*CheckoutAdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListFailedDeliveries",
+			Handler:    _CheckoutAdminService_ListFailedDeliveries_Handler,
+		},
+		{
+			MethodName: "ReplayFailedDeliveries",
+			Handler:    _CheckoutAdminService_ReplayFailedDeliveries_Handler,
+		},
+		{
+			MethodName: "IssueBalance",
+			Handler:    _CheckoutAdminService_IssueBalance_Handler,
+		},
+		{
+			MethodName: "GetBalance",
+			Handler:    _CheckoutAdminService_GetBalance_Handler,
+		},
+		{
+			MethodName: "ListWebhookDeliveries",
+			Handler:    _CheckoutAdminService_ListWebhookDeliveries_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "demo.proto",
@@ -0,0 +1,112 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"errors"
+	"math/big"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+var ErrUnknownCurrency = errors.New("unknown currency code")
+
+// Currency is an ISO 4217 currency.
+type Currency struct {
+	Code    string
+	Numeric int
+	// MinorUnits is the number of decimals amounts in the currency have,
+	// e.g. 2 for cents.
+	MinorUnits int
+	Symbol     string
+}
+
+// currencies are the ISO 4217 currencies the shop may see: those the
+// currency service converts between and a few more with uncommon minor
+// units.
+var currencies = map[string]Currency{
+	"AED": {"AED", 784, 2, "د.إ"},
+	"AUD": {"AUD", 36, 2, "A$"},
+	"BGN": {"BGN", 975, 2, "лв"},
+	"BHD": {"BHD", 48, 3, ".د.ب"},
+	"BRL": {"BRL", 986, 2, "R$"},
+	"CAD": {"CAD", 124, 2, "$"},
+	"CHF": {"CHF", 756, 2, "CHF"},
+	"CLP": {"CLP", 152, 0, "$"},
+	"CNY": {"CNY", 156, 2, "¥"},
+	"CZK": {"CZK", 203, 2, "Kč"},
+	"DKK": {"DKK", 208, 2, "kr"},
+	"EUR": {"EUR", 978, 2, "€"},
+	"GBP": {"GBP", 826, 2, "£"},
+	"HKD": {"HKD", 344, 2, "HK$"},
+	"HRK": {"HRK", 191, 2, "kn"},
+	"HUF": {"HUF", 348, 2, "Ft"},
+	"IDR": {"IDR", 360, 2, "Rp"},
+	"ILS": {"ILS", 376, 2, "₪"},
+	"INR": {"INR", 356, 2, "₹"},
+	"ISK": {"ISK", 352, 0, "kr"},
+	"JOD": {"JOD", 400, 3, "د.ا"},
+	"JPY": {"JPY", 392, 0, "¥"},
+	"KRW": {"KRW", 410, 0, "₩"},
+	"KWD": {"KWD", 414, 3, "د.ك"},
+	"MXN": {"MXN", 484, 2, "$"},
+	"MYR": {"MYR", 458, 2, "RM"},
+	"NOK": {"NOK", 578, 2, "kr"},
+	"NZD": {"NZD", 554, 2, "NZ$"},
+	"OMR": {"OMR", 512, 3, "ر.ع."},
+	"PHP": {"PHP", 608, 2, "₱"},
+	"PLN": {"PLN", 985, 2, "zł"},
+	"RON": {"RON", 946, 2, "lei"},
+	"RUB": {"RUB", 643, 2, "₽"},
+	"SEK": {"SEK", 752, 2, "kr"},
+	"SGD": {"SGD", 702, 2, "S$"},
+	"THB": {"THB", 764, 2, "฿"},
+	"TND": {"TND", 788, 3, "د.ت"},
+	"TRY": {"TRY", 949, 2, "₺"},
+	"USD": {"USD", 840, 2, "$"},
+	"VND": {"VND", 704, 0, "₫"},
+	"ZAR": {"ZAR", 710, 2, "R"},
+}
+
+// LookupCurrency returns the currency with the given ISO 4217 code.
+func LookupCurrency(code string) (Currency, bool) {
+	c, ok := currencies[code]
+	return c, ok
+}
+
+// Round returns m rounded with mode to the minor units of its currency.
+// Returns ErrInvalidValue if m is invalid, ErrUnknownCurrency if its
+// currency is not known and ErrOverflow if rounding up does not fit.
+func Round(m *pb.Money, mode RoundingMode) (*pb.Money, error) {
+	if !IsValid(m) {
+		return nil, ErrInvalidValue
+	}
+	c, ok := LookupCurrency(m.GetCurrencyCode())
+	if !ok {
+		return nil, ErrUnknownCurrency
+	}
+	step := minorUnit(c)
+	v := quo(nanos(m), step, mode)
+	out, ok := fromNanos(v.Mul(v, step), m.GetCurrencyCode())
+	if !ok {
+		return nil, ErrOverflow
+	}
+	return out, nil
+}
+
+// minorUnit returns the nanos in one minor unit of c.
+func minorUnit(c Currency) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(9-c.MinorUnits)), nil)
+}
@@ -0,0 +1,82 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+func TestCurrencies(t *testing.T) {
+	numerics := make(map[int]string)
+	for code, c := range currencies {
+		if c.Code != code {
+			t.Errorf("currency %s has code %s", code, c.Code)
+		}
+		if c.MinorUnits < 0 || c.MinorUnits > 3 {
+			t.Errorf("currency %s has %d minor units", code, c.MinorUnits)
+		}
+		if other, ok := numerics[c.Numeric]; ok {
+			t.Errorf("currencies %s and %s have numeric code %d", code, other, c.Numeric)
+		}
+		numerics[c.Numeric] = code
+	}
+	if c, ok := LookupCurrency("JPY"); !ok || c.MinorUnits != 0 || c.Numeric != 392 {
+		t.Errorf("LookupCurrency(JPY) = %+v, %v", c, ok)
+	}
+	if _, ok := LookupCurrency("XXX"); ok {
+		t.Error("LookupCurrency(XXX) found a currency")
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      *pb.Money
+		mode    RoundingMode
+		want    *pb.Money
+		wantErr error
+	}{
+		{"cents half up", mmc(1, 5000000, "USD"), RoundHalfUp, mmc(1, 10000000, "USD"), nil},
+		{"cents half even", mmc(1, 5000000, "USD"), RoundHalfEven, mmc(1, 0, "USD"), nil},
+		{"cents half even up", mmc(1, 15000000, "USD"), RoundHalfEven, mmc(1, 20000000, "USD"), nil},
+		{"cents towards zero", mmc(1, 19999999, "USD"), RoundDown, mmc(1, 10000000, "USD"), nil},
+		{"negative cents half up", mmc(-1, -5000000, "USD"), RoundHalfUp, mmc(-1, -10000000, "USD"), nil},
+		{"negative cents towards zero", mmc(-1, -19999999, "USD"), RoundDown, mmc(-1, -10000000, "USD"), nil},
+		{"yen", mmc(2345, 678000000, "JPY"), RoundHalfEven, mmc(2346, 0, "JPY"), nil},
+		{"yen half even", mmc(2344, 500000000, "JPY"), RoundHalfEven, mmc(2344, 0, "JPY"), nil},
+		{"yen towards zero", mmc(2345, 678000000, "JPY"), RoundDown, mmc(2345, 0, "JPY"), nil},
+		{"fils", mmc(12, 345500000, "KWD"), RoundHalfEven, mmc(12, 346000000, "KWD"), nil},
+		{"already rounded", mmc(19, 990000000, "EUR"), RoundUp, mmc(19, 990000000, "EUR"), nil},
+		{"Error: unknown currency", mmc(1, 5000000, "XXX"), RoundHalfUp, nil, ErrUnknownCurrency},
+		{"Error: no currency", mm(1, 5000000), RoundHalfUp, nil, ErrUnknownCurrency},
+		{"Error: invalid", mmc(1, -1, "USD"), RoundHalfUp, nil, ErrInvalidValue},
+		{"Error: overflow", mmc(math.MaxInt64, 999999999, "JPY"), RoundHalfUp, nil, ErrOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Round(tt.in, tt.mode)
+			if err != tt.wantErr {
+				t.Errorf("Round([%v], %d): expected err=\"%v\" got=\"%v\"", tt.in, tt.mode, tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Round([%v], %d) = %v, want %v", tt.in, tt.mode, got, tt.want)
+			}
+		})
+	}
+}
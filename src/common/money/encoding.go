@@ -0,0 +1,244 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// Money is an amount of a currency that encodes as a decimal string, such
+// as "19.99 USD", without losing any of its nanos. It converts to and from
+// pb.Money for the services.
+//
+// In JSON it is written as "19.99 USD" and read from that or from
+// {"amount": "19.99", "currency": "USD"}. In a database it is stored as
+// the amount alone, for NUMERIC columns, and scanning one leaves Currency
+// as it was.
+type Money struct {
+	Units    int64
+	Nanos    int32
+	Currency string
+}
+
+// FromProto returns m as a Money. A nil m is zero with no currency.
+func FromProto(m *pb.Money) Money {
+	return Money{Units: m.GetUnits(), Nanos: m.GetNanos(), Currency: m.GetCurrencyCode()}
+}
+
+// Proto returns m as a pb.Money.
+func (m Money) Proto() *pb.Money {
+	return &pb.Money{Units: m.Units, Nanos: m.Nanos, CurrencyCode: m.Currency}
+}
+
+// valid reports whether m is valid, as IsValid does for pb.Money.
+func (m Money) valid() bool {
+	return validNanos(m.Nanos) && (m.Nanos == 0 || m.Units == 0 || (m.Nanos < 0) == (m.Units < 0))
+}
+
+// Amount returns the amount of m as a decimal, with at least the minor
+// units of its currency and no trailing zeros past them, e.g. "19.99",
+// "-0.5" or "2346".
+func (m Money) Amount() string {
+	units, nanos := strconv.FormatInt(m.Units, 10), m.Nanos
+	negative := m.Units < 0 || nanos < 0
+	if nanos < 0 {
+		nanos = -nanos
+	}
+	if negative && m.Units == 0 {
+		units = "-0"
+	}
+	frac := strings.TrimRight(strconv.FormatInt(int64(nanos)+nanosMod, 10)[1:], "0")
+	if c, ok := LookupCurrency(m.Currency); ok && len(frac) < c.MinorUnits {
+		frac += strings.Repeat("0", c.MinorUnits-len(frac))
+	}
+	if frac == "" {
+		return units
+	}
+	return units + "." + frac
+}
+
+// String returns m as its amount and currency, e.g. "19.99 USD".
+func (m Money) String() string {
+	if m.Currency == "" {
+		return m.Amount()
+	}
+	return m.Amount() + " " + m.Currency
+}
+
+// ParseMoney reads a Money written as String does. The currency is
+// optional. Returns ErrInvalidValue if s is not such a value, including
+// amounts more precise than a nano, and ErrOverflow if the amount does not
+// fit.
+func ParseMoney(s string) (Money, error) {
+	amount, currency, _ := strings.Cut(strings.TrimSpace(s), " ")
+	if currency != "" && !isCurrencyCode(currency) {
+		return Money{}, ErrInvalidValue
+	}
+	units, nanos, err := parseDecimal(amount)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Units: units, Nanos: nanos, Currency: currency}, nil
+}
+
+// isCurrencyCode reports whether s looks like an ISO 4217 code. It need not
+// be one LookupCurrency knows.
+func isCurrencyCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDecimal reads a decimal such as "-19.99" as units and nanos. Digits
+// past the nanos must be zeros.
+func parseDecimal(s string) (int64, int32, error) {
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	whole, frac, dot := strings.Cut(s, ".")
+	if whole == "" && (!dot || frac == "") || !allDigits(whole) || !allDigits(frac) {
+		return 0, 0, ErrInvalidValue
+	}
+	if len(frac) > 9 {
+		if strings.Trim(frac[9:], "0") != "" {
+			return 0, 0, ErrInvalidValue
+		}
+		frac = frac[:9]
+	}
+
+	var units int64
+	if whole != "" {
+		if negative {
+			whole = "-" + whole
+		}
+		var err error
+		if units, err = strconv.ParseInt(whole, 10, 64); err != nil {
+			return 0, 0, ErrOverflow
+		}
+	}
+	// At most 9 digits, so this cannot fail.
+	nanos, _ := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 32)
+	if negative {
+		nanos = -nanos
+	}
+	return units, int32(nanos), nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m Money) MarshalText() ([]byte, error) {
+	if !m.valid() {
+		return nil, ErrInvalidValue
+	}
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (m *Money) UnmarshalText(text []byte) error {
+	v, err := ParseMoney(string(text))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m Money) MarshalJSON() ([]byte, error) {
+	text, err := m.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return m.UnmarshalText([]byte(s))
+	}
+	var obj struct {
+		// Amount may be a string or a number; either way its digits are
+		// read as they are written.
+		Amount   json.RawMessage `json:"amount"`
+		Currency string          `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	amount := string(obj.Amount)
+	if unquoted, err := strconv.Unquote(amount); err == nil {
+		amount = unquoted
+	}
+	if obj.Currency != "" && !isCurrencyCode(obj.Currency) {
+		return ErrInvalidValue
+	}
+	units, nanos, err := parseDecimal(amount)
+	if err != nil {
+		return err
+	}
+	*m = Money{Units: units, Nanos: nanos, Currency: obj.Currency}
+	return nil
+}
+
+// Value implements driver.Valuer, giving the amount as a decimal for a
+// NUMERIC column.
+func (m Money) Value() (driver.Value, error) {
+	if !m.valid() {
+		return nil, ErrInvalidValue
+	}
+	return m.Amount(), nil
+}
+
+// Scan implements sql.Scanner for NUMERIC columns. It sets the amount and
+// leaves the currency as it was.
+func (m *Money) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		// Drivers hand over some numeric columns as floats; the shortest
+		// decimal that reads back as v is the one that was stored.
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Errorf("cannot scan %T into money", src)
+	}
+	units, nanos, err := parseDecimal(s)
+	if err != nil {
+		return err
+	}
+	m.Units, m.Nanos = units, nanos
+	return nil
+}
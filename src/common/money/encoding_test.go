@@ -0,0 +1,251 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+var (
+	_ encoding.TextMarshaler   = Money{}
+	_ encoding.TextUnmarshaler = (*Money)(nil)
+	_ json.Marshaler           = Money{}
+	_ json.Unmarshaler         = (*Money)(nil)
+	_ driver.Valuer            = Money{}
+	_ sql.Scanner              = (*Money)(nil)
+)
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{Money{19, 990000000, "USD"}, "19.99 USD"},
+		{Money{5, 0, "USD"}, "5.00 USD"},
+		{Money{0, 1, "USD"}, "0.000000001 USD"},
+		{Money{-1, -500000000, "EUR"}, "-1.50 EUR"},
+		{Money{0, -500000000, "EUR"}, "-0.50 EUR"},
+		{Money{2346, 0, "JPY"}, "2346 JPY"},
+		{Money{12, 345000000, "KWD"}, "12.345 KWD"},
+		{Money{7, 250000000, ""}, "7.25"},
+		{Money{3, 0, "XTS"}, "3 XTS"},
+		{Money{math.MinInt64, -999999999, "USD"}, "-9223372036854775808.999999999 USD"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.in, got, tt.want)
+		}
+		got, err := ParseMoney(tt.want)
+		if err != nil || got != tt.in {
+			t.Errorf("ParseMoney(%q) = %#v, %v; want %#v", tt.want, got, err, tt.in)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr error
+	}{
+		{"19.990 USD", Money{19, 990000000, "USD"}, nil},
+		{" .5 EUR ", Money{0, 500000000, "EUR"}, nil},
+		{"+3.", Money{3, 0, ""}, nil},
+		{"1.0000000010000 USD", Money{1, 1, "USD"}, nil},
+		{"1.0000000001 USD", Money{}, ErrInvalidValue},
+		{"19.99 usd", Money{}, ErrInvalidValue},
+		{"19.99 USD extra", Money{}, ErrInvalidValue},
+		{"$19.99", Money{}, ErrInvalidValue},
+		{"1,000.00 USD", Money{}, ErrInvalidValue},
+		{"1e3 USD", Money{}, ErrInvalidValue},
+		{"- USD", Money{}, ErrInvalidValue},
+		{"", Money{}, ErrInvalidValue},
+		{"9223372036854775808 USD", Money{}, ErrOverflow},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if err != tt.wantErr || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %#v, %v; want %#v, %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	type product struct {
+		Price Money `json:"price"`
+	}
+	b, err := json.Marshal(product{Money{19, 990000000, "USD"}})
+	if err != nil || string(b) != `{"price":"19.99 USD"}` {
+		t.Errorf("json.Marshal() = %s, %v", b, err)
+	}
+	for _, in := range []string{
+		`{"price": "19.99 USD"}`,
+		`{"price": {"amount": "19.99", "currency": "USD"}}`,
+		`{"price": {"amount": 19.990, "currency": "USD"}}`,
+	} {
+		var p product
+		if err := json.Unmarshal([]byte(in), &p); err != nil || p.Price != (Money{19, 990000000, "USD"}) {
+			t.Errorf("json.Unmarshal(%s) = %#v, %v", in, p.Price, err)
+		}
+	}
+	for _, in := range []string{
+		`{"price": 19.99}`,
+		`{"price": {"amount": "19.99", "currency": "dollars"}}`,
+		`{"price": {"currency": "USD"}}`,
+		`{"price": {"amount": 1e3, "currency": "USD"}}`,
+	} {
+		var p product
+		if err := json.Unmarshal([]byte(in), &p); err == nil {
+			t.Errorf("json.Unmarshal(%s) = %#v, want error", in, p.Price)
+		}
+	}
+	if _, err := json.Marshal(Money{1, -1, "USD"}); err == nil {
+		t.Error("json.Marshal(invalid) succeeded")
+	}
+}
+
+func TestMoneySQL(t *testing.T) {
+	v, err := Money{-19, -990000000, "USD"}.Value()
+	if err != nil || v != "-19.99" {
+		t.Errorf("Value() = %v, %v; want -19.99", v, err)
+	}
+	for _, src := range []interface{}{"19.990000000000000000", []byte("19.99"), 19.99} {
+		m := Money{Currency: "USD"}
+		if err := m.Scan(src); err != nil || m != (Money{19, 990000000, "USD"}) {
+			t.Errorf("Scan(%#v) = %#v, %v", src, m, err)
+		}
+	}
+	m := Money{Units: 1, Currency: "JPY"}
+	if err := m.Scan(int64(2346)); err != nil || m != (Money{2346, 0, "JPY"}) {
+		t.Errorf("Scan(2346) = %#v, %v", m, err)
+	}
+	for _, src := range []interface{}{nil, "NaN", true, "0.0000000001"} {
+		if err := new(Money).Scan(src); err == nil {
+			t.Errorf("Scan(%#v) succeeded", src)
+		}
+	}
+}
+
+func TestMoneyProto(t *testing.T) {
+	p := &pb.Money{Units: -3, Nanos: -10, CurrencyCode: "EUR"}
+	if got := FromProto(p).Proto(); !proto.Equal(got, p) {
+		t.Errorf("FromProto(%v).Proto() = %v", p, got)
+	}
+	if got := FromProto(nil); got != (Money{}) {
+		t.Errorf("FromProto(nil) = %#v", got)
+	}
+}
+
+// fuzzMoney makes a valid Money of the fuzzer's values.
+func fuzzMoney(units int64, nanos int32, currency string) Money {
+	m := anyMoney(units, nanos)
+	if !isCurrencyCode(currency) {
+		currency = ""
+	}
+	return Money{m.GetUnits(), m.GetNanos(), currency}
+}
+
+func addMoneySeeds(f *testing.F) {
+	f.Add(int64(19), int32(990000000), "USD")
+	f.Add(int64(0), int32(-1), "EUR")
+	f.Add(int64(2346), int32(0), "JPY")
+	f.Add(int64(math.MaxInt64), int32(999999999), "KWD")
+	f.Add(int64(math.MinInt64), int32(-999999999), "")
+}
+
+func FuzzMoneyJSON(f *testing.F) {
+	addMoneySeeds(f)
+	f.Fuzz(func(t *testing.T, units int64, nanos int32, currency string) {
+		m := fuzzMoney(units, nanos, currency)
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Money
+		if err := json.Unmarshal(b, &got); err != nil || got != m {
+			t.Errorf("json round trip of %#v via %s = %#v, %v", m, b, got, err)
+		}
+		obj, _ := json.Marshal(map[string]string{"amount": m.Amount(), "currency": m.Currency})
+		if err := json.Unmarshal(obj, &got); err != nil || got != m {
+			t.Errorf("json round trip of %#v via %s = %#v, %v", m, obj, got, err)
+		}
+	})
+}
+
+func FuzzMoneyText(f *testing.F) {
+	addMoneySeeds(f)
+	f.Fuzz(func(t *testing.T, units int64, nanos int32, currency string) {
+		m := fuzzMoney(units, nanos, currency)
+		text, err := m.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Money
+		if err := got.UnmarshalText(text); err != nil || got != m {
+			t.Errorf("text round trip of %#v via %q = %#v, %v", m, text, got, err)
+		}
+		if p := FromProto(m.Proto()); p != m {
+			t.Errorf("proto round trip of %#v = %#v", m, p)
+		}
+	})
+}
+
+func FuzzMoneySQL(f *testing.F) {
+	addMoneySeeds(f)
+	f.Fuzz(func(t *testing.T, units int64, nanos int32, currency string) {
+		m := fuzzMoney(units, nanos, currency)
+		v, err := m.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := Money{Currency: m.Currency}
+		if err := got.Scan(v); err != nil || got != m {
+			t.Errorf("database round trip of %#v via %v = %#v, %v", m, v, got, err)
+		}
+		if err := got.Scan([]byte(v.(string))); err != nil || got != m {
+			t.Errorf("database round trip of %#v via []byte %v = %#v, %v", m, v, got, err)
+		}
+	})
+}
+
+func FuzzParseMoney(f *testing.F) {
+	for _, s := range []string{"19.99 USD", "-0.5", "1.000000000000 EUR", ".1", "9223372036854775807.999999999 KWD"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		m, err := ParseMoney(s)
+		if err != nil {
+			return
+		}
+		// Whatever parses is valid and reads back the same from its
+		// canonical form.
+		if !m.valid() {
+			t.Fatalf("ParseMoney(%q) = %#v, which is invalid", s, m)
+		}
+		got, err := ParseMoney(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseMoney(%q) = %#v, but its String %q reads as %#v, %v", s, m, m.String(), got, err)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// ExchangeRates is a snapshot of exchange rates for a service to convert
+// with when the currency service is unavailable or, if Primary, before
+// asking it at all.
+type ExchangeRates struct {
+	Table *RateTable
+	// Primary converts with the snapshot first, and only asks the currency
+	// service about currencies the snapshot has no rates for.
+	Primary bool
+}
+
+// ExchangeRatesFromEnv loads the snapshot at EXCHANGE_RATES_PATH, or
+// returns nil if that is not set. EXCHANGE_RATES_MODE is "fallback", the
+// default, or "primary".
+func ExchangeRatesFromEnv() (*ExchangeRates, error) {
+	p := os.Getenv("EXCHANGE_RATES_PATH")
+	if p == "" {
+		return nil, nil
+	}
+	t, err := LoadRates(p)
+	if err != nil {
+		return nil, err
+	}
+	r := &ExchangeRates{Table: t}
+	switch m := os.Getenv("EXCHANGE_RATES_MODE"); m {
+	case "", "fallback":
+	case "primary":
+		r.Primary = true
+	default:
+		return nil, fmt.Errorf("invalid EXCHANGE_RATES_MODE %q, want fallback or primary", m)
+	}
+	return r, nil
+}
+
+// Convert converts m to currency with the snapshot, rounded half to even,
+// or returns false if it has no rate for either currency.
+func (r *ExchangeRates) Convert(m *pb.Money, currency string) (*pb.Money, bool) {
+	out, err := r.Table.Convert(m, currency, RoundHalfEven)
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// CurrencyServiceUnavailable reports whether err from the currency service
+// means it could not be reached, rather than that it refused the request,
+// so that converting with ExchangeRates instead is right.
+func CurrencyServiceUnavailable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestExchangeRatesFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.xml")
+	if err := os.WriteFile(path, []byte(ecbRates), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		path, mode  string
+		want        bool
+		wantPrimary bool
+		wantErr     bool
+	}{
+		{"unset", "", "", false, false, false},
+		{"fallback by default", path, "", true, false, false},
+		{"fallback", path, "fallback", true, false, false},
+		{"primary", path, "primary", true, true, false},
+		{"Error: unknown mode", path, "always", false, false, true},
+		{"Error: missing file", filepath.Join(t.TempDir(), "missing.xml"), "", false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("EXCHANGE_RATES_PATH", tt.path)
+			t.Setenv("EXCHANGE_RATES_MODE", tt.mode)
+			got, err := ExchangeRatesFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExchangeRatesFromEnv() err = %v, want error: %v", err, tt.wantErr)
+			}
+			if (got != nil) != tt.want {
+				t.Fatalf("ExchangeRatesFromEnv() = %v, want rates: %v", got, tt.want)
+			}
+			if got != nil && got.Primary != tt.wantPrimary {
+				t.Errorf("ExchangeRatesFromEnv().Primary = %v, want %v", got.Primary, tt.wantPrimary)
+			}
+		})
+	}
+}
+
+func TestExchangeRatesConvert(t *testing.T) {
+	table, err := ParseRates([]byte(ecbRates))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &ExchangeRates{Table: table}
+	got, ok := r.Convert(mmc(10, 0, "EUR"), "USD")
+	if want := mmc(11, 305000000, "USD"); !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("Convert(10 EUR, USD) = %v, %v, want %v, true", got, ok, want)
+	}
+	if got, ok := r.Convert(mmc(10, 0, "EUR"), "CHF"); ok {
+		t.Errorf("Convert(10 EUR, CHF) = %v, true, want false", got)
+	}
+}
+
+func TestCurrencyServiceUnavailable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{status.Error(codes.Unavailable, "connection refused"), true},
+		{status.Error(codes.DeadlineExceeded, "deadline exceeded"), true},
+		{status.Error(codes.ResourceExhausted, "circuit open"), true},
+		{status.Error(codes.InvalidArgument, "unsupported currency"), false},
+		{errors.New("not a status"), false},
+	}
+	for _, tt := range tests {
+		if got := CurrencyServiceUnavailable(tt.err); got != tt.want {
+			t.Errorf("CurrencyServiceUnavailable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
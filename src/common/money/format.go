@@ -0,0 +1,173 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"strconv"
+	"strings"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// localeFormat is how a locale writes amounts of money.
+type localeFormat struct {
+	decimal, group string
+	// symbolAfter puts the currency symbol after the amount, and
+	// symbolSpace separates the two with a no-break space.
+	symbolAfter, symbolSpace bool
+}
+
+const (
+	nbsp       = "\u00a0"
+	narrowNbsp = "\u202f"
+)
+
+var defaultLocale = localeFormat{decimal: ".", group: ","}
+
+// locales are keyed by lower case BCP 47 tag; a language on its own is the
+// fallback for its regions.
+var locales = map[string]localeFormat{
+	"en":    defaultLocale,
+	"en-in": defaultLocale,
+	"ja":    defaultLocale,
+	"zh":    defaultLocale,
+	"ko":    defaultLocale,
+	"de":    {decimal: ",", group: ".", symbolAfter: true, symbolSpace: true},
+	"de-ch": {decimal: ".", group: "’", symbolSpace: true},
+	"es":    {decimal: ",", group: ".", symbolAfter: true, symbolSpace: true},
+	"fr":    {decimal: ",", group: narrowNbsp, symbolAfter: true, symbolSpace: true},
+	"it":    {decimal: ",", group: ".", symbolAfter: true, symbolSpace: true},
+	"nl":    {decimal: ",", group: ".", symbolSpace: true},
+	"pl":    {decimal: ",", group: nbsp, symbolAfter: true, symbolSpace: true},
+	"pt":    {decimal: ",", group: ".", symbolSpace: true},
+	"ru":    {decimal: ",", group: nbsp, symbolAfter: true, symbolSpace: true},
+	"sv":    {decimal: ",", group: nbsp, symbolAfter: true, symbolSpace: true},
+	"tr":    {decimal: ",", group: "."},
+}
+
+// lookupLocale returns the format of locale, a BCP 47 tag such as "de-DE",
+// falling back to its language and then to English.
+func lookupLocale(locale string) localeFormat {
+	tag := strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if f, ok := locales[tag]; ok {
+		return f
+	}
+	lang, _, _ := strings.Cut(tag, "-")
+	if f, ok := locales[lang]; ok {
+		return f
+	}
+	return defaultLocale
+}
+
+// Format returns m the way locale writes it, rounded half to even to the
+// minor units of its currency and with the currency's symbol, e.g.
+// "$1,234.50" in "en-US" or "1.234,50 €" in "de-DE". Returns
+// ErrInvalidValue if m is invalid and ErrUnknownCurrency if its currency is
+// not known.
+func Format(m *pb.Money, locale string) (string, error) {
+	c, ok := LookupCurrency(m.GetCurrencyCode())
+	if !ok {
+		return "", ErrUnknownCurrency
+	}
+	r, err := Round(m, RoundHalfEven)
+	if err != nil {
+		return "", err
+	}
+	f := lookupLocale(locale)
+
+	units, nanos := uint64(r.GetUnits()), r.GetNanos()
+	negative := r.GetUnits() < 0 || nanos < 0
+	if r.GetUnits() < 0 {
+		units = -units
+	}
+	if nanos < 0 {
+		nanos = -nanos
+	}
+	digits := strconv.FormatUint(units, 10)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(f.group)
+		}
+		b.WriteRune(d)
+	}
+	if c.MinorUnits > 0 {
+		b.WriteString(f.decimal)
+		b.WriteString(strconv.FormatInt(int64(nanos)+nanosMod, 10)[1 : 1+c.MinorUnits])
+	}
+
+	amount, sep := b.String(), ""
+	if f.symbolSpace {
+		sep = nbsp
+	}
+	out := c.Symbol + sep + amount
+	if f.symbolAfter {
+		out = amount + sep + c.Symbol
+	}
+	if negative {
+		out = "-" + out
+	}
+	return out, nil
+}
+
+// Parse reads an amount of currency written the way locale writes it, as
+// Format does. The currency symbol or code and grouping are optional, but
+// the amount may have no more decimals than the currency's minor units.
+// Returns ErrInvalidValue if s is not such an amount, ErrUnknownCurrency if
+// the currency is not known and ErrOverflow if the amount does not fit.
+func Parse(s, currency, locale string) (*pb.Money, error) {
+	c, ok := LookupCurrency(currency)
+	if !ok {
+		return nil, ErrUnknownCurrency
+	}
+	f := lookupLocale(locale)
+
+	s = strings.Replace(s, c.Code, "", 1)
+	s = strings.Replace(s, c.Symbol, "", 1)
+	s = strings.NewReplacer(" ", "", nbsp, "", narrowNbsp, "").Replace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	s = strings.ReplaceAll(s, f.group, "")
+	whole, frac, _ := strings.Cut(s, f.decimal)
+	if whole == "" && frac == "" || len(frac) > c.MinorUnits || !allDigits(whole) || !allDigits(frac) {
+		return nil, ErrInvalidValue
+	}
+
+	var units int64
+	if whole != "" {
+		var err error
+		if units, err = strconv.ParseInt(whole, 10, 64); err != nil {
+			return nil, ErrOverflow
+		}
+	}
+	var nanos int64
+	if frac != "" {
+		// At most 9 digits, so this cannot fail.
+		nanos, _ = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 32)
+	}
+	if negative {
+		units, nanos = -units, -nanos
+	}
+	return &pb.Money{Units: units, Nanos: int32(nanos), CurrencyCode: currency}, nil
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"testing/quick"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in     *pb.Money
+		locale string
+		want   string
+	}{
+		{mmc(1234, 500000000, "USD"), "en-US", "$1,234.50"},
+		{mmc(0, 5000000, "USD"), "en", "$0.00"},
+		{mmc(0, 15000000, "USD"), "en", "$0.02"},
+		{mmc(-19, -990000000, "USD"), "en", "-$19.99"},
+		{mmc(1234567, 0, "EUR"), "de-DE", "1.234.567,00\u00a0€"},
+		{mmc(-3, -500000000, "EUR"), "de", "-3,50\u00a0€"},
+		{mmc(1234, 500000000, "EUR"), "fr-FR", "1\u202f234,50\u00a0€"},
+		{mmc(1234, 500000000, "EUR"), "nl", "€\u00a01.234,50"},
+		{mmc(1234, 500000000, "CHF"), "de-CH", "CHF\u00a01’234.50"},
+		{mmc(2345, 678000000, "JPY"), "ja-JP", "¥2,346"},
+		{mmc(12, 345600000, "KWD"), "en", "د.ك12.346"},
+		{mmc(99, 990000000, "TRY"), "tr", "₺99,99"},
+		{mmc(5, 0, "GBP"), "xx-unknown", "£5.00"},
+		{mmc(5, 0, "GBP"), "", "£5.00"},
+	}
+	for _, tt := range tests {
+		got, err := Format(tt.in, tt.locale)
+		if err != nil || got != tt.want {
+			t.Errorf("Format([%v], %q) = %q, %v; want %q", tt.in, tt.locale, got, err, tt.want)
+		}
+	}
+	if _, err := Format(mmc(1, 0, "XXX"), "en"); err != ErrUnknownCurrency {
+		t.Errorf("Format(XXX): expected err=\"%v\" got=\"%v\"", ErrUnknownCurrency, err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in, currency, locale string
+		want                 *pb.Money
+		wantErr              error
+	}{
+		{"$1,234.50", "USD", "en-US", mmc(1234, 500000000, "USD"), nil},
+		{"1234.5", "USD", "en", mmc(1234, 500000000, "USD"), nil},
+		{" USD 12 ", "USD", "en", mmc(12, 0, "USD"), nil},
+		{"-$0.05", "USD", "en", mmc(0, -50000000, "USD"), nil},
+		{"1.234,50 €", "EUR", "de-AT", mmc(1234, 500000000, "EUR"), nil},
+		{"1 234,50 €", "EUR", "fr", mmc(1234, 500000000, "EUR"), nil},
+		{"¥2,346", "JPY", "ja", mmc(2346, 0, "JPY"), nil},
+		{"12.346", "KWD", "en", mmc(12, 346000000, "KWD"), nil},
+		{"$1.234", "USD", "en", nil, ErrInvalidValue},
+		{"¥2,346.5", "JPY", "ja", nil, ErrInvalidValue},
+		{"1,234.50", "EUR", "de", nil, ErrInvalidValue},
+		{"twelve", "USD", "en", nil, ErrInvalidValue},
+		{"$", "USD", "en", nil, ErrInvalidValue},
+		{"--1", "USD", "en", nil, ErrInvalidValue},
+		{"99999999999999999999", "USD", "en", nil, ErrOverflow},
+		{"1", "XXX", "en", nil, ErrUnknownCurrency},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in, tt.currency, tt.locale)
+		if err != tt.wantErr {
+			t.Errorf("Parse(%q, %s, %q): expected err=\"%v\" got=\"%v\"", tt.in, tt.currency, tt.locale, tt.wantErr, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q, %s, %q) = %v, want %v", tt.in, tt.currency, tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestParseReadsFormat(t *testing.T) {
+	var codes, tags []string
+	for code := range currencies {
+		codes = append(codes, code)
+	}
+	for tag := range locales {
+		tags = append(tags, tag)
+	}
+	sort.Strings(codes)
+	sort.Strings(tags)
+	f := func(units int64, ns int32, currency, locale uint8) bool {
+		m := anyMoney(units%1e15, ns)
+		m.CurrencyCode = codes[int(currency)%len(codes)]
+		tag := tags[int(locale)%len(tags)]
+		s, err := Format(m, tag)
+		if err != nil {
+			return false
+		}
+		got, err := Parse(s, m.GetCurrencyCode(), tag)
+		return err == nil && reflect.DeepEqual(got, Must(Round(m, RoundHalfEven)))
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
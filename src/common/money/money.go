@@ -0,0 +1,343 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"strings"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+const (
+	nanosMin = -999999999
+	nanosMax = +999999999
+	nanosMod = 1000000000
+)
+
+var (
+	ErrInvalidValue        = errors.New("one of the specified money values is invalid")
+	ErrMismatchingCurrency = errors.New("mismatching currency codes")
+	ErrOverflow            = errors.New("money value out of range")
+)
+
+// IsValid checks if specified value has a valid units/nanos signs and ranges.
+func IsValid(m *pb.Money) bool {
+	return signMatches(m) && validNanos(m.GetNanos())
+}
+
+func signMatches(m *pb.Money) bool {
+	return m.GetNanos() == 0 || m.GetUnits() == 0 || (m.GetNanos() < 0) == (m.GetUnits() < 0)
+}
+
+func validNanos(nanos int32) bool { return nanosMin <= nanos && nanos <= nanosMax }
+
+// IsZero returns true if the specified money value is equal to zero.
+func IsZero(m *pb.Money) bool { return m.GetUnits() == 0 && m.GetNanos() == 0 }
+
+// IsPositive returns true if the specified money value is valid and is
+// positive.
+func IsPositive(m *pb.Money) bool {
+	return IsValid(m) && m.GetUnits() > 0 || (m.GetUnits() == 0 && m.GetNanos() > 0)
+}
+
+// IsNegative returns true if the specified money value is valid and is
+// negative.
+func IsNegative(m *pb.Money) bool {
+	return IsValid(m) && m.GetUnits() < 0 || (m.GetUnits() == 0 && m.GetNanos() < 0)
+}
+
+// AreSameCurrency returns true if values l and r have a currency code and
+// they are the same values.
+func AreSameCurrency(l, r *pb.Money) bool {
+	return l.GetCurrencyCode() == r.GetCurrencyCode() && l.GetCurrencyCode() != ""
+}
+
+// AreEquals returns true if values l and r are the equal, including the
+// currency. This does not check validity of the provided values.
+func AreEquals(l, r *pb.Money) bool {
+	return l.GetCurrencyCode() == r.GetCurrencyCode() &&
+		l.GetUnits() == r.GetUnits() && l.GetNanos() == r.GetNanos()
+}
+
+// Negate returns the same amount with the sign negated.
+func Negate(m *pb.Money) *pb.Money {
+	return &pb.Money{
+		Units:        -m.GetUnits(),
+		Nanos:        -m.GetNanos(),
+		CurrencyCode: m.GetCurrencyCode()}
+}
+
+// Must panics if the given error is not nil. This can be used with other
+// functions like: "m := Must(Sum(a,b))".
+func Must(v *pb.Money, err error) *pb.Money {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Sum adds two values. Returns an error if one of the values are invalid or
+// currency codes are not matching (unless currency code is unspecified for
+// both).
+func Sum(l, r *pb.Money) (*pb.Money, error) {
+	if !IsValid(l) || !IsValid(r) {
+		return nil, ErrInvalidValue
+	} else if l.GetCurrencyCode() != r.GetCurrencyCode() {
+		return nil, ErrMismatchingCurrency
+	}
+	units := l.GetUnits() + r.GetUnits()
+	nanos := l.GetNanos() + r.GetNanos()
+
+	if (units >= 0 && nanos >= 0) || (units <= 0 && nanos <= 0) {
+		// same sign <units, nanos>
+		units += int64(nanos / nanosMod)
+		nanos = nanos % nanosMod
+	} else {
+		// different sign. nanos guaranteed to not to go over the limit
+		if units > 0 {
+			units--
+			nanos += nanosMod
+		} else {
+			units++
+			nanos -= nanosMod
+		}
+	}
+
+	return &pb.Money{
+		Units:        units,
+		Nanos:        nanos,
+		CurrencyCode: l.GetCurrencyCode()}, nil
+}
+
+// Compare returns -1, 0 or +1 depending on whether l is less than, equal to
+// or greater than r. Returns an error if one of the values is invalid or the
+// currency codes are not matching.
+func Compare(l, r *pb.Money) (int, error) {
+	if !IsValid(l) || !IsValid(r) {
+		return 0, ErrInvalidValue
+	} else if l.GetCurrencyCode() != r.GetCurrencyCode() {
+		return 0, ErrMismatchingCurrency
+	}
+	switch {
+	case l.GetUnits() < r.GetUnits():
+		return -1, nil
+	case l.GetUnits() > r.GetUnits():
+		return 1, nil
+	case l.GetNanos() < r.GetNanos():
+		return -1, nil
+	case l.GetNanos() > r.GetNanos():
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// RoundingMode says how a result that falls between two nanos is rounded.
+type RoundingMode int
+
+const (
+	// RoundHalfUp rounds to the nearest nano, halves away from zero.
+	RoundHalfUp RoundingMode = iota
+	// RoundHalfEven rounds to the nearest nano, halves to the even one.
+	RoundHalfEven
+	// RoundHalfDown rounds to the nearest nano, halves towards zero.
+	RoundHalfDown
+	// RoundDown rounds towards zero.
+	RoundDown
+	// RoundUp rounds away from zero.
+	RoundUp
+	// RoundFloor rounds towards negative infinity.
+	RoundFloor
+	// RoundCeiling rounds towards positive infinity.
+	RoundCeiling
+)
+
+// nanos returns m as a number of nanos.
+func nanos(m *pb.Money) *big.Int {
+	v := new(big.Int).Mul(big.NewInt(m.GetUnits()), big.NewInt(nanosMod))
+	return v.Add(v, big.NewInt(int64(m.GetNanos())))
+}
+
+// fromNanos returns v nanos in currency, or false if that does not fit in a
+// Money value.
+func fromNanos(v *big.Int, currency string) (*pb.Money, bool) {
+	units, nanos := new(big.Int).QuoRem(v, big.NewInt(nanosMod), new(big.Int))
+	if !units.IsInt64() {
+		return nil, false
+	}
+	return &pb.Money{
+		Units:        units.Int64(),
+		Nanos:        int32(nanos.Int64()),
+		CurrencyCode: currency}, true
+}
+
+// quo returns v/d rounded with mode. d must not be zero.
+func quo(v, d *big.Int, mode RoundingMode) *big.Int {
+	q, r := new(big.Int).QuoRem(v, d, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+	// q was truncated towards zero; away is the direction of the exact
+	// quotient.
+	away := v.Sign() * d.Sign()
+	half := new(big.Int).Abs(new(big.Int).Lsh(r, 1)).Cmp(new(big.Int).Abs(d))
+	var up bool
+	switch mode {
+	case RoundHalfUp:
+		up = half >= 0
+	case RoundHalfEven:
+		up = half > 0 || (half == 0 && q.Bit(0) == 1)
+	case RoundHalfDown:
+		up = half > 0
+	case RoundUp:
+		up = true
+	case RoundFloor:
+		up = away < 0
+	case RoundCeiling:
+		up = away > 0
+	}
+	if up {
+		q.Add(q, big.NewInt(int64(away)))
+	}
+	return q
+}
+
+// Fraction returns m*num/den, rounded half away from zero to the nearest
+// nano. Returns ErrInvalidValue if m is invalid, den is zero or the result
+// does not fit in a Money value.
+func Fraction(m *pb.Money, num, den int64) (*pb.Money, error) {
+	if !IsValid(m) || den == 0 {
+		return nil, ErrInvalidValue
+	}
+	v := nanos(m)
+	v.Mul(v, big.NewInt(num))
+	out, ok := fromNanos(quo(v, big.NewInt(den), RoundHalfUp), m.GetCurrencyCode())
+	if !ok {
+		return nil, ErrInvalidValue
+	}
+	return out, nil
+}
+
+// Multiply returns m*n. Returns ErrInvalidValue if m is invalid and
+// ErrOverflow if the result does not fit in a Money value.
+func Multiply(m *pb.Money, n int64) (*pb.Money, error) {
+	if !IsValid(m) {
+		return nil, ErrInvalidValue
+	}
+	v := nanos(m)
+	out, ok := fromNanos(v.Mul(v, big.NewInt(n)), m.GetCurrencyCode())
+	if !ok {
+		return nil, ErrOverflow
+	}
+	return out, nil
+}
+
+// MultiplyDecimal returns m times the decimal quantity qty, such as "2.5",
+// rounded with mode to the nearest nano. Returns ErrInvalidValue if m or
+// qty is invalid and ErrOverflow if the result does not fit in a Money
+// value.
+func MultiplyDecimal(m *pb.Money, qty string, mode RoundingMode) (*pb.Money, error) {
+	q, ok := new(big.Rat).SetString(qty)
+	if !IsValid(m) || !ok || strings.Contains(qty, "/") {
+		return nil, ErrInvalidValue
+	}
+	v := nanos(m)
+	v.Mul(v, q.Num())
+	out, ok := fromNanos(quo(v, q.Denom(), mode), m.GetCurrencyCode())
+	if !ok {
+		return nil, ErrOverflow
+	}
+	return out, nil
+}
+
+// Divide returns m/n rounded with mode to the nearest nano. Returns
+// ErrInvalidValue if m is invalid or n is zero.
+func Divide(m *pb.Money, n int64, mode RoundingMode) (*pb.Money, error) {
+	if !IsValid(m) || n == 0 {
+		return nil, ErrInvalidValue
+	}
+	out, ok := fromNanos(quo(nanos(m), big.NewInt(n), mode), m.GetCurrencyCode())
+	if !ok {
+		// Only dividing the most negative amount by -1 gets here.
+		return nil, ErrOverflow
+	}
+	return out, nil
+}
+
+// Allocate splits m into parts in proportion to ratios. The nanos left over
+// after sharing out are given one each to the parts that lost the most to
+// rounding, so the parts always add up to m and none is more than a nano
+// from its exact share. Returns ErrInvalidValue if m is invalid, a ratio is
+// negative or they are all zero.
+func Allocate(m *pb.Money, ratios ...int64) ([]*pb.Money, error) {
+	if !IsValid(m) || len(ratios) == 0 {
+		return nil, ErrInvalidValue
+	}
+	sum := new(big.Int)
+	for _, r := range ratios {
+		if r < 0 {
+			return nil, ErrInvalidValue
+		}
+		sum.Add(sum, big.NewInt(r))
+	}
+	if sum.Sign() == 0 {
+		return nil, ErrInvalidValue
+	}
+
+	total := nanos(m)
+	shares := make([]*big.Int, len(ratios))
+	rems := make([]*big.Int, len(ratios))
+	left := new(big.Int).Set(total)
+	for i, r := range ratios {
+		v := new(big.Int).Mul(total, big.NewInt(r))
+		shares[i], rems[i] = v.QuoRem(v, sum, new(big.Int))
+		left.Sub(left, shares[i])
+	}
+	// Fewer nanos are left over than there are parts.
+	order := make([]int, len(ratios))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return rems[order[i]].CmpAbs(rems[order[j]]) > 0
+	})
+	step := big.NewInt(int64(left.Sign()))
+	for _, i := range order[:new(big.Int).Abs(left).Int64()] {
+		shares[i].Add(shares[i], step)
+	}
+
+	out := make([]*pb.Money, len(shares))
+	for i, s := range shares {
+		// Every share is between zero and m, so it fits.
+		out[i], _ = fromNanos(s, m.GetCurrencyCode())
+	}
+	return out, nil
+}
+
+// AllocateEvenly splits m into n parts as equal as they can be: the first
+// parts take a nano more than the last when m does not divide evenly.
+// Returns ErrInvalidValue if m is invalid or n is not positive.
+func AllocateEvenly(m *pb.Money, n int) ([]*pb.Money, error) {
+	if n <= 0 {
+		return nil, ErrInvalidValue
+	}
+	ratios := make([]int64, n)
+	for i := range ratios {
+		ratios[i] = 1
+	}
+	return Allocate(m, ratios...)
+}
@@ -0,0 +1,603 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+func mmc(u int64, n int32, c string) *pb.Money { return &pb.Money{Units: u, Nanos: n, CurrencyCode: c} }
+func mm(u int64, n int32) *pb.Money            { return mmc(u, n, "") }
+
+func TestIsValid(t *testing.T) {
+	tests := []struct {
+		name string
+		in   *pb.Money
+		want bool
+	}{
+		{"valid -/-", mm(-981273891273, -999999999), true},
+		{"invalid -/+", mm(-981273891273, +999999999), false},
+		{"valid +/+", mm(981273891273, 999999999), true},
+		{"invalid +/-", mm(981273891273, -999999999), false},
+		{"invalid +/+overflow", mm(3, 1000000000), false},
+		{"invalid +/-overflow", mm(3, -1000000000), false},
+		{"invalid -/+overflow", mm(-3, 1000000000), false},
+		{"invalid -/-overflow", mm(-3, -1000000000), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsValid(tt.in); got != tt.want {
+				t.Errorf("IsValid(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsZero(t *testing.T) {
+	tests := []struct {
+		name string
+		in   *pb.Money
+		want bool
+	}{
+		{"zero", mm(0, 0), true},
+		{"not-zero (-/+)", mm(-1, +1), false},
+		{"not-zero (-/-)", mm(-1, -1), false},
+		{"not-zero (+/+)", mm(+1, +1), false},
+		{"not-zero (+/-)", mm(+1, -1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsZero(tt.in); got != tt.want {
+				t.Errorf("IsZero(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPositive(t *testing.T) {
+	tests := []struct {
+		name string
+		in   *pb.Money
+		want bool
+	}{
+		{"zero", mm(0, 0), false},
+		{"positive (+/+)", mm(+1, +1), true},
+		{"invalid (-/+)", mm(-1, +1), false},
+		{"negative (-/-)", mm(-1, -1), false},
+		{"invalid (+/-)", mm(+1, -1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPositive(tt.in); got != tt.want {
+				t.Errorf("IsPositive(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsNegative(t *testing.T) {
+	tests := []struct {
+		name string
+		in   *pb.Money
+		want bool
+	}{
+		{"zero", mm(0, 0), false},
+		{"positive (+/+)", mm(+1, +1), false},
+		{"invalid (-/+)", mm(-1, +1), false},
+		{"negative (-/-)", mm(-1, -1), true},
+		{"invalid (+/-)", mm(+1, -1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsNegative(tt.in); got != tt.want {
+				t.Errorf("IsNegative(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAreSameCurrency(t *testing.T) {
+	type args struct {
+		l *pb.Money
+		r *pb.Money
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{"both empty currency", args{mmc(1, 0, ""), mmc(2, 0, "")}, false},
+		{"left empty currency", args{mmc(1, 0, ""), mmc(2, 0, "USD")}, false},
+		{"right empty currency", args{mmc(1, 0, "USD"), mmc(2, 0, "")}, false},
+		{"mismatching", args{mmc(1, 0, "USD"), mmc(2, 0, "CAD")}, false},
+		{"matching", args{mmc(1, 0, "USD"), mmc(2, 0, "USD")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AreSameCurrency(tt.args.l, tt.args.r); got != tt.want {
+				t.Errorf("AreSameCurrency([%v],[%v]) = %v, want %v", tt.args.l, tt.args.r, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAreEquals(t *testing.T) {
+	type args struct {
+		l *pb.Money
+		r *pb.Money
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{"equals", args{mmc(1, 2, "USD"), mmc(1, 2, "USD")}, true},
+		{"mismatching currency", args{mmc(1, 2, "USD"), mmc(1, 2, "CAD")}, false},
+		{"mismatching units", args{mmc(10, 20, "USD"), mmc(1, 20, "USD")}, false},
+		{"mismatching nanos", args{mmc(1, 2, "USD"), mmc(1, 20, "USD")}, false},
+		{"negated", args{mmc(1, 2, "USD"), mmc(-1, -2, "USD")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AreEquals(tt.args.l, tt.args.r); got != tt.want {
+				t.Errorf("AreEquals([%v],[%v]) = %v, want %v", tt.args.l, tt.args.r, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   *pb.Money
+		want *pb.Money
+	}{
+		{"zero", mm(0, 0), mm(0, 0)},
+		{"negative", mm(-1, -200), mm(1, 200)},
+		{"positive", mm(1, 200), mm(-1, -200)},
+		{"carries currency code", mmc(0, 0, "XXX"), mmc(0, 0, "XXX")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Negate(tt.in); !AreEquals(got, tt.want) {
+				t.Errorf("Negate([%v]) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMust_pass(t *testing.T) {
+	v := Must(mm(2, 3), nil)
+	if !AreEquals(v, mm(2, 3)) {
+		t.Errorf("returned the wrong value: %v", v)
+	}
+}
+
+func TestMust_panic(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Logf("panic captured: %v", r)
+		}
+	}()
+	Must(mm(2, 3), fmt.Errorf("some error"))
+	t.Fatal("this should not have executed due to the panic above")
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name    string
+		l, r    *pb.Money
+		want    int
+		wantErr error
+	}{
+		{"equal", mmc(1, 500000000, "USD"), mmc(1, 500000000, "USD"), 0, nil},
+		{"less by units", mmc(1, 900000000, "USD"), mmc(2, 0, "USD"), -1, nil},
+		{"greater by nanos", mmc(1, 2, "USD"), mmc(1, 1, "USD"), 1, nil},
+		{"negative less than zero", mm(0, -1), mm(0, 0), -1, nil},
+		{"Error: currency mismatch", mmc(1, 0, "USD"), mmc(1, 0, "EUR"), 0, ErrMismatchingCurrency},
+		{"Error: invalid", mm(1, -1), mm(0, 0), 0, ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Compare(tt.l, tt.r)
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("Compare([%v],[%v]) = %d, %v; want %d, %v", tt.l, tt.r, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFraction(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       *pb.Money
+		num, den int64
+		want     *pb.Money
+		wantErr  error
+	}{
+		{"10% of 19.99", mmc(19, 990000000, "USD"), 10, 100, mmc(1, 999000000, "USD"), nil},
+		{"a third rounds down", mm(1, 0), 1, 3, mm(0, 333333333), nil},
+		{"two thirds rounds up", mm(1, 0), 2, 3, mm(0, 666666667), nil},
+		{"half a nano rounds away from zero", mm(0, 1), 1, 2, mm(0, 1), nil},
+		{"negative half a nano", mm(0, -1), 1, 2, mm(0, -1), nil},
+		{"negative", mm(-3, -300000000), 1, 3, mm(-1, -100000000), nil},
+		{"negative denominator", mm(3, 0), 1, -2, mm(-1, -500000000), nil},
+		{"large without overflow", mm(9000000000, 0), 3, 3, mm(9000000000, 0), nil},
+		{"Error: zero denominator", mm(1, 0), 1, 0, nil, ErrInvalidValue},
+		{"Error: overflow", mm(9000000000000000000, 0), 2, 1, nil, ErrInvalidValue},
+		{"Error: invalid", mm(1, -1), 1, 1, nil, ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Fraction(tt.in, tt.num, tt.den)
+			if err != tt.wantErr {
+				t.Errorf("Fraction([%v], %d, %d): expected err=\"%v\" got=\"%v\"", tt.in, tt.num, tt.den, tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fraction([%v], %d, %d) = %v, want %v", tt.in, tt.num, tt.den, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSum(t *testing.T) {
+	type args struct {
+		l *pb.Money
+		r *pb.Money
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *pb.Money
+		wantErr error
+	}{
+		{"0+0=0", args{mm(0, 0), mm(0, 0)}, mm(0, 0), nil},
+		{"Error: currency code on left", args{mmc(0, 0, "XXX"), mm(0, 0)}, nil, ErrMismatchingCurrency},
+		{"Error: currency code on right", args{mm(0, 0), mmc(0, 0, "YYY")}, nil, ErrMismatchingCurrency},
+		{"Error: currency code mismatch", args{mmc(0, 0, "AAA"), mmc(0, 0, "BBB")}, nil, ErrMismatchingCurrency},
+		{"Error: invalid +/-", args{mm(+1, -1), mm(0, 0)}, nil, ErrInvalidValue},
+		{"Error: invalid -/+", args{mm(0, 0), mm(-1, +2)}, nil, ErrInvalidValue},
+		{"Error: invalid nanos", args{mm(0, 1000000000), mm(1, 0)}, nil, ErrInvalidValue},
+		{"both positive (no carry)", args{mm(2, 200000000), mm(2, 200000000)}, mm(4, 400000000), nil},
+		{"both positive (nanos=max)", args{mm(2, 111111111), mm(2, 888888888)}, mm(4, 999999999), nil},
+		{"both positive (carry)", args{mm(2, 200000000), mm(2, 900000000)}, mm(5, 100000000), nil},
+		{"both negative (no carry)", args{mm(-2, -200000000), mm(-2, -200000000)}, mm(-4, -400000000), nil},
+		{"both negative (carry)", args{mm(-2, -200000000), mm(-2, -900000000)}, mm(-5, -100000000), nil},
+		{"mixed (larger positive, just decimals)", args{mm(11, 0), mm(-2, 0)}, mm(9, 0), nil},
+		{"mixed (larger negative, just decimals)", args{mm(-11, 0), mm(2, 0)}, mm(-9, 0), nil},
+		{"mixed (larger positive, no borrow)", args{mm(11, 100000000), mm(-2, -100000000)}, mm(9, 0), nil},
+		{"mixed (larger positive, with borrow)", args{mm(11, 100000000), mm(-2, -9000000 /*.09*/)}, mm(9, 91000000 /*.091*/), nil},
+		{"mixed (larger negative, no borrow)", args{mm(-11, -100000000), mm(2, 100000000)}, mm(-9, 0), nil},
+		{"mixed (larger negative, with borrow)", args{mm(-11, -100000000), mm(2, 9000000 /*.09*/)}, mm(-9, -91000000 /*.091*/), nil},
+		{"0+negative", args{mm(0, 0), mm(-2, -100000000)}, mm(-2, -100000000), nil},
+		{"negative+0", args{mm(-2, -100000000), mm(0, 0)}, mm(-2, -100000000), nil},
+		{"positive fractions", args{mm(0, 200000000), mm(0, 300000000)}, mm(0, 500000000), nil},
+		{"negative fractions", args{mm(0, -200000000), mm(0, -300000000)}, mm(0, -500000000), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Sum(tt.args.l, tt.args.r)
+			if err != tt.wantErr {
+				t.Errorf("Sum([%v],[%v]): expected err=\"%v\" got=\"%v\"", tt.args.l, tt.args.r, tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Sum([%v],[%v]) = %v, want %v", tt.args.l, tt.args.r, got, tt.want)
+			}
+		})
+	}
+}
+
+// anyMoney returns a valid value of units and nanos, giving nanos the sign
+// of units.
+func anyMoney(units int64, nanos int32) *pb.Money {
+	n := nanos % nanosMod
+	if (n < 0) != (units < 0) && units != 0 {
+		n = -n
+	}
+	return mmc(units, n, "EUR")
+}
+
+var roundingModes = []RoundingMode{RoundHalfUp, RoundHalfEven, RoundHalfDown, RoundDown, RoundUp, RoundFloor, RoundCeiling}
+
+func TestMultiply(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      *pb.Money
+		n       int64
+		want    *pb.Money
+		wantErr error
+	}{
+		{"by zero", mmc(19, 990000000, "USD"), 0, mmc(0, 0, "USD"), nil},
+		{"by one", mmc(19, 990000000, "USD"), 1, mmc(19, 990000000, "USD"), nil},
+		{"carries nanos", mmc(19, 990000000, "USD"), 3, mmc(59, 970000000, "USD"), nil},
+		{"by negative", mm(1, 500000000), -3, mm(-4, -500000000), nil},
+		{"negative by negative", mm(-1, -500000000), -2, mm(3, 0), nil},
+		{"large", mm(0, 1), math.MaxInt64, mm(9223372036, 854775807), nil},
+		{"Error: overflow", mm(math.MaxInt64/2+1, 0), 2, nil, ErrOverflow},
+		{"Error: overflow from nanos", mm(math.MaxInt64, 500000000), 2, nil, ErrOverflow},
+		{"Error: invalid", mm(1, -1), 2, nil, ErrInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Multiply(tt.in, tt.n)
+			if err != tt.wantErr {
+				t.Errorf("Multiply([%v], %d): expected err=\"%v\" got=\"%v\"", tt.in, tt.n, tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Multiply([%v], %d) = %v, want %v", tt.in, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiplyMatchesRepeatedSum(t *testing.T) {
+	f := func(units int64, ns int32, n int8) bool {
+		m := anyMoney(units%1e12, ns)
+		want := mmc(0, 0, "EUR")
+		for i := 0; i < int(n) || i < -int(n); i++ {
+			want = Must(Sum(want, m))
+		}
+		if n < 0 {
+			want = Negate(want)
+		}
+		got, err := Multiply(m, int64(n))
+		return err == nil && reflect.DeepEqual(got, want)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMultiplyDetectsOverflow(t *testing.T) {
+	f := func(units int64, ns int32, n int64) bool {
+		m := anyMoney(units, ns)
+		exact := new(big.Int).Mul(nanos(m), big.NewInt(n))
+		got, err := Multiply(m, n)
+		if new(big.Int).Quo(exact, big.NewInt(nanosMod)).IsInt64() {
+			return err == nil && nanos(got).Cmp(exact) == 0
+		}
+		return err == ErrOverflow
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMultiplyDecimal(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      *pb.Money
+		qty     string
+		mode    RoundingMode
+		want    *pb.Money
+		wantErr error
+	}{
+		{"whole", mmc(19, 990000000, "USD"), "3", RoundHalfUp, mmc(59, 970000000, "USD"), nil},
+		{"fraction", mmc(4, 0, "USD"), "2.5", RoundHalfUp, mmc(10, 0, "USD"), nil},
+		{"negative", mm(4, 0), "-0.25", RoundHalfUp, mm(-1, 0), nil},
+		{"rounds half up", mm(0, 1), "0.5", RoundHalfUp, mm(0, 1), nil},
+		{"rounds half even", mm(0, 1), "0.5", RoundHalfEven, mm(0, 0), nil},
+		{"rounds down", mm(0, 9), "0.15", RoundDown, mm(0, 1), nil},
+		{"rounds up", mm(0, 9), "0.15", RoundUp, mm(0, 2), nil},
+		{"Error: not a number", mm(1, 0), "two", RoundHalfUp, nil, ErrInvalidValue},
+		{"Error: not a decimal", mm(1, 0), "1/3", RoundHalfUp, nil, ErrInvalidValue},
+		{"Error: overflow", mm(math.MaxInt64/2, 0), "2.5", RoundHalfUp, nil, ErrOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MultiplyDecimal(tt.in, tt.qty, tt.mode)
+			if err != tt.wantErr {
+				t.Errorf("MultiplyDecimal([%v], %q): expected err=\"%v\" got=\"%v\"", tt.in, tt.qty, tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MultiplyDecimal([%v], %q) = %v, want %v", tt.in, tt.qty, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiplyDecimalMatchesMultiplyAndDivide(t *testing.T) {
+	f := func(units int64, ns int32, n int32, mode uint8) bool {
+		m := anyMoney(units%1e9, ns)
+		r := roundingModes[int(mode)%len(roundingModes)]
+		whole, err := MultiplyDecimal(m, fmt.Sprint(n), r)
+		if err != nil || !reflect.DeepEqual(whole, Must(Multiply(m, int64(n)))) {
+			return false
+		}
+		tenth, err := MultiplyDecimal(m, "0.1", r)
+		return err == nil && reflect.DeepEqual(tenth, Must(Divide(m, 10, r)))
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDivide(t *testing.T) {
+	tests := []struct {
+		name string
+		in   *pb.Money
+		n    int64
+		want map[RoundingMode]*pb.Money
+	}{
+		{"exact", mm(10, 500000000), 3, map[RoundingMode]*pb.Money{
+			RoundHalfUp: mm(3, 500000000), RoundDown: mm(3, 500000000), RoundCeiling: mm(3, 500000000)}},
+		{"a third", mm(10, 0), 3, map[RoundingMode]*pb.Money{
+			RoundHalfUp: mm(3, 333333333), RoundHalfEven: mm(3, 333333333), RoundHalfDown: mm(3, 333333333),
+			RoundDown: mm(3, 333333333), RoundUp: mm(3, 333333334),
+			RoundFloor: mm(3, 333333333), RoundCeiling: mm(3, 333333334)}},
+		{"minus two thirds", mm(-2, 0), 3, map[RoundingMode]*pb.Money{
+			RoundHalfUp: mm(0, -666666667), RoundHalfEven: mm(0, -666666667), RoundHalfDown: mm(0, -666666667),
+			RoundDown: mm(0, -666666666), RoundUp: mm(0, -666666667),
+			RoundFloor: mm(0, -666666667), RoundCeiling: mm(0, -666666666)}},
+		{"half of an odd nano", mm(0, 5), 2, map[RoundingMode]*pb.Money{
+			RoundHalfUp: mm(0, 3), RoundHalfEven: mm(0, 2), RoundHalfDown: mm(0, 2)}},
+		{"half of an odd nano by negative", mm(0, 3), -2, map[RoundingMode]*pb.Money{
+			RoundHalfUp: mm(0, -2), RoundHalfEven: mm(0, -2), RoundHalfDown: mm(0, -1),
+			RoundFloor: mm(0, -2), RoundCeiling: mm(0, -1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for mode, want := range tt.want {
+				got, err := Divide(tt.in, tt.n, mode)
+				if err != nil || !reflect.DeepEqual(got, want) {
+					t.Errorf("Divide([%v], %d, %d) = %v, %v; want %v", tt.in, tt.n, mode, got, err, want)
+				}
+			}
+		})
+	}
+	if _, err := Divide(mm(1, 0), 0, RoundHalfUp); err != ErrInvalidValue {
+		t.Errorf("Divide by zero: expected err=\"%v\" got=\"%v\"", ErrInvalidValue, err)
+	}
+	if _, err := Divide(mm(math.MinInt64, 0), -1, RoundHalfUp); err != ErrOverflow {
+		t.Errorf("Divide(min, -1): expected err=\"%v\" got=\"%v\"", ErrOverflow, err)
+	}
+}
+
+func TestDivideProperties(t *testing.T) {
+	f := func(units int64, ns int32, n int64) bool {
+		if n == 0 {
+			n = 1
+		}
+		m := anyMoney(units, ns)
+		floor, err := Divide(m, n, RoundFloor)
+		if err != nil {
+			return false
+		}
+		ceil := Must(Divide(m, n, RoundCeiling))
+		// The quotient is exact or between two adjacent nanos.
+		if gap := new(big.Int).Sub(nanos(ceil), nanos(floor)); gap.Sign() < 0 || gap.Cmp(big.NewInt(1)) > 0 {
+			return false
+		}
+		for _, mode := range roundingModes {
+			q, err := Divide(m, n, mode)
+			if err != nil {
+				return false
+			}
+			if lo, _ := Compare(q, floor); lo < 0 {
+				return false
+			}
+			if hi, _ := Compare(q, ceil); hi > 0 {
+				return false
+			}
+			// q*n is within |n| nanos of m.
+			diff := new(big.Int).Sub(nanos(m), new(big.Int).Mul(nanos(q), big.NewInt(n)))
+			if diff.CmpAbs(big.NewInt(n)) >= 0 {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     *pb.Money
+		ratios []int64
+		want   []*pb.Money
+	}{
+		{"thirds", mmc(10, 0, "USD"), []int64{1, 1, 1},
+			[]*pb.Money{mmc(3, 333333334, "USD"), mmc(3, 333333333, "USD"), mmc(3, 333333333, "USD")}},
+		{"negative thirds", mm(-10, 0), []int64{1, 1, 1},
+			[]*pb.Money{mm(-3, -333333334), mm(-3, -333333333), mm(-3, -333333333)}},
+		{"largest remainder first", mm(0, 10), []int64{1, 2, 4},
+			[]*pb.Money{mm(0, 1), mm(0, 3), mm(0, 6)}},
+		{"zero ratio", mm(5, 0), []int64{0, 1, 4}, []*pb.Money{mm(0, 0), mm(1, 0), mm(4, 0)}},
+		{"large", mm(math.MaxInt64, 999999999), []int64{math.MaxInt64, math.MaxInt64},
+			[]*pb.Money{mm(math.MaxInt64/2+1, 0), mm(math.MaxInt64/2, 999999999)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Allocate(tt.in, tt.ratios...)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Allocate([%v], %v) = %v, %v; want %v", tt.in, tt.ratios, got, err, tt.want)
+			}
+		})
+	}
+	for _, ratios := range [][]int64{nil, {0, 0}, {1, -1}} {
+		if _, err := Allocate(mm(1, 0), ratios...); err != ErrInvalidValue {
+			t.Errorf("Allocate(%v): expected err=\"%v\" got=\"%v\"", ratios, ErrInvalidValue, err)
+		}
+	}
+	if _, err := AllocateEvenly(mm(1, 0), 0); err != ErrInvalidValue {
+		t.Errorf("AllocateEvenly(0): expected err=\"%v\" got=\"%v\"", ErrInvalidValue, err)
+	}
+}
+
+func TestAllocateProperties(t *testing.T) {
+	f := func(units int64, ns int32, ratios []uint32) bool {
+		m := anyMoney(units, ns)
+		rs := make([]int64, 0, len(ratios)+1)
+		sum := big.NewInt(1)
+		rs = append(rs, 1)
+		for _, r := range ratios {
+			rs = append(rs, int64(r))
+			sum.Add(sum, big.NewInt(int64(r)))
+		}
+		parts, err := Allocate(m, rs...)
+		if err != nil || len(parts) != len(rs) {
+			return false
+		}
+		total := mmc(0, 0, "EUR")
+		for i, p := range parts {
+			if total, err = Sum(total, p); err != nil {
+				return false
+			}
+			// Each part is less than a nano from its exact share:
+			// |p*sum - m*r| < sum.
+			exact := new(big.Int).Mul(nanos(m), big.NewInt(rs[i]))
+			if new(big.Int).Sub(new(big.Int).Mul(nanos(p), sum), exact).CmpAbs(sum) >= 0 {
+				return false
+			}
+		}
+		return reflect.DeepEqual(total, m)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAllocateEvenlyProperties(t *testing.T) {
+	f := func(units int64, ns int32, n uint8) bool {
+		m := anyMoney(units, ns)
+		parts, err := AllocateEvenly(m, int(n)+1)
+		if err != nil || len(parts) != int(n)+1 {
+			return false
+		}
+		total := mmc(0, 0, "EUR")
+		for i, p := range parts {
+			total = Must(Sum(total, p))
+			// Parts shrink by at most the one nano, and only once.
+			if d := new(big.Int).Sub(nanos(parts[0]), nanos(p)); d.CmpAbs(big.NewInt(1)) > 0 ||
+				(i > 0 && new(big.Int).Sub(nanos(parts[i-1]), nanos(p)).CmpAbs(d) > 0) {
+				return false
+			}
+		}
+		return reflect.DeepEqual(total, m)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
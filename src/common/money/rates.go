@@ -0,0 +1,245 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// RateTable is a snapshot of exchange rates for converting money without
+// the currency service. Rates are quoted the way the ECB quotes them: how
+// much of each currency one unit of Base buys.
+type RateTable struct {
+	Base string
+	// AsOf is the day the rates were published, or zero if the snapshot
+	// does not say.
+	AsOf time.Time
+
+	rates map[string]*big.Rat
+}
+
+// NewRateTable returns a table of rates against base, given as decimals
+// such as "1.1305". The rate of base itself is 1 and may be left out.
+func NewRateTable(base string, asOf time.Time, rates map[string]string) (*RateTable, error) {
+	if !isCurrencyCode(base) {
+		return nil, fmt.Errorf("invalid base currency %q", base)
+	}
+	t := &RateTable{Base: base, AsOf: asOf, rates: map[string]*big.Rat{base: big.NewRat(1, 1)}}
+	for code, s := range rates {
+		if !isCurrencyCode(code) {
+			return nil, fmt.Errorf("invalid currency code %q", code)
+		}
+		r, ok := new(big.Rat).SetString(s)
+		if !ok || r.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid rate %q for %s", s, code)
+		}
+		if code == base && r.Cmp(t.rates[base]) != 0 {
+			return nil, fmt.Errorf("rate of base currency %s is %s, not 1", base, s)
+		}
+		t.rates[code] = r
+	}
+	return t, nil
+}
+
+// LoadRates reads a rate table from the snapshot at path, as ParseRates
+// does.
+func LoadRates(path string) (*RateTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := ParseRates(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// ParseRates reads a rate table from a snapshot in one of these formats:
+//
+//   - the ECB's reference rates XML, such as eurofxref-daily.xml, against
+//     EUR. Of several days, the latest is used.
+//   - JSON of the form {"base": "EUR", "date": "2026-10-16", "rates":
+//     {"USD": "1.1305", ...}}, with the date optional and the rates strings
+//     or numbers.
+//   - JSON of the rates alone, {"EUR": "1.0", "USD": "1.1305", ...}, as the
+//     currency service keeps them. The base is the currency whose rate is
+//     1, and there is no date.
+func ParseRates(data []byte) (*RateTable, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("<")) {
+		return parseECBRates(data)
+	}
+	return parseJSONRates(data)
+}
+
+// ecbEnvelope is the part of the ECB's reference rates XML with the rates.
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+func parseECBRates(data []byte) (*RateTable, error) {
+	var env ecbEnvelope
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("failed to parse ECB rates: %w", err)
+	}
+	latest := -1
+	for i, d := range env.Days {
+		// Dates are YYYY-MM-DD, so they sort as strings.
+		if latest < 0 || d.Time > env.Days[latest].Time {
+			latest = i
+		}
+	}
+	if latest < 0 {
+		return nil, fmt.Errorf("no rates in ECB snapshot")
+	}
+	day := env.Days[latest]
+	asOf, err := time.Parse("2006-01-02", day.Time)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ECB rates date %q", day.Time)
+	}
+	rates := make(map[string]string, len(day.Rates))
+	for _, r := range day.Rates {
+		rates[r.Currency] = r.Rate
+	}
+	return NewRateTable("EUR", asOf, rates)
+}
+
+func parseJSONRates(data []byte) (*RateTable, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse rates: %w", err)
+	}
+	if _, ok := fields["rates"]; !ok {
+		rates, err := jsonRates(data)
+		if err != nil {
+			return nil, err
+		}
+		base := ""
+		for code, s := range rates {
+			if r, ok := new(big.Rat).SetString(s); ok && r.Cmp(big.NewRat(1, 1)) == 0 {
+				if base != "" {
+					return nil, fmt.Errorf("both %s and %s have rate 1, cannot tell the base currency", base, code)
+				}
+				base = code
+			}
+		}
+		if base == "" {
+			return nil, fmt.Errorf("no currency has rate 1, cannot tell the base currency")
+		}
+		return NewRateTable(base, time.Time{}, rates)
+	}
+
+	var snapshot struct {
+		Base  string          `json:"base"`
+		Date  string          `json:"date"`
+		Rates json.RawMessage `json:"rates"`
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse rates: %w", err)
+	}
+	var asOf time.Time
+	if snapshot.Date != "" {
+		var err error
+		if asOf, err = time.Parse("2006-01-02", snapshot.Date); err != nil {
+			return nil, fmt.Errorf("invalid rates date %q", snapshot.Date)
+		}
+	}
+	rates, err := jsonRates(snapshot.Rates)
+	if err != nil {
+		return nil, err
+	}
+	return NewRateTable(snapshot.Base, asOf, rates)
+}
+
+// jsonRates reads an object of rates by currency code. Rates may be
+// strings or numbers; either way their digits are kept as written.
+func jsonRates(data []byte) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse rates: %w", err)
+	}
+	rates := make(map[string]string, len(raw))
+	for code, v := range raw {
+		s := string(v)
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			s = unquoted
+		}
+		rates[code] = s
+	}
+	return rates, nil
+}
+
+// Has reports whether t has a rate for code.
+func (t *RateTable) Has(code string) bool {
+	_, ok := t.rates[code]
+	return ok
+}
+
+// Currencies returns the codes t has rates for, sorted.
+func (t *RateTable) Currencies() []string {
+	out := make([]string, 0, len(t.rates))
+	for code := range t.rates {
+		out = append(out, code)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Convert returns m in currency to, going through the base currency when
+// neither is it, and rounded with mode to the nearest nano. Round the
+// result to charge or show it. Returns ErrInvalidValue if m is invalid,
+// ErrUnknownCurrency if t has no rate for either currency and ErrOverflow
+// if the result does not fit.
+func (t *RateTable) Convert(m *pb.Money, to string, mode RoundingMode) (*pb.Money, error) {
+	if !IsValid(m) {
+		return nil, ErrInvalidValue
+	}
+	from, ok := t.rates[m.GetCurrencyCode()]
+	if !ok {
+		return nil, ErrUnknownCurrency
+	}
+	rate, ok := t.rates[to]
+	if !ok {
+		return nil, ErrUnknownCurrency
+	}
+	// m / from is the amount in the base currency, and that times rate is
+	// the amount in to.
+	num := nanos(m)
+	num.Mul(num, rate.Num())
+	num.Mul(num, from.Denom())
+	den := new(big.Int).Mul(rate.Denom(), from.Num())
+	out, ok := fromNanos(quo(num, den, mode), to)
+	if !ok {
+		return nil, ErrOverflow
+	}
+	return out, nil
+}
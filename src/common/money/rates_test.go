@@ -0,0 +1,143 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package money
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+const ecbRates = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<gesmes:Sender>
+		<gesmes:name>European Central Bank</gesmes:name>
+	</gesmes:Sender>
+	<Cube>
+		<Cube time="2026-10-15">
+			<Cube currency="USD" rate="1.1200"/>
+			<Cube currency="JPY" rate="125.00"/>
+		</Cube>
+		<Cube time="2026-10-16">
+			<Cube currency="USD" rate="1.1305"/>
+			<Cube currency="JPY" rate="126.40"/>
+			<Cube currency="GBP" rate="0.85970"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestParseRates(t *testing.T) {
+	oct16 := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		in       string
+		wantBase string
+		wantAsOf time.Time
+		wantErr  bool
+	}{
+		{"ECB XML", ecbRates, "EUR", oct16, false},
+		{"JSON snapshot", `{"base": "USD", "date": "2026-10-16", "rates": {"EUR": "0.8846", "JPY": 111.81, "GBP": "0.7605"}}`, "USD", oct16, false},
+		{"JSON snapshot without date", `{"base": "EUR", "rates": {"USD": "1.1305", "JPY": "126.40", "GBP": "0.85970"}}`, "EUR", time.Time{}, false},
+		{"currency service JSON", `{"EUR": "1.0", "USD": "1.1305", "JPY": "126.40", "GBP": "0.85970"}`, "EUR", time.Time{}, false},
+		{"Error: no base", `{"USD": "1.1305", "JPY": "126.40"}`, "", time.Time{}, true},
+		{"Error: two bases", `{"EUR": "1.0", "USD": "1", "JPY": "126.40"}`, "", time.Time{}, true},
+		{"Error: negative rate", `{"base": "EUR", "rates": {"USD": "-1.1305"}}`, "", time.Time{}, true},
+		{"Error: invalid rate", `{"base": "EUR", "rates": {"USD": "lots"}}`, "", time.Time{}, true},
+		{"Error: invalid code", `{"base": "EUR", "rates": {"usd": "1.1305"}}`, "", time.Time{}, true},
+		{"Error: invalid base", `{"base": "euro", "rates": {"USD": "1.1305"}}`, "", time.Time{}, true},
+		{"Error: base not 1", `{"base": "EUR", "rates": {"EUR": "2", "USD": "1.1305"}}`, "", time.Time{}, true},
+		{"Error: invalid date", `{"base": "EUR", "date": "16/10/2026", "rates": {"USD": "1.1305"}}`, "", time.Time{}, true},
+		{"Error: no ECB rates", `<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01"></gesmes:Envelope>`, "", time.Time{}, true},
+		{"Error: not JSON", `rates`, "", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRates([]byte(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRates() err = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Base != tt.wantBase || !got.AsOf.Equal(tt.wantAsOf) {
+				t.Errorf("ParseRates() = base %s as of %v, want %s as of %v", got.Base, got.AsOf, tt.wantBase, tt.wantAsOf)
+			}
+			if want := []string{"EUR", "GBP", "JPY", "USD"}; !reflect.DeepEqual(got.Currencies(), want) {
+				t.Errorf("Currencies() = %v, want %v", got.Currencies(), want)
+			}
+		})
+	}
+}
+
+func TestRateTableConvert(t *testing.T) {
+	table, err := ParseRates([]byte(ecbRates))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		in      *pb.Money
+		to      string
+		mode    RoundingMode
+		want    *pb.Money
+		wantErr error
+	}{
+		{"from base", mmc(10, 0, "EUR"), "JPY", RoundHalfEven, mmc(1264, 0, "JPY"), nil},
+		{"to base", mmc(100, 0, "USD"), "EUR", RoundHalfEven, mmc(88, 456435206, "EUR"), nil},
+		{"to base towards zero", mmc(100, 0, "USD"), "EUR", RoundDown, mmc(88, 456435205, "EUR"), nil},
+		{"triangulated", mmc(19, 990000000, "USD"), "JPY", RoundHalfEven, mmc(2235, 60592658, "JPY"), nil},
+		{"negative half even", mmc(-19, -990000000, "USD"), "GBP", RoundHalfEven, mmc(-15, -201594870, "GBP"), nil},
+		{"negative towards zero", mmc(-19, -990000000, "USD"), "GBP", RoundDown, mmc(-15, -201594869, "GBP"), nil},
+		{"negative floor", mmc(-19, -990000000, "USD"), "GBP", RoundFloor, mmc(-15, -201594870, "GBP"), nil},
+		{"negative ceiling", mmc(-19, -990000000, "USD"), "GBP", RoundCeiling, mmc(-15, -201594869, "GBP"), nil},
+		{"same currency", mmc(19, 990000000, "USD"), "USD", RoundHalfEven, mmc(19, 990000000, "USD"), nil},
+		{"zero", mmc(0, 0, "USD"), "JPY", RoundUp, mmc(0, 0, "JPY"), nil},
+		{"Error: unknown from", mmc(1, 0, "CHF"), "EUR", RoundHalfEven, nil, ErrUnknownCurrency},
+		{"Error: unknown to", mmc(1, 0, "EUR"), "CHF", RoundHalfEven, nil, ErrUnknownCurrency},
+		{"Error: invalid", mmc(1, -1, "EUR"), "USD", RoundHalfEven, nil, ErrInvalidValue},
+		{"Error: overflow", mmc(math.MaxInt64, 0, "EUR"), "JPY", RoundHalfEven, nil, ErrOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := table.Convert(tt.in, tt.to, tt.mode)
+			if err != tt.wantErr {
+				t.Errorf("Convert([%v], %s, %d): expected err=\"%v\" got=\"%v\"", tt.in, tt.to, tt.mode, tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Convert([%v], %s, %d) = %v, want %v", tt.in, tt.to, tt.mode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateTableRoundTrip(t *testing.T) {
+	table, err := NewRateTable("EUR", time.Time{}, map[string]string{"USD": "1.1305", "JPY": "126.40"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Converting there and back loses at most a nano to rounding.
+	for _, m := range []*pb.Money{mmc(19, 990000000, "USD"), mmc(-3, -50000000, "USD"), mmc(123456, 0, "USD")} {
+		jpy := Must(table.Convert(m, "JPY", RoundHalfEven))
+		back := Must(table.Convert(jpy, "USD", RoundHalfEven))
+		diff := Must(Sum(back, Negate(m)))
+		if diff.GetUnits() != 0 || diff.GetNanos() < -1 || diff.GetNanos() > 1 {
+			t.Errorf("%v converted to JPY and back = %v", m, back)
+		}
+	}
+}
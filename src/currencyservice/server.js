@@ -136,9 +136,16 @@ function convert (call, callback) {
   try {
     _getCurrencyData((data) => {
       const request = call.request;
+      const from = request.from;
+      for (const code of [from.currency_code, request.to_code]) {
+        if (!(code in data)) {
+          logger.warn(`conversion request for unsupported currency ${code}`);
+          callback({code: 3, message: `unsupported currency ${code}`}); // gRPC INVALID_ARGUMENT
+          return;
+        }
+      }
 
       // Convert: from_currency --> EUR
-      const from = request.from;
       const euros = _carry({
         units: from.units / data[from.currency_code],
         nanos: from.nanos / data[from.currency_code]
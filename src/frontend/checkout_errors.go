@@ -0,0 +1,119 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkoutErrorDomain is the domain of the ErrorInfo on checkout errors.
+const checkoutErrorDomain = "checkoutservice"
+
+// checkoutFailure is what the shopper is told about an order checkout
+// failed to place for a given reason.
+type checkoutFailure struct {
+	status  int
+	message string
+}
+
+// checkoutFailures maps the reasons of checkout errors to what to tell the
+// shopper.
+var checkoutFailures = map[string]checkoutFailure{
+	"CARD_DECLINED": {http.StatusPaymentRequired,
+		"Your card was declined. Please check the card details or use another card."},
+	"PRODUCT_NOT_FOUND": {http.StatusConflict,
+		"An item in your cart is no longer available. Please remove it and try again."},
+	"OUT_OF_STOCK": {http.StatusConflict,
+		"Some items in your cart are out of stock. Please lower the quantity or try again later."},
+	"CURRENCY_UNSUPPORTED": {http.StatusBadRequest,
+		"Orders cannot be placed in your currency. Please choose another currency."},
+	"BALANCE_UNAVAILABLE": {http.StatusPaymentRequired,
+		"Your gift card could not be used. Please check the code, or that it is in your currency."},
+	"INSUFFICIENT_FUNDS": {http.StatusPaymentRequired,
+		"Your gift card and store credit do not cover the order. Please add a card to pay the rest."},
+	"INSUFFICIENT_POINTS": {http.StatusConflict,
+		"You do not have that many loyalty points to redeem yet. Points can be redeemed once they settle."},
+	// Declined orders say which kind of check failed, but not which rule,
+	// so that fraudsters cannot tell what to change.
+	"DENY_LISTED": {http.StatusForbidden,
+		"We cannot accept orders with these account, card or shipping details. Please contact support."},
+	"HIGH_RISK_SCORE": {http.StatusForbidden,
+		"We could not verify this order. Please check your details, or contact support if it keeps happening."},
+}
+
+// checkoutErrorInfo returns the ErrorInfo checkout attached to st, if any.
+func checkoutErrorInfo(st *status.Status) *errdetails.ErrorInfo {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.GetDomain() == checkoutErrorDomain {
+			return info
+		}
+	}
+	return nil
+}
+
+// fieldViolations lists the fields of a BadRequest detail in st, or returns
+// "" if there is none.
+func fieldViolations(st *status.Status) string {
+	var out []string
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.GetFieldViolations() {
+				out = append(out, v.GetField()+": "+v.GetDescription())
+			}
+		}
+	}
+	return strings.Join(out, "; ")
+}
+
+// placeOrderError returns the HTTP status code to send when checkout failed
+// to place an order, and what to tell the shopper. The error itself, which
+// may reveal internals, is only logged.
+func placeOrderError(log logrus.FieldLogger, err error) (int, string) {
+	st := status.Convert(err)
+	info := checkoutErrorInfo(st)
+	log.WithFields(logrus.Fields{"error": err, "reason": info.GetReason()}).Warn("failed to place order")
+
+	if f, ok := checkoutFailures[info.GetReason()]; ok {
+		return f.status, f.message
+	}
+	switch st.Code() {
+	case codes.PermissionDenied:
+		return http.StatusForbidden, "We could not process this order. Please contact support."
+	case codes.InvalidArgument:
+		if v := fieldViolations(st); v != "" {
+			return http.StatusBadRequest, "Please check your order details: " + v
+		}
+		return http.StatusBadRequest, "Please check your order: " + st.Message()
+	case codes.FailedPrecondition:
+		if info.GetReason() == "SHIPPING_UNAVAILABLE" {
+			return http.StatusUnprocessableEntity, "We cannot ship to this address. Please check it or use another one."
+		}
+		return http.StatusConflict, "Your order could not be placed: " + st.Message()
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict, "This order was already submitted. Please check your orders before trying again."
+	case codes.Unavailable, codes.DeadlineExceeded:
+		if info.GetReason() == "SHIPPING_UNAVAILABLE" {
+			return http.StatusServiceUnavailable, "Shipping is unavailable right now. Please try again in a few minutes."
+		}
+		return http.StatusServiceUnavailable, "Checkout is unavailable right now. Please try again in a few minutes."
+	}
+	return http.StatusInternalServerError, "Something went wrong and your order was not placed. Please try again."
+}
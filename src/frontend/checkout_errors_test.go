@@ -0,0 +1,123 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// checkoutError returns a checkout status with the given reason and any
+// other details.
+func checkoutError(t *testing.T, code codes.Code, reason, msg string, details ...protoadapt.MessageV1) error {
+	t.Helper()
+	if reason != "" {
+		details = append([]protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: reason, Domain: checkoutErrorDomain}}, details...)
+	}
+	st, err := status.New(code, msg).WithDetails(details...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Err()
+}
+
+func TestPlaceOrderError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			"product not found",
+			checkoutError(t, codes.FailedPrecondition, "PRODUCT_NOT_FOUND", "product OLJCESPC7Z not found"),
+			http.StatusConflict,
+			"An item in your cart is no longer available. Please remove it and try again.",
+		},
+		{
+			"out of stock",
+			checkoutError(t, codes.FailedPrecondition, "OUT_OF_STOCK", "could not reserve stock: insufficient stock"),
+			http.StatusConflict,
+			"Some items in your cart are out of stock. Please lower the quantity or try again later.",
+		},
+		{
+			"high risk score",
+			checkoutError(t, codes.PermissionDenied, "HIGH_RISK_SCORE", "order o1 was declined by risk assessment: HIGH_RISK_SCORE"),
+			http.StatusForbidden,
+			"We could not verify this order. Please check your details, or contact support if it keeps happening.",
+		},
+		{
+			"deny listed",
+			checkoutError(t, codes.PermissionDenied, "DENY_LISTED", "order o1 was declined by risk assessment: DENY_LISTED"),
+			http.StatusForbidden,
+			"We cannot accept orders with these account, card or shipping details. Please contact support.",
+		},
+		{
+			"permission denied without a reason",
+			status.Error(codes.PermissionDenied, "declined"),
+			http.StatusForbidden,
+			"We could not process this order. Please contact support.",
+		},
+		{
+			"field violations",
+			checkoutError(t, codes.InvalidArgument, "INVALID_REQUEST", "invalid request: email: must be set; credit_card.credit_card_number: must be set",
+				&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+					{Field: "email", Description: "must be set"},
+					{Field: "credit_card.credit_card_number", Description: "must be set"},
+				}}),
+			http.StatusBadRequest,
+			"Please check your order details: email: must be set; credit_card.credit_card_number: must be set",
+		},
+		{
+			"invalid argument without field violations",
+			checkoutError(t, codes.InvalidArgument, "", "idempotency key too long"),
+			http.StatusBadRequest,
+			"Please check your order: idempotency key too long",
+		},
+		{
+			"reason from another domain",
+			checkoutError(t, codes.FailedPrecondition, "", "insufficient stock",
+				&errdetails.ErrorInfo{Reason: "OUT_OF_STOCK", Domain: "productcatalogservice"}),
+			http.StatusConflict,
+			"Your order could not be placed: insufficient stock",
+		},
+		{
+			"shipping unavailable",
+			checkoutError(t, codes.Unavailable, "SHIPPING_UNAVAILABLE", "shipment failed: no trucks"),
+			http.StatusServiceUnavailable,
+			"Shipping is unavailable right now. Please try again in a few minutes.",
+		},
+		{
+			"not a status",
+			errors.New("connection reset"),
+			http.StatusInternalServerError,
+			"Something went wrong and your order was not placed. Please try again.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStatus, gotMsg := placeOrderError(testLogger(), tt.err)
+			if gotStatus != tt.wantStatus || gotMsg != tt.wantMsg {
+				t.Errorf("placeOrderError() = %d, %q, want %d, %q", gotStatus, gotMsg, tt.wantStatus, tt.wantMsg)
+			}
+		})
+	}
+}
@@ -3,36 +3,22 @@ package main
 import (
 	"net/http"
 	"os"
-	"time"
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/bootstrap"
 )
 
 var deploymentDetailsMap map[string]string
-var log *logrus.Logger
+var log = bootstrap.NewLogger()
 
 func init() {
-	initializeLogger()
 	// Use a goroutine to ensure loadDeploymentDetails()'s GCP API
 	// calls don't block non-GCP deployments. See issue #685.
 	go loadDeploymentDetails()
 }
 
-func initializeLogger() {
-	log = logrus.New()
-	log.Level = logrus.DebugLevel
-	log.Formatter = &logrus.JSONFormatter{
-		FieldMap: logrus.FieldMap{
-			logrus.FieldKeyTime:  "timestamp",
-			logrus.FieldKeyLevel: "severity",
-			logrus.FieldKeyMsg:   "message",
-		},
-		TimestampFormat: time.RFC3339Nano,
-	}
-	log.Out = os.Stdout
-}
-
 func loadDeploymentDetails() {
 	deploymentDetailsMap = make(map[string]string)
 	var metaServerClient = metadata.NewClient(&http.Client{})
@@ -0,0 +1,96 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
+)
+
+// snapshotCurrencies returns the whitelisted currencies rates has rates for.
+func snapshotCurrencies(rates *money.ExchangeRates) []string {
+	var out []string
+	for _, c := range rates.Table.Currencies() {
+		if whitelistedCurrencies[c] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+type ctxKeyStaleRates struct{}
+
+// staleRates records whether a page shows prices converted with a snapshot
+// of exchange rates, here or by checkout, so that it can say so.
+type staleRates struct {
+	mu   sync.Mutex
+	used bool
+	asOf time.Time
+}
+
+func withStaleRates(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyStaleRates{}, &staleRates{})
+}
+
+// markStaleRates records that the request's page shows prices converted
+// with rates as of asOf, which is zero if not known.
+func markStaleRates(ctx context.Context, asOf time.Time) {
+	s, ok := ctx.Value(ctxKeyStaleRates{}).(*staleRates)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used = true
+	if s.asOf.IsZero() || (!asOf.IsZero() && asOf.Before(s.asOf)) {
+		s.asOf = asOf
+	}
+}
+
+// markOrderStaleRates records that the request's page shows order, if it
+// was priced with a snapshot of exchange rates.
+func markOrderStaleRates(ctx context.Context, order *pb.OrderResult) {
+	if !order.GetStaleExchangeRates() {
+		return
+	}
+	var asOf time.Time
+	if order.GetExchangeRatesAsOf() != nil {
+		asOf = order.GetExchangeRatesAsOf().AsTime()
+	}
+	markStaleRates(ctx, asOf)
+}
+
+// staleRatesNotice returns what to tell the user about the exchange rates
+// the request's page was priced with, or "" if they are live.
+func staleRatesNotice(ctx context.Context) string {
+	s, ok := ctx.Value(ctxKeyStaleRates{}).(*staleRates)
+	if !ok {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case !s.used:
+		return ""
+	case s.asOf.IsZero():
+		return "Prices are converted with saved exchange rates and may be out of date."
+	default:
+		return "Prices are converted with exchange rates from " + s.asOf.Format("2 January 2006") + " and may be out of date."
+	}
+}
@@ -28,12 +28,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/text/language"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
-	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
-	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/money"
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
 	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/validator"
 )
 
@@ -232,7 +237,7 @@ func (fe *frontendServer) addToCartHandler(w http.ResponseWriter, r *http.Reques
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to add to cart"), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("location", baseUrl + "/cart")
+	w.Header().Set("location", baseUrl+"/cart")
 	w.WriteHeader(http.StatusFound)
 }
 
@@ -244,10 +249,19 @@ func (fe *frontendServer) emptyCartHandler(w http.ResponseWriter, r *http.Reques
 		renderHTTPError(log, r, w, errors.Wrap(err, "failed to empty cart"), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("location", baseUrl + "/")
+	w.Header().Set("location", baseUrl+"/")
 	w.WriteHeader(http.StatusFound)
 }
 
+// checkoutAddress is the address the checkout form is filled in with.
+var checkoutAddress = &pb.Address{
+	StreetAddress: "1600 Amphitheatre Parkway",
+	City:          "Mountain View",
+	State:         "CA",
+	Country:       "United States",
+	ZipCode:       94043,
+}
+
 func (fe *frontendServer) viewCartHandler(w http.ResponseWriter, r *http.Request) {
 	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
 	log.Debug("view user cart")
@@ -267,60 +281,75 @@ func (fe *frontendServer) viewCartHandler(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		log.WithField("error", err).Warn("failed to get product recommendations")
 	}
-
-	shippingCost, err := fe.getShippingQuote(r.Context(), cart, currentCurrency(r))
+	// Nor is the loyalty balance; without it, points can still be entered.
+	loyalty, err := fe.getLoyaltyBalance(r.Context(), sessionID(r))
 	if err != nil {
-		renderHTTPError(log, r, w, errors.Wrap(err, "failed to get shipping quote"), http.StatusInternalServerError)
-		return
+		log.WithField("error", err).Warn("failed to get loyalty balance")
 	}
 
+	// The cart is priced by checkout, for the address the checkout form is
+	// filled in with, so that the total shown is the one that is charged.
+	var preview *pb.PreviewOrderResponse
+	if len(cart) > 0 {
+		preview, err = fe.previewOrder(r.Context(), sessionID(r), currentCurrency(r), checkoutAddress)
+		if err != nil {
+			renderHTTPError(log, r, w, errors.Wrap(err, "failed to price cart"), http.StatusInternalServerError)
+			return
+		}
+	}
+	order := preview.GetOrder()
+	markOrderStaleRates(r.Context(), order)
+
 	type cartItemView struct {
 		Item     *pb.Product
 		Quantity int32
 		Price    *pb.Money
 	}
-	items := make([]cartItemView, len(cart))
-	totalPrice := pb.Money{CurrencyCode: currentCurrency(r)}
-	for i, item := range cart {
-		p, err := fe.getProduct(r.Context(), item.GetProductId())
-		if err != nil {
-			renderHTTPError(log, r, w, errors.Wrapf(err, "could not retrieve product #%s", item.GetProductId()), http.StatusInternalServerError)
-			return
-		}
-		price, err := fe.convertCurrency(r.Context(), p.GetPriceUsd(), currentCurrency(r))
+	items := make([]cartItemView, len(order.GetItems()))
+	for i, item := range order.GetItems() {
+		p, err := fe.getProduct(r.Context(), item.GetItem().GetProductId())
 		if err != nil {
-			renderHTTPError(log, r, w, errors.Wrapf(err, "could not convert currency for product #%s", item.GetProductId()), http.StatusInternalServerError)
+			renderHTTPError(log, r, w, errors.Wrapf(err, "could not retrieve product #%s", item.GetItem().GetProductId()), http.StatusInternalServerError)
 			return
 		}
-
-		multPrice := money.MultiplySlow(*price, uint32(item.GetQuantity()))
+		multPrice := money.Must(money.Multiply(item.GetCost(), int64(item.GetItem().GetQuantity())))
 		items[i] = cartItemView{
 			Item:     p,
-			Quantity: item.GetQuantity(),
-			Price:    &multPrice}
-		totalPrice = money.Must(money.Sum(totalPrice, multPrice))
+			Quantity: item.GetItem().GetQuantity(),
+			Price:    multPrice}
 	}
-	totalPrice = money.Must(money.Sum(totalPrice, *shippingCost))
 	year := time.Now().Year()
 
+	// Each render of the checkout form gets its own idempotency key, so a
+	// resubmitted form does not place (and charge) the order twice.
+	idempotencyKey, err := uuid.NewRandom()
+	if err != nil {
+		renderHTTPError(log, r, w, errors.Wrap(err, "failed to generate idempotency key"), http.StatusInternalServerError)
+		return
+	}
+
 	if err := templates.ExecuteTemplate(w, "cart", injectCommonTemplateData(r, map[string]interface{}{
 		"currencies":       currencies,
 		"recommendations":  recommendations,
 		"cart_size":        cartSize(cart),
-		"shipping_cost":    shippingCost,
+		"shipping_cost":    order.GetShippingCost(),
+		"discounts":        order.GetDiscounts(),
+		"taxes":            order.GetTaxes(),
 		"show_currency":    true,
-		"total_cost":       totalPrice,
+		"total_cost":       order.GetTotal(),
 		"items":            items,
 		"expiration_years": []int{year, year + 1, year + 2, year + 3, year + 4},
+		"idempotency_key":  idempotencyKey.String(),
+		"quote_token":      preview.GetQuoteToken(),
+		"address":          checkoutAddress,
+		"loyalty":          loyalty,
 	})); err != nil {
 		log.Println(err)
 	}
 }
 
-func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Request) {
-	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
-	log.Debug("placing order")
-
+// placeOrderRequest reads and validates the checkout form.
+func placeOrderRequest(r *http.Request) (*pb.PlaceOrderRequest, error) {
 	var (
 		email         = r.FormValue("email")
 		streetAddress = r.FormValue("street_address")
@@ -332,56 +361,116 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
 		ccMonth, _    = strconv.ParseInt(r.FormValue("credit_card_expiration_month"), 10, 32)
 		ccYear, _     = strconv.ParseInt(r.FormValue("credit_card_expiration_year"), 10, 32)
 		ccCVV, _      = strconv.ParseInt(r.FormValue("credit_card_cvv"), 10, 32)
+		idemKey       = r.FormValue("idempotency_key")
+		promoCode     = r.FormValue("promo_code")
+		quoteToken    = r.FormValue("quote_token")
+		giftCardCode  = strings.TrimSpace(r.FormValue("gift_card_code"))
+		storeCredit   = r.FormValue("use_store_credit") == "on"
+		points, _     = strconv.ParseInt(r.FormValue("redeem_points"), 10, 64)
 	)
 
 	payload := validator.PlaceOrderPayload{
-		Email:         email,
-		StreetAddress: streetAddress,
-		ZipCode:       zipCode,
-		City:          city,
-		State:         state,
-		Country:       country,
-		CcNumber:      ccNumber,
-		CcMonth:       ccMonth,
-		CcYear:        ccYear,
-		CcCVV:         ccCVV,
+		Email:          email,
+		StreetAddress:  streetAddress,
+		ZipCode:        zipCode,
+		City:           city,
+		State:          state,
+		Country:        country,
+		CcNumber:       ccNumber,
+		CcMonth:        ccMonth,
+		CcYear:         ccYear,
+		CcCVV:          ccCVV,
+		IdempotencyKey: idemKey,
+		PromoCode:      promoCode,
+		QuoteToken:     quoteToken,
+		GiftCardCode:   giftCardCode,
+		UseStoreCredit: storeCredit,
+		RedeemPoints:   points,
 	}
 	if err := payload.Validate(); err != nil {
+		return nil, err
+	}
+	address := &pb.Address{
+		StreetAddress: payload.StreetAddress,
+		City:          payload.City,
+		State:         payload.State,
+		ZipCode:       int32(payload.ZipCode),
+		Country:       payload.Country}
+	// The cart was quoted for checkoutAddress without a promo code; an
+	// order shipped elsewhere or with a code is priced afresh.
+	if payload.PromoCode != "" || !proto.Equal(address, checkoutAddress) {
+		payload.QuoteToken = ""
+	}
+	// The gift card goes first and store credit next; the card pays the
+	// rest.
+	var instruments []*pb.PaymentInstrument
+	if payload.GiftCardCode != "" {
+		instruments = append(instruments, &pb.PaymentInstrument{
+			Method: pb.PaymentMethod_PAYMENT_METHOD_GIFT_CARD, Id: payload.GiftCardCode})
+	}
+	if payload.UseStoreCredit {
+		instruments = append(instruments, &pb.PaymentInstrument{Method: pb.PaymentMethod_PAYMENT_METHOD_STORE_CREDIT})
+	}
+
+	return &pb.PlaceOrderRequest{
+		Email: payload.Email,
+		CreditCard: &pb.CreditCardInfo{
+			CreditCardNumber:          payload.CcNumber,
+			CreditCardExpirationMonth: int32(payload.CcMonth),
+			CreditCardExpirationYear:  int32(payload.CcYear),
+			CreditCardCvv:             int32(payload.CcCVV)},
+		UserId:         sessionID(r),
+		UserCurrency:   currentCurrency(r),
+		Address:        address,
+		IdempotencyKey: payload.IdempotencyKey,
+		PromoCode:      payload.PromoCode,
+		QuoteToken:     payload.QuoteToken,
+
+		PaymentInstruments: instruments,
+		RedeemPoints:       payload.RedeemPoints,
+	}, nil
+}
+
+func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	log.Debug("placing order")
+
+	req, err := placeOrderRequest(r)
+	if err != nil {
 		renderHTTPError(log, r, w, validator.ValidationErrorResponse(err), http.StatusUnprocessableEntity)
 		return
 	}
-
-	order, err := pb.NewCheckoutServiceClient(fe.checkoutSvcConn).
-		PlaceOrder(r.Context(), &pb.PlaceOrderRequest{
-			Email: payload.Email,
-			CreditCard: &pb.CreditCardInfo{
-				CreditCardNumber:          payload.CcNumber,
-				CreditCardExpirationMonth: int32(payload.CcMonth),
-				CreditCardExpirationYear:  int32(payload.CcYear),
-				CreditCardCvv:             int32(payload.CcCVV)},
-			UserId:       sessionID(r),
-			UserCurrency: currentCurrency(r),
-			Address: &pb.Address{
-				StreetAddress: payload.StreetAddress,
-				City:          payload.City,
-				State:         payload.State,
-				ZipCode:       int32(payload.ZipCode),
-				Country:       payload.Country},
-		})
+	order, err := pb.NewCheckoutServiceClient(fe.checkoutSvcConn).PlaceOrder(r.Context(), req)
 	if err != nil {
-		renderHTTPError(log, r, w, errors.Wrap(err, "failed to complete the order"), http.StatusInternalServerError)
+		code, msg := placeOrderError(log, err)
+		renderHTTPError(log, r, w, errors.New(msg), code)
 		return
 	}
 	log.WithField("order", order.GetOrder().GetOrderId()).Info("order placed")
+	fe.renderOrder(log, w, r, order.GetOrder())
+}
 
-	order.GetOrder().GetItems()
-	recommendations, _ := fe.getRecommendations(r.Context(), sessionID(r), nil)
+// orderHandler shows an order placed in this session, such as the one
+// placeOrderStreamHandler has just placed.
+func (fe *frontendServer) orderHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	id := mux.Vars(r)["id"]
 
-	totalPaid := *order.GetOrder().GetShippingCost()
-	for _, v := range order.GetOrder().GetItems() {
-		multPrice := money.MultiplySlow(*v.GetCost(), uint32(v.GetItem().GetQuantity()))
-		totalPaid = money.Must(money.Sum(totalPaid, multPrice))
+	resp, err := pb.NewCheckoutServiceClient(fe.checkoutSvcConn).GetOrder(r.Context(), &pb.GetOrderRequest{OrderId: id})
+	if status.Code(err) == codes.NotFound || (err == nil && resp.GetOrder().GetUserId() != sessionID(r)) {
+		renderHTTPError(log, r, w, errors.Errorf("order %q not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		renderHTTPError(log, r, w, errors.Wrap(err, "could not retrieve order"), http.StatusInternalServerError)
+		return
 	}
+	fe.renderOrder(log, w, r, resp.GetOrder())
+}
+
+func (fe *frontendServer) renderOrder(log logrus.FieldLogger, w http.ResponseWriter, r *http.Request, order *pb.OrderResult) {
+	markOrderStaleRates(r.Context(), order)
+	recommendations, _ := fe.getRecommendations(r.Context(), sessionID(r), nil)
 
 	currencies, err := fe.getCurrencies(r.Context())
 	if err != nil {
@@ -392,8 +481,8 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
 	if err := templates.ExecuteTemplate(w, "order", injectCommonTemplateData(r, map[string]interface{}{
 		"show_currency":   false,
 		"currencies":      currencies,
-		"order":           order.GetOrder(),
-		"total_paid":      &totalPaid,
+		"order":           order,
+		"total_paid":      order.GetTotal(),
 		"recommendations": recommendations,
 	})); err != nil {
 		log.Println(err)
@@ -423,7 +512,7 @@ func (fe *frontendServer) logoutHandler(w http.ResponseWriter, r *http.Request)
 		c.MaxAge = -1
 		http.SetCookie(w, c)
 	}
-	w.Header().Set("Location", baseUrl + "/")
+	w.Header().Set("Location", baseUrl+"/")
 	w.WriteHeader(http.StatusFound)
 }
 
@@ -438,7 +527,14 @@ func (fe *frontendServer) getProductByID(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	jsonData, err := json.Marshal(p)
+	jsonData, err := json.Marshal(struct {
+		ID          string      `json:"id"`
+		Name        string      `json:"name"`
+		Description string      `json:"description"`
+		Picture     string      `json:"picture"`
+		PriceUsd    money.Money `json:"price_usd"`
+		Categories  []string    `json:"categories"`
+	}{p.GetId(), p.GetName(), p.GetDescription(), p.GetPicture(), money.FromProto(p.GetPriceUsd()), p.GetCategories()})
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -553,6 +649,7 @@ func injectCommonTemplateData(r *http.Request, payload map[string]interface{}) m
 		"session_id":        sessionID(r),
 		"request_id":        r.Context().Value(ctxKeyRequestID{}),
 		"user_currency":     currentCurrency(r),
+		"locale":            currentLocale(r),
 		"platform_css":      plat.css,
 		"platform_name":     plat.provider,
 		"is_cymbal_brand":   isCymbalBrand,
@@ -561,6 +658,7 @@ func injectCommonTemplateData(r *http.Request, payload map[string]interface{}) m
 		"frontendMessage":   frontendMessage,
 		"currentYear":       time.Now().Year(),
 		"baseUrl":           baseUrl,
+		"ratesNotice":       staleRatesNotice(r.Context()),
 	}
 
 	for k, v := range payload {
@@ -578,6 +676,16 @@ func currentCurrency(r *http.Request) string {
 	return defaultCurrency
 }
 
+// currentLocale returns the language the request prefers most, e.g. "de-DE",
+// or "en-US" if it does not say.
+func currentLocale(r *http.Request) string {
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(tags) == 0 {
+		return defaultLocale
+	}
+	return tags[0].String()
+}
+
 func sessionID(r *http.Request) string {
 	v := r.Context().Value(ctxKeySessionID{})
 	if v != nil {
@@ -603,24 +711,20 @@ func cartSize(c []*pb.CartItem) int {
 	return cartSize
 }
 
-func renderMoney(money pb.Money) string {
-	currencyLogo := renderCurrencyLogo(money.GetCurrencyCode())
-	return fmt.Sprintf("%s%d.%02d", currencyLogo, money.GetUnits(), money.GetNanos()/10000000)
+// renderMoney formats m for locale with the minor units of its currency.
+func renderMoney(m *pb.Money, locale string) string {
+	s, err := money.Format(m, locale)
+	if err != nil {
+		// Not a currency we know the minor units of.
+		return fmt.Sprintf("%s %d.%09d", m.GetCurrencyCode(), m.GetUnits(), m.GetNanos())
+	}
+	return s
 }
 
 func renderCurrencyLogo(currencyCode string) string {
-	logos := map[string]string{
-		"USD": "$",
-		"CAD": "$",
-		"JPY": "¥",
-		"EUR": "€",
-		"TRY": "₺",
-		"GBP": "£",
-	}
-
 	logo := "$" //default
-	if val, ok := logos[currencyCode]; ok {
-		logo = val
+	if c, ok := money.LookupCurrency(currencyCode); ok {
+		logo = c.Symbol
 	}
 	return logo
 }
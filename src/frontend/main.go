@@ -22,26 +22,23 @@ import (
     "strconv"
     "time"
 
-    "cloud.google.com/go/profiler"
     "github.com/gorilla/mux"
-    "github.com/pkg/errors"
-    "github.com/sirupsen/logrus"
     "github.com/prometheus/client_golang/prometheus"
     "github.com/prometheus/client_golang/prometheus/promhttp"
 
-    "go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
     "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
-    "go.opentelemetry.io/otel"
-    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-    "go.opentelemetry.io/otel/propagation"
-    sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
     "google.golang.org/grpc"
+
+    "github.com/GoogleCloudPlatform/microservices-demo/src/common/bootstrap"
+    "github.com/GoogleCloudPlatform/microservices-demo/src/common/envconfig"
+    "github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
 )
 
 const (
 	port            = "8080"
 	defaultCurrency = "USD"
+	defaultLocale   = "en-US"
 	cookieMaxAge    = 60 * 60 * 48
 
 	cookiePrefix    = "shop_"
@@ -109,31 +106,23 @@ func init() {
 type ctxKeySessionID struct{}
 
 type frontendServer struct {
-	productCatalogSvcAddr string
 	productCatalogSvcConn *grpc.ClientConn
 
-	currencySvcAddr string
 	currencySvcConn *grpc.ClientConn
 
-	cartSvcAddr string
 	cartSvcConn *grpc.ClientConn
 
-	recommendationSvcAddr string
 	recommendationSvcConn *grpc.ClientConn
 
-	checkoutSvcAddr string
 	checkoutSvcConn *grpc.ClientConn
 
-	shippingSvcAddr string
 	shippingSvcConn *grpc.ClientConn
 
-	adSvcAddr string
 	adSvcConn *grpc.ClientConn
 
-	collectorAddr string
-	collectorConn *grpc.ClientConn
-
 	shoppingAssistantSvcAddr string
+
+	rates *money.ExchangeRates
 }
 
 // responseWriter wraps http.ResponseWriter to capture status code
@@ -147,6 +136,12 @@ func (rw *responseWriter) WriteHeader(code int) {
     rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush server-sent events.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+    return rw.ResponseWriter
+}
+
 // MetricsMiddleware wraps HTTP handlers to collect metrics for error rate calculation
 func MetricsMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -208,6 +203,8 @@ func normalizePath(path string) string {
         return "/cart/empty"
     case path == "/cart/checkout":
         return "/cart/checkout"
+    case path == "/cart/checkout/stream":
+        return "/cart/checkout/stream"
     case path == "/setCurrency":
         return "/setCurrency"
     case path == "/logout":
@@ -220,6 +217,8 @@ func normalizePath(path string) string {
         return "/product/{id}"
     case len(path) > 13 && path[:13] == "/product-meta":
         return "/product-meta/{ids}"
+    case len(path) > 7 && path[:7] == "/order/":
+        return "/order/{id}"
     case len(path) > 7 && path[:7] == "/static":
         return "/static/*"
     default:
@@ -227,63 +226,54 @@ func normalizePath(path string) string {
     }
 }
 
+// config is the environment the frontend reads besides bootstrap.Config.
+type config struct {
+	ListenAddr            string `env:"LISTEN_ADDR"`
+	ProductCatalogAddr    string `env:"PRODUCT_CATALOG_SERVICE_ADDR" required:"true"`
+	CurrencyAddr          string `env:"CURRENCY_SERVICE_ADDR" required:"true"`
+	CartAddr              string `env:"CART_SERVICE_ADDR" required:"true"`
+	RecommendationAddr    string `env:"RECOMMENDATION_SERVICE_ADDR" required:"true"`
+	CheckoutAddr          string `env:"CHECKOUT_SERVICE_ADDR" required:"true"`
+	ShippingAddr          string `env:"SHIPPING_SERVICE_ADDR" required:"true"`
+	AdAddr                string `env:"AD_SERVICE_ADDR" required:"true"`
+	ShoppingAssistantAddr string `env:"SHOPPING_ASSISTANT_SERVICE_ADDR" required:"true"`
+}
+
 func main() {
 	ctx := context.Background()
-	log := logrus.New()
-	log.Level = logrus.DebugLevel
-	log.Formatter = &logrus.JSONFormatter{
-		FieldMap: logrus.FieldMap{
-			logrus.FieldKeyTime:  "timestamp",
-			logrus.FieldKeyLevel: "severity",
-			logrus.FieldKeyMsg:   "message",
-		},
-		TimestampFormat: time.RFC3339Nano,
+	app, err := bootstrap.Start(ctx, bootstrap.Options{
+		Name:    "frontend",
+		Version: "1.0.0",
+		Port:    port,
+		Log:     log,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	var cfg config
+	if err := envconfig.Load(&cfg); err != nil {
+		log.Fatal(err)
 	}
-	log.Out = os.Stdout
-
-	svc := new(frontendServer)
-
-	otel.SetTextMapPropagator(
-		propagation.NewCompositeTextMapPropagator(
-			propagation.TraceContext{}, propagation.Baggage{}))
 
 	baseUrl = os.Getenv("BASE_URL")
 
-	if os.Getenv("ENABLE_TRACING") == "1" {
-		log.Info("Tracing enabled.")
-		initTracing(log, ctx, svc)
-	} else {
-		log.Info("Tracing disabled.")
-	}
-
-	if os.Getenv("ENABLE_PROFILER") == "1" {
-		log.Info("Profiling enabled.")
-		go initProfiling(log, "frontend", "1.0.0")
-	} else {
-		log.Info("Profiling disabled.")
+	svc := new(frontendServer)
+	svc.currencySvcConn = app.MustDial(cfg.CurrencyAddr)
+	svc.productCatalogSvcConn = app.MustDial(cfg.ProductCatalogAddr)
+	svc.cartSvcConn = app.MustDial(cfg.CartAddr)
+	svc.recommendationSvcConn = app.MustDial(cfg.RecommendationAddr)
+	svc.shippingSvcConn = app.MustDial(cfg.ShippingAddr)
+	svc.checkoutSvcConn = app.MustDial(cfg.CheckoutAddr)
+	svc.adSvcConn = app.MustDial(cfg.AdAddr)
+	svc.shoppingAssistantSvcAddr = cfg.ShoppingAssistantAddr
+
+	rates, err := money.ExchangeRatesFromEnv()
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	srvPort := port
-	if os.Getenv("PORT") != "" {
-		srvPort = os.Getenv("PORT")
+	if svc.rates = rates; rates == nil {
+		log.Warn("EXCHANGE_RATES_PATH not set, pages with prices will fail while the currency service is unavailable")
 	}
-	addr := os.Getenv("LISTEN_ADDR")
-	mustMapEnv(&svc.productCatalogSvcAddr, "PRODUCT_CATALOG_SERVICE_ADDR")
-	mustMapEnv(&svc.currencySvcAddr, "CURRENCY_SERVICE_ADDR")
-	mustMapEnv(&svc.cartSvcAddr, "CART_SERVICE_ADDR")
-	mustMapEnv(&svc.recommendationSvcAddr, "RECOMMENDATION_SERVICE_ADDR")
-	mustMapEnv(&svc.checkoutSvcAddr, "CHECKOUT_SERVICE_ADDR")
-	mustMapEnv(&svc.shippingSvcAddr, "SHIPPING_SERVICE_ADDR")
-	mustMapEnv(&svc.adSvcAddr, "AD_SERVICE_ADDR")
-	mustMapEnv(&svc.shoppingAssistantSvcAddr, "SHOPPING_ASSISTANT_SERVICE_ADDR")
-
-	mustConnGRPC(ctx, &svc.currencySvcConn, svc.currencySvcAddr)
-	mustConnGRPC(ctx, &svc.productCatalogSvcConn, svc.productCatalogSvcAddr)
-	mustConnGRPC(ctx, &svc.cartSvcConn, svc.cartSvcAddr)
-	mustConnGRPC(ctx, &svc.recommendationSvcConn, svc.recommendationSvcAddr)
-	mustConnGRPC(ctx, &svc.shippingSvcConn, svc.shippingSvcAddr)
-	mustConnGRPC(ctx, &svc.checkoutSvcConn, svc.checkoutSvcAddr)
-	mustConnGRPC(ctx, &svc.adSvcConn, svc.adSvcAddr)
 
 	r := mux.NewRouter()
 	r.HandleFunc(baseUrl+"/", svc.homeHandler).Methods(http.MethodGet, http.MethodHead)
@@ -294,6 +284,8 @@ func main() {
 	r.HandleFunc(baseUrl+"/setCurrency", svc.setCurrencyHandler).Methods(http.MethodPost)
 	r.HandleFunc(baseUrl+"/logout", svc.logoutHandler).Methods(http.MethodGet)
 	r.HandleFunc(baseUrl+"/cart/checkout", svc.placeOrderHandler).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl+"/cart/checkout/stream", svc.placeOrderStreamHandler).Methods(http.MethodPost)
+	r.HandleFunc(baseUrl+"/order/{id}", svc.orderHandler).Methods(http.MethodGet, http.MethodHead)
 	r.HandleFunc(baseUrl+"/assistant", svc.assistantHandler).Methods(http.MethodGet)
 	r.PathPrefix(baseUrl+"/static/").Handler(http.StripPrefix(baseUrl+"/static/", http.FileServer(http.Dir("./static/"))))
 	r.HandleFunc(baseUrl+"/robots.txt", func(w http.ResponseWriter, _ *http.Request) { fmt.Fprint(w, "User-agent: *\nDisallow: /") })
@@ -308,73 +300,7 @@ func main() {
 	handler = ensureSessionID(handler)                 // add session ID
 	handler = otelhttp.NewHandler(handler, "frontend") // add OTel tracing
 
-	log.Infof("starting server on " + addr + ":" + srvPort)
-	log.Fatal(http.ListenAndServe(addr+":"+srvPort, handler))
-}
-
-func initStats(log logrus.FieldLogger) {
-	// TODO(arbrown) Implement OpenTelemtry stats
-}
-
-func initTracing(log logrus.FieldLogger, ctx context.Context, svc *frontendServer) (*sdktrace.TracerProvider, error) {
-	mustMapEnv(&svc.collectorAddr, "COLLECTOR_SERVICE_ADDR")
-	mustConnGRPC(ctx, &svc.collectorConn, svc.collectorAddr)
-	exporter, err := otlptracegrpc.New(
-		ctx,
-		otlptracegrpc.WithGRPCConn(svc.collectorConn))
-	if err != nil {
-		log.Warnf("warn: Failed to create trace exporter: %v", err)
-	}
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
-		sdktrace.WithSampler(sdktrace.AlwaysSample()))
-	otel.SetTracerProvider(tp)
-
-	return tp, err
-}
-
-func initProfiling(log logrus.FieldLogger, service, version string) {
-	// TODO(ahmetb) this method is duplicated in other microservices using Go
-	// since they are not sharing packages.
-	for i := 1; i <= 3; i++ {
-		log = log.WithField("retry", i)
-		if err := profiler.Start(profiler.Config{
-			Service:        service,
-			ServiceVersion: version,
-			// ProjectID must be set if not running on GCP.
-			// ProjectID: "my-project",
-		}); err != nil {
-			log.Warnf("warn: failed to start profiler: %+v", err)
-		} else {
-			log.Info("started Stackdriver profiler")
-			return
-		}
-		d := time.Second * 10 * time.Duration(i)
-		log.Debugf("sleeping %v to retry initializing Stackdriver profiler", d)
-		time.Sleep(d)
-	}
-	log.Warn("warning: could not initialize Stackdriver profiler after retrying, giving up")
-}
-
-func mustMapEnv(target *string, envKey string) {
-	v := os.Getenv(envKey)
-	if v == "" {
-		panic(fmt.Sprintf("environment variable %q not set", envKey))
+	if err := app.ServeHTTP(ctx, cfg.ListenAddr, handler); err != nil {
+		log.Fatal(err)
 	}
-	*target = v
 }
-
-func mustConnGRPC(ctx context.Context, conn **grpc.ClientConn, addr string) {
-    var err error
-    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
-    defer cancel()
-
-    *conn, err = grpc.NewClient(
-        addr,
-        grpc.WithInsecure(),
-        grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
-    )
-    if err != nil {
-        panic(errors.Wrapf(err, "grpc: failed to connect %s", addr))
-    }
-}
\ No newline at end of file
@@ -54,10 +54,13 @@ func (r *responseRecorder) WriteHeader(statusCode int) {
 	r.w.WriteHeader(statusCode)
 }
 
+func (r *responseRecorder) Unwrap() http.ResponseWriter { return r.w }
+
 func (lh *logHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	requestID, _ := uuid.NewRandom()
 	ctx = context.WithValue(ctx, ctxKeyRequestID{}, requestID.String())
+	ctx = withStaleRates(ctx)
 
 	start := time.Now()
 	rr := &responseRecorder{w: w}
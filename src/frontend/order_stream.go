@@ -0,0 +1,121 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/frontend/validator"
+)
+
+// orderProgressEvent is the data of a "progress" server-sent event.
+type orderProgressEvent struct {
+	Stage string `json:"stage"`
+	State string `json:"state"`
+}
+
+// orderCompleteEvent is the data of the "complete" event ending the stream
+// of a placed order.
+type orderCompleteEvent struct {
+	OrderID string `json:"order_id"`
+	URL     string `json:"url"`
+}
+
+// orderErrorEvent is the data of the "error" event ending the stream of an
+// order that could not be placed.
+type orderErrorEvent struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// writeEvent writes a server-sent event and flushes it to the browser.
+func writeEvent(w http.ResponseWriter, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// enumName returns the lower case name of an enum value without its prefix,
+// such as "payment" for PLACE_ORDER_STAGE_PAYMENT.
+func enumName(v fmt.Stringer, prefix string) string {
+	return strings.ToLower(strings.TrimPrefix(v.String(), prefix))
+}
+
+// placeOrderStreamHandler places the order in the checkout form like
+// placeOrderHandler, relaying checkout's progress to the browser as
+// server-sent events. The stream ends with a "complete" event linking to
+// the order page, or an "error" event.
+func (fe *frontendServer) placeOrderStreamHandler(w http.ResponseWriter, r *http.Request) {
+	log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+	log.Debug("placing order with progress")
+
+	req, err := placeOrderRequest(r)
+	if err != nil {
+		http.Error(w, validator.ValidationErrorResponse(err).Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	stream, err := pb.NewCheckoutServiceClient(fe.checkoutSvcConn).PlaceOrderStream(r.Context(), req)
+	if err != nil {
+		log.WithField("error", err).Error("failed to start placing order")
+		http.Error(w, "failed to complete the order", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies such as nginx from buffering the events.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		ev, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			// Checkout always ends a placed order with its complete event.
+			err = errors.New("order stream ended without a result")
+		}
+		if err != nil {
+			code, msg := placeOrderError(log, err)
+			writeEvent(w, "error", orderErrorEvent{Status: code, Message: msg})
+			return
+		}
+		if ev.GetStage() == pb.PlaceOrderStage_PLACE_ORDER_STAGE_COMPLETE {
+			id := ev.GetOrder().GetOrderId()
+			log.WithField("order", id).Info("order placed")
+			writeEvent(w, "complete", orderCompleteEvent{OrderID: id, URL: baseUrl + "/order/" + id})
+			return
+		}
+		if err := writeEvent(w, "progress", orderProgressEvent{
+			Stage: enumName(ev.GetStage(), "PLACE_ORDER_STAGE_"),
+			State: enumName(ev.GetState(), "PLACE_ORDER_STAGE_STATE_"),
+		}); err != nil {
+			// The browser has gone, which also cancels the order.
+			log.WithField("error", err).Warn("failed to send order progress")
+			return
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+// fakeCheckout streams events and then ends the stream with err.
+type fakeCheckout struct {
+	pb.UnimplementedCheckoutServiceServer
+	events []*pb.PlaceOrderProgress
+	err    error
+}
+
+func (f *fakeCheckout) PlaceOrderStream(_ *pb.PlaceOrderRequest, stream grpc.ServerStreamingServer[pb.PlaceOrderProgress]) error {
+	for _, ev := range f.events {
+		if err := stream.Send(ev); err != nil {
+			return err
+		}
+	}
+	return f.err
+}
+
+// newTestFrontend returns a frontendServer whose checkout service is
+// checkout, served over an in-memory listener.
+func newTestFrontend(t *testing.T, checkout pb.CheckoutServiceServer) *frontendServer {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterCheckoutServiceServer(srv, checkout)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &frontendServer{checkoutSvcConn: conn}
+}
+
+// testLogger returns a logger that discards what it is given.
+func testLogger() logrus.FieldLogger {
+	l := logrus.New()
+	l.Out = io.Discard
+	return l
+}
+
+// checkoutForm returns a filled in checkout form.
+func checkoutForm() url.Values {
+	return url.Values{
+		"email":                        {"someone@example.com"},
+		"street_address":               {"1600 Amphitheatre Parkway"},
+		"zip_code":                     {"94043"},
+		"city":                         {"Mountain View"},
+		"state":                        {"CA"},
+		"country":                      {"United States"},
+		"credit_card_number":           {"4432801561520454"},
+		"credit_card_expiration_month": {"1"},
+		"credit_card_expiration_year":  {"2039"},
+		"credit_card_cvv":              {"672"},
+	}
+}
+
+// checkoutRequest returns a request submitting form.
+func checkoutRequest(form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/cart/checkout/stream", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r.WithContext(context.WithValue(r.Context(), ctxKeyLog{}, testLogger()))
+}
+
+func progress(stage pb.PlaceOrderStage, state pb.PlaceOrderStageState) *pb.PlaceOrderProgress {
+	return &pb.PlaceOrderProgress{Stage: stage, State: state}
+}
+
+func TestPlaceOrderStreamHandler(t *testing.T) {
+	outOfStock, err := status.New(codes.FailedPrecondition, "insufficient stock").WithDetails(
+		&errdetails.ErrorInfo{Reason: "OUT_OF_STOCK", Domain: checkoutErrorDomain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := progress(pb.PlaceOrderStage_PLACE_ORDER_STAGE_PAYMENT, pb.PlaceOrderStageState_PLACE_ORDER_STAGE_STATE_STARTED)
+	paid := progress(pb.PlaceOrderStage_PLACE_ORDER_STAGE_PAYMENT, pb.PlaceOrderStageState_PLACE_ORDER_STAGE_STATE_SUCCEEDED)
+	complete := &pb.PlaceOrderProgress{Stage: pb.PlaceOrderStage_PLACE_ORDER_STAGE_COMPLETE, Order: &pb.OrderResult{OrderId: "o1"}}
+
+	tests := []struct {
+		name     string
+		checkout *fakeCheckout
+		want     string
+	}{
+		{
+			name:     "complete",
+			checkout: &fakeCheckout{events: []*pb.PlaceOrderProgress{payment, paid, complete}},
+			want: "event: progress\ndata: {\"stage\":\"payment\",\"state\":\"started\"}\n\n" +
+				"event: progress\ndata: {\"stage\":\"payment\",\"state\":\"succeeded\"}\n\n" +
+				"event: complete\ndata: {\"order_id\":\"o1\",\"url\":\"/order/o1\"}\n\n",
+		},
+		{
+			name:     "failed",
+			checkout: &fakeCheckout{events: []*pb.PlaceOrderProgress{payment}, err: outOfStock.Err()},
+			want: "event: progress\ndata: {\"stage\":\"payment\",\"state\":\"started\"}\n\n" +
+				"event: error\ndata: {\"status\":409,\"message\":\"Some items in your cart are out of stock. Please lower the quantity or try again later.\"}\n\n",
+		},
+		{
+			name:     "ended without a result",
+			checkout: &fakeCheckout{events: []*pb.PlaceOrderProgress{payment}},
+			want: "event: progress\ndata: {\"stage\":\"payment\",\"state\":\"started\"}\n\n" +
+				"event: error\ndata: {\"status\":500,\"message\":\"Something went wrong and your order was not placed. Please try again.\"}\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fe := newTestFrontend(t, tt.checkout)
+			w := httptest.NewRecorder()
+			fe.placeOrderStreamHandler(w, checkoutRequest(checkoutForm()))
+
+			if w.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+				t.Errorf("Content-Type = %q, want %q", got, "text/event-stream")
+			}
+			if !w.Flushed {
+				t.Error("events were not flushed")
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaceOrderStreamHandlerRejectsInvalidForm(t *testing.T) {
+	fe := newTestFrontend(t, &fakeCheckout{})
+	form := checkoutForm()
+	form.Set("email", "not an email")
+	w := httptest.NewRecorder()
+	fe.placeOrderStreamHandler(w, checkoutRequest(form))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+	if strings.Contains(w.Body.String(), "event:") {
+		t.Errorf("invalid form started an event stream: %q", w.Body.String())
+	}
+}
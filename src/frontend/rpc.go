@@ -18,9 +18,11 @@ import (
 	"context"
 	"time"
 
-	pb "github.com/GoogleCloudPlatform/microservices-demo/src/frontend/genproto"
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -28,8 +30,14 @@ const (
 )
 
 func (fe *frontendServer) getCurrencies(ctx context.Context) ([]string, error) {
+	if fe.rates != nil && fe.rates.Primary {
+		return snapshotCurrencies(fe.rates), nil
+	}
 	currs, err := pb.NewCurrencyServiceClient(fe.currencySvcConn).
 		GetSupportedCurrencies(ctx, &pb.Empty{})
+	if err != nil && fe.rates != nil && money.CurrencyServiceUnavailable(err) {
+		return snapshotCurrencies(fe.rates), nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -74,26 +82,41 @@ func (fe *frontendServer) insertCart(ctx context.Context, userID, productID stri
 	return err
 }
 
-func (fe *frontendServer) convertCurrency(ctx context.Context, money *pb.Money, currency string) (*pb.Money, error) {
-	if avoidNoopCurrencyConversionRPC && money.GetCurrencyCode() == currency {
-		return money, nil
+func (fe *frontendServer) convertCurrency(ctx context.Context, m *pb.Money, currency string) (*pb.Money, error) {
+	if avoidNoopCurrencyConversionRPC && m.GetCurrencyCode() == currency {
+		return m, nil
+	}
+	if fe.rates != nil && fe.rates.Primary {
+		if out, ok := fe.rates.Convert(m, currency); ok {
+			markStaleRates(ctx, fe.rates.Table.AsOf)
+			return out, nil
+		}
 	}
-	return pb.NewCurrencyServiceClient(fe.currencySvcConn).
+	out, err := pb.NewCurrencyServiceClient(fe.currencySvcConn).
 		Convert(ctx, &pb.CurrencyConversionRequest{
-			From:   money,
+			From:   m,
 			ToCode: currency})
+	if err != nil && fe.rates != nil && !fe.rates.Primary && money.CurrencyServiceUnavailable(err) {
+		if out, ok := fe.rates.Convert(m, currency); ok {
+			if log, ok := ctx.Value(ctxKeyLog{}).(logrus.FieldLogger); ok {
+				log.WithField("error", err).Warnf("converting %s to %s with exchange rates from the snapshot", m.GetCurrencyCode(), currency)
+			}
+			markStaleRates(ctx, fe.rates.Table.AsOf)
+			return out, nil
+		}
+	}
+	return out, err
 }
 
-func (fe *frontendServer) getShippingQuote(ctx context.Context, items []*pb.CartItem, currency string) (*pb.Money, error) {
-	quote, err := pb.NewShippingServiceClient(fe.shippingSvcConn).GetQuote(ctx,
-		&pb.GetQuoteRequest{
-			Address: nil,
-			Items:   items})
-	if err != nil {
-		return nil, err
-	}
-	localized, err := fe.convertCurrency(ctx, quote.GetCostUsd(), currency)
-	return localized, errors.Wrap(err, "failed to convert currency for shipping cost")
+func (fe *frontendServer) previewOrder(ctx context.Context, userID, currency string, address *pb.Address) (*pb.PreviewOrderResponse, error) {
+	return pb.NewCheckoutServiceClient(fe.checkoutSvcConn).PreviewOrder(ctx, &pb.PreviewOrderRequest{
+		UserId:       userID,
+		UserCurrency: currency,
+		Address:      address})
+}
+
+func (fe *frontendServer) getLoyaltyBalance(ctx context.Context, userID string) (*pb.GetLoyaltyBalanceResponse, error) {
+	return pb.NewCheckoutServiceClient(fe.checkoutSvcConn).GetLoyaltyBalance(ctx, &pb.GetLoyaltyBalanceRequest{UserId: userID})
 }
 
 func (fe *frontendServer) getRecommendations(ctx context.Context, userID string, productIDs []string) ([]*pb.Product, error) {
@@ -50,6 +50,15 @@ type PlaceOrderPayload struct {
 	CcMonth       int64  `validate:"required,gte=1,lte=12"`
 	CcYear        int64  `validate:"required"`
 	CcCVV         int64  `validate:"required"`
+	// IdempotencyKey is generated when the checkout form is rendered.
+	IdempotencyKey string `validate:"omitempty,uuid"`
+	PromoCode      string `validate:"omitempty,max=32,alphanum"`
+	// QuoteToken is the cart's price quote from the checkout service.
+	QuoteToken string `validate:"omitempty,max=1024"`
+	// GiftCardCode may be printed with spaces or dashes.
+	GiftCardCode   string `validate:"omitempty,max=32"`
+	UseStoreCredit bool
+	RedeemPoints   int64 `validate:"gte=0"`
 }
 
 type SetCurrencyPayload struct {
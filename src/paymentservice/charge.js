@@ -15,6 +15,7 @@
 const cardValidator = require('simple-card-validator');
 const { v4: uuidv4 } = require('uuid');
 const pino = require('pino');
+const charges = require('./charges');
 
 const logger = pino({
   name: 'paymentservice-charge',
@@ -30,7 +31,7 @@ const logger = pino({
 class CreditCardError extends Error {
   constructor (message) {
     super(message);
-    this.code = 400; // Invalid argument error
+    this.code = 3; // gRPC INVALID_ARGUMENT; checkout reports it as a declined card
   }
 }
 
@@ -52,14 +53,27 @@ class ExpiredCreditCard extends CreditCardError {
   }
 }
 
+class VoidedCharge extends Error {
+  constructor (key) {
+    super(`The charge ${key} was voided`);
+    this.code = 9; // gRPC FAILED_PRECONDITION
+  }
+}
+
 /**
- * Verifies the credit card number and (pretend) charges the card.
+ * Verifies the credit card number and (pretend) charges the card. A request
+ * repeating the idempotency_key of an earlier charge returns that charge.
  *
  * @param {*} request
  * @return transaction_id - a random uuid.
  */
 module.exports = function charge (request) {
-  const { amount, credit_card: creditCard } = request;
+  const { amount, credit_card: creditCard, idempotency_key: key } = request;
+  if (key) {
+    const previous = charges.get(key);
+    if (previous === charges.VOIDED) { throw new VoidedCharge(key); }
+    if (previous) { return { transaction_id: previous }; }
+  }
   const cardNumber = creditCard.credit_card_number;
   const cardInfo = cardValidator(cardNumber);
   const {
@@ -82,5 +96,7 @@ module.exports = function charge (request) {
   logger.info(`Transaction processed: ${cardType} ending ${cardNumber.substr(-4)} \
     Amount: ${amount.currency_code}${amount.units}.${amount.nanos}`);
 
-  return { transaction_id: uuidv4() };
+  const transactionId = uuidv4();
+  if (key) { charges.record(key, transactionId); }
+  return { transaction_id: transactionId };
 };
@@ -0,0 +1,43 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+ * Charges by idempotency key, so that a repeated Charge is not made twice and
+ * a refund can find a charge whose transaction_id the caller never learned.
+ * Like the charges themselves, they are not persisted.
+ */
+const byKey = new Map();
+
+// VOIDED marks a key that was refunded before any charge was made with it.
+const VOIDED = Symbol('voided');
+
+module.exports = {
+  VOIDED,
+
+  /**
+   * @param {string} key
+   * @return the transaction_id charged with key, VOIDED or undefined.
+   */
+  get (key) {
+    return byKey.get(key);
+  },
+
+  record (key, transactionId) {
+    byKey.set(key, transactionId);
+  },
+
+  void (key) {
+    byKey.set(key, VOIDED);
+  }
+};
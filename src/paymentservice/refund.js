@@ -0,0 +1,61 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+const { v4: uuidv4 } = require('uuid');
+const pino = require('pino');
+const charges = require('./charges');
+
+const logger = pino({
+  name: 'paymentservice-refund',
+  messageKey: 'message',
+  formatters: {
+    level (logLevelString, logLevelNum) {
+      return { severity: logLevelString }
+    }
+  }
+});
+
+class RefundError extends Error {
+  constructor (message, code = 3 /* gRPC INVALID_ARGUMENT */) {
+    super(message);
+    this.code = code;
+  }
+}
+
+/**
+ * (Pretend) refunds a previously charged transaction. Charges are not
+ * persisted by this service, so any well-formed request for a
+ * transaction_id is accepted. A request for an idempotency_key refunds the
+ * charge made with it, or voids the key if there is none.
+ *
+ * @param {*} request
+ * @return refund_id - a random uuid.
+ */
+module.exports = function refund (request) {
+  let { transaction_id: transactionId, amount, reason, idempotency_key: key } = request;
+  if (!transactionId && key) {
+    transactionId = charges.get(key);
+    if (!transactionId || transactionId === charges.VOIDED) {
+      charges.void(key);
+      throw new RefundError(`no charge was made for ${key}`, 5 /* gRPC NOT_FOUND */);
+    }
+  }
+  if (!transactionId) { throw new RefundError('transaction_id or idempotency_key is required'); }
+  if (!amount || !amount.currency_code) { throw new RefundError('refund amount is required'); }
+
+  logger.info(`Refund processed: transaction ${transactionId} \
+    Amount: ${amount.currency_code}${amount.units}.${amount.nanos} Reason: ${reason}`);
+
+  return { refund_id: uuidv4() };
+};
@@ -15,8 +15,8 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"os"
@@ -25,8 +25,8 @@ import (
 	"cloud.google.com/go/alloydbconn"
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
-	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
-	"github.com/golang/protobuf/jsonpb"
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/money"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -41,6 +41,34 @@ func loadCatalog(catalog *pb.ListProductsResponse) error {
 	return loadCatalogFromLocalFile(catalog)
 }
 
+// catalogFile is the format of products.json. Prices are written as
+// decimals with their currency, e.g. "19.99 USD".
+type catalogFile struct {
+	Products []catalogProduct `json:"products"`
+}
+
+type catalogProduct struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Picture     string      `json:"picture"`
+	PriceUsd    money.Money `json:"priceUsd"`
+	Categories  []string    `json:"categories"`
+	Stock       int32       `json:"stock"`
+}
+
+func (p catalogProduct) proto() *pb.Product {
+	return &pb.Product{
+		Id:          p.ID,
+		Name:        p.Name,
+		Description: p.Description,
+		Picture:     p.Picture,
+		PriceUsd:    p.PriceUsd.Proto(),
+		Categories:  p.Categories,
+		Stock:       p.Stock,
+	}
+}
+
 func loadCatalogFromLocalFile(catalog *pb.ListProductsResponse) error {
 	log.Info("loading catalog from local products.json file...")
 
@@ -50,10 +78,15 @@ func loadCatalogFromLocalFile(catalog *pb.ListProductsResponse) error {
 		return err
 	}
 
-	if err := jsonpb.Unmarshal(bytes.NewReader(catalogJSON), catalog); err != nil {
+	var file catalogFile
+	if err := json.Unmarshal(catalogJSON, &file); err != nil {
 		log.Warnf("failed to parse the catalog JSON: %v", err)
 		return err
 	}
+	catalog.Products = catalog.Products[:0]
+	for _, p := range file.Products {
+		catalog.Products = append(catalog.Products, p.proto())
+	}
 
 	log.Info("successfully parsed product catalog json")
 	return nil
@@ -82,29 +115,26 @@ func getSecretPayload(project, secret, version string) (string, error) {
 	return string(result.Payload.Data), nil
 }
 
-func loadCatalogFromAlloyDB(catalog *pb.ListProductsResponse) error {
-	log.Info("loading catalog from AlloyDB...")
-
+// newAlloyDBPool connects to the AlloyDB instance configured in the
+// environment. The returned cleanup closes the pool and its dialer.
+func newAlloyDBPool(ctx context.Context) (*pgxpool.Pool, func(), error) {
 	projectID := os.Getenv("PROJECT_ID")
 	region := os.Getenv("REGION")
 	pgClusterName := os.Getenv("ALLOYDB_CLUSTER_NAME")
 	pgInstanceName := os.Getenv("ALLOYDB_INSTANCE_NAME")
 	pgDatabaseName := os.Getenv("ALLOYDB_DATABASE_NAME")
-	pgTableName := os.Getenv("ALLOYDB_TABLE_NAME")
 	pgSecretName := os.Getenv("ALLOYDB_SECRET_NAME")
 
 	pgPassword, err := getSecretPayload(projectID, pgSecretName, "latest")
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	dialer, err := alloydbconn.NewDialer(context.Background())
+	dialer, err := alloydbconn.NewDialer(ctx)
 	if err != nil {
 		log.Warnf("failed to set-up dialer connection: %v", err)
-		return err
+		return nil, nil, err
 	}
-	cleanup := func() error { return dialer.Close() }
-	defer cleanup()
 
 	dsn := fmt.Sprintf(
 		"user=%s password=%s dbname=%s sslmode=disable",
@@ -114,7 +144,8 @@ func loadCatalogFromAlloyDB(catalog *pb.ListProductsResponse) error {
 	config, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		log.Warnf("failed to parse DSN config: %v", err)
-		return err
+		dialer.Close()
+		return nil, nil, err
 	}
 
 	pgInstanceURI := fmt.Sprintf("projects/%s/locations/%s/clusters/%s/instances/%s", projectID, region, pgClusterName, pgInstanceName)
@@ -122,14 +153,31 @@ func loadCatalogFromAlloyDB(catalog *pb.ListProductsResponse) error {
 		return dialer.Dial(ctx, pgInstanceURI)
 	}
 
-	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
 		log.Warnf("failed to set-up pgx pool: %v", err)
+		dialer.Close()
+		return nil, nil, err
+	}
+	return pool, func() { pool.Close(); dialer.Close() }, nil
+}
+
+func loadCatalogFromAlloyDB(catalog *pb.ListProductsResponse) error {
+	log.Info("loading catalog from AlloyDB...")
+
+	pgTableName := os.Getenv("ALLOYDB_TABLE_NAME")
+	pool, cleanup, err := newAlloyDBPool(context.Background())
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err := addStockColumn(context.Background(), pool, pgTableName); err != nil {
+		log.Warnf("failed to migrate catalog table: %v", err)
 		return err
 	}
-	defer pool.Close()
 
-	query := "SELECT id, name, description, picture, price_usd_currency_code, price_usd_units, price_usd_nanos, categories FROM " + pgTableName
+	// The price is read as one NUMERIC so that it scans into a money.Money.
+	query := "SELECT id, name, description, picture, price_usd_currency_code, price_usd_units + price_usd_nanos / 1e9 AS price_usd, categories, stock FROM " + pgTableName
 	rows, err := pool.Query(context.Background(), query)
 	if err != nil {
 		log.Warnf("failed to query database: %v", err)
@@ -140,16 +188,18 @@ func loadCatalogFromAlloyDB(catalog *pb.ListProductsResponse) error {
 	catalog.Products = catalog.Products[:0]
 	for rows.Next() {
 		product := &pb.Product{}
-		product.PriceUsd = &pb.Money{}
 
-		var categories string
+		var (
+			price      money.Money
+			categories string
+		)
 		err = rows.Scan(&product.Id, &product.Name, &product.Description,
-			&product.Picture, &product.PriceUsd.CurrencyCode, &product.PriceUsd.Units,
-			&product.PriceUsd.Nanos, &categories)
+			&product.Picture, &price.Currency, &price, &categories, &product.Stock)
 		if err != nil {
 			log.Warnf("failed to scan query result row: %v", err)
 			return err
 		}
+		product.PriceUsd = price.Proto()
 		categories = strings.ToLower(categories)
 		product.Categories = strings.Split(categories, ",")
 
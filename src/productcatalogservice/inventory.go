@@ -0,0 +1,296 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+)
+
+const (
+	defaultReservationTTL = 10 * time.Minute
+	maxReservationTTL     = time.Hour
+)
+
+var (
+	errUnknownProduct      = errors.New("unknown product")
+	errInsufficientStock   = errors.New("insufficient stock")
+	errReservationNotFound = errors.New("reservation not found")
+)
+
+// inventory tracks how many units of each product can still be sold.
+// Reserving takes units out of stock straight away; committing keeps them
+// out, while releasing or letting the reservation expire puts them back.
+type inventory interface {
+	// Reserve holds back quantity units of every product in items, or none
+	// of them if any product is unknown or short of stock.
+	Reserve(ctx context.Context, orderID string, items map[string]int32, ttl time.Duration) (id string, expires time.Time, err error)
+	// Commit makes a reservation permanent. It returns errReservationNotFound
+	// if the reservation is unknown, released or expired.
+	Commit(ctx context.Context, id string) error
+	// Release returns the stock held by a reservation. Unknown reservations
+	// are ignored.
+	Release(ctx context.Context, id string) error
+	// Levels returns the stock of the products with ids. Unknown products
+	// are left out.
+	Levels(ctx context.Context, ids []string) (map[string]int32, error)
+}
+
+// newInventory returns the inventory for the configured catalog: AlloyDB if
+// ALLOYDB_CLUSTER_NAME is set, otherwise the stock of products kept in memory
+// and restocked every restockInterval.
+func newInventory(products []*pb.Product, restockInterval time.Duration) (inventory, error) {
+	if os.Getenv("ALLOYDB_CLUSTER_NAME") != "" {
+		return newAlloyDBInventory(context.Background())
+	}
+	inv := newMemoryInventory(products)
+	inv.restockInterval = restockInterval
+	return inv, nil
+}
+
+type reservation struct {
+	orderID string
+	items   map[string]int32
+	expires time.Time
+}
+
+// memoryInventory keeps stock and reservations in memory. Stock starts from
+// the catalog and is not shared between replicas. If restockInterval is set,
+// every product is topped back up to its catalog stock that often, so that
+// a shop under constant load does not sell out for good.
+type memoryInventory struct {
+	mu              sync.Mutex
+	stock           map[string]int32
+	catalogStock    map[string]int32
+	reservations    map[string]*reservation
+	restockInterval time.Duration
+	restocked       time.Time
+	now             func() time.Time
+}
+
+func newMemoryInventory(products []*pb.Product) *memoryInventory {
+	inv := &memoryInventory{
+		stock:        make(map[string]int32, len(products)),
+		catalogStock: make(map[string]int32, len(products)),
+		reservations: make(map[string]*reservation),
+		now:          time.Now,
+	}
+	for _, p := range products {
+		inv.stock[p.GetId()] = p.GetStock()
+		inv.catalogStock[p.GetId()] = p.GetStock()
+	}
+	inv.restocked = inv.now()
+	return inv
+}
+
+// restock tops every product up to its catalog stock if restockInterval has
+// passed since it last did. mu must be held.
+func (inv *memoryInventory) restock() {
+	if inv.restockInterval <= 0 || inv.now().Sub(inv.restocked) < inv.restockInterval {
+		return
+	}
+	for id, n := range inv.catalogStock {
+		if inv.stock[id] < n {
+			inv.stock[id] = n
+		}
+	}
+	inv.restocked = inv.now()
+}
+
+// expire releases the reservations that have run out. mu must be held.
+func (inv *memoryInventory) expire() {
+	now := inv.now()
+	for id, r := range inv.reservations {
+		if !now.Before(r.expires) {
+			log.Infof("reservation %s for order %s expired", id, r.orderID)
+			inv.release(id)
+		}
+	}
+}
+
+// release puts back the stock of reservation id. mu must be held.
+func (inv *memoryInventory) release(id string) {
+	r, ok := inv.reservations[id]
+	if !ok {
+		return
+	}
+	for productID, n := range r.items {
+		inv.stock[productID] += n
+	}
+	delete(inv.reservations, id)
+}
+
+func (inv *memoryInventory) Reserve(_ context.Context, orderID string, items map[string]int32, ttl time.Duration) (string, time.Time, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.expire()
+	inv.restock()
+
+	// Check everything before taking anything, so that a short item leaves
+	// stock untouched.
+	for productID, n := range items {
+		have, ok := inv.stock[productID]
+		if !ok {
+			return "", time.Time{}, fmt.Errorf("%w: %s", errUnknownProduct, productID)
+		}
+		if have < n {
+			return "", time.Time{}, fmt.Errorf("%w for product %s: %d requested, %d available", errInsufficientStock, productID, n, have)
+		}
+	}
+	for productID, n := range items {
+		inv.stock[productID] -= n
+	}
+	id := uuid.New().String()
+	expires := inv.now().Add(ttl)
+	inv.reservations[id] = &reservation{orderID: orderID, items: items, expires: expires}
+	return id, expires, nil
+}
+
+func (inv *memoryInventory) Commit(_ context.Context, id string) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.expire()
+
+	if _, ok := inv.reservations[id]; !ok {
+		return errReservationNotFound
+	}
+	delete(inv.reservations, id)
+	return nil
+}
+
+func (inv *memoryInventory) Release(_ context.Context, id string) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.release(id)
+	return nil
+}
+
+func (inv *memoryInventory) Levels(_ context.Context, ids []string) (map[string]int32, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.expire()
+	inv.restock()
+
+	levels := make(map[string]int32, len(ids))
+	for _, id := range ids {
+		if n, ok := inv.stock[id]; ok {
+			levels[id] = n
+		}
+	}
+	return levels, nil
+}
+
+// reservationTTL returns how long a reservation asked for with ttlSeconds
+// lasts.
+func reservationTTL(ttlSeconds int32) time.Duration {
+	ttl := time.Duration(ttlSeconds) * time.Second
+	if ttl <= 0 {
+		return defaultReservationTTL
+	}
+	if ttl > maxReservationTTL {
+		return maxReservationTTL
+	}
+	return ttl
+}
+
+func (p *productCatalog) ReserveStock(ctx context.Context, req *pb.ReserveStockRequest) (*pb.ReserveStockResponse, error) {
+	log.Infof("[ReserveStock] order_id=%q items=%d", req.GetOrderId(), len(req.GetItems()))
+	if req.GetTtlSeconds() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl_seconds must not be negative")
+	}
+	if len(req.GetItems()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no items to reserve")
+	}
+	items := make(map[string]int32, len(req.GetItems()))
+	for _, item := range req.GetItems() {
+		if item.GetQuantity() <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid quantity %d for product %s", item.GetQuantity(), item.GetProductId())
+		}
+		items[item.GetProductId()] += item.GetQuantity()
+	}
+
+	id, expires, err := p.inventory.Reserve(ctx, req.GetOrderId(), items, reservationTTL(req.GetTtlSeconds()))
+	switch {
+	case errors.Is(err, errUnknownProduct):
+		return nil, status.Errorf(codes.NotFound, "%v", err)
+	case errors.Is(err, errInsufficientStock):
+		return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "failed to reserve stock: %+v", err)
+	}
+	return &pb.ReserveStockResponse{ReservationId: id, ExpiresAt: timestamppb.New(expires)}, nil
+}
+
+func (p *productCatalog) CommitReservation(ctx context.Context, req *pb.CommitReservationRequest) (*pb.Empty, error) {
+	log.Infof("[CommitReservation] reservation_id=%q", req.GetReservationId())
+	if req.GetReservationId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "reservation_id is required")
+	}
+	err := p.inventory.Commit(ctx, req.GetReservationId())
+	if errors.Is(err, errReservationNotFound) {
+		return nil, status.Errorf(codes.NotFound, "no reservation with ID %s, it may have expired", req.GetReservationId())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to commit reservation: %+v", err)
+	}
+	return &pb.Empty{}, nil
+}
+
+func (p *productCatalog) ReleaseReservation(ctx context.Context, req *pb.ReleaseReservationRequest) (*pb.Empty, error) {
+	log.Infof("[ReleaseReservation] reservation_id=%q", req.GetReservationId())
+	if req.GetReservationId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "reservation_id is required")
+	}
+	if err := p.inventory.Release(ctx, req.GetReservationId()); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to release reservation: %+v", err)
+	}
+	return &pb.Empty{}, nil
+}
+
+// withStock returns copies of products carrying their current stock, reading
+// the stock of only those products. Without an inventory, or if it cannot be
+// read, products are returned as loaded.
+func (p *productCatalog) withStock(ctx context.Context, products []*pb.Product) []*pb.Product {
+	if p.inventory == nil || len(products) == 0 {
+		return products
+	}
+	ids := make([]string, len(products))
+	for i, product := range products {
+		ids[i] = product.GetId()
+	}
+	levels, err := p.inventory.Levels(ctx, ids)
+	if err != nil {
+		log.Warnf("failed to read stock levels: %v", err)
+		return products
+	}
+	out := make([]*pb.Product, len(products))
+	for i, product := range products {
+		c := proto.Clone(product).(*pb.Product)
+		c.Stock = levels[c.GetId()]
+		out[i] = c
+	}
+	return out
+}
@@ -0,0 +1,216 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// alloyDBInventory keeps stock in the stock column of the catalog table and
+// reservations in a table next to it, so that every replica sees the same
+// stock. A reservation decrements stock with a conditional UPDATE, which
+// cannot take stock below zero however many replicas reserve at once.
+// Expired reservations are put back by the next Reserve; until then Levels
+// counts them as in stock, so that reading stock never writes.
+type alloyDBInventory struct {
+	pool         *pgxpool.Pool
+	close        func()
+	products     string
+	reservations string
+}
+
+func newAlloyDBInventory(ctx context.Context) (*alloyDBInventory, error) {
+	pool, cleanup, err := newAlloyDBPool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	products := os.Getenv("ALLOYDB_TABLE_NAME")
+	reservations := os.Getenv("ALLOYDB_RESERVATIONS_TABLE_NAME")
+	if reservations == "" {
+		reservations = products + "_reservations"
+	}
+	inv := &alloyDBInventory{pool: pool, close: cleanup, products: products, reservations: reservations}
+
+	if err := addStockColumn(ctx, pool, products); err != nil {
+		cleanup()
+		return nil, err
+	}
+	_, err = pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+reservations+` (
+		id         TEXT NOT NULL,
+		product_id TEXT NOT NULL,
+		quantity   INTEGER NOT NULL,
+		order_id   TEXT NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (id, product_id)
+	)`)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to create reservations table: %w", err)
+	}
+	return inv, nil
+}
+
+// migratedStock is the stock addStockColumn gives the products of a catalog
+// table created before it had stock.
+const migratedStock = 1000
+
+var stockColumnOnce struct {
+	sync.Once
+	err error
+}
+
+// addStockColumn adds the stock column to catalog tables that predate it,
+// once per process.
+func addStockColumn(ctx context.Context, pool *pgxpool.Pool, table string) error {
+	stockColumnOnce.Do(func() {
+		_, err := pool.Exec(ctx, fmt.Sprintf(
+			`ALTER TABLE %s ADD COLUMN IF NOT EXISTS stock INTEGER NOT NULL DEFAULT %d CHECK (stock >= 0)`,
+			table, migratedStock))
+		if err != nil {
+			stockColumnOnce.err = fmt.Errorf("failed to add stock column: %w", err)
+		}
+	})
+	return stockColumnOnce.err
+}
+
+// releaseWhere deletes the reservation rows matching cond and adds their
+// quantities back to stock.
+func (inv *alloyDBInventory) releaseWhere(ctx context.Context, tx pgx.Tx, cond string, args ...interface{}) error {
+	_, err := tx.Exec(ctx, `WITH released AS (
+			DELETE FROM `+inv.reservations+` WHERE `+cond+` RETURNING product_id, quantity
+		), totals AS (
+			SELECT product_id, SUM(quantity) AS quantity FROM released GROUP BY product_id
+		)
+		UPDATE `+inv.products+` p SET stock = p.stock + totals.quantity
+		FROM totals WHERE p.id = totals.product_id`, args...)
+	return err
+}
+
+func (inv *alloyDBInventory) expire(ctx context.Context, tx pgx.Tx) error {
+	if err := inv.releaseWhere(ctx, tx, `expires_at <= now()`); err != nil {
+		return fmt.Errorf("failed to release expired reservations: %w", err)
+	}
+	return nil
+}
+
+func (inv *alloyDBInventory) Reserve(ctx context.Context, orderID string, items map[string]int32, ttl time.Duration) (string, time.Time, error) {
+	tx, err := inv.pool.Begin(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer tx.Rollback(ctx)
+	if err := inv.expire(ctx, tx); err != nil {
+		return "", time.Time{}, err
+	}
+
+	id := uuid.New().String()
+	expires := time.Now().Add(ttl)
+	// Take rows in a fixed order so that concurrent reservations of the
+	// same products cannot deadlock.
+	ids := make([]string, 0, len(items))
+	for productID := range items {
+		ids = append(ids, productID)
+	}
+	sort.Strings(ids)
+	for _, productID := range ids {
+		n := items[productID]
+		tag, err := tx.Exec(ctx, `UPDATE `+inv.products+` SET stock = stock - $1 WHERE id = $2 AND stock >= $1`, n, productID)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to reserve product %s: %w", productID, err)
+		}
+		if tag.RowsAffected() == 0 {
+			var have int32
+			err := tx.QueryRow(ctx, `SELECT stock FROM `+inv.products+` WHERE id = $1`, productID).Scan(&have)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return "", time.Time{}, fmt.Errorf("%w: %s", errUnknownProduct, productID)
+			} else if err != nil {
+				return "", time.Time{}, err
+			}
+			return "", time.Time{}, fmt.Errorf("%w for product %s: %d requested, %d available", errInsufficientStock, productID, n, have)
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO `+inv.reservations+` (id, product_id, quantity, order_id, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+			id, productID, n, orderID, expires); err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to record reservation: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", time.Time{}, err
+	}
+	return id, expires, nil
+}
+
+func (inv *alloyDBInventory) Commit(ctx context.Context, id string) error {
+	// An expired reservation is left for the next Reserve to put back.
+	tag, err := inv.pool.Exec(ctx, `DELETE FROM `+inv.reservations+` WHERE id = $1 AND expires_at > now()`, id)
+	if err != nil {
+		return fmt.Errorf("failed to commit reservation: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errReservationNotFound
+	}
+	return nil
+}
+
+func (inv *alloyDBInventory) Release(ctx context.Context, id string) error {
+	tx, err := inv.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := inv.releaseWhere(ctx, tx, `id = $1`, id); err != nil {
+		return fmt.Errorf("failed to release reservation: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func (inv *alloyDBInventory) Levels(ctx context.Context, ids []string) (map[string]int32, error) {
+	rows, err := inv.pool.Query(ctx, `SELECT p.id, (p.stock + COALESCE(SUM(r.quantity), 0))::INTEGER
+		FROM `+inv.products+` p
+		LEFT JOIN `+inv.reservations+` r ON r.product_id = p.id AND r.expires_at <= now()
+		WHERE p.id = ANY($1)
+		GROUP BY p.id, p.stock`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stock: %w", err)
+	}
+	levels := make(map[string]int32, len(ids))
+	for rows.Next() {
+		var id string
+		var n int32
+		if err := rows.Scan(&id, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		levels[id] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// Close closes the connection pool.
+func (inv *alloyDBInventory) Close() { inv.close() }
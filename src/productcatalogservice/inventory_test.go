@@ -0,0 +1,283 @@
+// Copyright 2026 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newStockedCatalog(stock map[string]int32) (*productCatalog, *memoryInventory) {
+	p := &productCatalog{}
+	for id, n := range stock {
+		p.catalog.Products = append(p.catalog.Products, &pb.Product{Id: id, Name: id, Stock: n})
+	}
+	inv := newMemoryInventory(p.catalog.Products)
+	p.inventory = inv
+	return p, inv
+}
+
+func stockOf(t *testing.T, p *productCatalog, id string) int32 {
+	t.Helper()
+	product, err := p.GetProduct(context.Background(), &pb.GetProductRequest{Id: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return product.GetStock()
+}
+
+func TestReserveCommitRelease(t *testing.T) {
+	p, _ := newStockedCatalog(map[string]int32{"a": 5, "b": 2})
+	ctx := context.Background()
+
+	res, err := p.ReserveStock(ctx, &pb.ReserveStockRequest{OrderId: "o1", Items: []*pb.CartItem{
+		{ProductId: "a", Quantity: 2}, {ProductId: "b", Quantity: 1}, {ProductId: "a", Quantity: 1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stockOf(t, p, "a"); got != 2 {
+		t.Errorf("stock of a after reserving 3 = %d, want 2", got)
+	}
+
+	// A reservation that cannot be met in full takes nothing.
+	_, err = p.ReserveStock(ctx, &pb.ReserveStockRequest{Items: []*pb.CartItem{
+		{ProductId: "a", Quantity: 1}, {ProductId: "b", Quantity: 2},
+	}})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ReserveStock(short) error = %v, want code %v", err, codes.FailedPrecondition)
+	}
+	if got := stockOf(t, p, "a"); got != 2 {
+		t.Errorf("stock of a after failed reservation = %d, want 2", got)
+	}
+	if _, err := p.ReserveStock(ctx, &pb.ReserveStockRequest{Items: []*pb.CartItem{{ProductId: "zzz", Quantity: 1}}}); status.Code(err) != codes.NotFound {
+		t.Errorf("ReserveStock(unknown) error = %v, want code %v", err, codes.NotFound)
+	}
+	if _, err := p.ReserveStock(ctx, &pb.ReserveStockRequest{Items: []*pb.CartItem{{ProductId: "a", Quantity: 0}}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ReserveStock(zero quantity) error = %v, want code %v", err, codes.InvalidArgument)
+	}
+
+	if _, err := p.CommitReservation(ctx, &pb.CommitReservationRequest{ReservationId: res.GetReservationId()}); err != nil {
+		t.Fatal(err)
+	}
+	// Committed stock stays taken; releasing afterwards does nothing.
+	if _, err := p.ReleaseReservation(ctx, &pb.ReleaseReservationRequest{ReservationId: res.GetReservationId()}); err != nil {
+		t.Fatal(err)
+	}
+	if got := stockOf(t, p, "a"); got != 2 {
+		t.Errorf("stock of a after commit and release = %d, want 2", got)
+	}
+
+	res, err = p.ReserveStock(ctx, &pb.ReserveStockRequest{Items: []*pb.CartItem{{ProductId: "b", Quantity: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ReleaseReservation(ctx, &pb.ReleaseReservationRequest{ReservationId: res.GetReservationId()}); err != nil {
+		t.Fatal(err)
+	}
+	if got := stockOf(t, p, "b"); got != 1 {
+		t.Errorf("stock of b after release = %d, want 1", got)
+	}
+	if _, err := p.CommitReservation(ctx, &pb.CommitReservationRequest{ReservationId: res.GetReservationId()}); status.Code(err) != codes.NotFound {
+		t.Errorf("CommitReservation(released) error = %v, want code %v", err, codes.NotFound)
+	}
+}
+
+// levelsRecorder records whose stock is read from its inventory.
+type levelsRecorder struct {
+	inventory
+	asked [][]string
+}
+
+func (r *levelsRecorder) Levels(ctx context.Context, ids []string) (map[string]int32, error) {
+	r.asked = append(r.asked, ids)
+	return r.inventory.Levels(ctx, ids)
+}
+
+func TestGetProductReadsOnlyItsStock(t *testing.T) {
+	p, inv := newStockedCatalog(map[string]int32{"a": 5, "b": 2, "c": 1})
+	rec := &levelsRecorder{inventory: inv}
+	p.inventory = rec
+
+	if got := stockOf(t, p, "b"); got != 2 {
+		t.Errorf("stock of b = %d, want 2", got)
+	}
+	if want := [][]string{{"b"}}; !reflect.DeepEqual(rec.asked, want) {
+		t.Errorf("read the stock of %v, want %v", rec.asked, want)
+	}
+}
+
+func TestReservationExpires(t *testing.T) {
+	p, inv := newStockedCatalog(map[string]int32{"a": 3})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	inv.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	res, err := p.ReserveStock(ctx, &pb.ReserveStockRequest{Items: []*pb.CartItem{{ProductId: "a", Quantity: 3}}, TtlSeconds: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.GetExpiresAt().AsTime(), now.Add(time.Minute); !got.Equal(want) {
+		t.Errorf("expires_at = %v, want %v", got, want)
+	}
+	if got := stockOf(t, p, "a"); got != 0 {
+		t.Errorf("stock before expiry = %d, want 0", got)
+	}
+
+	now = now.Add(time.Minute)
+	if got := stockOf(t, p, "a"); got != 3 {
+		t.Errorf("stock after expiry = %d, want 3", got)
+	}
+	if _, err := p.CommitReservation(ctx, &pb.CommitReservationRequest{ReservationId: res.GetReservationId()}); status.Code(err) != codes.NotFound {
+		t.Errorf("CommitReservation(expired) error = %v, want code %v", err, codes.NotFound)
+	}
+}
+
+func TestReservationTTL(t *testing.T) {
+	for _, tc := range []struct {
+		seconds int32
+		want    time.Duration
+	}{
+		{0, defaultReservationTTL},
+		{30, 30 * time.Second},
+		{int32(2 * maxReservationTTL / time.Second), maxReservationTTL},
+	} {
+		if got := reservationTTL(tc.seconds); got != tc.want {
+			t.Errorf("reservationTTL(%d) = %v, want %v", tc.seconds, got, tc.want)
+		}
+	}
+}
+
+// TestConcurrentReservationsNeverOversell is meant to be run with -race.
+func TestConcurrentReservationsNeverOversell(t *testing.T) {
+	const (
+		stock   = 50
+		buyers  = 200
+		perItem = 2
+	)
+	p, _ := newStockedCatalog(map[string]int32{"a": stock, "b": stock})
+	ctx := context.Background()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved = map[string]int32{}
+		ids      []string
+	)
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Every buyer wants some of a; half of them also want b, listed
+			// first to mix up the order items are taken in.
+			items := []*pb.CartItem{{ProductId: "a", Quantity: perItem}}
+			if i%2 == 0 {
+				items = append([]*pb.CartItem{{ProductId: "b", Quantity: 1}}, items...)
+			}
+			res, err := p.ReserveStock(ctx, &pb.ReserveStockRequest{Items: items})
+			if status.Code(err) == codes.FailedPrecondition {
+				return
+			} else if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, item := range items {
+				reserved[item.GetProductId()] += item.GetQuantity()
+			}
+			ids = append(ids, res.GetReservationId())
+		}(i)
+	}
+	wg.Wait()
+
+	for _, id := range []string{"a", "b"} {
+		if reserved[id] > stock {
+			t.Errorf("reserved %d units of %s, only %d in stock", reserved[id], id, stock)
+		}
+		if got := stockOf(t, p, id); got != stock-reserved[id] {
+			t.Errorf("stock of %s = %d, want %d", id, got, stock-reserved[id])
+		}
+	}
+	if reserved["a"] != stock {
+		t.Errorf("reserved %d units of a, want all %d to sell", reserved["a"], stock)
+	}
+
+	// Releasing everything concurrently restores the original stock.
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if _, err := p.ReleaseReservation(ctx, &pb.ReleaseReservationRequest{ReservationId: id}); err != nil {
+				t.Error(err)
+			}
+		}(id)
+	}
+	wg.Wait()
+	for _, id := range []string{"a", "b"} {
+		if got := stockOf(t, p, id); got != stock {
+			t.Errorf("stock of %s after releasing all = %d, want %d", id, got, stock)
+		}
+	}
+}
+
+// TestRestockKeepsUpWithLoad places a day of checkouts at about the
+// rate the load generator does, each of up to 10 units, and expects none of
+// them to run out of stock.
+func TestRestockKeepsUpWithLoad(t *testing.T) {
+	stock := map[string]int32{}
+	var catalog pb.ListProductsResponse
+	if err := loadCatalog(&catalog); err != nil {
+		t.Fatal(err)
+	}
+	for _, product := range catalog.Products {
+		stock[product.GetId()] = product.GetStock()
+	}
+	p, inv := newStockedCatalog(stock)
+	inv.restockInterval = time.Minute
+	now := time.Unix(0, 0)
+	inv.now = func() time.Time { return now }
+	inv.restocked = now
+	ctx := context.Background()
+
+	var sold int32
+	products := catalog.Products
+	for i := 0; i < 24*60*10; i++ {
+		now = now.Add(6 * time.Second)
+		item := &pb.CartItem{ProductId: products[i%len(products)].GetId(), Quantity: int32(i%10 + 1)}
+		res, err := p.ReserveStock(ctx, &pb.ReserveStockRequest{OrderId: "o", Items: []*pb.CartItem{item}})
+		if err != nil {
+			t.Fatalf("checkout %d of %v failed: %v", i, item, err)
+		}
+		if _, err := p.CommitReservation(ctx, &pb.CommitReservationRequest{ReservationId: res.GetReservationId()}); err != nil {
+			t.Fatal(err)
+		}
+		sold += item.GetQuantity()
+	}
+	var total int32
+	for _, n := range stock {
+		total += n
+	}
+	if sold <= total {
+		t.Errorf("sold %d units, no more than the %d in stock, so restocking was not tested", sold, total)
+	}
+}
@@ -19,7 +19,7 @@ import (
 	"os"
 	"testing"
 
-	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -99,3 +99,20 @@ func TestSearchProducts(t *testing.T) {
 		t.Errorf("got %d, want %d", got, want)
 	}
 }
+
+func TestLoadCatalogFromLocalFile(t *testing.T) {
+	var catalog pb.ListProductsResponse
+	if err := loadCatalogFromLocalFile(&catalog); err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Products) == 0 {
+		t.Fatal("loaded no products")
+	}
+	p := catalog.Products[0]
+	if got := p.GetPriceUsd(); got.GetCurrencyCode() != "USD" || got.GetUnits() != 19 || got.GetNanos() != 990000000 {
+		t.Errorf("price of %s = %v, want 19.99 USD", p.GetId(), got)
+	}
+	if p.GetStock() == 0 || len(p.GetCategories()) == 0 {
+		t.Errorf("product %s = %v, want its stock and categories", p.GetId(), p)
+	}
+}
@@ -17,32 +17,22 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
-	"net"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
 
-	pb "github.com/GoogleCloudPlatform/microservices-demo/src/productcatalogservice/genproto"
-	"google.golang.org/grpc/credentials/insecure"
-	healthpb "google.golang.org/grpc/health/grpc_health_v1"
-
-	"cloud.google.com/go/profiler"
-	"github.com/pkg/errors"
+	pb "github.com/GoogleCloudPlatform/microservices-demo/src/common/genproto"
 	"github.com/sirupsen/logrus"
-	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/propagation"
-	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	"google.golang.org/grpc"
+
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/bootstrap"
+	"github.com/GoogleCloudPlatform/microservices-demo/src/common/envconfig"
 )
 
 var (
 	catalogMutex *sync.Mutex
-	log          *logrus.Logger
+	log          = bootstrap.NewLogger()
 	extraLatency time.Duration
 
 	port = "3550"
@@ -50,49 +40,41 @@ var (
 	reloadCatalog bool
 )
 
+// config is the environment the catalog reads besides bootstrap.Config.
+type config struct {
+	ExtraLatency time.Duration `env:"EXTRA_LATENCY"`
+	// RestockInterval is how often stock kept in memory is topped back up
+	// to products.json; 0 never restocks.
+	RestockInterval time.Duration `env:"RESTOCK_INTERVAL" default:"1m"`
+}
+
 func init() {
-	log = logrus.New()
-	log.Formatter = &logrus.JSONFormatter{
-		FieldMap: logrus.FieldMap{
-			logrus.FieldKeyTime:  "timestamp",
-			logrus.FieldKeyLevel: "severity",
-			logrus.FieldKeyMsg:   "message",
-		},
-		TimestampFormat: time.RFC3339Nano,
-	}
-	log.Out = os.Stdout
+	log.Level = logrus.InfoLevel
 	catalogMutex = &sync.Mutex{}
 }
 
 func main() {
-	if os.Getenv("ENABLE_TRACING") == "1" {
-		err := initTracing()
-		if err != nil {
-			log.Warnf("warn: failed to start tracer: %+v", err)
-		}
-	} else {
-		log.Info("Tracing disabled.")
+	ctx := context.Background()
+	app, err := bootstrap.Start(ctx, bootstrap.Options{
+		Name:    "productcatalogservice",
+		Version: "1.0.0",
+		Port:    port,
+		Profile: true,
+		Log:     log,
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	if os.Getenv("DISABLE_PROFILER") == "" {
-		log.Info("Profiling enabled.")
-		go initProfiling("productcatalogservice", "1.0.0")
-	} else {
-		log.Info("Profiling disabled.")
+	var cfg config
+	if err := envconfig.Load(&cfg); err != nil {
+		log.Fatal(err)
 	}
 
 	flag.Parse()
 
 	// set injected latency
-	if s := os.Getenv("EXTRA_LATENCY"); s != "" {
-		v, err := time.ParseDuration(s)
-		if err != nil {
-			log.Fatalf("failed to parse EXTRA_LATENCY (%s) as time.Duration: %+v", v, err)
-		}
-		extraLatency = v
+	if extraLatency = cfg.ExtraLatency; extraLatency > 0 {
 		log.Infof("extra latency enabled (duration: %v)", extraLatency)
-	} else {
-		extraLatency = time.Duration(0)
 	}
 
 	sigs := make(chan os.Signal, 1)
@@ -111,107 +93,17 @@ func main() {
 		}
 	}()
 
-	if os.Getenv("PORT") != "" {
-		port = os.Getenv("PORT")
-	}
-	log.Infof("starting grpc server at :%s", port)
-	run(port)
-	select {}
-}
-
-func run(port string) string {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Propagate trace context
-	otel.SetTextMapPropagator(
-		propagation.NewCompositeTextMapPropagator(
-			propagation.TraceContext{}, propagation.Baggage{}))
-	var srv *grpc.Server
-	srv = grpc.NewServer(
-		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
-		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()))
-
 	svc := &productCatalog{}
-	err = loadCatalog(&svc.catalog)
-	if err != nil {
+	if err := loadCatalog(&svc.catalog); err != nil {
 		log.Fatalf("could not parse product catalog: %v", err)
 	}
-
-	pb.RegisterProductCatalogServiceServer(srv, svc)
-	healthpb.RegisterHealthServer(srv, svc)
-	go srv.Serve(listener)
-
-	return listener.Addr().String()
-}
-
-func initStats() {
-	// TODO(drewbr) Implement OpenTelemetry stats
-}
-
-func initTracing() error {
-	var (
-		collectorAddr string
-		collectorConn *grpc.ClientConn
-	)
-
-	ctx := context.Background()
-
-	mustMapEnv(&collectorAddr, "COLLECTOR_SERVICE_ADDR")
-	mustConnGRPC(ctx, &collectorConn, collectorAddr)
-
-	exporter, err := otlptracegrpc.New(
-		ctx,
-		otlptracegrpc.WithGRPCConn(collectorConn))
-	if err != nil {
-		log.Warnf("warn: Failed to create trace exporter: %v", err)
-	}
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
-		sdktrace.WithSampler(sdktrace.AlwaysSample()))
-	otel.SetTracerProvider(tp)
-	return err
-}
-
-func initProfiling(service, version string) {
-	for i := 1; i <= 3; i++ {
-		if err := profiler.Start(profiler.Config{
-			Service:        service,
-			ServiceVersion: version,
-			// ProjectID must be set if not running on GCP.
-			// ProjectID: "my-project",
-		}); err != nil {
-			log.Warnf("failed to start profiler: %+v", err)
-		} else {
-			log.Info("started Stackdriver profiler")
-			return
-		}
-		d := time.Second * 10 * time.Duration(i)
-		log.Infof("sleeping %v to retry initializing Stackdriver profiler", d)
-		time.Sleep(d)
+	if svc.inventory, err = newInventory(svc.catalog.Products, cfg.RestockInterval); err != nil {
+		log.Fatalf("could not set up inventory: %v", err)
 	}
-	log.Warn("could not initialize Stackdriver profiler after retrying, giving up")
-}
 
-func mustMapEnv(target *string, envKey string) {
-	v := os.Getenv(envKey)
-	if v == "" {
-		panic(fmt.Sprintf("environment variable %q not set", envKey))
-	}
-	*target = v
-}
-
-func mustConnGRPC(ctx context.Context, conn **grpc.ClientConn, addr string) {
-	var err error
-	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
-	defer cancel()
-	*conn, err = grpc.DialContext(ctx, addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
-		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()))
-	if err != nil {
-		panic(errors.Wrapf(err, "grpc: failed to connect %s", addr))
+	srv := app.NewServer()
+	pb.RegisterProductCatalogServiceServer(srv, svc)
+	if err := app.ServeGRPC(ctx, srv); err != nil {
+		log.Fatal(err)
 	}
 }